@@ -0,0 +1,385 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultDatabases è il numero di DB logici, come databases in Redis
+const DefaultDatabases = 16
+
+// I DB logici non hanno partizioni proprie: ogni DB è un namespace delle
+// chiavi dentro le stesse partizioni RAM e lo stesso disco, così il budget di
+// memoria resta unico. Partizione e stripe dipendono solo dalla chiave logica,
+// quindi i lock sono condivisi tra i DB. Il namespace 0 usa la chiave così com'è;
+// gli altri la prefissano con namespacePrefix<n>\x00, come typedMagic inizia
+// con NUL per non collidere con chiavi scritte dai client. Le chiavi dei client
+// sono binarie e possono iniziare con NUL: nel namespace 0 sono prefissate anche
+// loro, così non si confondono con quelle degli altri namespace.
+const namespacePrefix = "\x00PCDB"
+
+// partPrefix inizia le chiavi delle parti interne di una chiave, come i blocchi
+// di entry di uno stream: partPrefix<n>\x00<parte>\x00<chiave>. Stanno nella
+// partizione e nella stripe della chiave a cui appartengono e non contano come
+// chiavi del DB; un client non può scriverle perché le sue chiavi che iniziano
+// con NUL sono sempre prefissate con namespacePrefix.
+const partPrefix = "\x00PCPT"
+
+var (
+	ErrInvalidDB      = errors.New("ERR DB index is out of range")
+	ErrSameDB         = errors.New("ERR source and destination objects are the same")
+	ErrPartitionsHeld = errors.New("ERR command needs every partition lock but only some are held")
+)
+
+// databaseMap associa i DB logici ai namespace; è immutabile e SwapDB ne
+// installa una nuova con tutti i lock acquisiti, così chi tiene il lock di una
+// stripe la vede stabile per tutta l'operazione
+type databaseMap struct {
+	namespaces []int // DB logico -> namespace
+	owners     []int // namespace -> DB logico
+	// keys conta le chiavi di ogni namespace, così DBSIZE e INFO keyspace non
+	// scorrono le partizioni; è condiviso dalle mappe installate da SwapDB ed è
+	// aggiornato con il lock della stripe della chiave
+	keys []atomic.Int64
+}
+
+func newDatabaseMap(n int) *databaseMap {
+	m := &databaseMap{namespaces: make([]int, n), owners: make([]int, n), keys: make([]atomic.Int64, n)}
+	for i := 0; i < n; i++ {
+		m.namespaces[i] = i
+		m.owners[i] = i
+	}
+	return m
+}
+
+// KeyspaceStats è il numero di chiavi di un DB, come la sezione keyspace di INFO
+type KeyspaceStats struct {
+	DB   int    `json:"db"`
+	Keys uint64 `json:"keys"`
+}
+
+// SetDatabases imposta il numero di DB logici; va chiamato prima di servire richieste
+func (c *PodCache) SetDatabases(n int) {
+	m := newDatabaseMap(n)
+	if current := c.databases.Load(); current != nil {
+		for ns := 0; ns < n && ns < len(current.keys); ns++ {
+			m.keys[ns].Store(current.keys[ns].Load())
+		}
+	}
+	c.databases.Store(m)
+}
+
+// Databases restituisce il numero di DB logici
+func (c *PodCache) Databases() int {
+	return len(c.databases.Load().namespaces)
+}
+
+// DB restituisce l'indice del DB su cui opera questa vista
+func (c *PodCache) DB() int {
+	return c.db
+}
+
+// Select restituisce una vista sul DB indicato che conserva origine e lock della vista corrente
+func (c *PodCache) Select(db int) (*PodCache, error) {
+	if db < 0 || db >= c.Databases() {
+		return nil, ErrInvalidDB
+	}
+	view := *c
+	view.db = db
+	return &view, nil
+}
+
+// storageKey è la chiave con cui key è memorizzata in RAM e su disco
+func (c *PodCache) storageKey(key string) string {
+	return namespaceKey(c.namespace(), key)
+}
+
+// namespace è il namespace del DB della vista
+func (c *PodCache) namespace() int {
+	return c.databases.Load().namespaces[c.db]
+}
+
+func namespaceKey(ns int, key string) string {
+	if ns == 0 && !strings.HasPrefix(key, "\x00") {
+		return key
+	}
+	return namespacePrefix + strconv.Itoa(ns) + "\x00" + key
+}
+
+// partKey è la chiave memorizzata di una parte interna di key (vedi partPrefix)
+func partKey(ns int, key, part string) string {
+	return partPrefix + strconv.Itoa(ns) + "\x00" + part + "\x00" + key
+}
+
+func isPartKey(stored string) bool {
+	return strings.HasPrefix(stored, partPrefix)
+}
+
+// splitStorageKey ricava namespace e chiave logica da una chiave memorizzata;
+// per una parte interna restituisce la chiave a cui appartiene
+func splitStorageKey(stored string) (int, string) {
+	prefix := namespacePrefix
+	if isPartKey(stored) {
+		prefix = partPrefix
+	} else if !strings.HasPrefix(stored, namespacePrefix) {
+		return 0, stored
+	}
+	rest := stored[len(prefix):]
+	end := strings.IndexByte(rest, 0)
+	if end < 0 {
+		return 0, stored
+	}
+	ns, err := strconv.Atoi(rest[:end])
+	if err != nil {
+		return 0, stored
+	}
+	rest = rest[end+1:]
+	if prefix == partPrefix {
+		if end = strings.IndexByte(rest, 0); end < 0 {
+			return 0, stored
+		}
+		rest = rest[end+1:]
+	}
+	return ns, rest
+}
+
+// notifyStored notifica un evento su una chiave memorizzata, che può
+// appartenere a un DB diverso da quello della vista (es. lo spill della coda LRU)
+func (c *PodCache) notifyStored(class EventClass, event, stored string) {
+	if c.notifier == nil || isPartKey(stored) {
+		return
+	}
+	ns, key := splitStorageKey(stored)
+	owners := c.databases.Load().owners
+	if ns >= len(owners) {
+		return
+	}
+	c.notifier(owners[ns], class, event, key)
+}
+
+// lockAll acquisisce i lock di tutte le stripe in ordine crescente. Una
+// vista di Atomic che ne possiede solo alcuni non può completarli senza
+// rischiare un deadlock, per questo restituisce ErrPartitionsHeld.
+func (c *PodCache) lockAll() (func(), error) {
+	if c.heldAll {
+		return func() {}, nil
+	}
+	if c.held {
+		return nil, ErrPartitionsHeld
+	}
+	wait := c.span.Child("cache.lock_wait")
+	wait.SetAttr("cache.stripes", len(c.locks))
+	for i := range c.locks {
+		c.locks[i].Lock()
+	}
+	wait.End()
+	return func() {
+		for i := len(c.locks) - 1; i >= 0; i-- {
+			c.locks[i].Unlock()
+		}
+	}, nil
+}
+
+// AtomicAll esegue fn con i lock di tutte le stripe, per le transazioni
+// che contengono comandi sull'intero keyspace come SWAPDB e FLUSHDB
+func (c *PodCache) AtomicAll(fn func(view *PodCache) error) error {
+	unlock, err := c.lockAll()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	view := *c
+	view.held = true
+	view.heldAll = true
+	return fn(&view)
+}
+
+// SwapDB scambia il contenuto di due DB: i client connessi a uno vedono
+// subito i dati dell'altro. Le chiavi in WATCH di entrambi risultano modificate.
+func (c *PodCache) SwapDB(a, b int) error {
+	n := c.Databases()
+	if a < 0 || a >= n || b < 0 || b >= n {
+		return ErrInvalidDB
+	}
+	unlock, err := c.lockAll()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if a == b {
+		return nil
+	}
+	current := c.databases.Load()
+	swapped := &databaseMap{
+		namespaces: append([]int(nil), current.namespaces...),
+		owners:     append([]int(nil), current.owners...),
+		keys:       current.keys,
+	}
+	nsA, nsB := swapped.namespaces[a], swapped.namespaces[b]
+	swapped.namespaces[a], swapped.namespaces[b] = nsB, nsA
+	swapped.owners[nsA], swapped.owners[nsB] = b, a
+	c.databases.Store(swapped)
+
+	for _, table := range c.watches {
+		for id, w := range table {
+			if id.db == a || id.db == b {
+				w.version++
+			}
+		}
+	}
+	return nil
+}
+
+// Move sposta key dal DB della vista al DB indicato, con le sue parti interne.
+// Restituisce false se key non esiste o esiste già nel DB di destinazione.
+// Il lock della stripe basta per entrambi i DB perché dipende solo dalla chiave logica.
+func (c *PodCache) Move(key string, db int) (bool, error) {
+	target, err := c.Select(db)
+	if err != nil {
+		return false, err
+	}
+	if db == c.db {
+		return false, ErrSameDB
+	}
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	value, err := c.get(key)
+	if err != nil || value == nil {
+		return false, err
+	}
+	if existing, err := target.get(key); err != nil || existing != nil {
+		return false, err
+	}
+
+	stored := target.storageKey(key)
+	from, err := partsOf(c.namespace(), key, value)
+	if err != nil {
+		return false, err
+	}
+	to, _ := partsOf(target.namespace(), key, value)
+	for i, part := range from {
+		data, err := c.getStored(key, part)
+		if err != nil {
+			return false, err
+		}
+		if data == nil {
+			continue
+		}
+		if _, _, err := c.putStored(key, to[i], data); err != nil {
+			return false, err
+		}
+	}
+	if len(from) > 0 {
+		c.parted[stripeIndex(key)][stored] = true
+	}
+	if err := target.putValue(key, stored, value); err != nil {
+		return false, err
+	}
+	c.evict(key)
+	c.notify(EventGeneric, "move_from", key)
+	target.notify(EventGeneric, "move_to", key)
+	return true, nil
+}
+
+// storedKeys restituisce le chiavi logiche del namespace presenti in una
+// partizione RAM o su disco; le chiavi sono in uno solo dei due livelli
+func (c *PodCache) storedKeys(ns int) []string {
+	var keys []string
+	collect := func(stored []string) {
+		for _, s := range stored {
+			if isPartKey(s) {
+				continue
+			}
+			if keyNS, key := splitStorageKey(s); keyNS == ns {
+				keys = append(keys, key)
+			}
+		}
+	}
+	table := c.table.Load()
+	for _, partition := range table.all() {
+		collect(partition.Keys())
+	}
+	collect(c.disk_cache.Keys())
+	if table.old != nil {
+		// una chiave in spostamento può comparire in entrambe le partizioni
+		keys = dedupe(keys)
+	}
+	return keys
+}
+
+// DBSize restituisce il numero di chiavi del DB della vista
+func (c *PodCache) DBSize() int {
+	m := c.databases.Load()
+	return int(m.keys[m.namespaces[c.db]].Load())
+}
+
+// countKey aggiorna il numero di chiavi del namespace di una chiave memorizzata
+func (c *PodCache) countKey(stored string, delta int64) {
+	ns, _ := splitStorageKey(stored)
+	if keys := c.databases.Load().keys; ns < len(keys) {
+		keys[ns].Add(delta)
+	}
+}
+
+// FlushDB rimuove tutte le chiavi del DB della vista
+func (c *PodCache) FlushDB() error {
+	unlock, err := c.lockAll()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	c.flush()
+	return nil
+}
+
+// FlushAll rimuove le chiavi di tutti i DB
+func (c *PodCache) FlushAll() error {
+	unlock, err := c.lockAll()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for db := 0; db < c.Databases(); db++ {
+		view := *c
+		view.db = db
+		view.flush()
+	}
+	return nil
+}
+
+// flush assume che il caller abbia tutti i lock; come in Redis non genera
+// eventi del keyspace per le singole chiavi, ma invalida WATCH e tracking
+func (c *PodCache) flush() {
+	view := *c
+	view.notifier = nil
+	for _, key := range c.storedKeys(c.databases.Load().namespaces[c.db]) {
+		view.evict(key)
+	}
+}
+
+// Keyspace restituisce il numero di chiavi dei DB non vuoti, in ordine di indice
+func (c *PodCache) Keyspace() []KeyspaceStats {
+	m := c.databases.Load()
+	var stats []KeyspaceStats
+	for ns := range m.keys {
+		if keys := m.keys[ns].Load(); keys > 0 {
+			stats = append(stats, KeyspaceStats{DB: m.owners[ns], Keys: uint64(keys)})
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].DB < stats[j].DB })
+	return stats
+}
+
+// watchID identifica una chiave osservata con WATCH nel suo DB
+type watchID struct {
+	db  int
+	key string
+}
@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func selectDB(t *testing.T, c *PodCache, db int) *PodCache {
+	t.Helper()
+	view, err := c.Select(db)
+	if err != nil {
+		t.Fatalf("Select(%d) returned an error: %v", db, err)
+	}
+	return view
+}
+
+func TestDatabasesIsolation(t *testing.T) {
+	c := newTestPodCache(t, 2, 1024*1024)
+	db1 := selectDB(t, c, 1)
+
+	c.Put("k", []byte("zero"))
+	db1.Put("k", []byte("one"))
+	db1.Put("only1", []byte("x"))
+
+	if value, _ := c.Get("k"); string(value) != "zero" {
+		t.Fatalf("db0 Get(k) = %q, want zero", value)
+	}
+	if value, _ := db1.Get("k"); string(value) != "one" {
+		t.Fatalf("db1 Get(k) = %q, want one", value)
+	}
+	if value, _ := c.Get("only1"); value != nil {
+		t.Fatalf("db0 sees a key of db1: %q", value)
+	}
+	if c.DBSize() != 1 || db1.DBSize() != 2 {
+		t.Fatalf("DBSize() = %d/%d, want 1/2", c.DBSize(), db1.DBSize())
+	}
+
+	if _, err := c.Select(DefaultDatabases); err != ErrInvalidDB {
+		t.Fatalf("Select(out of range) = %v, want ErrInvalidDB", err)
+	}
+}
+
+// una chiave di db0 che imita il prefisso di un altro DB resta in db0
+func TestDatabasesPrefixedClientKey(t *testing.T) {
+	c := newTestPodCache(t, 2, 1024*1024)
+	db1 := selectDB(t, c, 1)
+	key := namespacePrefix + "1\x00foo"
+
+	if err := c.Put(key, []byte("zero")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := db1.Get("foo"); value != nil {
+		t.Fatalf("db1 Get(foo) = %q, want a miss", value)
+	}
+	if c.DBSize() != 1 || db1.DBSize() != 0 {
+		t.Fatalf("DBSize() = %d/%d, want 1/0", c.DBSize(), db1.DBSize())
+	}
+	if err := db1.FlushDB(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := c.Get(key); string(value) != "zero" {
+		t.Fatalf("db0 Get(%q) after FLUSHDB on db1 = %q, want zero", key, value)
+	}
+	if got := c.Keyspace(); !reflect.DeepEqual(got, []KeyspaceStats{{DB: 0, Keys: 1}}) {
+		t.Fatalf("Keyspace() = %v", got)
+	}
+}
+
+func TestDatabasesSharedBudget(t *testing.T) {
+	c := newTestPodCache(t, 1, 10)
+	type dbEvent struct {
+		db    int
+		event string
+		key   string
+	}
+	var events []dbEvent
+	c.SetNotifier(func(db int, _ EventClass, event, key string) {
+		events = append(events, dbEvent{db, event, key})
+	})
+	db1 := selectDB(t, c, 1)
+
+	db1.Put("a", []byte("11111"))
+	c.Put("b", []byte("22222"))
+	// la coda LRU è la chiave di db1 e finisce su disco per far posto a quella di db0
+	c.Put("c", []byte("33333"))
+
+	want := []dbEvent{{1, "set", "a"}, {0, "set", "b"}, {1, EventSpill, "a"}, {0, "set", "c"}}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	if value, _ := db1.Get("a"); string(value) != "11111" {
+		t.Fatalf("db1 Get(a) after spill = %q", value)
+	}
+	if c.DBSize() != 2 || db1.DBSize() != 1 {
+		t.Fatalf("DBSize() = %d/%d, want 2/1", c.DBSize(), db1.DBSize())
+	}
+
+	// i contatori seguono le chiavi riscritte o cancellate su disco
+	db1.Put("a", []byte("1"))
+	if db1.DBSize() != 1 {
+		t.Fatalf("db1 DBSize() after overwriting a key on disk = %d, want 1", db1.DBSize())
+	}
+	c.Evict("b")
+	c.Evict("b")
+	db1.Evict("a")
+	if got := c.Keyspace(); !reflect.DeepEqual(got, []KeyspaceStats{{DB: 0, Keys: 1}}) {
+		t.Fatalf("Keyspace() after deletes = %v", got)
+	}
+}
+
+func TestSwapDB(t *testing.T) {
+	c := newTestPodCache(t, 2, 1024*1024)
+	db1 := selectDB(t, c, 1)
+	c.Put("k", []byte("zero"))
+	db1.Put("k", []byte("one"))
+	before := db1.Watch("k")
+
+	if err := c.SwapDB(0, 1); err != nil {
+		t.Fatalf("SwapDB() returned an error: %v", err)
+	}
+	if value, _ := c.Get("k"); string(value) != "one" {
+		t.Fatalf("db0 Get(k) after swap = %q, want one", value)
+	}
+	if value, _ := db1.Get("k"); string(value) != "zero" {
+		t.Fatalf("db1 Get(k) after swap = %q, want zero", value)
+	}
+	if after := db1.Versions("k"); after[0] == before[0] {
+		t.Fatal("SwapDB did not touch the keys watched in the swapped databases")
+	}
+	if got := c.Keyspace(); !reflect.DeepEqual(got, []KeyspaceStats{{DB: 0, Keys: 1}, {DB: 1, Keys: 1}}) {
+		t.Fatalf("Keyspace() = %v", got)
+	}
+	if err := c.SwapDB(0, DefaultDatabases); err != ErrInvalidDB {
+		t.Fatalf("SwapDB(out of range) = %v, want ErrInvalidDB", err)
+	}
+}
+
+func TestFlushDB(t *testing.T) {
+	c := newTestPodCache(t, 2, 1024*1024)
+	db1 := selectDB(t, c, 1)
+	c.Put("a", []byte("1"))
+	db1.Put("a", []byte("1"))
+	db1.Put("b", []byte("2"))
+
+	if err := db1.FlushDB(); err != nil {
+		t.Fatalf("FlushDB() returned an error: %v", err)
+	}
+	if db1.DBSize() != 0 || c.DBSize() != 1 {
+		t.Fatalf("DBSize() after FlushDB = %d/%d, want 1/0", c.DBSize(), db1.DBSize())
+	}
+
+	// dentro Atomic con solo alcuni lock non si possono prendere tutti
+	err := c.Atomic([]string{"a"}, func(view *PodCache) error {
+		return view.FlushDB()
+	})
+	if err != ErrPartitionsHeld {
+		t.Fatalf("FlushDB() inside Atomic = %v, want ErrPartitionsHeld", err)
+	}
+	err = c.AtomicAll(func(view *PodCache) error {
+		return view.FlushAll()
+	})
+	if err != nil || c.DBSize() != 0 {
+		t.Fatalf("FlushAll() inside AtomicAll = %v, DBSize() = %d", err, c.DBSize())
+	}
+}
+
+func TestMove(t *testing.T) {
+	c := newTestPodCache(t, 2, 1024*1024)
+	db1 := selectDB(t, c, 1)
+	for i := 0; i < 3*streamChunkSize; i++ {
+		c.XAdd("s", "*", []string{"f", "v"}, false, nil)
+	}
+	before := db1.Watch("s")
+
+	if moved, err := c.Move("s", 1); err != nil || !moved {
+		t.Fatalf("Move(s) = %v, %v, want true", moved, err)
+	}
+	if n, _ := db1.XLen("s"); n != 3*streamChunkSize {
+		t.Fatalf("db1 XLen(s) after Move = %d, want %d", n, 3*streamChunkSize)
+	}
+	if after := db1.Versions("s"); after[0] == before[0] {
+		t.Fatal("Move did not touch the key in the destination DB")
+	}
+	if got := c.Keyspace(); !reflect.DeepEqual(got, []KeyspaceStats{{DB: 1, Keys: 1}}) {
+		t.Fatalf("Keyspace() after Move = %v", got)
+	}
+
+	// le parti seguono la chiave: nessuna resta nel namespace di origine
+	for _, partition := range c.table.Load().partitions {
+		for _, stored := range partition.Keys() {
+			if ns, _ := splitStorageKey(stored); ns == 0 {
+				t.Fatalf("%q left in DB 0 after Move", stored)
+			}
+		}
+	}
+
+	db1.Put("k", []byte("one"))
+	c.Put("k", []byte("zero"))
+	if moved, err := c.Move("k", 1); err != nil || moved {
+		t.Fatalf("Move onto an existing key = %v, %v, want false", moved, err)
+	}
+	if moved, err := c.Move("missing", 1); err != nil || moved {
+		t.Fatalf("Move(missing) = %v, %v, want false", moved, err)
+	}
+	if _, err := c.Move("k", 0); err != ErrSameDB {
+		t.Fatalf("Move to the same DB = %v, want ErrSameDB", err)
+	}
+	if _, err := c.Move("k", DefaultDatabases); err != ErrInvalidDB {
+		t.Fatalf("Move(out of range) = %v, want ErrInvalidDB", err)
+	}
+}
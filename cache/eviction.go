@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EvictionPolicy decide cosa succede a una scrittura che non sta nella partizione
+type EvictionPolicy int32
+
+const (
+	// PolicyAllKeysLRU sposta su disco le chiavi meno usate finché il valore non
+	// sta in RAM: è il comportamento predefinito
+	PolicyAllKeysLRU EvictionPolicy = iota
+	// PolicyNoEviction rifiuta la scrittura con ErrOutOfMemory
+	PolicyNoEviction
+)
+
+var ErrOutOfMemory = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+var evictionPolicyNames = [...]string{"allkeys-lru", "noeviction"}
+
+func (p EvictionPolicy) String() string {
+	if int(p) < len(evictionPolicyNames) {
+		return evictionPolicyNames[p]
+	}
+	return fmt.Sprintf("EvictionPolicy(%d)", int32(p))
+}
+
+// EvictionPolicyNames restituisce i nomi accettati da ParseEvictionPolicy
+func EvictionPolicyNames() []string {
+	return evictionPolicyNames[:]
+}
+
+// ParseEvictionPolicy interpreta il nome di maxmemory-policy
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	for i, n := range evictionPolicyNames {
+		if n == name {
+			return EvictionPolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown eviction policy %s", name)
+}
@@ -0,0 +1,523 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+// Indicizzazione geografica compatibile con Redis: ogni membro di un sorted set
+// ha come score il geohash a 52 bit (26 step per asse) delle sue coordinate,
+// con le stesse costanti, lo stesso interleaving e la stessa formula di distanza
+// di geohash.c / geohash_helper.c, così coordinate e distanze coincidono.
+
+const (
+	GeoLatMin  = -85.05112878
+	GeoLatMax  = 85.05112878
+	GeoLongMin = -180.0
+	GeoLongMax = 180.0
+
+	geoStepMax          = 26
+	earthRadiusInMeters = 6372797.560856
+	mercatorMax         = 20037726.37
+)
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+var ErrGeoMemberMissing = errors.New("could not decode requested zset member")
+
+type GeoPoint struct {
+	Longitude float64
+	Latitude  float64
+}
+
+// GeoSort indica l'ordinamento dei risultati di GeoSearch
+type GeoSort int
+
+const (
+	GeoSortNone GeoSort = iota
+	GeoSortAsc
+	GeoSortDesc
+)
+
+// GeoQuery descrive una ricerca GEOSEARCH; le dimensioni sono in metri
+type GeoQuery struct {
+	FromMember string
+	UseMember  bool
+	Center     GeoPoint
+
+	ByBox  bool
+	Radius float64
+	Width  float64
+	Height float64
+
+	Sort  GeoSort
+	Count int
+	Any   bool
+}
+
+type GeoResult struct {
+	Member   string
+	Distance float64
+	Hash     uint64
+	Point    GeoPoint
+}
+
+type GeoMember struct {
+	Member string
+	Point  GeoPoint
+}
+
+func ValidateGeoPoint(p GeoPoint) error {
+	if p.Longitude < GeoLongMin || p.Longitude > GeoLongMax ||
+		p.Latitude < GeoLatMin || p.Latitude > GeoLatMax {
+		return fmt.Errorf("invalid longitude,latitude pair %f,%f", p.Longitude, p.Latitude)
+	}
+	return nil
+}
+
+// spread sposta i 32 bit bassi di v sulle posizioni pari di un uint64
+func spread(v uint64) uint64 {
+	v &= 0xffffffff
+	v = (v | v<<16) & 0x0000ffff0000ffff
+	v = (v | v<<8) & 0x00ff00ff00ff00ff
+	v = (v | v<<4) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+// squash è l'inverso di spread: raccoglie i bit in posizione pari
+func squash(v uint64) uint64 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v>>4) & 0x00ff00ff00ff00ff
+	v = (v | v>>8) & 0x0000ffff0000ffff
+	v = (v | v>>16) & 0x00000000ffffffff
+	return v
+}
+
+// geohashEncode interleava latitudine (bit pari) e longitudine (bit dispari)
+func geohashEncode(p GeoPoint, latMin, latMax float64, step uint) uint64 {
+	latOffset := (p.Latitude - latMin) / (latMax - latMin)
+	longOffset := (p.Longitude - GeoLongMin) / (GeoLongMax - GeoLongMin)
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return spread(uint64(latOffset)) | spread(uint64(longOffset))<<1
+}
+
+// geohashDecode restituisce il centro della cella identificata dal geohash
+func geohashDecode(bits uint64) GeoPoint {
+	lat := squash(bits)
+	long := squash(bits >> 1)
+
+	cells := float64(uint64(1) << geoStepMax)
+	latScale := GeoLatMax - GeoLatMin
+	longScale := GeoLongMax - GeoLongMin
+
+	latMinCell := GeoLatMin + (float64(lat)/cells)*latScale
+	latMaxCell := GeoLatMin + (float64(lat+1)/cells)*latScale
+	longMinCell := GeoLongMin + (float64(long)/cells)*longScale
+	longMaxCell := GeoLongMin + (float64(long+1)/cells)*longScale
+
+	return GeoPoint{
+		Longitude: math.Max(GeoLongMin, math.Min(GeoLongMax, (longMinCell+longMaxCell)/2)),
+		Latitude:  math.Max(GeoLatMin, math.Min(GeoLatMax, (latMinCell+latMaxCell)/2)),
+	}
+}
+
+func geoScore(p GeoPoint) float64 {
+	return float64(geohashEncode(p, GeoLatMin, GeoLatMax, geoStepMax))
+}
+
+// GeohashString restituisce il geohash standard a 11 caratteri (latitudine ±90)
+func GeohashString(p GeoPoint) string {
+	bits := geohashEncode(p, -90, 90, geoStepMax)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := uint64(0)
+		// l'ultimo carattere richiederebbe 55 bit, Redis lo fissa a '0'
+		if i < 10 {
+			idx = (bits >> (52 - uint((i+1)*5))) & 0x1f
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return string(buf)
+}
+
+func degRad(deg float64) float64 {
+	return deg * math.Pi / 180.0
+}
+
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return earthRadiusInMeters * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+// GeoDistance è la formula di haversine usata da Redis, in metri
+func GeoDistance(a, b GeoPoint) float64 {
+	lon1r := degRad(a.Longitude)
+	lon2r := degRad(b.Longitude)
+	v := math.Sin((lon2r - lon1r) / 2)
+	if v == 0 {
+		return geoLatDistance(a.Latitude, b.Latitude)
+	}
+	lat1r := degRad(a.Latitude)
+	lat2r := degRad(b.Latitude)
+	u := math.Sin((lat2r - lat1r) / 2)
+	h := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2.0 * earthRadiusInMeters * math.Asin(math.Sqrt(h))
+}
+
+// geoCell è una cella del geohash con step bit per asse; step 0 indica una
+// cella esclusa dalla ricerca
+type geoCell struct {
+	bits uint64
+	step uint
+}
+
+// scoreRange restituisce gli score [min, max) dei membri contenuti nella cella
+func (cell geoCell) scoreRange() (float64, float64) {
+	shift := 2 * (geoStepMax - cell.step)
+	return float64(cell.bits << shift), float64((cell.bits + 1) << shift)
+}
+
+// bounds restituisce latitudine e longitudine minime e massime della cella
+func (cell geoCell) bounds() (latMin, latMax, longMin, longMax float64) {
+	lat := float64(squash(cell.bits))
+	long := float64(squash(cell.bits >> 1))
+	cells := float64(uint64(1) << cell.step)
+	latScale := GeoLatMax - GeoLatMin
+	longScale := GeoLongMax - GeoLongMin
+	return GeoLatMin + lat/cells*latScale, GeoLatMin + (lat+1)/cells*latScale,
+		GeoLongMin + long/cells*longScale, GeoLongMin + (long+1)/cells*longScale
+}
+
+// move sposta la cella di una colonna (longitudine, bit dispari) nel verso di
+// dx e di una riga (latitudine, bit pari) nel verso di dy, ricominciando dal
+// lato opposto ai bordi come geohash_move_x e geohash_move_y
+func (cell geoCell) move(dx, dy int) geoCell {
+	mask := ^uint64(0) >> (64 - 2*cell.step)
+	x := cell.bits & 0xaaaaaaaaaaaaaaaa
+	y := cell.bits & 0x5555555555555555
+	// il riporto della somma attraversa i bit dell'altro asse messi a 1,
+	// il prestito della sottrazione quelli a 0
+	switch {
+	case dx > 0:
+		x = (x | 0x5555555555555555) + 1
+	case dx < 0:
+		x -= 2
+	}
+	switch {
+	case dy > 0:
+		y = (y | 0xaaaaaaaaaaaaaaaa) + 1
+	case dy < 0:
+		y--
+	}
+	return geoCell{bits: (x&0xaaaaaaaaaaaaaaaa | y&0x5555555555555555) & mask, step: cell.step}
+}
+
+// geoEstimateSteps è geohashEstimateStepsByRadius: la precisione più alta con
+// cui la cella del centro e le sue 8 vicine coprono il raggio
+func geoEstimateSteps(radius, latitude float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	// verso i poli le celle si restringono in longitudine
+	step -= 2
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	return uint(max(1, min(geoStepMax, step)))
+}
+
+// boundingBox è geohashBoundingBox: il rettangolo in gradi che contiene la
+// forma della query
+func (q *GeoQuery) boundingBox() (latMin, latMax, longMin, longMax float64) {
+	width, height := q.Radius, q.Radius
+	if q.ByBox {
+		width, height = q.Width/2, q.Height/2
+	}
+	latDelta := height / earthRadiusInMeters * 180 / math.Pi
+	// i gradi di longitudine si misurano sul lato verso il polo, dove sono più corti
+	lat := q.Center.Latitude + latDelta
+	if q.Center.Latitude < 0 {
+		lat = q.Center.Latitude - latDelta
+	}
+	longDelta := width / earthRadiusInMeters / math.Cos(degRad(lat)) * 180 / math.Pi
+	return q.Center.Latitude - latDelta, q.Center.Latitude + latDelta,
+		q.Center.Longitude - longDelta, q.Center.Longitude + longDelta
+}
+
+// cells restituisce le celle da scandire come geohashGetAreasByShape: quella
+// del centro e le 8 vicine, alla precisione stimata dal raggio, senza quelle
+// che cadono fuori dal bounding box della query
+func (q *GeoQuery) cells() []geoCell {
+	radius := q.Radius
+	if q.ByBox {
+		radius = math.Sqrt(q.Width*q.Width/4 + q.Height*q.Height/4)
+	}
+	latMin, latMax, longMin, longMax := q.boundingBox()
+
+	step := geoEstimateSteps(radius, q.Center.Latitude)
+	neighbours := func(step uint) [9]geoCell {
+		center := geoCell{bits: geohashEncode(q.Center, GeoLatMin, GeoLatMax, step), step: step}
+		return [9]geoCell{
+			center,
+			center.move(0, 1), center.move(0, -1), center.move(1, 0), center.move(-1, 0),
+			center.move(1, 1), center.move(-1, 1), center.move(1, -1), center.move(-1, -1),
+		}
+	}
+	cells := neighbours(step)
+
+	// se le vicine non arrivano ai bordi del bounding box serve una cella più grande
+	_, northMax, _, _ := cells[1].bounds()
+	southMin, _, _, _ := cells[2].bounds()
+	_, _, _, eastMax := cells[3].bounds()
+	_, _, westMin, _ := cells[4].bounds()
+	if step > 1 && (northMax < latMax || southMin > latMin || eastMax < longMax || westMin > longMin) {
+		step--
+		cells = neighbours(step)
+	}
+
+	if step >= 2 {
+		areaLatMin, areaLatMax, areaLongMin, areaLongMax := cells[0].bounds()
+		exclude := func(indexes ...int) {
+			for _, i := range indexes {
+				cells[i] = geoCell{}
+			}
+		}
+		if areaLatMin < latMin {
+			exclude(2, 7, 8)
+		}
+		if areaLatMax > latMax {
+			exclude(1, 5, 6)
+		}
+		if areaLongMin < longMin {
+			exclude(4, 6, 8)
+		}
+		if areaLongMax > longMax {
+			exclude(3, 5, 7)
+		}
+	}
+
+	// con raggi molto grandi le vicine possono coincidere
+	result := make([]geoCell, 0, len(cells))
+	for _, cell := range cells {
+		if cell.step != 0 && !slices.Contains(result, cell) {
+			result = append(result, cell)
+		}
+	}
+	return result
+}
+
+// match riporta se il punto soddisfa la query e la sua distanza dal centro
+func (q *GeoQuery) match(p GeoPoint) (float64, bool) {
+	if !q.ByBox {
+		distance := GeoDistance(q.Center, p)
+		return distance, distance <= q.Radius
+	}
+
+	// come Redis: prima la distanza in latitudine, poi quella in longitudine
+	if geoLatDistance(p.Latitude, q.Center.Latitude) > q.Height/2 {
+		return 0, false
+	}
+	if GeoDistance(GeoPoint{Longitude: p.Longitude, Latitude: p.Latitude}, GeoPoint{Longitude: q.Center.Longitude, Latitude: p.Latitude}) > q.Width/2 {
+		return 0, false
+	}
+	return GeoDistance(q.Center, p), true
+}
+
+// GeoAdd aggiunge o aggiorna i membri; con ch conta anche quelli modificati
+func (c *PodCache) GeoAdd(key string, members []GeoMember, nx, xx, ch bool) (int, error) {
+	for _, m := range members {
+		if err := ValidateGeoPoint(m.Point); err != nil {
+			return 0, err
+		}
+	}
+
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	zset, err := c.loadZSet(key)
+	if err != nil {
+		return 0, err
+	}
+	if zset == nil {
+		zset = c.newZSet(key)
+	}
+
+	count, modified := 0, false
+	for _, m := range members {
+		added, changed := zset.Add(m.Member, geoScore(m.Point), nx, xx)
+		if added || (ch && changed) {
+			count++
+		}
+		modified = modified || added || changed
+	}
+	if !modified {
+		return 0, zset.err
+	}
+
+	if err := c.saveZSet(zset); err != nil {
+		return 0, err
+	}
+	// GEOADD è uno ZADD sul sorted set sottostante
+	c.notify(EventZSet, "zadd", key)
+	return count, nil
+}
+
+// GeoPos restituisce le coordinate dei membri; nil per i membri assenti
+func (c *PodCache) GeoPos(key string, members ...string) ([]*GeoPoint, error) {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	zset, err := c.loadZSet(key)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*GeoPoint, len(members))
+	if zset == nil {
+		return result, nil
+	}
+	for i, member := range members {
+		if score, ok := zset.Score(member); ok {
+			p := geohashDecode(uint64(score))
+			result[i] = &p
+		}
+	}
+	return result, zset.err
+}
+
+// GeoDist restituisce la distanza in metri tra due membri; false se uno dei due manca
+func (c *PodCache) GeoDist(key, member1, member2 string) (float64, bool, error) {
+	positions, err := c.GeoPos(key, member1, member2)
+	if err != nil {
+		return 0, false, err
+	}
+	if positions[0] == nil || positions[1] == nil {
+		return 0, false, nil
+	}
+	return GeoDistance(*positions[0], *positions[1]), true, nil
+}
+
+// GeoHash restituisce il geohash testuale dei membri; stringa vuota per i membri assenti
+func (c *PodCache) GeoHash(key string, members ...string) ([]string, error) {
+	positions, err := c.GeoPos(key, members...)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, len(positions))
+	for i, p := range positions {
+		if p != nil {
+			result[i] = GeohashString(*p)
+		}
+	}
+	return result, nil
+}
+
+func (c *PodCache) geoSearch(key string, q GeoQuery) ([]GeoResult, error) {
+	zset, err := c.loadZSet(key)
+	if err != nil || zset == nil {
+		return nil, err
+	}
+
+	if q.UseMember {
+		score, ok := zset.Score(q.FromMember)
+		if !ok {
+			return nil, ErrGeoMemberMissing
+		}
+		q.Center = geohashDecode(uint64(score))
+	}
+
+	// come Redis scandisce solo gli intervalli di score delle celle vicine al centro
+	var results []GeoResult
+search:
+	for _, cell := range q.cells() {
+		for _, m := range zset.rangeByScore(cell.scoreRange()) {
+			p := geohashDecode(uint64(m.Score))
+			distance, ok := q.match(p)
+			if !ok {
+				continue
+			}
+			results = append(results, GeoResult{Member: m.Member, Distance: distance, Hash: uint64(m.Score), Point: p})
+			if q.Any && q.Count > 0 && len(results) == q.Count {
+				break search
+			}
+		}
+	}
+
+	if zset.err != nil {
+		return nil, zset.err
+	}
+
+	sortMode := q.Sort
+	if q.Count > 0 && sortMode == GeoSortNone && !q.Any {
+		sortMode = GeoSortAsc
+	}
+	switch sortMode {
+	case GeoSortAsc:
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Distance < results[j].Distance })
+	case GeoSortDesc:
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Distance > results[j].Distance })
+	}
+
+	if q.Count > 0 && len(results) > q.Count {
+		results = results[:q.Count]
+	}
+	return results, nil
+}
+
+// GeoSearch restituisce i membri entro il raggio o il rettangolo della query
+func (c *PodCache) GeoSearch(key string, q GeoQuery) ([]GeoResult, error) {
+	unlock := c.lockKeys(key)
+	defer unlock()
+	return c.geoSearch(key, q)
+}
+
+// GeoSearchStore salva i risultati in dest come sorted set. Con storeDist lo score
+// è la distanza divisa per unit, altrimenti il geohash del membro.
+func (c *PodCache) GeoSearchStore(dest, source string, q GeoQuery, storeDist bool, unit float64) (int, error) {
+	unlock := c.lockKeys(dest, source)
+	defer unlock()
+
+	results, err := c.geoSearch(source, q)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(results) == 0 {
+		if c.evict(dest) {
+			c.notify(EventGeneric, "del", dest)
+		}
+		return 0, nil
+	}
+
+	// il nuovo insieme sostituisce dest: le sue parti precedenti vanno tolte
+	// prima di scrivere quelle nuove
+	c.dropParts(dest, c.storageKey(dest))
+	zset := c.newZSet(dest)
+	for _, r := range results {
+		score := float64(r.Hash)
+		if storeDist {
+			score = r.Distance / unit
+		}
+		zset.Add(r.Member, score, false, false)
+	}
+	if err := c.saveZSet(zset); err != nil {
+		return 0, err
+	}
+	c.notify(EventZSet, "geosearchstore", dest)
+	return len(results), nil
+}
@@ -0,0 +1,214 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// valori di riferimento presi dagli esempi della documentazione Redis
+func addSicily(t *testing.T, c *PodCache) {
+	t.Helper()
+	_, err := c.GeoAdd("Sicily", []GeoMember{
+		{Member: "Palermo", Point: GeoPoint{Longitude: 13.361389, Latitude: 38.115556}},
+		{Member: "Catania", Point: GeoPoint{Longitude: 15.087269, Latitude: 37.502669}},
+		{Member: "edge1", Point: GeoPoint{Longitude: 12.758489, Latitude: 38.788135}},
+		{Member: "edge2", Point: GeoPoint{Longitude: 17.241510, Latitude: 38.788135}},
+	}, false, false, false)
+	if err != nil {
+		t.Fatalf("GeoAdd() returned an error: %v", err)
+	}
+}
+
+func TestGeoDistAndHash(t *testing.T) {
+	c := newTestPodCache(t, 2, 1024*1024)
+	addSicily(t, c)
+
+	distance, found, err := c.GeoDist("Sicily", "Palermo", "Catania")
+	if err != nil || !found {
+		t.Fatalf("GeoDist() = %v, %v, %v", distance, found, err)
+	}
+	if got := fmt.Sprintf("%.4f", distance); got != "166274.1516" {
+		t.Fatalf("GeoDist() = %s, want 166274.1516", got)
+	}
+
+	hashes, _ := c.GeoHash("Sicily", "Palermo", "Catania", "missing")
+	if hashes[0] != "sqc8b49rny0" || hashes[1] != "sqdtr74hyu0" || hashes[2] != "" {
+		t.Fatalf("GeoHash() = %v", hashes)
+	}
+
+	positions, _ := c.GeoPos("Sicily", "Palermo")
+	if math.Abs(positions[0].Longitude-13.36138933897018433) > 1e-12 ||
+		math.Abs(positions[0].Latitude-38.11555639549629859) > 1e-12 {
+		t.Fatalf("GeoPos() = %+v", positions[0])
+	}
+
+	zset, _ := c.loadZSet("Sicily")
+	if score, _ := zset.Score("Palermo"); uint64(score) != 3479099956230698 {
+		t.Fatalf("geohash score = %d, want 3479099956230698", uint64(score))
+	}
+}
+
+func TestGeoSearch(t *testing.T) {
+	c := newTestPodCache(t, 2, 1024*1024)
+	addSicily(t, c)
+
+	center := GeoPoint{Longitude: 15, Latitude: 37}
+
+	results, err := c.GeoSearch("Sicily", GeoQuery{Center: center, Radius: 200_000, Sort: GeoSortAsc})
+	if err != nil || len(results) != 2 || results[0].Member != "Catania" || results[1].Member != "Palermo" {
+		t.Fatalf("GeoSearch(BYRADIUS) = %+v, %v", results, err)
+	}
+
+	results, _ = c.GeoSearch("Sicily", GeoQuery{Center: center, ByBox: true, Width: 400_000, Height: 400_000, Sort: GeoSortAsc})
+	want := []string{"Catania", "Palermo", "edge2", "edge1"}
+	if len(results) != len(want) {
+		t.Fatalf("GeoSearch(BYBOX) = %+v", results)
+	}
+	for i, r := range results {
+		if r.Member != want[i] {
+			t.Fatalf("GeoSearch(BYBOX)[%d] = %s, want %s", i, r.Member, want[i])
+		}
+	}
+
+	// COUNT senza ordinamento esplicito ordina comunque per distanza crescente
+	results, _ = c.GeoSearch("Sicily", GeoQuery{UseMember: true, FromMember: "Palermo", Radius: 500_000, Count: 1})
+	if len(results) != 1 || results[0].Member != "Palermo" {
+		t.Fatalf("GeoSearch(FROMMEMBER, COUNT 1) = %+v", results)
+	}
+
+	if _, err := c.GeoSearch("Sicily", GeoQuery{UseMember: true, FromMember: "Roma", Radius: 1}); err != ErrGeoMemberMissing {
+		t.Fatalf("GeoSearch() from missing member = %v", err)
+	}
+
+	stored, err := c.GeoSearchStore("near", "Sicily", GeoQuery{Center: center, Radius: 200_000}, true, 1000)
+	if err != nil || stored != 2 {
+		t.Fatalf("GeoSearchStore() = %d, %v", stored, err)
+	}
+	near, _ := c.loadZSet("near")
+	if near.members()[0].Member != "Catania" || math.Abs(near.members()[0].Score-56.4413) > 1e-4 {
+		t.Fatalf("stored distances = %+v", near.members())
+	}
+}
+
+func TestGeoAddValidation(t *testing.T) {
+	c := newTestPodCache(t, 1, 1024*1024)
+
+	if _, err := c.GeoAdd("bad", []GeoMember{{Member: "x", Point: GeoPoint{Longitude: 181}}}, false, false, false); err == nil {
+		t.Fatal("GeoAdd() with invalid longitude should fail")
+	}
+	if _, err := c.GeoAdd("bad", []GeoMember{{Member: "x", Point: GeoPoint{Latitude: 86}}}, false, false, false); err == nil {
+		t.Fatal("GeoAdd() with invalid latitude should fail")
+	}
+
+	addSicily(t, c)
+	added, _ := c.GeoAdd("Sicily", []GeoMember{{Member: "Palermo", Point: GeoPoint{Longitude: 13.4, Latitude: 38.1}}}, false, false, true)
+	if added != 1 {
+		t.Fatalf("GeoAdd(CH) of moved member = %d, want 1", added)
+	}
+	added, _ = c.GeoAdd("Sicily", []GeoMember{{Member: "Palermo", Point: GeoPoint{Longitude: 13.5, Latitude: 38.1}}}, true, false, true)
+	if added != 0 {
+		t.Fatalf("GeoAdd(NX) of existing member = %d, want 0", added)
+	}
+}
+
+func TestGeoCellMove(t *testing.T) {
+	p := GeoPoint{Longitude: 13.361389, Latitude: 38.115556}
+	for _, step := range []uint{1, 2, 10, geoStepMax} {
+		cell := geoCell{bits: geohashEncode(p, GeoLatMin, GeoLatMax, step), step: step}
+		latMin, latMax, longMin, longMax := cell.bounds()
+		height, width := latMax-latMin, longMax-longMin
+
+		// le vicine sono le celle che contengono i punti spostati di una cella
+		for _, d := range [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}, {1, 1}, {-1, -1}} {
+			moved := GeoPoint{Longitude: p.Longitude + float64(d[0])*width, Latitude: p.Latitude + float64(d[1])*height}
+			if moved.Latitude < GeoLatMin || moved.Latitude > GeoLatMax {
+				continue
+			}
+			moved.Longitude = math.Mod(moved.Longitude+540, 360) - 180
+			want := geohashEncode(moved, GeoLatMin, GeoLatMax, step)
+			if got := cell.move(d[0], d[1]); got.bits != want || got.step != step {
+				t.Errorf("step %d move(%d, %d) = %b, want %b", step, d[0], d[1], got.bits, want)
+			}
+		}
+		if back := cell.move(1, 1).move(-1, -1); back != cell {
+			t.Errorf("step %d move(1, 1).move(-1, -1) = %+v, want %+v", step, back, cell)
+		}
+	}
+
+	// all'antimeridiano la cella a est ricomincia da -180
+	east := geoCell{bits: geohashEncode(GeoPoint{Longitude: 179.99, Latitude: 0}, GeoLatMin, GeoLatMax, 8), step: 8}.move(1, 0)
+	if _, _, longMin, _ := east.bounds(); longMin != GeoLongMin {
+		t.Errorf("cell east of the antimeridian starts at %f, want %f", longMin, GeoLongMin)
+	}
+}
+
+// TestGeoSearchCells confronta la ricerca sulle celle vicine con una
+// scansione di tutti i membri
+func TestGeoSearchCells(t *testing.T) {
+	c := newTestPodCache(t, 2, 8*1024*1024)
+	rng := rand.New(rand.NewSource(1))
+
+	centers := []GeoPoint{
+		{Longitude: 13.361389, Latitude: 38.115556},
+		{Longitude: 179.95, Latitude: 0.5},
+		{Longitude: -179.95, Latitude: -0.5},
+		{Longitude: 20, Latitude: 83},
+		{Longitude: -70, Latitude: -70},
+		{Longitude: 0, Latitude: 0},
+	}
+	var members []GeoMember
+	for i := 0; i < 3000; i++ {
+		var p GeoPoint
+		if i%3 == 0 {
+			p = GeoPoint{Longitude: rng.Float64()*360 - 180, Latitude: rng.Float64()*2*GeoLatMax - GeoLatMax}
+		} else {
+			center := centers[i%len(centers)]
+			p = GeoPoint{
+				Longitude: math.Mod(center.Longitude+rng.NormFloat64()*2+540, 360) - 180,
+				Latitude:  math.Max(-85, math.Min(85, center.Latitude+rng.NormFloat64()*2)),
+			}
+		}
+		members = append(members, GeoMember{Member: fmt.Sprintf("m%d", i), Point: p})
+	}
+	if _, err := c.GeoAdd("points", members, false, false, false); err != nil {
+		t.Fatalf("GeoAdd() returned an error: %v", err)
+	}
+	zset, _ := c.loadZSet("points")
+
+	for _, center := range centers {
+		for _, size := range []float64{0, 1_000, 50_000, 300_000, 2_000_000, 8_000_000} {
+			for _, q := range []GeoQuery{
+				{Center: center, Radius: size},
+				{Center: center, ByBox: true, Width: size, Height: size / 2},
+			} {
+				want := make(map[string]bool)
+				for _, m := range zset.members() {
+					if _, ok := q.match(geohashDecode(uint64(m.Score))); ok {
+						want[m.Member] = true
+					}
+				}
+				results, err := c.GeoSearch("points", q)
+				if err != nil {
+					t.Fatalf("GeoSearch(%+v) returned an error: %v", q, err)
+				}
+				got := make(map[string]bool)
+				for _, r := range results {
+					if got[r.Member] {
+						t.Fatalf("GeoSearch(%+v) returned %s twice", q, r.Member)
+					}
+					got[r.Member] = true
+				}
+				if len(got) != len(want) {
+					t.Fatalf("GeoSearch(%+v) returned %d members, want %d", q, len(got), len(want))
+				}
+				for member := range want {
+					if !got[member] {
+						t.Fatalf("GeoSearch(%+v) is missing %s", q, member)
+					}
+				}
+			}
+		}
+	}
+}
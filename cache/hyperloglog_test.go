@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"mi0772/podcache/logging"
+	"testing"
+)
+
+func newTestPodCache(t *testing.T, partitions uint8, capacity uint64) *PodCache {
+	t.Helper()
+
+	c, err := NewPodCache(partitions, capacity, t.TempDir(), logging.NewNoOpLogger())
+	if err != nil {
+		t.Fatalf("NewPodCache() returned an error: %v", err)
+	}
+	return c
+}
+
+func TestPFAddCount(t *testing.T) {
+	c := newTestPodCache(t, 3, 1024*1024)
+
+	updated, err := c.PFAdd("hll", []byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e"), []byte("f"), []byte("g"))
+	if err != nil || !updated {
+		t.Fatalf("PFAdd() = %v, %v", updated, err)
+	}
+
+	updated, err = c.PFAdd("hll", []byte("a"))
+	if err != nil || updated {
+		t.Fatalf("PFAdd() of existing element = %v, %v, want false", updated, err)
+	}
+
+	count, err := c.PFCount("hll")
+	if err != nil {
+		t.Fatalf("PFCount() returned an error: %v", err)
+	}
+	if count != 7 {
+		t.Fatalf("PFCount() = %d, want 7", count)
+	}
+
+	// la seconda lettura usa la cardinalità in cache
+	value, _ := c.Get("hll")
+	if card, ok := hllCachedCardinality(value); !ok || card != 7 {
+		t.Fatalf("cached cardinality = %d, %v", card, ok)
+	}
+}
+
+func TestPFAddCreatesEmpty(t *testing.T) {
+	c := newTestPodCache(t, 1, 1024*1024)
+
+	updated, err := c.PFAdd("empty")
+	if err != nil || !updated {
+		t.Fatalf("PFAdd() without elements = %v, %v", updated, err)
+	}
+	if count, _ := c.PFCount("empty"); count != 0 {
+		t.Fatalf("PFCount() = %d, want 0", count)
+	}
+	if count, _ := c.PFCount("missing"); count != 0 {
+		t.Fatalf("PFCount() of missing key = %d, want 0", count)
+	}
+}
+
+func TestHLLErrorBound(t *testing.T) {
+	c := newTestPodCache(t, 3, 16*1024*1024)
+
+	const total = 100_000
+	batch := make([][]byte, 0, 1000)
+	for i := 0; i < total; i++ {
+		batch = append(batch, []byte(fmt.Sprintf("element-%d", i)))
+		if len(batch) == cap(batch) {
+			if _, err := c.PFAdd("visitors", batch...); err != nil {
+				t.Fatalf("PFAdd() returned an error: %v", err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	count, err := c.PFCount("visitors")
+	if err != nil {
+		t.Fatalf("PFCount() returned an error: %v", err)
+	}
+
+	// 0.81% di errore standard, tolleriamo 5 sigma
+	if relErr := math.Abs(float64(count)-total) / total; relErr > 5*0.0081 {
+		t.Fatalf("PFCount() = %d, relative error %.4f too high", count, relErr)
+	}
+
+	value, _ := c.Get("visitors")
+	if value[4] != hllEncodingDense || len(value) != hllDenseSize {
+		t.Fatalf("expected dense encoding after %d elements", total)
+	}
+}
+
+func TestHLLSparseRoundTrip(t *testing.T) {
+	h := newHyperLogLog()
+	for i := 0; i < 200; i++ {
+		h.add([]byte(fmt.Sprintf("k%d", i)))
+	}
+
+	encoded := h.encode()
+	if encoded[4] != hllEncodingSparse {
+		t.Fatal("expected sparse encoding for few elements")
+	}
+
+	decoded, err := decodeHLL(encoded)
+	if err != nil {
+		t.Fatalf("decodeHLL() returned an error: %v", err)
+	}
+	if decoded.registers != h.registers {
+		t.Fatal("sparse round trip changed the registers")
+	}
+
+	h.sparse = false
+	dense, err := decodeHLL(h.encode())
+	if err != nil {
+		t.Fatalf("decodeHLL() of dense returned an error: %v", err)
+	}
+	if dense.registers != h.registers {
+		t.Fatal("dense round trip changed the registers")
+	}
+}
+
+func TestPFMergeAcrossPartitions(t *testing.T) {
+	c := newTestPodCache(t, 4, 1024*1024)
+
+	for i := 0; i < 4; i++ {
+		key := fmt.Sprintf("page:%d", i)
+		for j := 0; j < 100; j++ {
+			// ogni pagina condivide metà dei visitatori con la successiva
+			c.PFAdd(key, []byte(fmt.Sprintf("user-%d", i*50+j)))
+		}
+	}
+
+	if err := c.PFMerge("all", "page:0", "page:1", "page:2", "page:3"); err != nil {
+		t.Fatalf("PFMerge() returned an error: %v", err)
+	}
+
+	merged, _ := c.PFCount("all")
+	union, _ := c.PFCount("page:0", "page:1", "page:2", "page:3")
+	if merged != union {
+		t.Fatalf("PFCount(all) = %d, PFCount(union) = %d", merged, union)
+	}
+	if math.Abs(float64(merged)-250) > 10 {
+		t.Fatalf("PFCount(all) = %d, want about 250", merged)
+	}
+}
+
+func TestPFWrongType(t *testing.T) {
+	c := newTestPodCache(t, 1, 1024*1024)
+	c.Put("plain", []byte("not an hll"))
+
+	if _, err := c.PFAdd("plain", []byte("a")); !errors.Is(err, ErrNotHLL) {
+		t.Fatalf("PFAdd() on string = %v, want ErrNotHLL", err)
+	}
+	if _, err := c.PFCount("plain"); !errors.Is(err, ErrNotHLL) {
+		t.Fatalf("PFCount() on string = %v, want ErrNotHLL", err)
+	}
+
+	corrupted := newHyperLogLog().encode()
+	corrupted = append(corrupted, 0x00)
+	c.Put("broken", corrupted)
+	if _, err := c.PFCount("broken", "plain"); err == nil {
+		t.Fatal("PFCount() on corrupted hll should fail")
+	}
+	if _, err := c.PFAdd("broken", []byte("a")); !errors.Is(err, ErrCorruptedHLL) {
+		t.Fatalf("PFAdd() on corrupted hll = %v, want ErrCorruptedHLL", err)
+	}
+}
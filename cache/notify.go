@@ -0,0 +1,55 @@
+package cache
+
+// EventClass è la categoria di un evento del keyspace, con la stessa
+// suddivisione dei flag di notify-keyspace-events di Redis
+type EventClass uint16
+
+const (
+	EventGeneric EventClass = 1 << iota // g: DEL e comandi indipendenti dal tipo
+	EventString                         // $: SET, PFADD...
+	EventStream                         // t: XADD, XTRIM, XGROUP...
+	EventZSet                           // z: sorted set e comandi GEO
+	EventExpired                        // x: riservato, PodCache non ha ancora TTL
+	EventEvicted                        // e: chiavi perse per mancanza di memoria
+	EventTier                           // podcache: spostamenti tra RAM e disco
+)
+
+// Nomi degli eventi specifici di PodCache (classe EventTier)
+const (
+	EventSpill     = "spill"     // la chiave è passata dalla RAM al disco per far spazio
+	EventDiskEvict = "diskevict" // la copia su disco della chiave è stata rimossa
+)
+
+// Notifier riceve gli eventi del keyspace con il DB della chiave. È chiamato
+// con il lock della stripe della chiave acquisito, quindi non deve bloccare
+// né richiamare la cache.
+type Notifier func(db int, class EventClass, event, key string)
+
+// SetNotifier registra il destinatario degli eventi; va chiamato prima di servire richieste
+func (c *PodCache) SetNotifier(notifier Notifier) {
+	c.notifier = notifier
+}
+
+// Invalidator è chiamato per ogni chiave il cui valore cambia o viene rimosso,
+// con l'origine della vista che ha fatto la modifica (nil per la cache condivisa).
+// Come Notifier, viene eseguito con i lock delle stripe acquisiti.
+type Invalidator func(key string, origin any)
+
+// SetInvalidator registra il destinatario delle invalidazioni; va chiamato prima di servire richieste
+func (c *PodCache) SetInvalidator(invalidator Invalidator) {
+	c.invalidator = invalidator
+}
+
+// WithOrigin restituisce una vista della cache che riporta origin nelle
+// invalidazioni, così chi le riceve sa quale client ha modificato la chiave
+func (c *PodCache) WithOrigin(origin any) *PodCache {
+	view := *c
+	view.origin = origin
+	return &view
+}
+
+func (c *PodCache) notify(class EventClass, event, key string) {
+	if c.notifier != nil {
+		c.notifier(c.db, class, event, key)
+	}
+}
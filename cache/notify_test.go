@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+type recordedEvent struct {
+	class EventClass
+	event string
+	key   string
+}
+
+func recordEvents(c *PodCache) *[]recordedEvent {
+	events := &[]recordedEvent{}
+	c.SetNotifier(func(_ int, class EventClass, event, key string) {
+		*events = append(*events, recordedEvent{class, event, key})
+	})
+	return events
+}
+
+func TestNotifyPutEvict(t *testing.T) {
+	c := newTestPodCache(t, 1, 10)
+	events := recordEvents(c)
+
+	c.Put("a", []byte("11111"))
+	c.Put("b", []byte("22222"))
+	// "a" è la coda LRU e finisce su disco per far posto a "c"
+	c.Put("c", []byte("33333"))
+	c.Evict("a")
+	c.Evict("missing")
+
+	want := []recordedEvent{
+		{EventString, "set", "a"},
+		{EventString, "set", "b"},
+		{EventTier, EventSpill, "a"},
+		{EventString, "set", "c"},
+		{EventTier, EventDiskEvict, "a"},
+		{EventGeneric, "del", "a"},
+	}
+	if !reflect.DeepEqual(*events, want) {
+		t.Fatalf("events = %v, want %v", *events, want)
+	}
+}
+
+func TestNotifyTypedCommands(t *testing.T) {
+	c := newTestPodCache(t, 2, 1024*1024)
+	events := recordEvents(c)
+
+	c.XAdd("s", "*", []string{"f", "v"}, false, nil)
+	c.XGroupCreate("s", "g", "$", false, 0)
+	c.PFAdd("h", []byte("x"))
+	c.PFAdd("h", []byte("x"))
+	c.IncrBy("n", 5)
+	c.Move("n", 1)
+	c.GeoAdd("geo", []GeoMember{{Member: "m", Point: GeoPoint{Longitude: 1, Latitude: 1}}}, false, false, false)
+	c.ZRem("geo", "m")
+
+	want := []recordedEvent{
+		{EventStream, "xadd", "s"},
+		{EventStream, "xgroup-create", "s"},
+		{EventString, "pfadd", "h"},
+		{EventString, "incrby", "n"},
+		{EventGeneric, "move_from", "n"},
+		{EventGeneric, "move_to", "n"},
+		{EventZSet, "zadd", "geo"},
+		{EventZSet, "zrem", "geo"},
+		{EventGeneric, "del", "geo"},
+	}
+	if !reflect.DeepEqual(*events, want) {
+		t.Fatalf("events = %v, want %v", *events, want)
+	}
+}
+
+func TestInvalidatorOrigin(t *testing.T) {
+	c := newTestPodCache(t, 2, 1024*1024)
+
+	var keys []string
+	var origins []any
+	c.SetInvalidator(func(key string, origin any) {
+		keys = append(keys, key)
+		origins = append(origins, origin)
+	})
+
+	view := c.WithOrigin("client-1")
+	view.Put("a", []byte("1"))
+	c.Evict("a")
+	view.Atomic([]string{"b"}, func(tx *PodCache) error {
+		return tx.Put("b", []byte("2"))
+	})
+
+	if !reflect.DeepEqual(keys, []string{"a", "a", "b"}) {
+		t.Fatalf("invalidated keys = %v", keys)
+	}
+	if !reflect.DeepEqual(origins, []any{"client-1", nil, "client-1"}) {
+		t.Fatalf("origins = %v", origins)
+	}
+}
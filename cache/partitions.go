@@ -0,0 +1,281 @@
+package cache
+
+import (
+	"errors"
+	"mi0772/podcache/ram"
+	"sync/atomic"
+	"time"
+)
+
+// lockStripes è il numero di lock per chiave. Non dipende dalle partizioni, così
+// il loro numero può cambiare senza rimappare lock e WATCH.
+const lockStripes = 256
+
+// rehashRetry è l'attesa prima di riprovare le chiavi che il rehash non è
+// riuscito a spostare (es. disco pieno durante uno spill); dopo rehashRetries
+// tentativi le chiavi rimaste sono rimosse, così le vecchie partizioni si liberano
+const rehashRetries = 5
+
+// rehashRetry è una variabile per poterla ridurre nei test
+var rehashRetry = time.Second
+
+var ErrRehashInProgress = errors.New("partitions are being rehashed, retry when the rehash completes")
+
+// partitionTable è l'insieme delle partizioni RAM; è immutabile e viene
+// sostituito da SetPartitions. Durante un rehash old contiene le partizioni
+// precedenti: non ricevono più scritture e si svuotano in background, quindi
+// una chiave va cercata prima nella partizione nuova e poi in quella vecchia.
+type partitionTable struct {
+	partitions []*ram.Cache[[]byte]
+	old        []*ram.Cache[[]byte]
+
+	// moved e total misurano l'avanzamento del rehash, failed le chiavi che
+	// l'ultimo passaggio non è riuscito a spostare
+	moved, total, failed *atomic.Uint64
+}
+
+// RehashStats descrive un rehash in corso
+type RehashStats struct {
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+	Moved  uint64 `json:"moved"`
+	Total  uint64 `json:"total"`
+	Failed uint64 `json:"failed"`
+}
+
+func newPartitions(count int, capacity uint64) []*ram.Cache[[]byte] {
+	partitions := make([]*ram.Cache[[]byte], count)
+	for i := range partitions {
+		partitions[i] = ram.New[[]byte](capacity)
+		if partitions[i] == nil {
+			panic("ram.New() returned nil")
+		}
+	}
+	return partitions
+}
+
+// partition è la partizione in cui la chiave va scritta
+func (t *partitionTable) partition(key string) *ram.Cache[[]byte] {
+	return t.partitions[partitionIndex(key, len(t.partitions))]
+}
+
+// oldPartition è la partizione in cui la chiave può essere rimasta durante un
+// rehash, nil altrimenti
+func (t *partitionTable) oldPartition(key string) *ram.Cache[[]byte] {
+	if t.old == nil {
+		return nil
+	}
+	return t.old[partitionIndex(key, len(t.old))]
+}
+
+// lookup restituisce le partizioni in cui cercare la chiave, in ordine
+func (t *partitionTable) lookup(key string) []*ram.Cache[[]byte] {
+	if old := t.oldPartition(key); old != nil {
+		return []*ram.Cache[[]byte]{t.partition(key), old}
+	}
+	return []*ram.Cache[[]byte]{t.partition(key)}
+}
+
+// all restituisce tutte le partizioni che possono contenere chiavi
+func (t *partitionTable) all() []*ram.Cache[[]byte] {
+	if t.old == nil {
+		return t.partitions
+	}
+	return append(append([]*ram.Cache[[]byte](nil), t.partitions...), t.old...)
+}
+
+func (t *partitionTable) stats() *RehashStats {
+	if t.old == nil {
+		return nil
+	}
+	return &RehashStats{From: len(t.old), To: len(t.partitions), Moved: t.moved.Load(), Total: t.total.Load(), Failed: t.failed.Load()}
+}
+
+// Partitions restituisce il numero di partizioni RAM
+func (pc *PodCache) Partitions() int {
+	return len(pc.table.Load().partitions)
+}
+
+// SetPartitions cambia il numero di partizioni RAM, che si dividono in parti
+// uguali la capacità totale. Le chiavi sono spostate in background da rehash
+// senza bloccare i client; un solo rehash alla volta.
+func (pc *PodCache) SetPartitions(partitions uint8) error {
+	if partitions == 0 {
+		return errors.New("at least one partition is required")
+	}
+	pc.resize.Lock()
+	defer pc.resize.Unlock()
+
+	current := pc.table.Load()
+	if current.old != nil {
+		return ErrRehashInProgress
+	}
+	if int(partitions) == len(current.partitions) {
+		return nil
+	}
+	partitionCapacity := pc.capacity.Load() / uint64(partitions)
+	table := &partitionTable{
+		partitions: newPartitions(int(partitions), partitionCapacity),
+		old:        current.partitions,
+		moved:      &atomic.Uint64{},
+		total:      &atomic.Uint64{},
+		failed:     &atomic.Uint64{},
+	}
+
+	// le operazioni leggono la tabella con il lock della loro stripe: da qui in
+	// poi scrivono solo nelle nuove partizioni. Non si prendono tutti i lock
+	// perché il chiamante può già possederne alcuni, es. CONFIG SET dentro EXEC;
+	// le operazioni ancora in corso sulla tabella precedente le attende rehash.
+	pc.table.Store(table)
+
+	pc.logger.Info("Cache partitions changed, rehash started", "from", len(current.partitions), "to", partitions, "partition_capacity", partitionCapacity)
+	go pc.rehash(table)
+	return nil
+}
+
+// rehash sposta le chiavi dalle vecchie partizioni alle nuove, una alla volta e
+// con il solo lock della sua stripe. L'ordine va dalla meno alla più usata,
+// così nelle nuove partizioni resta l'ordine LRU; se la nuova partizione è
+// piena le chiavi meno usate vanno su disco come per una scrittura; una chiave
+// che non si riesce a spostare dopo rehashRetries tentativi viene rimossa.
+func (pc *PodCache) rehash(table *partitionTable) {
+	// un'operazione iniziata prima del cambio di tabella può ancora scrivere
+	// nelle vecchie partizioni: passare da ogni stripe, un lock alla volta,
+	// attende che siano tutte concluse prima di elencare le chiavi
+	for i := range pc.locks {
+		pc.locks[i].Lock()
+		pc.locks[i].Unlock()
+	}
+	for _, partition := range table.old {
+		table.total.Add(uint64(partition.ItemCount()))
+	}
+	for attempt := 1; ; attempt++ {
+		failed := 0
+		var lastErr error
+		for _, partition := range table.old {
+			for _, stored := range partition.KeysLRU() {
+				if err := pc.move(table, partition, stored); err != nil {
+					failed++
+					lastErr = err
+				}
+			}
+		}
+		table.failed.Store(uint64(failed))
+		if failed == 0 {
+			break
+		}
+		if attempt == rehashRetries {
+			dropped := 0
+			for _, partition := range table.old {
+				for _, stored := range partition.KeysLRU() {
+					if pc.drop(partition, stored) {
+						dropped++
+					}
+				}
+			}
+			pc.tiers.rehashDropped.Add(uint64(dropped))
+			pc.logger.Error("Rehash could not move some keys, they were evicted", "keys", dropped, "attempts", attempt, "error", lastErr)
+			break
+		}
+		pc.logger.Warn("Rehash failed to move some keys, they stay in the old partitions until the next attempt", "keys", failed, "attempt", attempt, "error", lastErr)
+		time.Sleep(rehashRetry)
+	}
+
+	pc.resize.Lock()
+	pc.table.Store(&partitionTable{partitions: table.partitions})
+	pc.resize.Unlock()
+	pc.logger.Info("Cache partitions rehash completed", "partitions", len(table.partitions), "moved", table.moved.Load())
+}
+
+// move sposta una chiave da una vecchia partizione alla nuova; una chiave già
+// riscritta o cancellata nel frattempo non è più nella vecchia
+func (pc *PodCache) move(table *partitionTable, from *ram.Cache[[]byte], stored string) error {
+	_, key := splitStorageKey(stored)
+	unlock := pc.lockKeys(key)
+	defer unlock()
+
+	value, ok := from.Peek(stored)
+	if !ok {
+		return nil
+	}
+	if err := pc.store(table.partition(key), stored, value, false); err != nil {
+		return err
+	}
+	from.Evict(stored)
+	table.moved.Add(1)
+	return nil
+}
+
+// drop rimuove da una vecchia partizione una chiave che il rehash non è
+// riuscito a spostare: come un'eviction per mancanza di memoria invalida WATCH
+// e tracking e notifica l'evento evicted. Per una parte interna, come un blocco
+// di uno stream, si perde l'intera chiave a cui appartiene.
+func (pc *PodCache) drop(from *ram.Cache[[]byte], stored string) bool {
+	ns, key := splitStorageKey(stored)
+	unlock := pc.lockKeys(key)
+	defer unlock()
+
+	if _, ok := from.Peek(stored); !ok {
+		return false
+	}
+	if owners := pc.databases.Load().owners; ns < len(owners) {
+		view := *pc
+		view.db = owners[ns]
+		view.evict(key)
+	}
+	from.Evict(stored)
+	pc.notifyStored(EventEvicted, "evicted", namespaceKey(ns, key))
+	return true
+}
+
+// spill sposta su disco le chiavi meno usate delle partizioni che superano la
+// capacità, dopo una riduzione di maxmemory; prende il lock della stripe di una
+// chiave alla volta
+func (pc *PodCache) spill(partitions []*ram.Cache[[]byte]) {
+	spilled := 0
+	for _, partition := range partitions {
+		for {
+			used, max := partition.Capacity()
+			stored, ok := partition.Oldest()
+			if used <= max || !ok {
+				break
+			}
+			moved, err := pc.spillKey(partition, stored)
+			if err != nil {
+				pc.logger.Error("Failed to move a key to disk after a capacity change", "error", err)
+				return
+			}
+			if moved {
+				spilled++
+			}
+		}
+	}
+	if spilled > 0 {
+		pc.logger.Info("Keys moved to disk after a capacity change", "keys", spilled)
+	}
+}
+
+func (pc *PodCache) spillKey(partition *ram.Cache[[]byte], stored string) (bool, error) {
+	_, key := splitStorageKey(stored)
+	unlock := pc.lockKeys(key)
+	defer unlock()
+
+	// la chiave può essere stata letta, riscritta o cancellata prima del lock
+	if oldest, ok := partition.Oldest(); !ok || oldest != stored {
+		return false, nil
+	}
+	return pc.moveToDisk(partition, stored)
+}
+
+// dedupe toglie i duplicati mantenendo l'ordine
+func dedupe(keys []string) []string {
+	seen := make(map[string]bool, len(keys))
+	unique := keys[:0]
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	return unique
+}
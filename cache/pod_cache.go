@@ -1,21 +1,81 @@
 package cache
 
 import (
+	"bytes"
+	"encoding/gob"
 	"errors"
 	"fmt"
+	"math"
 	"mi0772/podcache/disk"
 	"mi0772/podcache/hash"
 	"mi0772/podcache/logging"
+	"mi0772/podcache/metrics"
 	"mi0772/podcache/ram"
+	"mi0772/podcache/tracing"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	ErrNotInteger   = errors.New("ERR value is not an integer or out of range")
+	ErrIncrOverflow = errors.New("ERR increment or decrement would overflow")
+)
+
 type PodCache struct {
-	partitions      []*ram.Cache[[]byte]
-	disk_cache      *disk.Cache
-	partition_count uint8
-	capacity        uint64
-	logger          logging.Logger
+	// table è condivisa tra le viste: cambia con SetPartitions
+	table      *atomic.Pointer[partitionTable]
+	disk_cache *disk.Cache
+	logger     logging.Logger
+
+	// capacity e policy sono condivise tra le viste e cambiano a runtime
+	capacity *atomic.Uint64
+	policy   *atomic.Int32
+	// resize serializza SetCapacity e SetPartitions
+	resize *sync.Mutex
+	// tiers conta i passaggi tra RAM e disco, condiviso tra le viste
+	tiers *tierCounters
+	// latency registra i picchi di latenza per evento (vedi LatencyMonitor)
+	latency *metrics.LatencyMonitor
+
+	// locks serializza le operazioni composte (read-modify-write) per stripe
+	// (vedi stripeIndex). Vanno sempre acquisiti in ordine crescente di indice,
+	// prima del lock del disco.
+	locks []sync.Mutex
+
+	// watches contiene, per stripe, i contatori di versione delle chiavi
+	// osservate con WATCH; protetti dal lock della stripe
+	watches []map[watchID]*watchedKey
+	// parted contiene, per stripe, le chiavi memorizzate che hanno parti
+	// interne, come stream e sorted set: riscriverle o cancellarle rimuove
+	// anche le loro parti
+	parted []map[string]bool
+
+	// databases è condivisa tra le viste; db è il DB logico di questa vista
+	databases *atomic.Pointer[databaseMap]
+	db        int
+
+	// held indica una vista creata da Atomic: i lock sono già posseduti;
+	// heldAll che li possiede tutti (AtomicAll)
+	held    bool
+	heldAll bool
+
+	notifier    Notifier
+	invalidator Invalidator
+	// origin identifica chi opera tramite questa vista (vedi WithOrigin)
+	origin any
+	// span è lo span della richiesta campionata che usa questa vista (vedi WithSpan)
+	span *tracing.Span
+	// usage registra i livelli usati tramite questa vista (vedi WithTierUsage)
+	usage *TierUsage
+}
+
+type watchedKey struct {
+	version uint64
+	refs    int
 }
 
 type PodCacheStats struct {
@@ -25,6 +85,10 @@ type PodCacheStats struct {
 	Free       uint64           `json:"free"`
 	Partitions []PartitionStats `json:"partitions"`
 	Disk       DiskStats        `json:"disk"`
+	Keyspace   []KeyspaceStats  `json:"keyspace"`
+	Rehash     *RehashStats     `json:"rehash,omitempty"`
+	// RehashDropped sono le chiavi rimosse perché un rehash non è riuscito a spostarle
+	RehashDropped uint64 `json:"rehash_dropped"`
 }
 
 type PartitionStats struct {
@@ -40,146 +104,689 @@ type PartitionStats struct {
 type DiskStats struct {
 	Entries uint64 `json:"entries"`
 	Used    uint64 `json:"used"`
+	// Spills sono le chiavi passate dalla RAM al disco per far spazio,
+	// Evictions quelle tolte dal disco (cancellate o riscritte in RAM)
+	Spills    uint64 `json:"spills"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// tierCounters sono i contatori riportati in DiskStats e in RehashDropped
+type tierCounters struct {
+	spills        atomic.Uint64
+	evictions     atomic.Uint64
+	rehashDropped atomic.Uint64
 }
 
 func (pc *PodCache) Stats() PodCacheStats {
 	result := PodCacheStats{
 		Timestamp: time.Now(),
 	}
-	result.Capacity = pc.capacity
+	result.Capacity = pc.capacity.Load()
 	var totalUsed uint64 = 0
 
-	for _, partition := range pc.partitions {
+	// Stats è chiamato anche da INFO mentre i client scrivono: si usano gli
+	// accessori con lock delle partizioni e del disco
+	table := pc.table.Load()
+	for _, partition := range table.partitions {
 		pstat := PartitionStats{}
-		pstat.Capacity = partition.MaxCapacity
+		pstat.Used, pstat.Capacity = partition.Capacity()
 		pstat.Entries = uint64(partition.ItemCount())
-		pstat.Used = partition.CurrentCapacity
-		pstat.Free = partition.MaxCapacity - partition.CurrentCapacity
-		totalUsed += pstat.Used
-		pstat.Hits = partition.Hits
-		pstat.Misses = partition.Misses
-
-		// Calculate hit ratio
-		total := pstat.Hits + pstat.Misses
-		if total > 0 {
-			pstat.HitRatio = float64(pstat.Hits) / float64(total)
+		if pstat.Used < pstat.Capacity {
+			pstat.Free = pstat.Capacity - pstat.Used
 		}
+		totalUsed += pstat.Used
+		pstat.Hits, pstat.Misses, pstat.HitRatio = partition.Stats()
 
 		result.Partitions = append(result.Partitions, pstat)
 	}
-	result.Disk.Used = pc.disk_cache.Capacity
-	result.Disk.Entries = pc.disk_cache.Entries_count
+	// le partizioni in uscita da un rehash occupano ancora memoria
+	for _, partition := range table.old {
+		used, _ := partition.Capacity()
+		totalUsed += used
+	}
+	result.Rehash = table.stats()
+	result.RehashDropped = pc.tiers.rehashDropped.Load()
+	result.Disk.Entries, result.Disk.Used = pc.disk_cache.Usage()
+	result.Disk.Spills = pc.tiers.spills.Load()
+	result.Disk.Evictions = pc.tiers.evictions.Load()
 	result.Used = totalUsed
-	result.Free = result.Capacity - totalUsed
+	if totalUsed < result.Capacity {
+		result.Free = result.Capacity - totalUsed
+	}
+	result.Keyspace = pc.Keyspace()
 	return result
 }
 
-func NewPodCache(partitions uint8, capacity uint64, logger logging.Logger) (*PodCache, error) {
-	partition_capacity := capacity / uint64(partitions)
+// ResetStats azzera hits e misses delle partizioni e i contatori del disco,
+// come CONFIG RESETSTAT
+func (pc *PodCache) ResetStats() {
+	for _, partition := range pc.table.Load().all() {
+		partition.ResetStats()
+	}
+	pc.tiers.spills.Store(0)
+	pc.tiers.evictions.Store(0)
+	pc.tiers.rehashDropped.Store(0)
+	read, write := pc.disk_cache.Latency()
+	read.Reset()
+	write.Reset()
+}
 
-	p := make([]*ram.Cache[[]byte], int(partitions))
-	for i := 0; i < int(partitions); i++ {
-		p[i] = ram.New[[]byte](partition_capacity)
-		if p[i] == nil {
-			panic("ram.New() returned nil")
-		}
+// DiskLatency restituisce gli istogrammi delle latenze di lettura e scrittura del disco
+func (pc *PodCache) DiskLatency() (read, write *metrics.Histogram) {
+	return pc.disk_cache.Latency()
+}
+
+// Eventi registrati dal latency monitor della cache
+const (
+	LatencyDiskRead  = "disk-read"
+	LatencyDiskWrite = "disk-write"
+	LatencySpill     = "spill"
+	LatencyShrink    = "shrink"
+)
+
+// LatencyMonitor restituisce il monitor dei picchi di latenza, condiviso tra
+// le viste: la cache vi registra letture e scritture del disco, gli spill
+// durante le scritture e Shrink; il server vi aggiunge i propri eventi
+func (pc *PodCache) LatencyMonitor() *metrics.LatencyMonitor {
+	return pc.latency
+}
+
+// NewPodCache crea la cache; il tier su disco è in una nuova sottodirectory di basePath
+func NewPodCache(partitions uint8, capacity uint64, basePath string, logger logging.Logger) (*PodCache, error) {
+	if partitions == 0 {
+		return nil, errors.New("at least one partition is required")
 	}
+	partition_capacity := capacity / uint64(partitions)
 	logger.Info("Creating Cache", "partitions_number", partitions, "partition_capacity", partition_capacity)
 
-	dc := disk.NewCache()
+	dc := disk.NewCache(basePath)
+
+	pc := &PodCache{
+		table:      &atomic.Pointer[partitionTable]{},
+		disk_cache: dc,
+		capacity:   &atomic.Uint64{},
+		policy:     &atomic.Int32{},
+		resize:     &sync.Mutex{},
+		tiers:      &tierCounters{},
+		latency:    metrics.NewLatencyMonitor(),
+		logger:     logger,
+		locks:      make([]sync.Mutex, lockStripes),
+		watches:    newWatchTables(lockStripes),
+		parted:     make([]map[string]bool, lockStripes),
+		databases:  &atomic.Pointer[databaseMap]{},
+	}
+	pc.table.Store(&partitionTable{partitions: newPartitions(int(partitions), partition_capacity)})
+	pc.capacity.Store(capacity)
+	for i := range pc.parted {
+		pc.parted[i] = make(map[string]bool)
+	}
+	pc.SetDatabases(DefaultDatabases)
+	return pc, nil
+}
 
-	return &PodCache{
-		partitions:      p,
-		disk_cache:      dc,
-		capacity:        capacity,
-		partition_count: partitions,
-		logger:          logger,
-	}, nil
+// SetCapacity cambia la capacità totale, divisa in parti uguali tra le
+// partizioni. Se una partizione resta oltre il nuovo limite, le sue chiavi
+// meno usate vanno su disco in background (vedi spill).
+func (pc *PodCache) SetCapacity(capacity uint64) {
+	pc.resize.Lock()
+	defer pc.resize.Unlock()
+
+	table := pc.table.Load()
+	partitionCapacity := capacity / uint64(len(table.partitions))
+	for _, partition := range table.partitions {
+		partition.SetMaxCapacity(partitionCapacity)
+	}
+	previous := pc.capacity.Swap(capacity)
+	pc.logger.Info("Cache capacity changed", "capacity", capacity, "partition_capacity", partitionCapacity)
+	if capacity < previous {
+		go pc.spill(table.partitions)
+	}
+}
+
+// SetEvictionPolicy cambia cosa succede quando una partizione è piena
+func (pc *PodCache) SetEvictionPolicy(policy EvictionPolicy) {
+	pc.policy.Store(int32(policy))
+}
+
+func (pc *PodCache) EvictionPolicy() EvictionPolicy {
+	return EvictionPolicy(pc.policy.Load())
+}
+
+func newWatchTables(partitions int) []map[watchID]*watchedKey {
+	tables := make([]map[watchID]*watchedKey, partitions)
+	for i := range tables {
+		tables[i] = make(map[watchID]*watchedKey)
+	}
+	return tables
 }
 
 func (c *PodCache) Put(key string, value []byte) error {
-	partitionIndex := partitionIndex(key, c.partition_count)
-	var partition = c.partitions[partitionIndex]
-
-	var sentinelError = ram.ErrMemoryFull
-	for sentinelError == ram.ErrMemoryFull {
-		err := partition.Put(key, value, uint64(len(value)))
-		if err != nil && errors.Is(err, ram.ErrMemoryFull) {
-			tailNode := partition.Tail
-			var m = fmt.Sprintf("Evicting key %s to disk due to memory pressure, %d bytes left on partition", tailNode.Key, partition.MaxCapacity-partition.CurrentCapacity)
-			c.logger.Debug("Cache proxy", "operation", "put", "event", m)
-
-			if tailNode == nil {
-				return errors.New("ram.Tail() returned nil, memory full but tail is empty, do you create a cache with 0 bytes of capacity ")
-			}
-			//salvo su disco e poi faccio evict dalla memoria
-			if err := c.disk_cache.Put(tailNode.Key, tailNode.Value); err != nil {
-				return fmt.Errorf("failed to save to disk cache: %w", err)
-			}
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	if err := c.put(key, encodeString(value)); err != nil {
+		return err
+	}
+	c.notify(EventString, "set", key)
+	return nil
+}
+
+// Get restituisce il valore stringa di key; per i tipi strutturati restituisce ErrWrongType
+func (c *PodCache) Get(key string) ([]byte, error) {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	value, err := c.get(key)
+	if err != nil || value == nil {
+		return value, err
+	}
+	if valueTypeOf(value) != TypeString {
+		return nil, ErrWrongType
+	}
+	return decodeString(value), nil
+}
+
+// IncrBy somma increment al valore intero di key, 0 se la chiave non esiste,
+// e restituisce il nuovo valore. Lettura e scrittura avvengono con il lock
+// della stripe, così incrementi concorrenti non si perdono.
+func (c *PodCache) IncrBy(key string, increment int64) (int64, error) {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	value, err := c.get(key)
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	if value != nil {
+		if valueTypeOf(value) != TypeString {
+			return 0, ErrWrongType
+		}
+		if current, err = strconv.ParseInt(string(decodeString(value)), 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+		return 0, ErrIncrOverflow
+	}
+	current += increment
+
+	if err := c.put(key, []byte(strconv.FormatInt(current, 10))); err != nil {
+		return 0, err
+	}
+	c.notify(EventString, "incrby", key)
+	return current, nil
+}
+
+func (c *PodCache) Evict(key string) bool {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	if !c.evict(key) {
+		return false
+	}
+	c.notify(EventGeneric, "del", key)
+	return true
+}
+
+// Atomic esegue fn con i lock delle stripe delle chiavi indicate. fn riceve
+// una vista della cache che non riacquisisce i lock, quindi deve toccare solo
+// le chiavi dichiarate. Il lock del disco resta sempre l'ultimo della catena
+// (stripe in ordine crescente, poi disco) e viene preso per singola
+// operazione: l'isolamento delle chiavi su disco è garantito dal lock della
+// loro stripe.
+func (c *PodCache) Atomic(keys []string, fn func(view *PodCache) error) error {
+	if c.held {
+		return fn(c)
+	}
+
+	unlock := c.lockKeys(keys...)
+	defer unlock()
+
+	view := *c
+	view.held = true
+	return fn(&view)
+}
+
+// Watch registra l'interesse per le chiavi e ne restituisce la versione corrente
+func (c *PodCache) Watch(keys ...string) []uint64 {
+	unlock := c.lockKeys(keys...)
+	defer unlock()
+
+	versions := make([]uint64, len(keys))
+	for i, key := range keys {
+		table := c.watches[stripeIndex(key)]
+		id := watchID{c.db, key}
+		w, ok := table[id]
+		if !ok {
+			w = &watchedKey{}
+			table[id] = w
+		}
+		w.refs++
+		versions[i] = w.version
+	}
+	return versions
+}
 
-			if !partition.Evict(tailNode.Key) {
-				return fmt.Errorf("eviction of tail node failed, this is abnormal condition")
+// Unwatch rilascia le chiavi registrate con Watch
+func (c *PodCache) Unwatch(keys ...string) {
+	unlock := c.lockKeys(keys...)
+	defer unlock()
+
+	for _, key := range keys {
+		table := c.watches[stripeIndex(key)]
+		id := watchID{c.db, key}
+		if w, ok := table[id]; ok {
+			w.refs--
+			if w.refs <= 0 {
+				delete(table, id)
 			}
-		} else {
-			sentinelError = nil
 		}
 	}
+}
+
+// Versions restituisce la versione corrente delle chiavi osservate
+func (c *PodCache) Versions(keys ...string) []uint64 {
+	unlock := c.lockKeys(keys...)
+	defer unlock()
+
+	versions := make([]uint64, len(keys))
+	for i, key := range keys {
+		if w, ok := c.watches[stripeIndex(key)][watchID{c.db, key}]; ok {
+			versions[i] = w.version
+		}
+	}
+	return versions
+}
+
+// touch segnala che il valore della chiave è cambiato o è stato rimosso:
+// incrementa la versione se qualcuno la osserva e avvisa l'invalidator
+func (c *PodCache) touch(key string) {
+	if w, ok := c.watches[stripeIndex(key)][watchID{c.db, key}]; ok {
+		w.version++
+	}
+	if c.invalidator != nil {
+		c.invalidator(key, c.origin)
+	}
+}
+
+// WithSpan restituisce una vista della cache che registra come figli di span
+// l'attesa dei lock, le ricerche in RAM e gli accessi al disco; con span nil la
+// vista non traccia nulla
+func (c *PodCache) WithSpan(span *tracing.Span) *PodCache {
+	view := *c
+	view.span = span
+	return &view
+}
+
+// lockKeys acquisisce i lock delle stripe che contengono le chiavi indicate,
+// in ordine crescente di indice per evitare deadlock tra operazioni multi-chiave.
+func (c *PodCache) lockKeys(keys ...string) func() {
+	if c.held {
+		return func() {}
+	}
+
+	indexes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		idx := stripeIndex(key)
+		if !seen[idx] {
+			seen[idx] = true
+			indexes = append(indexes, idx)
+		}
+	}
+	sort.Ints(indexes)
+
+	wait := c.span.Child("cache.lock_wait")
+	wait.SetAttr("cache.stripes", len(indexes))
+	for _, idx := range indexes {
+		c.locks[idx].Lock()
+	}
+	wait.End()
+	return func() {
+		for i := len(indexes) - 1; i >= 0; i-- {
+			c.locks[indexes[i]].Unlock()
+		}
+	}
+}
+
+/* ************************************************************************
+   Metodi privati - assumono che il caller abbia già acquisito lockKeys()
+ * ************************************************************************ */
+
+// put scrive il valore di key, che sostituisce qualunque valore precedente
+func (c *PodCache) put(key string, value []byte) error {
+	stored := c.storageKey(key)
+	c.dropParts(key, stored)
+	return c.putValue(key, stored, value)
+}
+
+// putValue scrive il valore di key senza toccarne le parti interne
+func (c *PodCache) putValue(key, stored string, value []byte) error {
+	existed, onDisk, err := c.putStored(key, stored, value)
+	if err != nil {
+		return err
+	}
+	if onDisk {
+		c.notify(EventTier, EventDiskEvict, key)
+	}
+	if !existed {
+		c.countKey(stored, 1)
+	}
+	c.touch(key)
 	return nil
 }
 
-func (c *PodCache) Get(key string) ([]byte, error) {
-	partitionIndex := partitionIndex(key, c.partition_count)
-	v, found := c.partitions[partitionIndex].Get(key)
-	if !found {
-		v, found, err := c.disk_cache.Get(key)
+// putStored scrive stored nella partizione di key, che sia la chiave o una sua
+// parte interna, e rimuove le copie precedenti; existed indica se stored era
+// già memorizzata, onDisk se la copia precedente era su disco
+func (c *PodCache) putStored(key, stored string, value []byte) (existed, onDisk bool, err error) {
+	table := c.table.Load()
+	index := partitionIndex(key, len(table.partitions))
+	for _, partition := range table.lookup(key) {
+		if _, ok := partition.Peek(stored); ok {
+			existed = true
+			break
+		}
+	}
+	if err := c.store(table.partitions[index], stored, value, c.EvictionPolicy() == PolicyNoEviction); err != nil {
+		return false, false, err
+	}
+	c.usage.partition(index)
+	// durante un rehash la chiave può essere ancora nella partizione precedente
+	if old := table.oldPartition(key); old != nil {
+		old.Evict(stored)
+	}
+
+	// una copia precedente finita su disco non è più valida
+	diskEvict := c.span.Child("disk.evict")
+	dropped, err := c.disk_cache.Evict(stored)
+	diskEvict.SetAttr("disk.found", dropped)
+	diskEvict.End()
+	if err != nil {
+		return false, false, fmt.Errorf("failed to drop stale disk entry: %w", err)
+	}
+	if dropped {
+		c.usage.useDisk()
+		c.tiers.evictions.Add(1)
+	}
+	return existed || dropped, dropped, nil
+}
+
+// evictPart rimuove una parte interna di key dalla RAM e dal disco
+func (c *PodCache) evictPart(key, stored string) error {
+	for _, partition := range c.table.Load().lookup(key) {
+		partition.Evict(stored)
+	}
+	if _, err := c.disk_cache.Evict(stored); err != nil {
+		return fmt.Errorf("failed to drop disk entry: %w", err)
+	}
+	return nil
+}
+
+// putPart scrive una parte interna di key, codificata con gob
+func (c *PodCache) putPart(key, stored string, v any) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return fmt.Errorf("failed to encode part of %s: %w", key, err)
+	}
+	_, _, err := c.putStored(key, stored, buf.Bytes())
+	return err
+}
+
+// dropParts cancella le parti interne di key se ne ha (vedi parted);
+// chiamata prima di riscrivere o cancellare la chiave
+func (c *PodCache) dropParts(key, stored string) {
+	index := c.parted[stripeIndex(key)]
+	if !index[stored] {
+		return
+	}
+	delete(index, stored)
+
+	value, err := c.getStored(key, stored)
+	var parts []string
+	if err == nil && value != nil {
+		parts, err = partsOf(c.namespace(), key, value)
+	}
+	if err != nil {
+		c.logger.Warn("Cache proxy", "operation", "evict", "event", fmt.Sprintf("failed to read %s, its parts are left behind: %v", key, err))
+		return
+	}
+	for _, part := range parts {
+		if err := c.evictPart(key, part); err != nil {
+			c.logger.Warn("Cache proxy", "operation", "evict", "event", err.Error())
+		}
+	}
+}
+
+// partsOf restituisce le parti interne di key nel namespace ns, dato il valore della chiave
+func partsOf(ns int, key string, value []byte) ([]string, error) {
+	switch valueTypeOf(value) {
+	case TypeStream:
+		return streamParts(ns, key, value)
+	case TypeZSet:
+		return zsetParts(ns, key, value)
+	}
+	return nil, nil
+}
+
+// store inserisce il valore nella partizione, spostando su disco le chiavi meno
+// usate finché non c'è spazio; con noEviction restituisce ErrOutOfMemory.
+// Il caller possiede il lock della stripe di stored.
+func (c *PodCache) store(partition *ram.Cache[[]byte], stored string, value []byte, noEviction bool) error {
+	// spillStart è l'inizio del primo spill: la scrittura attende tutti gli spill
+	var spillStart time.Time
+	defer func() {
+		if !spillStart.IsZero() {
+			c.latency.Since(LatencySpill, spillStart)
+		}
+	}()
+	busy := 0
+	for {
+		err := partition.Put(stored, value, uint64(len(value)))
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ram.ErrMemoryFull) {
+			return err
+		}
+		if noEviction {
+			return ErrOutOfMemory
+		}
+
+		if spillStart.IsZero() {
+			spillStart = time.Now()
+		}
+		moved, err := c.spillOldest(partition, stored)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if moved {
+			busy = 0
+			continue
+		}
+		// tutte le candidate sono bloccate da altre scritture
+		if busy++; busy > spillAttempts {
+			return fmt.Errorf("no key of the partition can be moved to disk, every candidate is locked")
+		}
+		runtime.Gosched()
+	}
+}
+
+// Limiti dello spill durante una scrittura: quante chiavi meno usate provare a
+// ogni giro e quanti giri senza progressi attendere prima di rinunciare
+const (
+	spillCandidates = 16
+	spillAttempts   = 10000
+)
+
+// spillOldest sposta su disco la chiave meno usata della partizione di cui si
+// ha il lock della stripe. Quella di writing è già del caller, le altre si
+// provano con TryLock: attendere potrebbe bloccare una scrittura che a sua
+// volta attende spazio in questa partizione. false se erano tutte occupate.
+func (c *PodCache) spillOldest(partition *ram.Cache[[]byte], writing string) (bool, error) {
+	candidates := partition.OldestKeys(spillCandidates)
+	if len(candidates) == 0 {
+		return false, errors.New("ram.Tail() returned nil, memory full but tail is empty, do you create a cache with 0 bytes of capacity ")
+	}
+	_, owner := splitStorageKey(writing)
+	held := stripeIndex(owner)
+	for _, stored := range candidates {
+		_, key := splitStorageKey(stored)
+		idx := stripeIndex(key)
+		if !c.heldAll && idx != held {
+			if !c.locks[idx].TryLock() {
+				continue
+			}
+			moved, err := c.moveToDisk(partition, stored)
+			c.locks[idx].Unlock()
+			if moved || err != nil {
+				return moved, err
+			}
+			continue
 		}
-		if !found {
-			return nil, nil
+		if moved, err := c.moveToDisk(partition, stored); moved || err != nil {
+			return moved, err
 		}
+	}
+	return false, nil
+}
+
+// moveToDisk scrive stored su disco e lo toglie dalla partizione; il caller
+// possiede il lock della sua stripe. false se nel frattempo è stata rimossa.
+func (c *PodCache) moveToDisk(partition *ram.Cache[[]byte], stored string) (bool, error) {
+	value, ok := partition.Peek(stored)
+	if !ok {
+		return false, nil
+	}
+	used, max := partition.Capacity()
+	m := fmt.Sprintf("Evicting key %s to disk due to memory pressure, %d bytes left on partition", stored, max-used)
+	c.logger.Debug("Cache proxy", "operation", "put", "event", m)
+
+	//salvo su disco e poi faccio evict dalla memoria
+	diskWrite := c.span.Child("disk.write")
+	diskWrite.SetAttr("disk.bytes", len(value))
+	writeStart := time.Now()
+	err := c.disk_cache.Put(stored, value)
+	c.latency.Since(LatencyDiskWrite, writeStart)
+	diskWrite.End()
+	if err != nil {
+		return false, fmt.Errorf("failed to save to disk cache: %w", err)
+	}
 
-		return v, nil
+	if !partition.Evict(stored) {
+		return false, fmt.Errorf("eviction of tail node failed, this is abnormal condition")
 	}
+	// la chiave spostata può appartenere a un altro DB
+	c.usage.useDisk()
+	c.tiers.spills.Add(1)
+	c.notifyStored(EventTier, EventSpill, stored)
+	return true, nil
+}
+
+func (c *PodCache) get(key string) ([]byte, error) {
+	return c.getStored(key, c.storageKey(key))
+}
+
+// getStored legge stored, la chiave key o una sua parte interna
+func (c *PodCache) getStored(key, stored string) ([]byte, error) {
+	table := c.table.Load()
+	index := partitionIndex(key, len(table.partitions))
+	lookup := c.span.Child("cache.ram_lookup")
+	lookup.SetAttr("cache.partition", index)
+	for _, partition := range table.lookup(key) {
+		if v, found := partition.Get(stored); found {
+			lookup.SetAttr("cache.hit", true)
+			lookup.End()
+			c.usage.partition(index)
+			return v, nil
+		}
+	}
+	lookup.SetAttr("cache.hit", false)
+	lookup.End()
+
+	diskRead := c.span.Child("disk.read")
+	readStart := time.Now()
+	v, found, err := c.disk_cache.Get(stored)
+	c.latency.Since(LatencyDiskRead, readStart)
+	diskRead.SetAttr("disk.found", found)
+	diskRead.End()
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	c.usage.useDisk()
 	return v, nil
 }
 
-func (c *PodCache) Evict(key string) bool {
-	partitionIndex := partitionIndex(key, c.partition_count)
+func (c *PodCache) evict(key string) bool {
+	stored := c.storageKey(key)
+	c.dropParts(key, stored)
 
-	if _, found := c.partitions[partitionIndex].Get(key); found {
-		return c.partitions[partitionIndex].Evict(key)
+	table := c.table.Load()
+	for _, partition := range table.lookup(key) {
+		if partition.Evict(stored) {
+			c.usage.partition(partitionIndex(key, len(table.partitions)))
+			c.countKey(stored, -1)
+			c.touch(key)
+			return true
+		}
 	}
 
-	m := fmt.Sprintf("ram.Get: key %s not found on partition %d, try looking into disk", key, partitionIndex)
+	m := fmt.Sprintf("ram.Get: key %s not found on partition %d, try looking into disk", key, partitionIndex(key, len(c.table.Load().partitions)))
 	c.logger.Debug("Cache proxy", "operation", "evict", "event", m)
 
-	_, found, err := c.disk_cache.Get(key)
+	diskRead := c.span.Child("disk.read")
+	readStart := time.Now()
+	_, found, err := c.disk_cache.Get(stored)
+	c.latency.Since(LatencyDiskRead, readStart)
+	diskRead.SetAttr("disk.found", found)
+	diskRead.End()
 	if err != nil || !found {
 
 		c.logger.Debug("Cache proxy", "operation", "evict", "event", fmt.Sprintf("disk.Get: key %s not found or error: %v", key, err))
 		return false
 	}
 
-	ok, err := c.disk_cache.Evict(key)
+	diskEvict := c.span.Child("disk.evict")
+	ok, err := c.disk_cache.Evict(stored)
+	diskEvict.SetAttr("disk.found", ok)
+	diskEvict.End()
 	if err != nil {
 
 		c.logger.Debug("Cache proxy", "operation", "evict", "event", fmt.Sprintf("disk.Evict error for key %s: %v", key, err))
 		return false
 	}
 
+	if ok {
+		c.usage.useDisk()
+		c.countKey(stored, -1)
+		c.touch(key)
+		c.tiers.evictions.Add(1)
+		c.notify(EventTier, EventDiskEvict, key)
+	}
 	return ok
 }
 
 func (pc *PodCache) Shrink() {
+	start := time.Now()
+	defer pc.latency.Since(LatencyShrink, start)
 	pc.logger.Info("Cache shrink operation", "status", "initiated")
-	for i, partition := range pc.partitions {
+	for i, partition := range pc.table.Load().all() {
 		pc.logger.Debug("Cache shrink", "partition", i)
 		partition.Shrink()
 	}
 	pc.logger.Info("Cache shrink operation", "status", "completed")
 }
 
-func partitionIndex(key string, partition_count uint8) uint8 {
-	return uint8(hash.CalculateDJB2(key) % uint32(partition_count))
+func partitionIndex(key string, partition_count int) int {
+	return int(hash.CalculateDJB2(key) % uint32(partition_count))
+}
+
+// stripeIndex è la stripe di lock e WATCH della chiave; non dipende dal numero
+// di partizioni, che può cambiare a runtime
+func stripeIndex(key string) int {
+	return int(hash.CalculateDJB2(key) % lockStripes)
 }
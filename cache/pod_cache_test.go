@@ -0,0 +1,427 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchVersions(t *testing.T) {
+	c := newTestPodCache(t, 4, 1024*1024)
+
+	before := c.Watch("a", "b")
+	if err := c.Put("a", []byte("1")); err != nil {
+		t.Fatalf("Put() returned an error: %v", err)
+	}
+
+	after := c.Versions("a", "b")
+	if after[0] == before[0] {
+		t.Fatalf("version of a did not change after Put: %d", after[0])
+	}
+	if after[1] != before[1] {
+		t.Fatalf("version of b changed without writes: %d -> %d", before[1], after[1])
+	}
+
+	c.Evict("b")
+	if got := c.Versions("b"); got[0] != before[1] {
+		t.Fatalf("evicting a missing key changed its version: %d -> %d", before[1], got[0])
+	}
+
+	c.Unwatch("a", "b")
+	if got := c.Versions("a"); got[0] != 0 {
+		t.Fatalf("Versions() of an unwatched key = %d, want 0", got[0])
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	c := newTestPodCache(t, 4, 1024*1024)
+	if err := c.Put("counter", []byte("1")); err != nil {
+		t.Fatalf("Put() returned an error: %v", err)
+	}
+
+	err := c.Atomic([]string{"counter", "other"}, func(view *PodCache) error {
+		value, err := view.Get("counter")
+		if err != nil {
+			return err
+		}
+		if err := view.Put("other", value); err != nil {
+			return err
+		}
+		// una Atomic annidata riusa i lock già acquisiti
+		return view.Atomic([]string{"counter"}, func(inner *PodCache) error {
+			return inner.Put("counter", []byte("2"))
+		})
+	})
+	if err != nil {
+		t.Fatalf("Atomic() returned an error: %v", err)
+	}
+
+	if value, _ := c.Get("counter"); string(value) != "2" {
+		t.Fatalf("Get(counter) = %q, want 2", value)
+	}
+	if value, _ := c.Get("other"); string(value) != "1" {
+		t.Fatalf("Get(other) = %q, want 1", value)
+	}
+}
+
+// waitRehash attende la fine del rehash in background
+func waitRehash(t *testing.T, c *PodCache) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Stats().Rehash != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("rehash did not complete")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSetPartitions(t *testing.T) {
+	c := newTestPodCache(t, 3, 1024*1024)
+	for i := 0; i < 500; i++ {
+		if err := c.Put(fmt.Sprintf("key:%d", i), []byte(fmt.Sprintf("value:%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.SetPartitions(7); err != nil {
+		t.Fatalf("SetPartitions(7) error = %v", err)
+	}
+	// durante il rehash le chiavi restano leggibili e scrivibili
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i += 2 {
+			c.Put(fmt.Sprintf("key:%d", i), []byte(fmt.Sprintf("new:%d", i)))
+		}
+	}()
+	for i := 1; i < 500; i += 2 {
+		if v, err := c.Get(fmt.Sprintf("key:%d", i)); err != nil || string(v) != fmt.Sprintf("value:%d", i) {
+			t.Fatalf("Get(key:%d) during rehash = %q, %v", i, v, err)
+		}
+	}
+	wg.Wait()
+	waitRehash(t, c)
+
+	if c.Partitions() != 7 || len(c.Stats().Partitions) != 7 {
+		t.Fatalf("Partitions() = %d, want 7", c.Partitions())
+	}
+	if n := c.DBSize(); n != 500 {
+		t.Fatalf("DBSize() = %d, want 500", n)
+	}
+	for i := 0; i < 500; i++ {
+		want := fmt.Sprintf("value:%d", i)
+		if i%2 == 0 {
+			want = fmt.Sprintf("new:%d", i)
+		}
+		if v, err := c.Get(fmt.Sprintf("key:%d", i)); err != nil || string(v) != want {
+			t.Fatalf("Get(key:%d) after rehash = %q, %v, want %q", i, v, err, want)
+		}
+	}
+	if err := c.SetPartitions(0); err == nil {
+		t.Fatal("SetPartitions(0) succeeded")
+	}
+}
+
+// SetPartitions chiamato da chi possiede già dei lock, come CONFIG SET
+// partitions dentro MULTI/EXEC, non deve attendere i lock posseduti
+func TestSetPartitionsWithLocksHeld(t *testing.T) {
+	c := newTestPodCache(t, 3, 1024*1024)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Atomic([]string{"foo"}, func(view *PodCache) error {
+			if err := view.Put("foo", []byte("1")); err != nil {
+				return err
+			}
+			return c.SetPartitions(5)
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Atomic() returned an error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SetPartitions() inside Atomic deadlocked")
+	}
+
+	waitRehash(t, c)
+	if v, err := c.Get("foo"); err != nil || string(v) != "1" {
+		t.Fatalf("Get(foo) after rehash = %q, %v, want 1", v, err)
+	}
+	if c.Partitions() != 5 {
+		t.Fatalf("Partitions() = %d, want 5", c.Partitions())
+	}
+}
+
+// una chiave che non entra nella nuova partizione non blocca il rehash per
+// sempre: dopo rehashRetries tentativi viene rimossa
+func TestRehashDropsUnmovableKeys(t *testing.T) {
+	defer func(retry time.Duration) { rehashRetry = retry }(rehashRetry)
+	rehashRetry = time.Millisecond
+
+	c := newTestPodCache(t, 1, 1024*1024)
+	if err := c.Put("big", make([]byte, 600*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put("small", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	versions := c.Watch("big")
+
+	// ogni nuova partizione ha 256KB: big non può essere spostata
+	if err := c.SetPartitions(4); err != nil {
+		t.Fatalf("SetPartitions(4) error = %v", err)
+	}
+	waitRehash(t, c)
+
+	if v, err := c.Get("big"); err != nil || v != nil {
+		t.Fatalf("Get(big) after a failed rehash = %d bytes, %v, want a miss", len(v), err)
+	}
+	if v, err := c.Get("small"); err != nil || string(v) != "1" {
+		t.Fatalf("Get(small) = %q, %v, want 1", v, err)
+	}
+	if got := c.Versions("big"); got[0] == versions[0] {
+		t.Fatal("dropping big did not change its WATCH version")
+	}
+	if dropped := c.Stats().RehashDropped; dropped != 1 {
+		t.Fatalf("RehashDropped = %d, want 1", dropped)
+	}
+	if err := c.SetPartitions(2); err != nil {
+		t.Fatalf("SetPartitions(2) after a failed rehash error = %v", err)
+	}
+	waitRehash(t, c)
+}
+
+// una chiave copiata nella nuova partizione ma non ancora rimossa dalla
+// vecchia va contata una volta sola
+func TestKeyspaceDuringRehash(t *testing.T) {
+	c := newTestPodCache(t, 2, 1024*1024)
+	if err := c.Put("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put("b", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	table := &partitionTable{
+		partitions: newPartitions(3, 1024*1024),
+		old:        c.table.Load().partitions,
+		moved:      &atomic.Uint64{},
+		total:      &atomic.Uint64{},
+		failed:     &atomic.Uint64{},
+	}
+	table.partition("a").Put("a", []byte("1"), 1)
+	c.table.Store(table)
+
+	want := []KeyspaceStats{{DB: 0, Keys: 2}}
+	if got := c.Keyspace(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Keyspace() during rehash = %v, want %v", got, want)
+	}
+	if n := c.DBSize(); n != 2 {
+		t.Fatalf("DBSize() during rehash = %d, want 2", n)
+	}
+}
+
+func TestSetCapacitySpillsToDisk(t *testing.T) {
+	c := newTestPodCache(t, 2, 64*1024)
+	value := make([]byte, 1024)
+	for i := 0; i < 50; i++ {
+		if err := c.Put(fmt.Sprintf("key:%d", i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.SetCapacity(16 * 1024)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := c.Stats()
+		if stats.Used <= stats.Capacity {
+			if stats.Disk.Entries == 0 {
+				t.Fatal("no key was moved to disk")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("used = %d after shrinking capacity to %d", stats.Used, stats.Capacity)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 50; i++ {
+		if v, err := c.Get(fmt.Sprintf("key:%d", i)); err != nil || len(v) != len(value) {
+			t.Fatalf("Get(key:%d) = %d bytes, %v", i, len(v), err)
+		}
+	}
+}
+
+// TestConcurrentPutSpill scrive da più goroutine in una partizione piena: ogni
+// spill deve prendere il lock della chiave spostata, altrimenti una scrittura
+// concorrente può finire persa o duplicata su disco. Va eseguito con -race.
+func TestConcurrentPutSpill(t *testing.T) {
+	c := newTestPodCache(t, 1, 4096)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 300; i++ {
+				// ogni goroutine rilegge le proprie chiavi e condivide quelle shared:*
+				key := fmt.Sprintf("g%d:%d", g, i%20)
+				value := []byte(fmt.Sprintf("%s=%03d%0100d", key, i, 0))
+				if err := c.Put(key, value); err != nil {
+					errs <- fmt.Errorf("Put(%s): %w", key, err)
+					return
+				}
+				if err := c.Put(fmt.Sprintf("shared:%d", i%10), value); err != nil {
+					errs <- fmt.Errorf("Put(shared): %w", err)
+					return
+				}
+				got, err := c.Get(key)
+				if err != nil || string(got) != string(value) {
+					errs <- fmt.Errorf("Get(%s) = %.12q, %v, want the value just written", key, got, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// ogni chiave è in un solo livello
+	stats := c.Stats()
+	if keys := c.DBSize(); int(stats.Partitions[0].Entries)+int(stats.Disk.Entries) != keys {
+		t.Errorf("%d keys in RAM and %d on disk, want %d in total", stats.Partitions[0].Entries, stats.Disk.Entries, keys)
+	}
+}
+
+func TestIncrBy(t *testing.T) {
+	c := newTestPodCache(t, 1, 1024*1024)
+
+	// gli incrementi concorrenti sulla stessa chiave non si perdono
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				if _, err := c.IncrBy("counter", 1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got, err := c.Get("counter"); err != nil || string(got) != "4000" {
+		t.Fatalf("Get(counter) = %q, %v, want 4000", got, err)
+	}
+
+	if v, err := c.IncrBy("counter", -4010); err != nil || v != -10 {
+		t.Fatalf("IncrBy(-4010) = %d, %v, want -10", v, err)
+	}
+	c.Put("text", []byte("abc"))
+	if _, err := c.IncrBy("text", 1); err != ErrNotInteger {
+		t.Errorf("IncrBy() of a non integer = %v, want ErrNotInteger", err)
+	}
+	c.Put("max", []byte("9223372036854775807"))
+	if _, err := c.IncrBy("max", 1); err != ErrIncrOverflow {
+		t.Errorf("IncrBy() past MaxInt64 = %v, want ErrIncrOverflow", err)
+	}
+	c.XAdd("stream", "*", []string{"f", "v"}, false, nil)
+	if _, err := c.IncrBy("stream", 1); err != ErrWrongType {
+		t.Errorf("IncrBy() of a stream = %v, want ErrWrongType", err)
+	}
+}
+
+// TestStringWithTypedMagic verifica che una stringa che inizia con l'header
+// dei valori strutturati resti una stringa, anche dopo lo spill su disco
+func TestStringWithTypedMagic(t *testing.T) {
+	c := newTestPodCache(t, 1, 4096)
+	values := map[string][]byte{
+		"forged": []byte("\x00PCTYPE\x01garbage"),
+		"zset":   append(append([]byte(nil), typedMagic...), byte(TypeZSet)),
+		"escape": append(append([]byte(nil), typedMagic...), byte(TypeString), 'x'),
+		"magic":  append([]byte(nil), typedMagic...),
+	}
+	for key, value := range values {
+		if err := c.Put(key, value); err != nil {
+			t.Fatalf("Put(%s) returned an error: %v", key, err)
+		}
+	}
+	if _, err := c.XLen("forged"); err != ErrWrongType {
+		t.Errorf("XLen() of a string = %v, want ErrWrongType", err)
+	}
+	if _, _, err := c.XAdd("forged", "*", []string{"f", "v"}, false, nil); err != ErrWrongType {
+		t.Errorf("XAdd() to a string = %v, want ErrWrongType", err)
+	}
+	if _, err := c.ZCard("zset"); err != ErrWrongType {
+		t.Errorf("ZCard() of a string = %v, want ErrWrongType", err)
+	}
+
+	// le altre chiavi spingono quelle sopra su disco
+	for i := 0; i < 100; i++ {
+		if err := c.Put(fmt.Sprintf("fill:%d", i), make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Stats().Disk.Entries == 0 {
+		t.Fatal("no key was moved to disk")
+	}
+	for key, value := range values {
+		if got, err := c.Get(key); err != nil || !reflect.DeepEqual(got, value) {
+			t.Errorf("Get(%s) = %q, %v, want %q", key, got, err, value)
+		}
+	}
+}
+
+func TestTierUsage(t *testing.T) {
+	c := newTestPodCache(t, 4, 8*1024)
+	var usage TierUsage
+	view := c.WithTierUsage(&usage)
+
+	if err := view.Put("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("ram:%d", partitionIndex("a", 4))
+	if got := usage.String(); got != want {
+		t.Fatalf("after Put usage = %q, want %q", got, want)
+	}
+
+	usage.Reset()
+	selected, _ := view.Select(1)
+	selected.Put("b", []byte("2"))
+	if got := usage.String(); got != fmt.Sprintf("ram:%d", partitionIndex("b", 4)) {
+		t.Fatalf("Select() view usage = %q", got)
+	}
+
+	// una scrittura che non entra in RAM sposta su disco la coda LRU
+	usage.Reset()
+	for i := 0; i < 10; i++ {
+		if err := view.Put("a", make([]byte, 1024+i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := view.Put(fmt.Sprintf("big:%d", i), make([]byte, 1024)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !usage.disk {
+		t.Fatalf("usage = %q after spilling to disk", usage.String())
+	}
+
+	usage.Reset()
+	if v, err := view.Get("missing"); err != nil || v != nil {
+		t.Fatalf("Get(missing) = %q, %v", v, err)
+	}
+	if got := usage.String(); got != "none" {
+		t.Fatalf("after a miss usage = %q, want none", got)
+	}
+}
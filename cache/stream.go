@@ -0,0 +1,1105 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stream append-only con consumer group. Come i nodi listpack di Redis, le
+// entry sono memorizzate in blocchi di streamChunkSize entry, parti interne
+// della chiave (vedi partPrefix); la chiave contiene solo i metadati e i
+// consumer group stanno in un'altra parte. XADD legge e riscrive i metadati e
+// l'ultimo blocco, XLEN solo i metadati, le letture cercano per ID con una
+// ricerca binaria sulle posizioni che carica solo i blocchi visitati.
+
+// streamChunkSize è il numero di entry per blocco, come stream-node-max-entries di Redis
+const streamChunkSize = 100
+
+// groupsPart è la parte interna che contiene i consumer group
+const groupsPart = "groups"
+
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	MinStreamID = StreamID{}
+	MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+var (
+	ErrInvalidStreamID  = errors.New("Invalid stream ID specified as stream command argument")
+	ErrStreamIDTooSmall = errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	ErrStreamIDZero     = errors.New("The ID specified in XADD must be greater than 0-0")
+	ErrStreamExhausted  = errors.New("The stream has exhausted the last possible ID, unable to add more items")
+	ErrGroupExists      = errors.New("BUSYGROUP Consumer Group name already exists")
+	ErrStreamMissing    = errors.New("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+)
+
+type StreamEntry struct {
+	ID StreamID
+	// Fields alterna nome e valore; nil per entry cancellate ancora in una PEL
+	Fields []string
+}
+
+type PendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	DeliveryTime  int64
+	DeliveryCount uint64
+}
+
+type StreamConsumer struct {
+	Name       string
+	SeenTime   int64
+	ActiveTime int64
+	// Pending è la PEL del consumer, ordinata per ID
+	Pending []StreamID
+}
+
+type ConsumerGroup struct {
+	Name        string
+	LastID      StreamID
+	EntriesRead int64
+	// Pending è la PEL del gruppo, ordinata per ID
+	Pending   []PendingEntry
+	Consumers []*StreamConsumer
+}
+
+// streamMeta è il valore memorizzato nella chiave di uno stream
+type streamMeta struct {
+	LastID       StreamID
+	MaxDeletedID StreamID
+	EntriesAdded uint64
+	// Head è la posizione della prima entry non rimossa dal trim: le entry sono
+	// numerate in ordine di inserimento e la posizione p sta nel blocco
+	// p/streamChunkSize, all'indice p%streamChunkSize
+	Head uint64
+}
+
+// Stream è uno stream caricato da un'operazione che possiede il lock della sua
+// stripe: blocchi e consumer group sono letti quando servono e saveStream
+// scrive solo le parti modificate
+type Stream struct {
+	streamMeta
+
+	c   *PodCache
+	key string
+	ns  int
+	// chunks sono i blocchi letti o scritti, dirty quelli da salvare e removed
+	// quelli svuotati dal trim
+	chunks  map[uint64][]StreamEntry
+	dirty   map[uint64]bool
+	removed []uint64
+
+	groups       []*ConsumerGroup
+	groupsLoaded bool
+	groupsDirty  bool
+	// err è il primo errore di lettura di una parte, riportato da saveStream
+	// e dalle operazioni di sola lettura
+	err error
+}
+
+// StreamTrim descrive le opzioni MAXLEN/MINID di XADD e XTRIM
+type StreamTrim struct {
+	MaxLen      int64
+	MinID       StreamID
+	ByMinID     bool
+	Approximate bool
+	Limit       int64
+}
+
+type StreamReadResult struct {
+	Key     string
+	Entries []StreamEntry
+}
+
+type PendingSummary struct {
+	Count     int
+	Smallest  StreamID
+	Greatest  StreamID
+	Consumers []ConsumerPendingCount
+}
+
+type ConsumerPendingCount struct {
+	Name  string
+	Count int
+}
+
+// ClaimOptions raccoglie i parametri opzionali di XCLAIM
+type ClaimOptions struct {
+	Idle       *int64
+	Time       *int64
+	RetryCount *uint64
+	Force      bool
+	JustID     bool
+}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+func (id StreamID) Compare(other StreamID) int {
+	switch {
+	case id.Ms < other.Ms:
+		return -1
+	case id.Ms > other.Ms:
+		return 1
+	case id.Seq < other.Seq:
+		return -1
+	case id.Seq > other.Seq:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.Compare(other) < 0
+}
+
+func (id StreamID) next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{Ms: id.Ms + 1}, true
+	default:
+		return id, false
+	}
+}
+
+func (id StreamID) previous() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	default:
+		return id, false
+	}
+}
+
+// ParseStreamID interpreta "ms-seq" oppure "ms"; in quest'ultimo caso usa defaultSeq.
+// "-" e "+" indicano il minimo e il massimo ID possibile.
+func ParseStreamID(s string, defaultSeq uint64) (StreamID, error) {
+	switch s {
+	case "-":
+		return MinStreamID, nil
+	case "+":
+		return MaxStreamID, nil
+	}
+
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: defaultSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// ParseRangeStart interpreta l'estremo inferiore di XRANGE, anche esclusivo "(id"
+func ParseRangeStart(s string) (StreamID, error) {
+	if exclusive, ok := strings.CutPrefix(s, "("); ok {
+		id, err := ParseStreamID(exclusive, 0)
+		if err != nil {
+			return id, err
+		}
+		if id, ok = id.next(); !ok {
+			return id, errors.New("invalid start ID for the interval")
+		}
+		return id, nil
+	}
+	return ParseStreamID(s, 0)
+}
+
+// ParseRangeEnd interpreta l'estremo superiore di XRANGE, anche esclusivo "(id"
+func ParseRangeEnd(s string) (StreamID, error) {
+	if exclusive, ok := strings.CutPrefix(s, "("); ok {
+		id, err := ParseStreamID(exclusive, math.MaxUint64)
+		if err != nil {
+			return id, err
+		}
+		if id, ok = id.previous(); !ok {
+			return id, errors.New("invalid end ID for the interval")
+		}
+		return id, nil
+	}
+	return ParseStreamID(s, math.MaxUint64)
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+/* ************************************************************************
+   Operazioni sulla struttura dati, senza accesso alla cache
+ * ************************************************************************ */
+
+func (s *Stream) length() int {
+	return int(s.EntriesAdded - s.Head)
+}
+
+func (s *Stream) chunkKey(n uint64) string {
+	return partKey(s.ns, s.key, strconv.FormatUint(n, 10))
+}
+
+// chunk restituisce il blocco n, leggendolo la prima volta che serve
+func (s *Stream) chunk(n uint64) []StreamEntry {
+	if entries, ok := s.chunks[n]; ok {
+		return entries
+	}
+	var entries []StreamEntry
+	value, err := s.c.getStored(s.key, s.chunkKey(n))
+	if err == nil && value == nil {
+		err = fmt.Errorf("block %d of stream %s is missing", n, s.key)
+	}
+	if err == nil {
+		err = gob.NewDecoder(bytes.NewReader(value)).Decode(&entries)
+	}
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	s.chunks[n] = entries
+	return entries
+}
+
+// at restituisce l'i-esima entry dello stream
+func (s *Stream) at(i int) StreamEntry {
+	p := s.Head + uint64(i)
+	if chunk := s.chunk(p / streamChunkSize); int(p%streamChunkSize) < len(chunk) {
+		return chunk[p%streamChunkSize]
+	}
+	return StreamEntry{}
+}
+
+func (s *Stream) append(entry StreamEntry) {
+	n := s.EntriesAdded / streamChunkSize
+	var chunk []StreamEntry
+	if offset := int(s.EntriesAdded % streamChunkSize); offset != 0 {
+		// un blocco scritto da un salvataggio non completato può avere entry in più
+		chunk = s.chunk(n)
+		chunk = chunk[:min(offset, len(chunk)):min(offset, len(chunk))]
+	}
+	s.chunks[n] = append(chunk, entry)
+	s.dirty[n] = true
+	s.LastID = entry.ID
+	s.EntriesAdded++
+}
+
+// search restituisce l'indice della prima entry con ID >= id
+func (s *Stream) search(id StreamID) int {
+	return sort.Search(s.length(), func(i int) bool {
+		return !s.at(i).ID.Less(id)
+	})
+}
+
+func (s *Stream) lookup(id StreamID) (StreamEntry, bool) {
+	i := s.search(id)
+	if i < s.length() {
+		if entry := s.at(i); entry.ID == id {
+			return entry, true
+		}
+	}
+	return StreamEntry{}, false
+}
+
+// nextID risolve la specifica di XADD: "*", "ms-*" oppure un ID esplicito
+func (s *Stream) nextID(spec string) (StreamID, error) {
+	if spec == "*" {
+		ms := uint64(nowMillis())
+		if ms > s.LastID.Ms {
+			return StreamID{Ms: ms}, nil
+		}
+		id, ok := s.LastID.next()
+		if !ok {
+			return id, ErrStreamExhausted
+		}
+		return id, nil
+	}
+
+	if msPart, ok := strings.CutSuffix(spec, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return StreamID{}, ErrInvalidStreamID
+		}
+		switch {
+		case ms > s.LastID.Ms:
+			return StreamID{Ms: ms}, nil
+		case ms == s.LastID.Ms && s.LastID == MinStreamID:
+			// 0-0 non è un ID valido, il primo disponibile è 0-1
+			return StreamID{Seq: 1}, nil
+		case ms == s.LastID.Ms && s.LastID.Seq < math.MaxUint64:
+			return StreamID{Ms: ms, Seq: s.LastID.Seq + 1}, nil
+		default:
+			return StreamID{}, ErrStreamIDTooSmall
+		}
+	}
+
+	id, err := ParseStreamID(spec, 0)
+	if err != nil {
+		return id, err
+	}
+	if id == MinStreamID {
+		return id, ErrStreamIDZero
+	}
+	if !s.LastID.Less(id) {
+		return id, ErrStreamIDTooSmall
+	}
+	return id, nil
+}
+
+func (s *Stream) rangeEntries(start, end StreamID, count int, reverse bool) []StreamEntry {
+	if end.Less(start) {
+		return nil
+	}
+	from := s.search(start)
+	to := sort.Search(s.length(), func(i int) bool {
+		return end.Less(s.at(i).ID)
+	})
+
+	var result []StreamEntry
+	if reverse {
+		for i := to - 1; i >= from && (count <= 0 || len(result) < count); i-- {
+			result = append(result, s.at(i))
+		}
+	} else {
+		for i := from; i < to && (count <= 0 || len(result) < count); i++ {
+			result = append(result, s.at(i))
+		}
+	}
+	return result
+}
+
+// trim rimuove le entry più vecchie secondo MAXLEN o MINID e restituisce quante ne ha tolte.
+// Il trim approssimato viene eseguito comunque in modo esatto, ma rispetta LIMIT.
+// I blocchi rimasti senza entry sono cancellati; le entry rimosse da un blocco
+// che ne contiene ancora restano memorizzate fino alla sua cancellazione.
+func (s *Stream) trim(opts StreamTrim) int64 {
+	remove := 0
+	if opts.ByMinID {
+		remove = s.search(opts.MinID)
+	} else if int64(s.length()) > opts.MaxLen {
+		remove = s.length() - int(opts.MaxLen)
+	}
+	if opts.Approximate && opts.Limit > 0 && int64(remove) > opts.Limit {
+		remove = int(opts.Limit)
+	}
+	if remove <= 0 {
+		return 0
+	}
+
+	if last := s.at(remove - 1).ID; s.MaxDeletedID.Less(last) {
+		s.MaxDeletedID = last
+	}
+	head := s.Head + uint64(remove)
+	// il blocco della nuova testa resta, anche se le sue entry sono state tutte rimosse
+	for n := s.Head / streamChunkSize; n < head/streamChunkSize; n++ {
+		s.removed = append(s.removed, n)
+		delete(s.chunks, n)
+		delete(s.dirty, n)
+	}
+	s.Head = head
+	return int64(remove)
+}
+
+// consumerGroups restituisce i consumer group, leggendoli la prima volta che servono
+func (s *Stream) consumerGroups() []*ConsumerGroup {
+	if s.groupsLoaded {
+		return s.groups
+	}
+	s.groupsLoaded = true
+	value, err := s.c.getStored(s.key, partKey(s.ns, s.key, groupsPart))
+	if err == nil && value != nil {
+		err = gob.NewDecoder(bytes.NewReader(value)).Decode(&s.groups)
+	}
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	return s.groups
+}
+
+func (s *Stream) group(name string) *ConsumerGroup {
+	for _, g := range s.consumerGroups() {
+		if g.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+func (g *ConsumerGroup) consumer(name string) *StreamConsumer {
+	for _, c := range g.Consumers {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// ensureConsumer restituisce il consumer, creandolo se non esiste; il bool indica la creazione
+func (g *ConsumerGroup) ensureConsumer(name string) (*StreamConsumer, bool) {
+	if c := g.consumer(name); c != nil {
+		return c, false
+	}
+	now := nowMillis()
+	c := &StreamConsumer{Name: name, SeenTime: now, ActiveTime: -1}
+	g.Consumers = append(g.Consumers, c)
+	return c, true
+}
+
+func (g *ConsumerGroup) pendingIndex(id StreamID) (int, bool) {
+	i := sort.Search(len(g.Pending), func(i int) bool {
+		return !g.Pending[i].ID.Less(id)
+	})
+	return i, i < len(g.Pending) && g.Pending[i].ID == id
+}
+
+// assign registra (o riassegna) l'entry nella PEL del gruppo e in quella del consumer
+func (g *ConsumerGroup) assign(entry PendingEntry) {
+	i, found := g.pendingIndex(entry.ID)
+	if found {
+		if previous := g.Pending[i].Consumer; previous != entry.Consumer {
+			if c := g.consumer(previous); c != nil {
+				c.removePending(entry.ID)
+			}
+		}
+		g.Pending[i] = entry
+	} else {
+		g.Pending = append(g.Pending, PendingEntry{})
+		copy(g.Pending[i+1:], g.Pending[i:])
+		g.Pending[i] = entry
+	}
+
+	c, _ := g.ensureConsumer(entry.Consumer)
+	c.addPending(entry.ID)
+}
+
+func (g *ConsumerGroup) ack(id StreamID) bool {
+	i, found := g.pendingIndex(id)
+	if !found {
+		return false
+	}
+	if c := g.consumer(g.Pending[i].Consumer); c != nil {
+		c.removePending(id)
+	}
+	g.Pending = append(g.Pending[:i], g.Pending[i+1:]...)
+	return true
+}
+
+func (c *StreamConsumer) pendingIndex(id StreamID) (int, bool) {
+	i := sort.Search(len(c.Pending), func(i int) bool {
+		return !c.Pending[i].Less(id)
+	})
+	return i, i < len(c.Pending) && c.Pending[i] == id
+}
+
+func (c *StreamConsumer) addPending(id StreamID) {
+	i, found := c.pendingIndex(id)
+	if found {
+		return
+	}
+	c.Pending = append(c.Pending, StreamID{})
+	copy(c.Pending[i+1:], c.Pending[i:])
+	c.Pending[i] = id
+}
+
+func (c *StreamConsumer) removePending(id StreamID) {
+	if i, found := c.pendingIndex(id); found {
+		c.Pending = append(c.Pending[:i], c.Pending[i+1:]...)
+	}
+}
+
+/* ************************************************************************
+   Accesso agli stream memorizzati nella cache
+ * ************************************************************************ */
+
+// loadStream restituisce lo stream in key, nil se la chiave non esiste.
+// Legge solo i metadati: blocchi e consumer group sono letti quando servono.
+// Il caller deve possedere il lock della stripe.
+func (c *PodCache) loadStream(key string) (*Stream, error) {
+	value, err := c.get(key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	stream := c.newStream(key)
+	if err := decodeTyped(value, TypeStream, &stream.streamMeta); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (c *PodCache) newStream(key string) *Stream {
+	return &Stream{
+		c:      c,
+		key:    key,
+		ns:     c.namespace(),
+		chunks: make(map[uint64][]StreamEntry),
+		dirty:  make(map[uint64]bool),
+	}
+}
+
+// saveStream scrive i blocchi modificati, i consumer group se modificati e i
+// metadati, e cancella i blocchi svuotati dal trim
+func (c *PodCache) saveStream(stream *Stream) error {
+	if stream.err != nil {
+		return stream.err
+	}
+	for n := range stream.dirty {
+		if err := c.putPart(stream.key, stream.chunkKey(n), stream.chunks[n]); err != nil {
+			return err
+		}
+	}
+	for _, n := range stream.removed {
+		if err := c.evictPart(stream.key, stream.chunkKey(n)); err != nil {
+			return err
+		}
+	}
+	if stream.groupsDirty {
+		groups := partKey(stream.ns, stream.key, groupsPart)
+		var err error
+		if len(stream.groups) == 0 {
+			err = c.evictPart(stream.key, groups)
+		} else {
+			err = c.putPart(stream.key, groups, stream.groups)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	clear(stream.dirty)
+	stream.removed = nil
+	stream.groupsDirty = false
+
+	value, err := encodeTyped(TypeStream, &stream.streamMeta)
+	if err != nil {
+		return err
+	}
+	stored := c.storageKey(stream.key)
+	c.parted[stripeIndex(stream.key)][stored] = true
+	return c.putValue(stream.key, stored, value)
+}
+
+// streamParts restituisce le parti interne dello stream in key, dato il valore della chiave
+func streamParts(ns int, key string, value []byte) ([]string, error) {
+	var meta streamMeta
+	if err := decodeTyped(value, TypeStream, &meta); err != nil {
+		return nil, err
+	}
+	parts := []string{partKey(ns, key, groupsPart)}
+	for n := meta.Head / streamChunkSize; n*streamChunkSize < meta.EntriesAdded; n++ {
+		parts = append(parts, partKey(ns, key, strconv.FormatUint(n, 10)))
+	}
+	return parts, nil
+}
+
+// XAdd aggiunge un'entry allo stream e applica l'eventuale trim.
+// Con noMkStream e chiave assente non crea nulla e restituisce false.
+func (c *PodCache) XAdd(key, idSpec string, fields []string, noMkStream bool, trim *StreamTrim) (StreamID, bool, error) {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	stream, err := c.loadStream(key)
+	if err != nil {
+		return StreamID{}, false, err
+	}
+	if stream == nil {
+		if noMkStream {
+			return StreamID{}, false, nil
+		}
+		stream = c.newStream(key)
+	}
+
+	id, err := stream.nextID(idSpec)
+	if err != nil {
+		return StreamID{}, false, err
+	}
+
+	stream.append(StreamEntry{ID: id, Fields: fields})
+	var trimmed int64
+	if trim != nil {
+		trimmed = stream.trim(*trim)
+	}
+
+	if err := c.saveStream(stream); err != nil {
+		return StreamID{}, false, err
+	}
+	c.notify(EventStream, "xadd", key)
+	if trimmed > 0 {
+		c.notify(EventStream, "xtrim", key)
+	}
+	return id, true, nil
+}
+
+// XRange restituisce le entry con start <= ID <= end; count <= 0 significa nessun limite
+func (c *PodCache) XRange(key string, start, end StreamID, count int, reverse bool) ([]StreamEntry, error) {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	stream, err := c.loadStream(key)
+	if err != nil || stream == nil {
+		return nil, err
+	}
+	entries := stream.rangeEntries(start, end, count, reverse)
+	return entries, stream.err
+}
+
+func (c *PodCache) XLen(key string) (int, error) {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	stream, err := c.loadStream(key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	return stream.length(), nil
+}
+
+func (c *PodCache) XTrim(key string, trim StreamTrim) (int64, error) {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	stream, err := c.loadStream(key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	removed := stream.trim(trim)
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := c.saveStream(stream); err != nil {
+		return 0, err
+	}
+	c.notify(EventStream, "xtrim", key)
+	return removed, nil
+}
+
+// StreamLastIDs restituisce l'ultimo ID di ogni stream, usato per risolvere "$"
+func (c *PodCache) StreamLastIDs(keys ...string) ([]StreamID, error) {
+	unlock := c.lockKeys(keys...)
+	defer unlock()
+
+	ids := make([]StreamID, len(keys))
+	for i, key := range keys {
+		stream, err := c.loadStream(key)
+		if err != nil {
+			return nil, err
+		}
+		if stream != nil {
+			ids[i] = stream.LastID
+		}
+	}
+	return ids, nil
+}
+
+// XRead restituisce, per ogni stream, le entry con ID strettamente maggiore di quello indicato
+func (c *PodCache) XRead(keys []string, after []StreamID, count int) ([]StreamReadResult, error) {
+	unlock := c.lockKeys(keys...)
+	defer unlock()
+
+	var results []StreamReadResult
+	for i, key := range keys {
+		stream, err := c.loadStream(key)
+		if err != nil {
+			return nil, err
+		}
+		if stream == nil {
+			continue
+		}
+		start, ok := after[i].next()
+		if !ok {
+			continue
+		}
+		entries := stream.rangeEntries(start, MaxStreamID, count, false)
+		if stream.err != nil {
+			return nil, stream.err
+		}
+		if len(entries) > 0 {
+			results = append(results, StreamReadResult{Key: key, Entries: entries})
+		}
+	}
+	return results, nil
+}
+
+func noGroupError(key, group, command string) error {
+	return fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s' in %s", key, group, command)
+}
+
+// XGroupCreate crea un consumer group; id "$" parte dall'ultima entry dello stream
+func (c *PodCache) XGroupCreate(key, group, id string, mkStream bool, entriesRead int64) error {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	stream, err := c.loadStream(key)
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		if !mkStream {
+			return ErrStreamMissing
+		}
+		stream = c.newStream(key)
+	}
+	if stream.group(group) != nil {
+		return ErrGroupExists
+	}
+
+	lastID := stream.LastID
+	if id != "$" {
+		if lastID, err = ParseStreamID(id, 0); err != nil {
+			return err
+		}
+	}
+
+	stream.groups = append(stream.consumerGroups(), &ConsumerGroup{
+		Name:        group,
+		LastID:      lastID,
+		EntriesRead: entriesRead,
+	})
+	stream.groupsDirty = true
+	if err := c.saveStream(stream); err != nil {
+		return err
+	}
+	c.notify(EventStream, "xgroup-create", key)
+	return nil
+}
+
+func (c *PodCache) XGroupSetID(key, group, id string, entriesRead int64) error {
+	return c.updateGroup(key, group, "XGROUP", "xgroup-setid", func(stream *Stream, g *ConsumerGroup) (bool, error) {
+		lastID := stream.LastID
+		if id != "$" {
+			var err error
+			if lastID, err = ParseStreamID(id, 0); err != nil {
+				return false, err
+			}
+		}
+		g.LastID = lastID
+		g.EntriesRead = entriesRead
+		return true, nil
+	})
+}
+
+func (c *PodCache) XGroupDestroy(key, group string) (bool, error) {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	stream, err := c.loadStream(key)
+	if err != nil {
+		return false, err
+	}
+	if stream == nil {
+		return false, ErrStreamMissing
+	}
+	for i, g := range stream.consumerGroups() {
+		if g.Name == group {
+			stream.groups = append(stream.groups[:i], stream.groups[i+1:]...)
+			stream.groupsDirty = true
+			if err := c.saveStream(stream); err != nil {
+				return false, err
+			}
+			c.notify(EventStream, "xgroup-destroy", key)
+			return true, nil
+		}
+	}
+	return false, stream.err
+}
+
+func (c *PodCache) XGroupCreateConsumer(key, group, consumer string) (bool, error) {
+	created := false
+	err := c.updateGroup(key, group, "XGROUP", "xgroup-createconsumer", func(stream *Stream, g *ConsumerGroup) (bool, error) {
+		_, created = g.ensureConsumer(consumer)
+		return created, nil
+	})
+	return created, err
+}
+
+// XGroupDelConsumer elimina il consumer e restituisce quante entry aveva in sospeso
+func (c *PodCache) XGroupDelConsumer(key, group, consumer string) (int, error) {
+	pending := 0
+	err := c.updateGroup(key, group, "XGROUP", "xgroup-delconsumer", func(stream *Stream, g *ConsumerGroup) (bool, error) {
+		for i, cons := range g.Consumers {
+			if cons.Name != consumer {
+				continue
+			}
+			pending = len(cons.Pending)
+			for _, id := range append([]StreamID(nil), cons.Pending...) {
+				g.ack(id)
+			}
+			g.Consumers = append(g.Consumers[:i], g.Consumers[i+1:]...)
+			return true, nil
+		}
+		return false, nil
+	})
+	return pending, err
+}
+
+// updateGroup carica lo stream e il gruppo ed esegue fn; se fn riporta modifiche lo
+// stream viene salvato e, se event non è vuoto, viene notificato l'evento
+func (c *PodCache) updateGroup(key, group, command, event string, fn func(*Stream, *ConsumerGroup) (bool, error)) error {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	stream, err := c.loadStream(key)
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		if command == "XGROUP" {
+			return ErrStreamMissing
+		}
+		return noGroupError(key, group, command)
+	}
+	g := stream.group(group)
+	if stream.err != nil {
+		return stream.err
+	}
+	if g == nil {
+		return noGroupError(key, group, command)
+	}
+
+	changed, err := fn(stream, g)
+	if err == nil {
+		err = stream.err
+	}
+	if err != nil || !changed {
+		return err
+	}
+	stream.groupsDirty = true
+	if err := c.saveStream(stream); err != nil {
+		return err
+	}
+	if event != "" {
+		c.notify(EventStream, event, key)
+	}
+	return nil
+}
+
+// XReadGroup legge per conto di un consumer. L'ID ">" consegna le nuove entry
+// (aggiornando la PEL salvo noAck), un ID esplicito rilegge la PEL del consumer.
+func (c *PodCache) XReadGroup(group, consumer string, keys, ids []string, count int, noAck bool) ([]StreamReadResult, error) {
+	unlock := c.lockKeys(keys...)
+	defer unlock()
+
+	type loaded struct {
+		stream *Stream
+		group  *ConsumerGroup
+	}
+	streams := make([]loaded, len(keys))
+	for i, key := range keys {
+		stream, err := c.loadStream(key)
+		if err != nil {
+			return nil, err
+		}
+		var g *ConsumerGroup
+		if stream != nil {
+			if g = stream.group(group); stream.err != nil {
+				return nil, stream.err
+			}
+		}
+		if g == nil {
+			return nil, noGroupError(key, group, "XREADGROUP with GROUP option")
+		}
+		streams[i] = loaded{stream: stream, group: g}
+	}
+
+	now := nowMillis()
+	var results []StreamReadResult
+	for i, key := range keys {
+		stream, g := streams[i].stream, streams[i].group
+		cons, created := g.ensureConsumer(consumer)
+		cons.SeenTime = now
+
+		if ids[i] == ">" {
+			start, _ := g.LastID.next()
+			entries := stream.rangeEntries(start, MaxStreamID, count, false)
+			if len(entries) > 0 {
+				cons.ActiveTime = now
+				g.LastID = entries[len(entries)-1].ID
+				g.EntriesRead += int64(len(entries))
+				if !noAck {
+					for _, entry := range entries {
+						g.assign(PendingEntry{ID: entry.ID, Consumer: consumer, DeliveryTime: now, DeliveryCount: 1})
+					}
+				}
+				results = append(results, StreamReadResult{Key: key, Entries: entries})
+			}
+		} else {
+			after, err := ParseStreamID(ids[i], 0)
+			if err != nil {
+				return nil, err
+			}
+			var entries []StreamEntry
+			for _, id := range cons.Pending {
+				if !after.Less(id) {
+					continue
+				}
+				if count > 0 && len(entries) >= count {
+					break
+				}
+				entry, found := stream.lookup(id)
+				if !found {
+					entry = StreamEntry{ID: id}
+				}
+				entries = append(entries, entry)
+			}
+			// la lettura della storia restituisce sempre la chiave, anche vuota
+			results = append(results, StreamReadResult{Key: key, Entries: entries})
+		}
+
+		stream.groupsDirty = true
+		if err := c.saveStream(stream); err != nil {
+			return nil, err
+		}
+		if created {
+			c.notify(EventStream, "xgroup-createconsumer", key)
+		}
+	}
+	return results, nil
+}
+
+func (c *PodCache) XAck(key, group string, ids []StreamID) (int, error) {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	stream, err := c.loadStream(key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	g := stream.group(group)
+	if g == nil {
+		return 0, stream.err
+	}
+
+	acked := 0
+	for _, id := range ids {
+		if g.ack(id) {
+			acked++
+		}
+	}
+	if acked == 0 {
+		return 0, nil
+	}
+	stream.groupsDirty = true
+	return acked, c.saveStream(stream)
+}
+
+// XPending restituisce il riepilogo della PEL del gruppo
+func (c *PodCache) XPending(key, group string) (PendingSummary, error) {
+	var summary PendingSummary
+	err := c.updateGroup(key, group, "XPENDING", "", func(stream *Stream, g *ConsumerGroup) (bool, error) {
+		summary.Count = len(g.Pending)
+		if summary.Count == 0 {
+			return false, nil
+		}
+		summary.Smallest = g.Pending[0].ID
+		summary.Greatest = g.Pending[len(g.Pending)-1].ID
+		for _, cons := range g.Consumers {
+			if len(cons.Pending) > 0 {
+				summary.Consumers = append(summary.Consumers, ConsumerPendingCount{Name: cons.Name, Count: len(cons.Pending)})
+			}
+		}
+		return false, nil
+	})
+	return summary, err
+}
+
+// XPendingRange restituisce le entry in sospeso nell'intervallo, filtrate per idle minimo e consumer
+func (c *PodCache) XPendingRange(key, group string, minIdle int64, start, end StreamID, count int, consumer string) ([]PendingEntry, error) {
+	var result []PendingEntry
+	err := c.updateGroup(key, group, "XPENDING", "", func(stream *Stream, g *ConsumerGroup) (bool, error) {
+		now := nowMillis()
+		from, _ := g.pendingIndex(start)
+		for _, pending := range g.Pending[from:] {
+			if end.Less(pending.ID) || len(result) >= count {
+				break
+			}
+			if consumer != "" && pending.Consumer != consumer {
+				continue
+			}
+			if now-pending.DeliveryTime < minIdle {
+				continue
+			}
+			result = append(result, pending)
+		}
+		return false, nil
+	})
+	return result, err
+}
+
+// XClaim trasferisce al consumer le entry in sospeso da almeno minIdle millisecondi.
+// Le entry non più presenti nello stream vengono rimosse dalla PEL e non restituite.
+func (c *PodCache) XClaim(key, group, consumer string, minIdle int64, ids []StreamID, opts ClaimOptions) ([]StreamEntry, error) {
+	var claimed []StreamEntry
+	created := false
+	err := c.updateGroup(key, group, "XCLAIM", "", func(stream *Stream, g *ConsumerGroup) (bool, error) {
+		now := nowMillis()
+		deliveryTime := now
+		if opts.Idle != nil {
+			deliveryTime = now - *opts.Idle
+		} else if opts.Time != nil {
+			deliveryTime = *opts.Time
+		}
+
+		var cons *StreamConsumer
+		cons, created = g.ensureConsumer(consumer)
+		cons.SeenTime = now
+
+		for _, id := range ids {
+			i, found := g.pendingIndex(id)
+			entry, exists := stream.lookup(id)
+			if !exists {
+				if found {
+					g.ack(id)
+				}
+				continue
+			}
+
+			var pending PendingEntry
+			if found {
+				pending = g.Pending[i]
+				if minIdle > 0 && now-pending.DeliveryTime < minIdle {
+					continue
+				}
+			} else if opts.Force {
+				pending = PendingEntry{ID: id}
+			} else {
+				continue
+			}
+
+			pending.Consumer = consumer
+			pending.DeliveryTime = deliveryTime
+			if opts.RetryCount != nil {
+				pending.DeliveryCount = *opts.RetryCount
+			} else if !opts.JustID {
+				pending.DeliveryCount++
+			}
+			g.assign(pending)
+			cons.ActiveTime = now
+
+			if opts.JustID {
+				entry = StreamEntry{ID: id}
+			}
+			claimed = append(claimed, entry)
+		}
+		return true, nil
+	})
+	if err == nil && created {
+		c.notify(EventStream, "xgroup-createconsumer", key)
+	}
+	return claimed, err
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStreamAddRange(t *testing.T) {
+	c := newTestPodCache(t, 2, 1024*1024)
+
+	for i := 1; i <= 5; i++ {
+		id, added, err := c.XAdd("events", fmt.Sprintf("1-%d", i), []string{"n", fmt.Sprint(i)}, false, nil)
+		if err != nil || !added {
+			t.Fatalf("XAdd() = %v, %v, %v", id, added, err)
+		}
+	}
+
+	if _, _, err := c.XAdd("events", "1-3", []string{"n", "x"}, false, nil); !errors.Is(err, ErrStreamIDTooSmall) {
+		t.Fatalf("XAdd() with old ID = %v, want ErrStreamIDTooSmall", err)
+	}
+	if _, _, err := c.XAdd("fresh", "0-0", []string{"n", "x"}, false, nil); !errors.Is(err, ErrStreamIDZero) {
+		t.Fatalf("XAdd() with 0-0 = %v, want ErrStreamIDZero", err)
+	}
+
+	id, _, err := c.XAdd("events", "1-*", []string{"n", "6"}, false, nil)
+	if err != nil || id != (StreamID{Ms: 1, Seq: 6}) {
+		t.Fatalf("XAdd(1-*) = %v, %v", id, err)
+	}
+
+	entries, err := c.XRange("events", StreamID{Ms: 1, Seq: 2}, StreamID{Ms: 1, Seq: 4}, -1, false)
+	if err != nil || len(entries) != 3 || entries[0].ID.Seq != 2 {
+		t.Fatalf("XRange() = %v, %v", entries, err)
+	}
+
+	entries, _ = c.XRange("events", MinStreamID, MaxStreamID, 2, true)
+	if len(entries) != 2 || entries[0].ID.Seq != 6 {
+		t.Fatalf("XRange(reverse) = %v", entries)
+	}
+
+	removed, err := c.XTrim("events", StreamTrim{MaxLen: 2})
+	if err != nil || removed != 4 {
+		t.Fatalf("XTrim() = %d, %v", removed, err)
+	}
+	if n, _ := c.XLen("events"); n != 2 {
+		t.Fatalf("XLen() after trim = %d, want 2", n)
+	}
+
+	if _, err := c.Get("events"); !errors.Is(err, ErrWrongType) {
+		t.Fatalf("Get() on stream = %v, want ErrWrongType", err)
+	}
+}
+
+func TestStreamConsumerGroup(t *testing.T) {
+	c := newTestPodCache(t, 1, 1024*1024)
+
+	if err := c.XGroupCreate("jobs", "workers", "$", false, -1); !errors.Is(err, ErrStreamMissing) {
+		t.Fatalf("XGroupCreate() without stream = %v", err)
+	}
+	if err := c.XGroupCreate("jobs", "workers", "$", true, -1); err != nil {
+		t.Fatalf("XGroupCreate(MKSTREAM) returned an error: %v", err)
+	}
+	if err := c.XGroupCreate("jobs", "workers", "$", true, -1); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("XGroupCreate() twice = %v, want ErrGroupExists", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		c.XAdd("jobs", fmt.Sprintf("%d-0", i), []string{"job", fmt.Sprint(i)}, false, nil)
+	}
+
+	results, err := c.XReadGroup("workers", "alice", []string{"jobs"}, []string{">"}, 2, false)
+	if err != nil || len(results) != 1 || len(results[0].Entries) != 2 {
+		t.Fatalf("XReadGroup(alice) = %v, %v", results, err)
+	}
+	results, _ = c.XReadGroup("workers", "bob", []string{"jobs"}, []string{">"}, 0, false)
+	if len(results) != 1 || len(results[0].Entries) != 1 || results[0].Entries[0].ID.Ms != 3 {
+		t.Fatalf("XReadGroup(bob) = %v", results)
+	}
+
+	summary, err := c.XPending("jobs", "workers")
+	if err != nil || summary.Count != 3 || len(summary.Consumers) != 2 {
+		t.Fatalf("XPending() = %+v, %v", summary, err)
+	}
+
+	claimed, err := c.XClaim("jobs", "workers", "bob", 0, []StreamID{{Ms: 1}}, ClaimOptions{})
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("XClaim() = %v, %v", claimed, err)
+	}
+	pending, _ := c.XPendingRange("jobs", "workers", 0, MinStreamID, MaxStreamID, 10, "bob")
+	if len(pending) != 2 || pending[0].DeliveryCount != 2 {
+		t.Fatalf("XPendingRange(bob) = %+v", pending)
+	}
+
+	acked, err := c.XAck("jobs", "workers", []StreamID{{Ms: 1}, {Ms: 2}, {Ms: 9}})
+	if err != nil || acked != 2 {
+		t.Fatalf("XAck() = %d, %v", acked, err)
+	}
+
+	// la storia di bob contiene solo la 3-0 ancora da confermare
+	results, _ = c.XReadGroup("workers", "bob", []string{"jobs"}, []string{"0"}, 0, false)
+	if len(results) != 1 || len(results[0].Entries) != 1 || results[0].Entries[0].ID.Ms != 3 {
+		t.Fatalf("XReadGroup(history) = %v", results)
+	}
+
+	deleted, err := c.XGroupDelConsumer("jobs", "workers", "bob")
+	if err != nil || deleted != 1 {
+		t.Fatalf("XGroupDelConsumer() = %d, %v", deleted, err)
+	}
+	if _, err := c.XReadGroup("missing", "bob", []string{"jobs"}, []string{">"}, 0, false); err == nil {
+		t.Fatal("XReadGroup() on unknown group should fail")
+	}
+}
+
+func TestStreamSpillToDisk(t *testing.T) {
+	// una sola partizione piccola: lo stream finisce su disco appena arrivano altre chiavi
+	c := newTestPodCache(t, 1, 4096)
+
+	c.XAdd("audit", "1-1", []string{"user", "carlo"}, false, nil)
+	c.XGroupCreate("audit", "readers", "0", false, -1)
+	for i := 0; i < 10; i++ {
+		c.Put(fmt.Sprintf("filler-%d", i), make([]byte, 1000))
+	}
+
+	if stats := c.Stats(); stats.Disk.Entries == 0 {
+		t.Fatal("expected entries on disk after memory pressure")
+	}
+
+	results, err := c.XReadGroup("readers", "r1", []string{"audit"}, []string{">"}, 0, false)
+	if err != nil || len(results) != 1 || results[0].Entries[0].Fields[1] != "carlo" {
+		t.Fatalf("XReadGroup() after spill = %v, %v", results, err)
+	}
+}
+
+// storedEntries conta le chiavi memorizzate in RAM e su disco, parti interne comprese
+func storedEntries(c *PodCache) uint64 {
+	stats := c.Stats()
+	n := stats.Disk.Entries
+	for _, p := range stats.Partitions {
+		n += p.Entries
+	}
+	return n
+}
+
+func TestStreamChunks(t *testing.T) {
+	// partizione piccola: parte dei blocchi finisce su disco
+	c := newTestPodCache(t, 1, 2048)
+
+	for i := 1; i <= 250; i++ {
+		if _, _, err := c.XAdd("log", fmt.Sprintf("1-%d", i), []string{"n", fmt.Sprint(i)}, false, nil); err != nil {
+			t.Fatalf("XAdd(1-%d) = %v", i, err)
+		}
+	}
+	if err := c.XGroupCreate("log", "readers", "0", false, -1); err != nil {
+		t.Fatalf("XGroupCreate() = %v", err)
+	}
+	// metadati, 3 blocchi e consumer group
+	if n := storedEntries(c); n != 5 {
+		t.Fatalf("stored entries = %d, want 5", n)
+	}
+	if stats := c.Stats(); stats.Disk.Entries == 0 {
+		t.Fatal("expected stream blocks on disk")
+	}
+	if n := c.DBSize(); n != 1 {
+		t.Fatalf("DBSize() = %d, want 1", n)
+	}
+	if n, _ := c.XLen("log"); n != 250 {
+		t.Fatalf("XLen() = %d, want 250", n)
+	}
+
+	entries, err := c.XRange("log", StreamID{Ms: 1, Seq: 95}, StreamID{Ms: 1, Seq: 105}, -1, false)
+	if err != nil || len(entries) != 11 || entries[0].Fields[1] != "95" || entries[10].Fields[1] != "105" {
+		t.Fatalf("XRange() across blocks = %v, %v", entries, err)
+	}
+
+	removed, err := c.XTrim("log", StreamTrim{MaxLen: 120})
+	if err != nil || removed != 130 {
+		t.Fatalf("XTrim() = %d, %v", removed, err)
+	}
+	// il primo blocco è stato cancellato, il secondo contiene ancora entry
+	if n := storedEntries(c); n != 4 {
+		t.Fatalf("stored entries after trim = %d, want 4", n)
+	}
+	entries, _ = c.XRange("log", MinStreamID, MaxStreamID, 1, false)
+	if len(entries) != 1 || entries[0].ID != (StreamID{Ms: 1, Seq: 131}) {
+		t.Fatalf("first entry after trim = %v", entries)
+	}
+	id, _, err := c.XAdd("log", "*", []string{"n", "251"}, false, nil)
+	if err != nil || !(StreamID{Ms: 1, Seq: 250}).Less(id) {
+		t.Fatalf("XAdd() after trim = %v, %v", id, err)
+	}
+	results, err := c.XReadGroup("readers", "r1", []string{"log"}, []string{">"}, 10, false)
+	if err != nil || len(results) != 1 || results[0].Entries[0].ID != (StreamID{Ms: 1, Seq: 131}) {
+		t.Fatalf("XReadGroup() = %v, %v", results, err)
+	}
+
+	// riscrivere la chiave cancella blocchi e consumer group
+	if err := c.Put("log", []byte("plain")); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+	if n := storedEntries(c); n != 1 {
+		t.Fatalf("stored entries after overwrite = %d, want 1", n)
+	}
+	c.Evict("log")
+	c.XAdd("log", "*", []string{"n", "1"}, false, nil)
+	if n, _ := c.XLen("log"); n != 1 {
+		t.Fatalf("XLen() of new stream = %d, want 1", n)
+	}
+	if err := c.XGroupCreate("log", "readers", "0", false, -1); err != nil {
+		t.Fatalf("XGroupCreate() on new stream = %v", err)
+	}
+	c.Evict("log")
+	if n := storedEntries(c); n != 0 {
+		t.Fatalf("stored entries after evict = %d, want 0", n)
+	}
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// TierUsage registra i livelli che hanno servito le operazioni fatte tramite
+// una vista (vedi WithTierUsage): le partizioni RAM in cui le chiavi sono state
+// trovate o scritte e il disco, letto o scritto anche per uno spill. Non è
+// sincronizzato: va usato da una sola goroutine, come la vista di un client.
+type TierUsage struct {
+	// partitions è una bitmask: le partizioni sono al più 255
+	partitions [4]uint64
+	disk       bool
+}
+
+// WithTierUsage restituisce una vista della cache che registra in usage i
+// livelli usati; la registrazione si conserva nelle viste derivate (Select, Atomic)
+func (c *PodCache) WithTierUsage(usage *TierUsage) *PodCache {
+	view := *c
+	view.usage = usage
+	return &view
+}
+
+// Reset azzera la registrazione, es. prima di ogni comando
+func (u *TierUsage) Reset() {
+	*u = TierUsage{}
+}
+
+func (u *TierUsage) partition(i int) {
+	if u != nil {
+		u.partitions[i/64] |= 1 << (i % 64)
+	}
+}
+
+func (u *TierUsage) useDisk() {
+	if u != nil {
+		u.disk = true
+	}
+}
+
+// String descrive i livelli usati, es. "ram:0,2 disk", o "none"
+func (u *TierUsage) String() string {
+	var parts []string
+	var ram []string
+	for word, mask := range u.partitions {
+		for mask != 0 {
+			bit := bits.TrailingZeros64(mask)
+			ram = append(ram, strconv.Itoa(word*64+bit))
+			mask &^= 1 << bit
+		}
+	}
+	if len(ram) > 0 {
+		parts = append(parts, "ram:"+strings.Join(ram, ","))
+	}
+	if u.disk {
+		parts = append(parts, "disk")
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+// I valori diversi dalle stringhe vengono serializzati con un header dedicato,
+// in questo modo partizioni RAM e disco continuano a gestire solo []byte e
+// lo spill su disco funziona per ogni tipo senza casi particolari.
+
+type ValueType byte
+
+const (
+	TypeString ValueType = iota
+	TypeStream
+	TypeZSet
+)
+
+// typedMagic inizia i valori strutturati. Un client può scriverlo in una
+// stringa: encodeString la memorizza allora con l'header di TypeString, così i
+// valori delle stringhe restano binary-safe e non si confondono con gli altri tipi.
+var typedMagic = []byte("\x00PCTYPE")
+
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+func (t ValueType) String() string {
+	switch t {
+	case TypeStream:
+		return "stream"
+	case TypeZSet:
+		return "zset"
+	default:
+		return "string"
+	}
+}
+
+func valueTypeOf(value []byte) ValueType {
+	if len(value) <= len(typedMagic) || !bytes.HasPrefix(value, typedMagic) {
+		return TypeString
+	}
+	return ValueType(value[len(typedMagic)])
+}
+
+// encodeString restituisce il valore da memorizzare per una stringa del client
+func encodeString(value []byte) []byte {
+	if !bytes.HasPrefix(value, typedMagic) {
+		return value
+	}
+	stored := make([]byte, 0, len(typedMagic)+1+len(value))
+	stored = append(stored, typedMagic...)
+	stored = append(stored, byte(TypeString))
+	return append(stored, value...)
+}
+
+// decodeString è l'inverso di encodeString; value deve essere di tipo TypeString
+func decodeString(value []byte) []byte {
+	if len(value) <= len(typedMagic) || !bytes.HasPrefix(value, typedMagic) {
+		return value
+	}
+	return value[len(typedMagic)+1:]
+}
+
+func encodeTyped(t ValueType, v any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(typedMagic)
+	buf.WriteByte(byte(t))
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to encode %s value: %w", t, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeTyped(value []byte, t ValueType, v any) error {
+	if valueTypeOf(value) != t {
+		return ErrWrongType
+	}
+	payload := bytes.NewReader(value[len(typedMagic)+1:])
+	if err := gob.NewDecoder(payload).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s value: %w", t, err)
+	}
+	return nil
+}
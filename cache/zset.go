@@ -0,0 +1,444 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"mi0772/podcache/hash"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// SortedSet mantiene i membri ordinati per (score, member) come in Redis.
+// È la base dei comandi GEO: lo score è il geohash a 52 bit del membro.
+// Come gli stream è diviso in parti interne della chiave (vedi partPrefix): i
+// membri stanno in blocchi ordinati di al più zsetChunkSize elementi e gli
+// score in bucket scelti dall'hash del membro; la chiave contiene solo l'elenco
+// dei blocchi. Un aggiornamento legge e riscrive l'elenco, un bucket e uno o
+// due blocchi, non l'intero insieme.
+
+const (
+	// zsetChunkSize è il numero massimo di membri di un blocco: oltre si divide
+	// in due, sotto un quarto si unisce a un vicino se il risultato ci sta
+	zsetChunkSize = 128
+	// zsetBucketLoad è il numero medio di membri per bucket oltre il quale i
+	// bucket raddoppiano
+	zsetBucketLoad = 64
+)
+
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+func zLess(a, b ZMember) bool {
+	if a.Score != b.Score {
+		return a.Score < b.Score
+	}
+	return a.Member < b.Member
+}
+
+// zsetMeta è il valore memorizzato nella chiave di un sorted set
+type zsetMeta struct {
+	Card int
+	// Chunks sono i blocchi in ordine di (score, member)
+	Chunks    []zsetChunk
+	NextChunk uint64
+	// Buckets è il numero di bucket degli score, una potenza di 2
+	Buckets int
+}
+
+// zsetChunk descrive un blocco di membri: First è il primo
+type zsetChunk struct {
+	ID    uint64
+	First ZMember
+	Count int
+}
+
+// SortedSet è un sorted set caricato da un'operazione che possiede il lock
+// della sua stripe: blocchi e bucket sono letti quando servono e saveZSet
+// scrive solo quelli modificati
+type SortedSet struct {
+	zsetMeta
+
+	c   *PodCache
+	key string
+	ns  int
+	// chunks e buckets sono le parti lette o scritte, dirtyChunks e
+	// dirtyBuckets quelle da salvare e removed i blocchi eliminati
+	chunks       map[uint64][]ZMember
+	buckets      map[int]map[string]float64
+	dirtyChunks  map[uint64]bool
+	dirtyBuckets map[int]bool
+	removed      []uint64
+	// err è il primo errore di lettura di una parte, riportato da saveZSet
+	// e dalle operazioni di sola lettura
+	err error
+}
+
+/* ************************************************************************
+   Operazioni sulla struttura dati
+ * ************************************************************************ */
+
+func (z *SortedSet) chunkKey(id uint64) string {
+	return partKey(z.ns, z.key, "z"+strconv.FormatUint(id, 10))
+}
+
+func (z *SortedSet) bucketKey(n int) string {
+	return partKey(z.ns, z.key, "s"+strconv.Itoa(n))
+}
+
+// chunk restituisce i membri del blocco id, leggendolo la prima volta che serve
+func (z *SortedSet) chunk(id uint64) []ZMember {
+	if members, ok := z.chunks[id]; ok {
+		return members
+	}
+	var members []ZMember
+	value, err := z.c.getStored(z.key, z.chunkKey(id))
+	if err == nil && value == nil {
+		err = fmt.Errorf("block %d of sorted set %s is missing", id, z.key)
+	}
+	if err == nil {
+		err = gob.NewDecoder(bytes.NewReader(value)).Decode(&members)
+	}
+	if err != nil && z.err == nil {
+		z.err = err
+	}
+	z.chunks[id] = members
+	return members
+}
+
+// bucket restituisce il bucket n degli score; un bucket vuoto non è memorizzato
+func (z *SortedSet) bucket(n int) map[string]float64 {
+	if scores, ok := z.buckets[n]; ok {
+		return scores
+	}
+	scores := make(map[string]float64)
+	value, err := z.c.getStored(z.key, z.bucketKey(n))
+	if err == nil && value != nil {
+		err = gob.NewDecoder(bytes.NewReader(value)).Decode(&scores)
+	}
+	if err != nil && z.err == nil {
+		z.err = err
+	}
+	z.buckets[n] = scores
+	return scores
+}
+
+func bucketIndex(member string, buckets int) int {
+	return int(hash.CalculateDJB2(member) & uint32(buckets-1))
+}
+
+func (z *SortedSet) Len() int {
+	return z.Card
+}
+
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, ok := z.bucket(bucketIndex(member, z.Buckets))[member]
+	return score, ok
+}
+
+// locate restituisce il blocco che contiene, o conterrebbe, il membro
+func (z *SortedSet) locate(m ZMember) int {
+	i := sort.Search(len(z.Chunks), func(i int) bool { return zLess(m, z.Chunks[i].First) })
+	return max(0, i-1)
+}
+
+// members restituisce tutti i membri in ordine
+func (z *SortedSet) members() []ZMember {
+	result := make([]ZMember, 0, z.Card)
+	for _, info := range z.Chunks {
+		result = append(result, z.chunk(info.ID)...)
+	}
+	return result
+}
+
+// rangeByScore restituisce i membri con score in [min, max), in ordine; legge
+// solo i blocchi che possono contenerli
+func (z *SortedSet) rangeByScore(min, max float64) []ZMember {
+	var result []ZMember
+	// i membri con score min possono iniziare nel blocco precedente al primo che parte da min
+	i := sort.Search(len(z.Chunks), func(i int) bool { return z.Chunks[i].First.Score >= min })
+	if i > 0 {
+		i--
+	}
+	for ; i < len(z.Chunks) && z.Chunks[i].First.Score < max; i++ {
+		for _, m := range z.chunk(z.Chunks[i].ID) {
+			if m.Score >= max {
+				break
+			}
+			if m.Score >= min {
+				result = append(result, m)
+			}
+		}
+	}
+	return result
+}
+
+// Add inserisce o aggiorna il membro; nx e xx hanno la semantica di ZADD.
+func (z *SortedSet) Add(member string, score float64, nx, xx bool) (added, changed bool) {
+	current, exists := z.Score(member)
+	if (exists && nx) || (!exists && xx) {
+		return false, false
+	}
+	if exists {
+		if current == score {
+			return false, false
+		}
+		z.Remove(member)
+	}
+
+	z.insert(ZMember{Member: member, Score: score})
+	n := bucketIndex(member, z.Buckets)
+	z.bucket(n)[member] = score
+	z.dirtyBuckets[n] = true
+	z.Card++
+	if z.Card > z.Buckets*zsetBucketLoad {
+		z.grow()
+	}
+	return !exists, true
+}
+
+func (z *SortedSet) Remove(member string) bool {
+	n := bucketIndex(member, z.Buckets)
+	score, exists := z.bucket(n)[member]
+	if !exists {
+		return false
+	}
+	delete(z.buckets[n], member)
+	z.dirtyBuckets[n] = true
+	z.delete(ZMember{Member: member, Score: score})
+	z.Card--
+	return true
+}
+
+// insert aggiunge m al suo blocco, dividendolo se supera zsetChunkSize
+func (z *SortedSet) insert(m ZMember) {
+	if len(z.Chunks) == 0 {
+		z.Chunks = append(z.Chunks, z.newChunk([]ZMember{m}))
+		return
+	}
+	i := z.locate(m)
+	members := z.chunk(z.Chunks[i].ID)
+	j := sort.Search(len(members), func(j int) bool { return !zLess(members[j], m) })
+	members = slices.Insert(members, j, m)
+
+	if len(members) > zsetChunkSize {
+		half := len(members) / 2
+		next := z.newChunk(slices.Clone(members[half:]))
+		members = members[:half:half]
+		z.Chunks = slices.Insert(z.Chunks, i+1, next)
+	}
+	z.setChunk(i, members)
+}
+
+// delete toglie m dal suo blocco; un blocco rimasto piccolo si unisce a un vicino
+func (z *SortedSet) delete(m ZMember) {
+	if len(z.Chunks) == 0 {
+		return
+	}
+	i := z.locate(m)
+	members := z.chunk(z.Chunks[i].ID)
+	j := sort.Search(len(members), func(j int) bool { return !zLess(members[j], m) })
+	if j == len(members) || members[j] != m {
+		return
+	}
+	members = slices.Delete(members, j, j+1)
+
+	if len(members) == 0 {
+		z.dropChunk(i)
+		return
+	}
+	z.setChunk(i, members)
+	if len(members) < zsetChunkSize/4 {
+		switch {
+		case i+1 < len(z.Chunks) && len(members)+z.Chunks[i+1].Count <= zsetChunkSize:
+			z.merge(i)
+		case i > 0 && len(members)+z.Chunks[i-1].Count <= zsetChunkSize:
+			z.merge(i - 1)
+		}
+	}
+}
+
+func (z *SortedSet) newChunk(members []ZMember) zsetChunk {
+	id := z.NextChunk
+	z.NextChunk++
+	z.chunks[id] = members
+	z.dirtyChunks[id] = true
+	return zsetChunk{ID: id, First: members[0], Count: len(members)}
+}
+
+func (z *SortedSet) setChunk(i int, members []ZMember) {
+	info := &z.Chunks[i]
+	info.First, info.Count = members[0], len(members)
+	z.chunks[info.ID] = members
+	z.dirtyChunks[info.ID] = true
+}
+
+func (z *SortedSet) dropChunk(i int) {
+	id := z.Chunks[i].ID
+	z.Chunks = slices.Delete(z.Chunks, i, i+1)
+	delete(z.chunks, id)
+	delete(z.dirtyChunks, id)
+	z.removed = append(z.removed, id)
+}
+
+// merge unisce il blocco i+1 al blocco i
+func (z *SortedSet) merge(i int) {
+	members := append(z.chunk(z.Chunks[i].ID), z.chunk(z.Chunks[i+1].ID)...)
+	z.dropChunk(i + 1)
+	z.setChunk(i, members)
+}
+
+// grow raddoppia i bucket: i membri del bucket n restano in n o passano in n+Buckets
+func (z *SortedSet) grow() {
+	buckets := z.Buckets * 2
+	for n := 0; n < z.Buckets; n++ {
+		moved := make(map[string]float64)
+		for member, score := range z.bucket(n) {
+			if bucketIndex(member, buckets) != n {
+				moved[member] = score
+				delete(z.buckets[n], member)
+			}
+		}
+		z.buckets[n+z.Buckets] = moved
+		z.dirtyBuckets[n] = true
+		z.dirtyBuckets[n+z.Buckets] = true
+	}
+	z.Buckets = buckets
+}
+
+/* ************************************************************************
+   Accesso ai sorted set memorizzati nella cache
+ * ************************************************************************ */
+
+// loadZSet restituisce il sorted set in key, nil se la chiave non esiste.
+// Legge solo l'elenco dei blocchi: blocchi e bucket sono letti quando servono.
+// Il caller deve possedere il lock della stripe.
+func (c *PodCache) loadZSet(key string) (*SortedSet, error) {
+	value, err := c.get(key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	zset := c.newZSet(key)
+	if err := decodeTyped(value, TypeZSet, &zset.zsetMeta); err != nil {
+		return nil, err
+	}
+	return zset, nil
+}
+
+// newZSet restituisce un sorted set vuoto, che saveZSet scrive in key
+func (c *PodCache) newZSet(key string) *SortedSet {
+	return &SortedSet{
+		zsetMeta:     zsetMeta{Buckets: 1},
+		c:            c,
+		key:          key,
+		ns:           c.namespace(),
+		chunks:       make(map[uint64][]ZMember),
+		buckets:      make(map[int]map[string]float64),
+		dirtyChunks:  make(map[uint64]bool),
+		dirtyBuckets: make(map[int]bool),
+	}
+}
+
+// saveZSet scrive le parti modificate e l'elenco dei blocchi; un insieme
+// vuoto elimina la chiave come in Redis
+func (c *PodCache) saveZSet(zset *SortedSet) error {
+	if zset.err != nil {
+		return zset.err
+	}
+	if zset.Card == 0 {
+		c.evict(zset.key)
+		return nil
+	}
+	for id := range zset.dirtyChunks {
+		if err := c.putPart(zset.key, zset.chunkKey(id), zset.chunks[id]); err != nil {
+			return err
+		}
+	}
+	for _, id := range zset.removed {
+		if err := c.evictPart(zset.key, zset.chunkKey(id)); err != nil {
+			return err
+		}
+	}
+	for n := range zset.dirtyBuckets {
+		var err error
+		if scores := zset.buckets[n]; len(scores) == 0 {
+			err = c.evictPart(zset.key, zset.bucketKey(n))
+		} else {
+			err = c.putPart(zset.key, zset.bucketKey(n), scores)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	clear(zset.dirtyChunks)
+	clear(zset.dirtyBuckets)
+	zset.removed = nil
+
+	value, err := encodeTyped(TypeZSet, &zset.zsetMeta)
+	if err != nil {
+		return err
+	}
+	stored := c.storageKey(zset.key)
+	c.parted[stripeIndex(zset.key)][stored] = true
+	return c.putValue(zset.key, stored, value)
+}
+
+// zsetParts restituisce le parti interne del sorted set in key, dato il valore della chiave
+func zsetParts(ns int, key string, value []byte) ([]string, error) {
+	zset := &SortedSet{key: key, ns: ns}
+	if err := decodeTyped(value, TypeZSet, &zset.zsetMeta); err != nil {
+		return nil, err
+	}
+	var parts []string
+	for _, info := range zset.Chunks {
+		parts = append(parts, zset.chunkKey(info.ID))
+	}
+	for n := 0; n < zset.Buckets; n++ {
+		parts = append(parts, zset.bucketKey(n))
+	}
+	return parts, nil
+}
+
+// ZRem rimuove i membri indicati e restituisce quanti ne ha trovati
+func (c *PodCache) ZRem(key string, members ...string) (int, error) {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	zset, err := c.loadZSet(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		if zset.Remove(member) {
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, zset.err
+	}
+	if err := c.saveZSet(zset); err != nil {
+		return 0, err
+	}
+
+	c.notify(EventZSet, "zrem", key)
+	if zset.Len() == 0 {
+		c.notify(EventGeneric, "del", key)
+	}
+	return removed, nil
+}
+
+func (c *PodCache) ZCard(key string) (int, error) {
+	unlock := c.lockKeys(key)
+	defer unlock()
+
+	zset, err := c.loadZSet(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	return zset.Len(), nil
+}
@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestSortedSetScores(t *testing.T) {
+	c := newTestPodCache(t, 1, 1024*1024)
+	z := c.newZSet("z")
+	for i, member := range []string{"c", "a", "b"} {
+		if added, changed := z.Add(member, float64(i), false, false); !added || !changed {
+			t.Fatalf("Add(%s) = %v, %v, want added", member, added, changed)
+		}
+	}
+
+	// aggiornare lo score sposta il membro e aggiorna l'indice
+	if added, changed := z.Add("c", 5, false, false); added || !changed {
+		t.Fatalf("Add(c, 5) = %v, %v, want changed", added, changed)
+	}
+	if added, changed := z.Add("c", 5, false, false); added || changed {
+		t.Fatalf("Add(c, 5) again = %v, %v, want neither", added, changed)
+	}
+	if added, changed := z.Add("a", 9, true, false); added || changed {
+		t.Fatalf("Add(a, NX) = %v, %v, want neither", added, changed)
+	}
+	if added, changed := z.Add("d", 9, false, true); added || changed {
+		t.Fatalf("Add(d, XX) = %v, %v, want neither", added, changed)
+	}
+	want := []ZMember{{"a", 1}, {"b", 2}, {"c", 5}}
+	if got := z.members(); !slices.Equal(got, want) {
+		t.Fatalf("members() = %+v, want %+v", got, want)
+	}
+	for _, m := range want {
+		if score, ok := z.Score(m.Member); !ok || score != m.Score {
+			t.Fatalf("Score(%s) = %v, %v, want %v", m.Member, score, ok, m.Score)
+		}
+	}
+
+	if !z.Remove("b") || z.Remove("b") {
+		t.Fatal("Remove(b) should succeed only once")
+	}
+	if _, ok := z.Score("b"); ok {
+		t.Fatal("Score(b) after Remove should fail")
+	}
+	if got := z.rangeByScore(1, 5); len(got) != 1 || got[0].Member != "a" {
+		t.Fatalf("rangeByScore(1, 5) = %+v, want [a]", got)
+	}
+
+	// blocchi e bucket si rileggono dalle parti della chiave
+	if err := c.saveZSet(z); err != nil {
+		t.Fatalf("saveZSet() returned an error: %v", err)
+	}
+	loaded, err := c.loadZSet("z")
+	if err != nil || loaded == nil {
+		t.Fatalf("loadZSet() = %v, %v", loaded, err)
+	}
+	if score, ok := loaded.Score("c"); !ok || score != 5 {
+		t.Fatalf("Score(c) after loading = %v, %v, want 5", score, ok)
+	}
+	if _, ok := loaded.Score("b"); ok {
+		t.Fatal("Score(b) after loading should fail")
+	}
+}
+
+func TestSortedSetChunks(t *testing.T) {
+	c := newTestPodCache(t, 1, 64*1024*1024)
+	rng := rand.New(rand.NewSource(1))
+
+	// un modello in memoria verifica l'insieme dopo ogni salvataggio
+	scores := make(map[string]float64)
+	check := func(round int) {
+		t.Helper()
+		z, err := c.loadZSet("z")
+		if err != nil || z == nil {
+			t.Fatalf("round %d: loadZSet() = %v, %v", round, z, err)
+		}
+		want := make([]ZMember, 0, len(scores))
+		for member, score := range scores {
+			want = append(want, ZMember{Member: member, Score: score})
+		}
+		sort.Slice(want, func(i, j int) bool { return zLess(want[i], want[j]) })
+		if got := z.members(); z.Len() != len(want) || !slices.Equal(got, want) {
+			t.Fatalf("round %d: %d members, want %d", round, len(got), len(want))
+		}
+		for i, info := range z.Chunks {
+			if info.Count == 0 || info.Count > zsetChunkSize {
+				t.Fatalf("round %d: block %d has %d members", round, i, info.Count)
+			}
+		}
+		for member, score := range scores {
+			if got, ok := z.Score(member); !ok || got != score {
+				t.Fatalf("round %d: Score(%s) = %v, %v, want %v", round, member, got, ok, score)
+			}
+		}
+	}
+
+	for round := 0; round < 6; round++ {
+		z, err := c.loadZSet("z")
+		if err != nil {
+			t.Fatalf("loadZSet() returned an error: %v", err)
+		}
+		if z == nil {
+			z = c.newZSet("z")
+		}
+		for i := 0; i < 2000; i++ {
+			member := fmt.Sprintf("m%d", rng.Intn(5000))
+			if round >= 3 && rng.Intn(3) > 0 {
+				delete(scores, member)
+				z.Remove(member)
+				continue
+			}
+			score := float64(rng.Intn(1000))
+			scores[member] = score
+			z.Add(member, score, false, false)
+		}
+		if err := c.saveZSet(z); err != nil {
+			t.Fatalf("saveZSet() returned an error: %v", err)
+		}
+		check(round)
+	}
+
+	// aggiornare un membro legge e riscrive pochi blocchi e un solo bucket
+	z, _ := c.loadZSet("z")
+	if len(z.Chunks) < 10 || z.Buckets < 8 {
+		t.Fatalf("%d blocks and %d buckets, want the set split in parts", len(z.Chunks), z.Buckets)
+	}
+	for member := range scores {
+		z.Add(member, 2000, false, false)
+		break
+	}
+	if len(z.chunks) > 4 || len(z.dirtyChunks) > 4 || len(z.buckets) != 1 || len(z.dirtyBuckets) != 1 {
+		t.Fatalf("update read %d blocks and %d buckets, wrote %d and %d",
+			len(z.chunks), len(z.buckets), len(z.dirtyChunks), len(z.dirtyBuckets))
+	}
+
+	// svuotare l'insieme cancella la chiave e le sue parti
+	for member := range scores {
+		z.Remove(member)
+	}
+	if err := c.saveZSet(z); err != nil {
+		t.Fatalf("saveZSet() returned an error: %v", err)
+	}
+	if size := c.DBSize(); size != 0 {
+		t.Fatalf("DBSize() = %d after removing every member, want 0", size)
+	}
+	for _, partition := range c.table.Load().partitions {
+		if keys := partition.Keys(); len(keys) != 0 {
+			t.Fatalf("parts left behind: %q", keys)
+		}
+	}
+}
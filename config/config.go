@@ -0,0 +1,526 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"mi0772/podcache/util"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Type è il tipo di un parametro: ne determina il parsing e la forma canonica
+type Type int
+
+const (
+	String Type = iota
+	Int
+	Bool   // yes/no
+	Memory // byte, accetta unità come 512mb o 2gb
+	Enum
+)
+
+var (
+	ErrUnknown   = errors.New("unknown option")
+	ErrImmutable = errors.New("can't set immutable config")
+	ErrNoFile    = errors.New("The server is running without a config file")
+)
+
+// Error lega un errore di configurazione al parametro che lo ha causato
+type Error struct {
+	Name string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Param descrive un parametro di configurazione, con il nome di redis.conf
+type Param struct {
+	Name  string
+	Alias string // nome alternativo accettato da CONFIG e nel file
+	Env   string // variabile d'ambiente, se esiste
+	// EnvFile è la variabile con il percorso di un file che contiene il valore,
+	// per i secret montati da Kubernetes/Docker; ha la precedenza su Env
+	EnvFile string
+
+	// EnvUnit è l'unità sottintesa nella variabile d'ambiente,
+	// es. "mb" per PODCACHE_CAPACITY_MB
+	EnvUnit string
+
+	Type     Type
+	Default  string
+	Min, Max int64    // per Int e Memory; Max zero vuol dire nessun limite
+	Values   []string // per Enum
+	Mutable  bool     // modificabile con CONFIG SET
+
+	// Sensitive nasconde il valore nella configurazione stampata da Dump
+	Sensitive bool
+
+	// Normalize controlla i valori che il tipo non basta a descrivere e ne
+	// restituisce la forma canonica. Riceve anche il valore corrente (vuoto per
+	// il default), per i parametri che si applicano per differenza come
+	// client-output-buffer-limit.
+	Normalize func(value, current string) (string, error)
+}
+
+// Source è l'origine del valore corrente di un parametro. L'ordine di
+// precedenza all'avvio è flag > ambiente > file > default.
+type Source int
+
+const (
+	SourceDefault Source = iota
+	SourceFile
+	SourceEnv
+	SourceFlag
+	SourceSet // CONFIG SET
+)
+
+var sourceNames = [...]string{"default", "file", "env", "flag", "config set"}
+
+func (s Source) String() string {
+	return sourceNames[s]
+}
+
+// Entry è un parametro con il suo valore
+type Entry struct {
+	Name, Value string
+}
+
+// Registry contiene i parametri e i loro valori correnti. I valori sono letti
+// da variabili d'ambiente e file all'avvio; quelli Mutable possono cambiare a
+// runtime con Set, che avvisa chi si è registrato con OnChange.
+type Registry struct {
+	mu      sync.RWMutex
+	params  []*Param
+	byName  map[string]*Param
+	values  map[string]string
+	sources map[string]Source
+	hooks   map[string][]func(value string) error
+	file    string
+
+	// setMu serializza le Set: gli hook girano senza mu, così possono leggere il registro
+	setMu sync.Mutex
+}
+
+func New() *Registry {
+	return &Registry{
+		byName:  make(map[string]*Param),
+		values:  make(map[string]string),
+		sources: make(map[string]Source),
+		hooks:   make(map[string][]func(string) error),
+	}
+}
+
+// Register aggiunge i parametri con il loro valore di default
+func (r *Registry) Register(params ...Param) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range params {
+		p := &params[i]
+		for _, name := range []string{p.Name, p.Alias} {
+			if name == "" {
+				continue
+			}
+			if _, exists := r.byName[name]; exists {
+				panic("config: parameter registered twice: " + name)
+			}
+			r.byName[name] = p
+		}
+		value, err := p.parse(p.Default, "")
+		if err != nil {
+			panic(fmt.Sprintf("config: invalid default for %s: %v", p.Name, err))
+		}
+		r.params = append(r.params, p)
+		r.values[p.Name] = value
+	}
+}
+
+// Lookup cerca un parametro per nome o alias, senza distinguere maiuscole
+func (r *Registry) Lookup(name string) (*Param, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.byName[strings.ToLower(name)]
+	return p, ok
+}
+
+// LoadEnv legge le variabili d'ambiente dei parametri
+func (r *Registry) LoadEnv() error {
+	for _, p := range r.snapshot() {
+		if p.EnvFile != "" {
+			if path, exists := os.LookupEnv(p.EnvFile); exists {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return &Error{Name: p.EnvFile, Err: err}
+				}
+				if err := r.load(p, strings.TrimRight(string(data), "\r\n"), SourceEnv); err != nil {
+					return &Error{Name: p.EnvFile, Err: err}
+				}
+				continue
+			}
+		}
+		if p.Env == "" {
+			continue
+		}
+		value, exists := os.LookupEnv(p.Env)
+		if !exists {
+			continue
+		}
+		if p.EnvUnit != "" && isDigits(value) {
+			value += p.EnvUnit
+		}
+		if err := r.load(p, value, SourceEnv); err != nil {
+			return &Error{Name: p.Env, Err: err}
+		}
+	}
+	return nil
+}
+
+// LoadFile legge un file nel formato di redis.conf: una direttiva per riga,
+// "nome valore...", con le righe vuote e i commenti (#) ignorati. La direttiva
+// include legge altri file (anche con pattern glob) nel punto in cui compare;
+// i percorsi relativi partono dalla directory del file che li include. Il file
+// diventa quello scritto da Rewrite.
+func (r *Registry) LoadFile(path string) error {
+	if err := r.loadFile(path, make(map[string]bool)); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.file = path
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Registry) loadFile(path string, loading map[string]bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if loading[abs] {
+		return fmt.Errorf("%s: include loop", path)
+	}
+	loading[abs] = true
+	defer delete(loading, abs)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		args, err := splitArgs(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if strings.EqualFold(args[0], "include") {
+			if len(args) != 2 {
+				return fmt.Errorf("%s:%d: include needs exactly one path", path, i+1)
+			}
+			if err := r.include(filepath.Dir(path), args[1], loading); err != nil {
+				return fmt.Errorf("%s:%d: %w", path, i+1, err)
+			}
+			continue
+		}
+		p, ok := r.Lookup(args[0])
+		if !ok {
+			return fmt.Errorf("%s:%d: %w '%s'", path, i+1, ErrUnknown, args[0])
+		}
+		if err := r.load(p, strings.Join(args[1:], " "), SourceFile); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, i+1, &Error{Name: args[0], Err: err})
+		}
+	}
+	return nil
+}
+
+// include carica i file indicati da pattern, in ordine alfabetico; un pattern
+// glob che non trova file non è un errore, un percorso semplice mancante sì
+func (r *Registry) include(dir, pattern string, loading map[string]bool) error {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	files := []string{pattern}
+	if strings.ContainsAny(pattern, "*?[") {
+		var err error
+		if files, err = filepath.Glob(pattern); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		if err := r.loadFile(file, loading); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetFile imposta il file scritto da Rewrite senza leggerlo
+func (r *Registry) SetFile(path string) {
+	r.mu.Lock()
+	r.file = path
+	r.mu.Unlock()
+}
+
+func (r *Registry) File() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.file
+}
+
+func (r *Registry) load(p *Param, raw string, source Source) error {
+	value, err := p.parse(raw, r.String(p.Name))
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.values[p.Name] = value
+	r.sources[p.Name] = source
+	r.mu.Unlock()
+	return nil
+}
+
+// String restituisce il valore corrente nella forma canonica
+func (r *Registry) String(name string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	value, ok := r.values[name]
+	if !ok {
+		panic("config: unknown parameter " + name)
+	}
+	return value
+}
+
+// Int restituisce il valore di un parametro Int o Memory
+func (r *Registry) Int(name string) int64 {
+	n, _ := strconv.ParseInt(r.String(name), 10, 64)
+	return n
+}
+
+func (r *Registry) Bool(name string) bool {
+	return r.String(name) == "yes"
+}
+
+// IsSet indica se il parametro è stato impostato, invece di avere il default
+func (r *Registry) IsSet(name string) bool {
+	return r.Source(name) != SourceDefault
+}
+
+// Source restituisce l'origine del valore corrente
+func (r *Registry) Source(name string) Source {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sources[name]
+}
+
+// Match restituisce i parametri il cui nome o alias corrisponde al pattern glob,
+// ordinati per nome
+func (r *Registry) Match(pattern string) []Entry {
+	pattern = strings.ToLower(pattern)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var entries []Entry
+	for _, p := range r.params {
+		for _, name := range []string{p.Name, p.Alias} {
+			if name != "" && util.GlobMatch(pattern, name) {
+				entries = append(entries, Entry{Name: name, Value: r.values[p.Name]})
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// OnChange registra fn, chiamata con il nuovo valore quando Set cambia il
+// parametro; se fn fallisce la Set viene annullata
+func (r *Registry) OnChange(name string, fn func(value string) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.byName[name]
+	if !ok {
+		panic("config: unknown parameter " + name)
+	}
+	r.hooks[p.Name] = append(r.hooks[p.Name], fn)
+}
+
+// Set cambia più parametri in modo atomico, come CONFIG SET: se un valore non è
+// valido o un hook fallisce, nessun parametro resta cambiato
+func (r *Registry) Set(changes []Entry) error {
+	r.setMu.Lock()
+	defer r.setMu.Unlock()
+
+	pending := make([]change, 0, len(changes))
+	seen := make(map[*Param]bool, len(changes))
+	for _, entry := range changes {
+		p, ok := r.Lookup(entry.Name)
+		if !ok {
+			return &Error{Name: entry.Name, Err: ErrUnknown}
+		}
+		if seen[p] {
+			return &Error{Name: entry.Name, Err: errors.New("duplicate parameter")}
+		}
+		seen[p] = true
+		if !p.Mutable {
+			return &Error{Name: entry.Name, Err: ErrImmutable}
+		}
+		value, err := p.parse(entry.Value, r.String(p.Name))
+		if err != nil {
+			return &Error{Name: entry.Name, Err: err}
+		}
+		pending = append(pending, change{param: p, value: value, source: SourceSet})
+	}
+	return r.commit(pending)
+}
+
+// change è un valore già validato in attesa di commit
+type change struct {
+	param          *Param
+	value          string
+	source         Source
+	previous       string
+	previousSource Source
+}
+
+// commit applica i valori e chiama gli hook di quelli cambiati; se un hook
+// fallisce ripristina tutto. Richiede setMu.
+func (r *Registry) commit(pending []change) error {
+	r.mu.Lock()
+	for i := range pending {
+		c := &pending[i]
+		c.previous, c.previousSource = r.values[c.param.Name], r.sources[c.param.Name]
+		r.values[c.param.Name] = c.value
+		r.sources[c.param.Name] = c.source
+	}
+	r.mu.Unlock()
+
+	for i, c := range pending {
+		if c.value == c.previous {
+			continue
+		}
+		if err := r.notify(c.param, c.value); err != nil {
+			// si ripristinano i valori, e si riapplicano quelli già notificati
+			r.mu.Lock()
+			for _, c := range pending {
+				r.values[c.param.Name] = c.previous
+				r.sources[c.param.Name] = c.previousSource
+			}
+			r.mu.Unlock()
+			for _, c := range pending[:i] {
+				if c.value != c.previous {
+					r.notify(c.param, c.previous)
+				}
+			}
+			return &Error{Name: c.param.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+func (r *Registry) notify(p *Param, value string) error {
+	r.mu.RLock()
+	hooks := r.hooks[p.Name]
+	r.mu.RUnlock()
+	for _, fn := range hooks {
+		if err := fn(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Registry) snapshot() []*Param {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Param(nil), r.params...)
+}
+
+// parse interpreta un valore secondo il tipo e ne restituisce la forma canonica
+func (p *Param) parse(raw, current string) (string, error) {
+	value := raw
+	switch p.Type {
+	case Int, Memory:
+		var n int64
+		var err error
+		if p.Type == Memory {
+			n, err = ParseMemory(raw)
+		} else {
+			n, err = strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+			if err != nil {
+				err = errors.New("argument couldn't be parsed into an integer")
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		if n < p.Min || (p.Max != 0 && n > p.Max) {
+			return "", fmt.Errorf("argument must be between %d and %d inclusive", p.Min, p.Max)
+		}
+		value = strconv.FormatInt(n, 10)
+	case Bool:
+		switch strings.ToLower(raw) {
+		case "yes":
+			value = "yes"
+		case "no":
+			value = "no"
+		default:
+			return "", errors.New("argument must be 'yes' or 'no'")
+		}
+	case Enum:
+		value = strings.ToLower(raw)
+		found := false
+		for _, allowed := range p.Values {
+			if value == allowed {
+				found = true
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(p.Values, ", "))
+		}
+	}
+	if p.Normalize != nil {
+		return p.Normalize(value, current)
+	}
+	return value, nil
+}
+
+// ParseMemory interpreta una dimensione come redis.conf: 1k = 1000, 1kb = 1024,
+// e così per m/mb e g/gb; senza unità sono byte
+func ParseMemory(value string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(value))
+	units := []struct {
+		suffix string
+		mult   int64
+	}{{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1}}
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, mult = strings.TrimSuffix(lower, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("argument must be a memory value: %q", value)
+	}
+	return n * mult, nil
+}
+
+func isDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,454 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestRegistry() *Registry {
+	r := New()
+	r.Register(
+		Param{Name: "port", Env: "TEST_PORT", Type: Int, Default: "6379", Max: 65535},
+		Param{Name: "maxmemory", Env: "TEST_CAPACITY_MB", EnvUnit: "mb", Type: Memory, Default: "100mb", Mutable: true},
+		Param{Name: "maxmemory-policy", Type: Enum, Default: "allkeys-lru", Values: []string{"allkeys-lru", "noeviction"}, Mutable: true},
+		Param{Name: "busy-reply-threshold", Alias: "lua-time-limit", Type: Int, Default: "5000", Min: 1, Mutable: true},
+		Param{Name: "appendonly", Type: Bool, Default: "no", Mutable: true},
+		Param{Name: "notify-keyspace-events", Mutable: true},
+	)
+	return r
+}
+
+func TestParseTypes(t *testing.T) {
+	r := newTestRegistry()
+	if got := r.Int("maxmemory"); got != 100<<20 {
+		t.Fatalf("maxmemory default = %d", got)
+	}
+
+	err := r.Set([]Entry{{"maxmemory", "2GB"}, {"MAXMEMORY-POLICY", "NoEviction"}, {"appendonly", "YES"}})
+	if err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got := r.Int("maxmemory"); got != 2<<30 {
+		t.Fatalf("maxmemory = %d, want %d", got, 2<<30)
+	}
+	if got := r.String("maxmemory-policy"); got != "noeviction" {
+		t.Fatalf("maxmemory-policy = %q", got)
+	}
+	if !r.Bool("appendonly") {
+		t.Fatal("appendonly = no, want yes")
+	}
+
+	for _, change := range []Entry{
+		{"maxmemory", "lots"},
+		{"maxmemory-policy", "volatile-lru"},
+		{"appendonly", "maybe"},
+		{"lua-time-limit", "0"},
+	} {
+		if err := r.Set([]Entry{change}); err == nil {
+			t.Errorf("Set(%v) succeeded", change)
+		}
+	}
+}
+
+func TestSetIsAtomic(t *testing.T) {
+	r := newTestRegistry()
+
+	err := r.Set([]Entry{{"maxmemory", "1mb"}, {"port", "7000"}})
+	if !errors.Is(err, ErrImmutable) {
+		t.Fatalf("Set(port) error = %v, want ErrImmutable", err)
+	}
+	if r.Int("maxmemory") != 100<<20 {
+		t.Fatal("maxmemory changed by a failed Set")
+	}
+	if err := r.Set([]Entry{{"nosuch", "1"}}); !errors.Is(err, ErrUnknown) {
+		t.Fatalf("Set(nosuch) error = %v, want ErrUnknown", err)
+	}
+
+	// un hook che fallisce annulla anche i parametri già applicati
+	var applied []string
+	r.OnChange("maxmemory", func(value string) error {
+		applied = append(applied, value)
+		return nil
+	})
+	r.OnChange("maxmemory-policy", func(string) error {
+		return errors.New("refused")
+	})
+	err = r.Set([]Entry{{"maxmemory", "1mb"}, {"maxmemory-policy", "noeviction"}})
+	if err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Fatalf("Set() error = %v, want hook error", err)
+	}
+	if r.Int("maxmemory") != 100<<20 || r.String("maxmemory-policy") != "allkeys-lru" {
+		t.Fatal("values not restored after a failed hook")
+	}
+	if want := []string{"1048576", "104857600"}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("maxmemory hook calls = %v, want %v", applied, want)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	r := newTestRegistry()
+
+	got := r.Match("maxmemory*")
+	want := []Entry{{"maxmemory", "104857600"}, {"maxmemory-policy", "allkeys-lru"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Match(maxmemory*) = %v, want %v", got, want)
+	}
+	if got := r.Match("lua-*"); len(got) != 1 || got[0] != (Entry{"lua-time-limit", "5000"}) {
+		t.Fatalf("Match(lua-*) = %v", got)
+	}
+	if got := r.Match("*"); len(got) != 7 {
+		t.Fatalf("Match(*) returned %d entries, want 7 (6 names and 1 alias)", len(got))
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("TEST_CAPACITY_MB", "64")
+	t.Setenv("TEST_PORT", "7000")
+	r := newTestRegistry()
+	if err := r.LoadEnv(); err != nil {
+		t.Fatalf("LoadEnv() error = %v", err)
+	}
+	if r.Int("maxmemory") != 64<<20 || r.Int("port") != 7000 {
+		t.Fatalf("maxmemory = %d, port = %d", r.Int("maxmemory"), r.Int("port"))
+	}
+	if !r.IsSet("port") || r.IsSet("appendonly") {
+		t.Fatal("IsSet() does not reflect the environment")
+	}
+
+	t.Setenv("TEST_PORT", "70000")
+	if err := newTestRegistry().LoadEnv(); err == nil || !strings.Contains(err.Error(), "TEST_PORT") {
+		t.Fatalf("LoadEnv() error = %v, want an error naming TEST_PORT", err)
+	}
+}
+
+func TestLoadFileAndRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "podcache.conf")
+	original := strings.Join([]string{
+		"# cache",
+		"maxmemory 10mb",
+		"",
+		"lua-time-limit 200",
+		"appendonly no",
+	}, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestRegistry()
+	if err := r.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if r.Int("maxmemory") != 10<<20 || r.Int("busy-reply-threshold") != 200 {
+		t.Fatalf("maxmemory = %d, busy-reply-threshold = %d", r.Int("maxmemory"), r.Int("busy-reply-threshold"))
+	}
+
+	err := r.Set([]Entry{{"maxmemory", "20mb"}, {"lua-time-limit", "5000"}, {"notify-keyspace-events", "K E"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Rewrite(); err != nil {
+		t.Fatalf("Rewrite() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	want := strings.Join([]string{
+		"# cache",
+		"maxmemory 20971520",
+		"",
+		rewriteHeader,
+		`notify-keyspace-events "K E"`,
+	}, "\n") + "\n"
+	if string(data) != want {
+		t.Fatalf("rewritten file:\n%s\nwant:\n%s", data, want)
+	}
+
+	reloaded := newTestRegistry()
+	if err := reloaded.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile() of the rewritten file error = %v", err)
+	}
+	if reloaded.String("notify-keyspace-events") != "K E" || reloaded.Int("maxmemory") != 20<<20 {
+		t.Fatal("rewritten file does not round trip")
+	}
+}
+
+func TestRewriteSources(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "podcache.conf")
+	if err := os.WriteFile(path, []byte("maxmemory 10mb\nport 7000\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("topsecret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_CAPACITY_MB", "50")
+	t.Setenv("TEST_REQUIREPASS_FILE", secret)
+
+	r := newTestRegistry()
+	r.Register(Param{Name: "requirepass", Env: "TEST_REQUIREPASS", EnvFile: "TEST_REQUIREPASS_FILE", Mutable: true, Sensitive: true})
+	if err := r.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Apply(Overrides{{"port", "8000"}, {"appendonly", "yes"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set([]Entry{{"notify-keyspace-events", "K"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Rewrite(); err != nil {
+		t.Fatalf("Rewrite() error = %v", err)
+	}
+
+	// i valori di ambiente e riga di comando non sono scritti, le loro direttive restano
+	data, _ := os.ReadFile(path)
+	want := "maxmemory 10mb\nport 7000\n\n" + rewriteHeader + "\nnotify-keyspace-events K\n"
+	if string(data) != want {
+		t.Fatalf("rewritten file:\n%s\nwant:\n%s", data, want)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o640 {
+		t.Fatalf("rewritten file mode = %v, want 0640", info.Mode().Perm())
+	}
+
+	// CONFIG SET rende il valore scrivibile anche se veniva dall'ambiente
+	if err := r.Set([]Entry{{"maxmemory", "20mb"}}); err != nil {
+		t.Fatal(err)
+	}
+	fresh := filepath.Join(dir, "new.conf")
+	r.SetFile(fresh)
+	if err := r.Rewrite(); err != nil {
+		t.Fatalf("Rewrite() of a new file error = %v", err)
+	}
+	data, _ = os.ReadFile(fresh)
+	if got := string(data); !strings.Contains(got, "maxmemory 20971520\n") || strings.Contains(got, "topsecret") || strings.Contains(got, "port") {
+		t.Fatalf("new file:\n%s", got)
+	}
+	if info, _ := os.Stat(fresh); info.Mode().Perm() != 0o600 {
+		t.Fatalf("new file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestRewriteWithoutFile(t *testing.T) {
+	if err := newTestRegistry().Rewrite(); !errors.Is(err, ErrNoFile) {
+		t.Fatalf("Rewrite() error = %v, want ErrNoFile", err)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"   # comment", nil},
+		{"port 6379", []string{"port", "6379"}},
+		{"  requirepass   \"a b\\\"c\"  ", []string{"requirepass", `a b"c`}},
+		{"notify-keyspace-events ''", []string{"notify-keyspace-events", ""}},
+		{"client-output-buffer-limit pubsub 32mb 8mb 60", []string{"client-output-buffer-limit", "pubsub", "32mb", "8mb", "60"}},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, %v, want %q", tt.line, got, err, tt.want)
+		}
+	}
+	if _, err := splitArgs(`requirepass "open`); err == nil {
+		t.Error("splitArgs() accepted unbalanced quotes")
+	}
+}
+
+func TestLoadFileInclude(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	path := write("podcache.conf", "maxmemory 10mb\ninclude conf.d/*.conf\nport 7000\n")
+	write("conf.d/a.conf", "maxmemory 20mb\nport 6000\n")
+	write("conf.d/b.conf", "appendonly yes\n")
+
+	r := newTestRegistry()
+	if err := r.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	// le direttive successive all'include vincono su quelle incluse
+	if r.Int("maxmemory") != 20<<20 || r.Int("port") != 7000 || !r.Bool("appendonly") {
+		t.Fatalf("maxmemory = %d, port = %d, appendonly = %v", r.Int("maxmemory"), r.Int("port"), r.Bool("appendonly"))
+	}
+	if r.File() != path {
+		t.Fatalf("File() = %q, want the including file", r.File())
+	}
+
+	write("conf.d/c.conf", "include ../podcache.conf\n")
+	if err := newTestRegistry().LoadFile(path); err == nil || !strings.Contains(err.Error(), "include loop") {
+		t.Fatalf("LoadFile() error = %v, want an include loop", err)
+	}
+	missing := write("missing.conf", "include nosuch.conf\n")
+	if err := newTestRegistry().LoadFile(missing); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("LoadFile() error = %v, want ErrNotExist", err)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "podcache.conf")
+	if err := os.WriteFile(path, []byte("port 7000\nmaxmemory 10mb\nappendonly yes\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_PORT", "7001")
+	t.Setenv("TEST_CAPACITY_MB", "64")
+
+	r := newTestRegistry()
+	var overrides Overrides
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	r.BindFlags(fs, &overrides)
+	if err := fs.Parse([]string{"--maxmemory", "2gb", "--lua-time-limit=100"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Apply(overrides); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]Source{
+		"port":                 SourceEnv,
+		"maxmemory":            SourceFlag,
+		"appendonly":           SourceFile,
+		"busy-reply-threshold": SourceFlag,
+		"maxmemory-policy":     SourceDefault,
+	}
+	for name, source := range want {
+		if got := r.Source(name); got != source {
+			t.Errorf("Source(%s) = %v, want %v", name, got, source)
+		}
+	}
+	if r.Int("port") != 7001 || r.Int("maxmemory") != 2<<30 || r.Int("busy-reply-threshold") != 100 {
+		t.Fatalf("port = %d, maxmemory = %d, busy-reply-threshold = %d", r.Int("port"), r.Int("maxmemory"), r.Int("busy-reply-threshold"))
+	}
+
+	// i flag valgono anche per i parametri non modificabili a runtime
+	if err := r.Apply(Overrides{{"port", "8000"}}); err != nil || r.Int("port") != 8000 {
+		t.Fatalf("Apply(port) error = %v, port = %d", err, r.Int("port"))
+	}
+	if err := r.Apply(Overrides{{"port", "lots"}}); err == nil || !strings.Contains(err.Error(), "--port") {
+		t.Fatalf("Apply() error = %v, want an error naming --port", err)
+	}
+}
+
+func TestDump(t *testing.T) {
+	r := newTestRegistry()
+	r.Register(Param{Name: "requirepass", Sensitive: true})
+	t.Setenv("TEST_PORT", "7000")
+	if err := r.LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set([]Entry{{"notify-keyspace-events", "K E"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Apply(Overrides{{"requirepass", "secret"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := r.Dump(&b); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("Dump() wrote %d lines, want 7:\n%s", len(lines), b.String())
+	}
+	for _, want := range [][]string{
+		{"port", "7000", "# env TEST_PORT"},
+		{"maxmemory", "104857600", "# default"},
+		{"notify-keyspace-events", `"K E"`, "# config set"},
+		{"requirepass", "********", "# flag"},
+	} {
+		found := false
+		for _, line := range lines {
+			if strings.HasPrefix(line, want[0]+" ") {
+				found = strings.Join(strings.Fields(line), " ") == strings.Join(want, " ")
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Dump() has no line %q:\n%s", strings.Join(want, " "), b.String())
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "podcache.conf")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("maxmemory 10mb\nport 7000\nappendonly yes\n")
+	t.Setenv("TEST_CAPACITY_MB", "64")
+
+	r := newTestRegistry()
+	if err := r.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	var notified []string
+	r.OnChange("busy-reply-threshold", func(value string) error {
+		notified = append(notified, value)
+		return nil
+	})
+	if err := r.Set([]Entry{{"maxmemory-policy", "noeviction"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// maxmemory resta quello dell'ambiente, port richiede un riavvio,
+	// maxmemory-policy torna al default perché il file non lo imposta
+	write("maxmemory 20mb\nport 8000\nlua-time-limit 100\n")
+	changed, restart, err := r.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	wantChanged := []Change{
+		{"maxmemory-policy", "noeviction", "allkeys-lru"},
+		{"busy-reply-threshold", "5000", "100"},
+		{"appendonly", "yes", "no"},
+	}
+	if !reflect.DeepEqual(changed, wantChanged) {
+		t.Fatalf("Reload() changed = %v, want %v", changed, wantChanged)
+	}
+	if !reflect.DeepEqual(restart, []string{"port"}) {
+		t.Fatalf("Reload() restart = %v, want [port]", restart)
+	}
+	if r.Int("maxmemory") != 64<<20 || r.Int("port") != 7000 || r.Source("busy-reply-threshold") != SourceFile {
+		t.Fatalf("maxmemory = %d, port = %d, busy-reply-threshold source = %v", r.Int("maxmemory"), r.Int("port"), r.Source("busy-reply-threshold"))
+	}
+	if !reflect.DeepEqual(notified, []string{"100"}) {
+		t.Fatalf("busy-reply-threshold hook calls = %v", notified)
+	}
+
+	// un file non valido non cambia niente
+	write("lua-time-limit 200\nappendonly maybe\n")
+	if _, _, err := r.Reload(); err == nil {
+		t.Fatal("Reload() accepted an invalid file")
+	}
+	if r.Int("busy-reply-threshold") != 100 || len(notified) != 1 {
+		t.Fatal("an invalid reload changed the configuration")
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Overrides sono i valori passati da riga di comando, applicati con Apply dopo
+// file e ambiente perché hanno la precedenza su entrambi
+type Overrides []Entry
+
+// flagValue registra il valore di un flag --<parametro> senza applicarlo
+type flagValue struct {
+	name      string
+	overrides *Overrides
+}
+
+func (f *flagValue) String() string {
+	return ""
+}
+
+func (f *flagValue) Set(value string) error {
+	*f.overrides = append(*f.overrides, Entry{Name: f.name, Value: value})
+	return nil
+}
+
+// BindFlags definisce un flag --<nome> per ogni parametro e alias: i valori
+// letti da fs finiscono in overrides
+func (r *Registry) BindFlags(fs *flag.FlagSet, overrides *Overrides) {
+	for _, p := range r.snapshot() {
+		usage := fmt.Sprintf("set the %s parameter", p.Name)
+		if p.Env != "" {
+			usage += fmt.Sprintf(" (env %s)", p.Env)
+		}
+		if p.Default != "" {
+			usage += fmt.Sprintf(" (default %q)", p.Default)
+		}
+		for _, name := range []string{p.Name, p.Alias} {
+			if name != "" {
+				fs.Var(&flagValue{name: name, overrides: overrides}, name, usage)
+			}
+		}
+	}
+}
+
+// Apply applica i valori da riga di comando, anche ai parametri non modificabili
+// con CONFIG SET: siamo ancora all'avvio
+func (r *Registry) Apply(overrides Overrides) error {
+	for _, entry := range overrides {
+		p, ok := r.Lookup(entry.Name)
+		if !ok {
+			return &Error{Name: entry.Name, Err: ErrUnknown}
+		}
+		if err := r.load(p, entry.Value, SourceFlag); err != nil {
+			return &Error{Name: "--" + entry.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+// Dump scrive la configurazione effettiva nel formato del file, con l'origine
+// di ogni valore in commento; i parametri Sensitive sono mascherati
+func (r *Registry) Dump(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	r.mu.RLock()
+	for _, p := range r.params {
+		value := r.values[p.Name]
+		if p.Sensitive && value != "" {
+			value = "********"
+		}
+		source := r.sources[p.Name].String()
+		if r.sources[p.Name] == SourceEnv && p.Env != "" {
+			source += " " + p.Env
+		}
+		fmt.Fprintf(tw, "%s\t%s\t# %s\n", p.Name, quote(value), source)
+	}
+	r.mu.RUnlock()
+	return tw.Flush()
+}
@@ -0,0 +1,71 @@
+package config
+
+// Change è un parametro cambiato da Reload, con i valori in forma canonica
+type Change struct {
+	Name, Old, New string
+}
+
+// Reload rilegge il file di configurazione e applica i parametri cambiati in
+// modo atomico, come una CONFIG SET: se il file non è valido o un hook
+// fallisce non cambia niente. I valori da ambiente e flag mantengono la
+// precedenza sul file; quelli di CONFIG SET no, il file torna a essere la
+// sorgente (CONFIG REWRITE li rende persistenti). I parametri non modificabili
+// a runtime restano invariati e sono restituiti in restart.
+func (r *Registry) Reload() (changed []Change, restart []string, err error) {
+	path := r.File()
+	if path == "" {
+		return nil, nil, ErrNoFile
+	}
+
+	r.setMu.Lock()
+	defer r.setMu.Unlock()
+
+	staged := r.defaults()
+	if err := staged.loadFile(path, make(map[string]bool)); err != nil {
+		return nil, nil, err
+	}
+
+	var pending []change
+	r.mu.RLock()
+	for _, p := range r.params {
+		if source := r.sources[p.Name]; source == SourceEnv || source == SourceFlag {
+			continue
+		}
+		value, source := staged.values[p.Name], staged.sources[p.Name]
+		if value == r.values[p.Name] {
+			if source != r.sources[p.Name] {
+				// stesso valore, ma ora viene dal file (o dal default)
+				pending = append(pending, change{param: p, value: value, source: source})
+			}
+			continue
+		}
+		if !p.Mutable {
+			restart = append(restart, p.Name)
+			continue
+		}
+		changed = append(changed, Change{Name: p.Name, Old: r.values[p.Name], New: value})
+		pending = append(pending, change{param: p, value: value, source: source})
+	}
+	r.mu.RUnlock()
+
+	if err := r.commit(pending); err != nil {
+		return nil, nil, err
+	}
+	return changed, restart, nil
+}
+
+// defaults crea un registro con gli stessi parametri ai valori di default e
+// senza hook, dove caricare un file senza toccare i valori correnti
+func (r *Registry) defaults() *Registry {
+	staged := New()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, p := range r.byName {
+		staged.byName[name] = p
+	}
+	for _, p := range r.params {
+		staged.params = append(staged.params, p)
+		staged.values[p.Name], _ = p.parse(p.Default, "")
+	}
+	return staged
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rewriteHeader precede le direttive che Rewrite aggiunge in fondo al file
+const rewriteHeader = "# Generated by CONFIG REWRITE"
+
+// Rewrite riscrive il file di configurazione con i valori correnti, come CONFIG
+// REWRITE: commenti e righe estranee restano, le direttive dei parametri sono
+// aggiornate sul posto, quelle tornate al default vengono tolte e le nuove sono
+// aggiunte in fondo. Sono scritti solo i valori venuti dal file o da CONFIG SET:
+// quelli dell'ambiente o della riga di comando, come una password letta da un
+// file segreto, non finiscono nel file e le loro direttive restano com'erano.
+// Il file è sostituito in modo atomico e mantiene i permessi, 0600 se è nuovo.
+func (r *Registry) Rewrite() error {
+	path := r.File()
+	if path == "" {
+		return ErrNoFile
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	mode := os.FileMode(0o600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	r.mu.RLock()
+	written := make(map[*Param]bool)
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		args, err := splitArgs(line)
+		if err != nil || len(args) == 0 {
+			if line != "" || len(lines) > 0 {
+				lines = append(lines, line)
+			}
+			continue
+		}
+		p, ok := r.byName[strings.ToLower(args[0])]
+		if !ok {
+			lines = append(lines, line)
+			continue
+		}
+		if !r.rewritable(p) && !written[p] {
+			written[p] = true
+			lines = append(lines, line)
+			continue
+		}
+		// una direttiva ripetuta resta una sola volta, e solo se non è al default
+		if written[p] || !r.differs(p) {
+			written[p] = true
+			continue
+		}
+		written[p] = true
+		lines = append(lines, directive(p, r.values[p.Name]))
+	}
+
+	header := false
+	for _, p := range r.params {
+		if written[p] || !r.rewritable(p) || !r.differs(p) {
+			continue
+		}
+		if !header {
+			for len(lines) > 0 && lines[len(lines)-1] == "" {
+				lines = lines[:len(lines)-1]
+			}
+			if len(lines) > 0 {
+				lines = append(lines, "")
+			}
+			lines = append(lines, rewriteHeader)
+			header = true
+		}
+		lines = append(lines, directive(p, r.values[p.Name]))
+	}
+	r.mu.RUnlock()
+
+	content := strings.Join(lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, []byte(content), mode); err != nil {
+		return err
+	}
+	// WriteFile applica mode solo ai file nuovi e al netto della umask
+	if err := os.Chmod(tmp, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// rewritable indica se Rewrite può scrivere il valore corrente; richiede mu
+func (r *Registry) rewritable(p *Param) bool {
+	switch r.sources[p.Name] {
+	case SourceEnv, SourceFlag:
+		return false
+	}
+	return true
+}
+
+// differs indica se il valore corrente non è quello di default; richiede mu
+func (r *Registry) differs(p *Param) bool {
+	value, _ := p.parse(p.Default, "")
+	return r.values[p.Name] != value
+}
+
+func directive(p *Param, value string) string {
+	return p.Name + " " + quote(value)
+}
+
+// quote mette tra virgolette i valori vuoti o con spazi e caratteri speciali
+func quote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\"'\\#") {
+		return value
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// splitArgs divide una riga in argomenti come redis.conf: separati da spazi,
+// con "..." (con escape \) e '...' per i valori con spazi; # inizia un commento
+// solo all'inizio della riga
+func splitArgs(line string) ([]string, error) {
+	line = strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+	if line == "" || line[0] == '#' {
+		return nil, nil
+	}
+	var args []string
+	for i := 0; i < len(line); {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+		var b strings.Builder
+		switch quoteChar := line[i]; quoteChar {
+		case '"', '\'':
+			i++
+			closed := false
+			for i < len(line) {
+				c := line[i]
+				i++
+				if c == quoteChar {
+					closed = true
+					break
+				}
+				if c == '\\' && quoteChar == '"' && i < len(line) {
+					switch e := line[i]; e {
+					case 'n':
+						c = '\n'
+					case 't':
+						c = '\t'
+					default:
+						c = e
+					}
+					i++
+				}
+				b.WriteByte(c)
+			}
+			if !closed {
+				return nil, errors.New("unbalanced quotes in configuration line")
+			}
+			if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				return nil, errors.New("closing quote must be followed by a space")
+			}
+		default:
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				b.WriteByte(line[i])
+				i++
+			}
+		}
+		args = append(args, b.String())
+	}
+	return args, nil
+}
@@ -3,26 +3,30 @@ package disk
 import (
 	"fmt"
 	"mi0772/podcache/disk/hashpath"
+	"mi0772/podcache/metrics"
 	"mi0772/podcache/util"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
 type Cache struct {
-	entries       map[string]bool
+	entries       map[string]uint64
 	basePath      string
 	Entries_count uint64
 	Capacity      uint64
+	mutex         sync.Mutex
+
+	// latenze delle letture e scritture dei file, lock escluso
+	readLatency  *metrics.Histogram
+	writeLatency *metrics.Histogram
 }
 
-func NewCache() *Cache {
+// NewCache crea la cache in una nuova sottodirectory di basePath
+func NewCache(basePath string) *Cache {
 	//creo la directory di base, con un hash casuale di 8 bytes
-	bpath, ok := os.LookupEnv("CAS_BASE_PATH")
-	if !ok {
-		bpath = ".cas"
-	}
-
-	finalPath, err := createBasePath(bpath)
+	finalPath, err := createBasePath(basePath)
 	if err != nil {
 		panic(err)
 	}
@@ -31,14 +35,46 @@ func NewCache() *Cache {
 		basePath:      finalPath,
 		Entries_count: 0,
 		Capacity:      0,
-		entries:       make(map[string]bool, 0),
+		entries:       make(map[string]uint64, 0),
+		readLatency:   metrics.NewHistogram(metrics.LatencyBuckets),
+		writeLatency:  metrics.NewHistogram(metrics.LatencyBuckets),
 	}
 }
 
+// Latency restituisce gli istogrammi delle latenze di lettura e scrittura
+func (c *Cache) Latency() (read, write *metrics.Histogram) {
+	return c.readLatency, c.writeLatency
+}
+
+// Contains riporta se la chiave è presente su disco, senza leggere il valore
+func (c *Cache) Contains(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, exist := c.entries[key]
+	return exist
+}
+
+// Keys restituisce le chiavi presenti su disco
+func (c *Cache) Keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	keys := make([]string, 0, len(c.entries))
+	for k := range c.entries {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
 func (c *Cache) Get(key string) ([]byte, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	if _, exist := c.entries[key]; !exist {
 		return nil, false, nil
 	}
+	defer c.readLatency.Since(time.Now())
 
 	entryPath := filepath.Join(c.basePath, hashpath.PathFromKey(key))
 	valuePath := filepath.Join(entryPath, "value.dat")
@@ -51,7 +87,11 @@ func (c *Cache) Get(key string) ([]byte, bool, error) {
 }
 
 func (c *Cache) Evict(key string) (bool, error) {
-	if _, exist := c.entries[key]; !exist {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	size, exist := c.entries[key]
+	if !exist {
 		return false, nil
 	}
 	entryPath := filepath.Join(c.basePath, hashpath.PathFromKey(key))
@@ -60,13 +100,21 @@ func (c *Cache) Evict(key string) (bool, error) {
 	if err := os.RemoveAll(valuePath); err != nil {
 		return false, err
 	}
+
+	delete(c.entries, key)
+	c.Entries_count--
+	c.Capacity -= size
 	return true, nil
 }
 
 func (c *Cache) Put(key string, value []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	if _, exists := c.entries[key]; exists {
 		return fmt.Errorf("entry with key %q already present in disk cache", key)
 	}
+	defer c.writeLatency.Since(time.Now())
 
 	entryPath := filepath.Join(c.basePath, hashpath.PathFromKey(key))
 	valuePath := filepath.Join(entryPath, "value.dat")
@@ -79,7 +127,7 @@ func (c *Cache) Put(key string, value []byte) error {
 		return fmt.Errorf("failed to write value file: %w", err)
 	}
 
-	c.entries[key] = true
+	c.entries[key] = uint64(len(value))
 	c.Entries_count++
 	c.Capacity += uint64(len(value))
 
@@ -100,3 +148,10 @@ func createBasePath(basePath string) (string, error) {
 	}
 	return path, nil
 }
+
+// Usage restituisce numero di chiavi e byte occupati su disco
+func (c *Cache) Usage() (entries, used uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.Entries_count, c.Capacity
+}
@@ -1,7 +1,6 @@
 package disk
 
 import (
-	"os"
 	"path/filepath"
 	"testing"
 )
@@ -11,11 +10,7 @@ func newTestCache(t *testing.T) *Cache {
 	t.Helper()
 
 	base := t.TempDir()
-	if err := os.Setenv("CAS_BASE_PATH", base); err != nil {
-		t.Fatalf("failed to set CAS_BASE_PATH: %v", err)
-	}
-
-	c := NewCache()
+	c := NewCache(base)
 	if c == nil {
 		t.Fatal("NewCache() returned nil")
 	}
@@ -12,7 +12,7 @@ func TestJSONStats() {
 	logger := logging.NewDebugLogger()
 
 	// Crea una cache di test
-	testCache, err := cache.NewPodCache(2, 1024*1024, logger)
+	testCache, err := cache.NewPodCache(2, 1024*1024, ".cas", logger)
 	if err != nil {
 		fmt.Printf("Error creating cache: %v\n", err)
 		return
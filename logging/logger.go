@@ -1,8 +1,10 @@
 package logging
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
+	"strings"
 )
 
 // Logger interface for dependency injection and testing
@@ -14,18 +16,47 @@ type Logger interface {
 	Fatal(msg string, args ...any)
 }
 
+// LevelSetter is implemented by loggers whose level can change at runtime
+type LevelSetter interface {
+	SetLevel(level slog.Level)
+}
+
 // SlogLogger wraps slog.Logger to implement our Logger interface
 type SlogLogger struct {
 	logger *slog.Logger
+	level  *slog.LevelVar
 }
 
 // NewLogger creates a new configured logger
 func NewLogger(level slog.Level) Logger {
+	levelVar := &slog.LevelVar{}
+	levelVar.Set(level)
 	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: level,
+		Level: levelVar,
 	})
 	logger := slog.New(handler)
-	return &SlogLogger{logger: logger}
+	return &SlogLogger{logger: logger, level: levelVar}
+}
+
+// SetLevel changes the minimum level of the logger
+func (l *SlogLogger) SetLevel(level slog.Level) {
+	l.level.Set(level)
+}
+
+// ParseLevel converts a Redis loglevel name (debug, verbose, notice, warning)
+// or a slog level name (info, warn, error) to a slog.Level
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug", "verbose":
+		return slog.LevelDebug, nil
+	case "notice", "info":
+		return slog.LevelInfo, nil
+	case "warning", "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %s", name)
 }
 
 // NewDefaultLogger creates a logger with default configuration
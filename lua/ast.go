@@ -0,0 +1,178 @@
+package lua
+
+// Albero sintattico prodotto dal parser ed eseguito direttamente dall'interprete
+
+type Expr interface{ exprNode() }
+
+type Stmt interface{ stmtNode() }
+
+type Block struct {
+	Stmts []Stmt
+}
+
+type (
+	NilExpr    struct{}
+	TrueExpr   struct{}
+	FalseExpr  struct{}
+	VarargExpr struct{}
+
+	NumberExpr struct {
+		Value float64
+	}
+
+	StringExpr struct {
+		Value string
+	}
+
+	NameExpr struct {
+		Name string
+		Line int
+	}
+
+	IndexExpr struct {
+		Obj  Expr
+		Key  Expr
+		Line int
+	}
+
+	CallExpr struct {
+		Fn   Expr
+		Args []Expr
+		Line int
+	}
+
+	MethodCallExpr struct {
+		Obj    Expr
+		Method string
+		Args   []Expr
+		Line   int
+	}
+
+	// ParenExpr tronca a un solo valore il risultato di chiamate e vararg
+	ParenExpr struct {
+		Expr Expr
+	}
+
+	FunctionExpr struct {
+		Name     string
+		Params   []string
+		IsVararg bool
+		Body     *Block
+		Line     int
+	}
+
+	TableField struct {
+		Key   Expr // nil per gli elementi posizionali
+		Value Expr
+	}
+
+	TableExpr struct {
+		Fields []TableField
+	}
+
+	BinOpExpr struct {
+		Op    tokenKind
+		Left  Expr
+		Right Expr
+		Line  int
+	}
+
+	UnOpExpr struct {
+		Op   tokenKind
+		Expr Expr
+		Line int
+	}
+)
+
+type (
+	LocalStmt struct {
+		Names []string
+		Exprs []Expr
+	}
+
+	AssignStmt struct {
+		Targets []Expr
+		Exprs   []Expr
+		Line    int
+	}
+
+	CallStmt struct {
+		Call Expr
+	}
+
+	DoStmt struct {
+		Body *Block
+	}
+
+	WhileStmt struct {
+		Cond Expr
+		Body *Block
+	}
+
+	RepeatStmt struct {
+		Body *Block
+		Cond Expr
+	}
+
+	IfStmt struct {
+		Conds  []Expr
+		Blocks []*Block
+		Else   *Block
+	}
+
+	NumericForStmt struct {
+		Var   string
+		Start Expr
+		Limit Expr
+		Step  Expr
+		Body  *Block
+		Line  int
+	}
+
+	GenericForStmt struct {
+		Names []string
+		Exprs []Expr
+		Body  *Block
+		Line  int
+	}
+
+	LocalFunctionStmt struct {
+		Name string
+		Func *FunctionExpr
+	}
+
+	ReturnStmt struct {
+		Exprs []Expr
+	}
+
+	BreakStmt struct{}
+)
+
+func (*NilExpr) exprNode()        {}
+func (*TrueExpr) exprNode()       {}
+func (*FalseExpr) exprNode()      {}
+func (*VarargExpr) exprNode()     {}
+func (*NumberExpr) exprNode()     {}
+func (*StringExpr) exprNode()     {}
+func (*NameExpr) exprNode()       {}
+func (*IndexExpr) exprNode()      {}
+func (*CallExpr) exprNode()       {}
+func (*MethodCallExpr) exprNode() {}
+func (*ParenExpr) exprNode()      {}
+func (*FunctionExpr) exprNode()   {}
+func (*TableExpr) exprNode()      {}
+func (*BinOpExpr) exprNode()      {}
+func (*UnOpExpr) exprNode()       {}
+
+func (*LocalStmt) stmtNode()         {}
+func (*AssignStmt) stmtNode()        {}
+func (*CallStmt) stmtNode()          {}
+func (*DoStmt) stmtNode()            {}
+func (*WhileStmt) stmtNode()         {}
+func (*RepeatStmt) stmtNode()        {}
+func (*IfStmt) stmtNode()            {}
+func (*NumericForStmt) stmtNode()    {}
+func (*GenericForStmt) stmtNode()    {}
+func (*LocalFunctionStmt) stmtNode() {}
+func (*ReturnStmt) stmtNode()        {}
+func (*BreakStmt) stmtNode()         {}
@@ -0,0 +1,969 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenLibs registra le funzioni di base e le librerie string, table e math
+func (s *State) OpenLibs() {
+	Register(s.Globals, map[string]func(*State, []Value) ([]Value, error){
+		"assert":   baseAssert,
+		"error":    baseError,
+		"ipairs":   baseIpairs,
+		"next":     baseNext,
+		"pairs":    basePairs,
+		"pcall":    basePcall,
+		"rawequal": baseRawequal,
+		"rawget":   baseRawget,
+		"rawset":   baseRawset,
+		"select":   baseSelect,
+		"tonumber": baseTonumber,
+		"tostring": baseTostring,
+		"type":     baseType,
+		"unpack":   tableUnpack,
+	})
+
+	s.stringLib = NewTable()
+	Register(s.stringLib, map[string]func(*State, []Value) ([]Value, error){
+		"byte":   strByte,
+		"char":   strChar,
+		"find":   strFind,
+		"format": strFormat,
+		"gmatch": strGmatch,
+		"gsub":   strGsub,
+		"len":    strLen,
+		"lower":  strLower,
+		"match":  strMatch,
+		"rep":    strRep,
+		"reverse": func(_ *State, args []Value) ([]Value, error) {
+			str, err := checkString(args, 0, "reverse")
+			if err != nil {
+				return nil, err
+			}
+			b := []byte(str)
+			for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+				b[i], b[j] = b[j], b[i]
+			}
+			return []Value{string(b)}, nil
+		},
+		"sub":   strSub,
+		"upper": strUpper,
+	})
+	s.Globals.Set("string", s.stringLib)
+
+	table := NewTable()
+	Register(table, map[string]func(*State, []Value) ([]Value, error){
+		"concat": tableConcat,
+		"getn":   tableGetn,
+		"insert": tableInsert,
+		"remove": tableRemove,
+		"sort":   tableSort,
+		"unpack": tableUnpack,
+	})
+	s.Globals.Set("table", table)
+
+	mathLib := NewTable()
+	Register(mathLib, map[string]func(*State, []Value) ([]Value, error){
+		"abs":   mathFunc("abs", math.Abs),
+		"ceil":  mathFunc("ceil", math.Ceil),
+		"floor": mathFunc("floor", math.Floor),
+		"sqrt":  mathFunc("sqrt", math.Sqrt),
+		"exp":   mathFunc("exp", math.Exp),
+		"log":   mathFunc("log", math.Log),
+		"log10": mathFunc("log10", math.Log10),
+		"fmod": func(_ *State, args []Value) ([]Value, error) {
+			a, b, err := checkTwoNumbers(args, "fmod")
+			if err != nil {
+				return nil, err
+			}
+			return []Value{math.Mod(a, b)}, nil
+		},
+		"pow": func(_ *State, args []Value) ([]Value, error) {
+			a, b, err := checkTwoNumbers(args, "pow")
+			if err != nil {
+				return nil, err
+			}
+			return []Value{math.Pow(a, b)}, nil
+		},
+		"max": mathMinMax("max", func(a, b float64) bool { return a > b }),
+		"min": mathMinMax("min", func(a, b float64) bool { return a < b }),
+	})
+	mathLib.Set("huge", math.Inf(1))
+	mathLib.Set("pi", math.Pi)
+	s.Globals.Set("math", mathLib)
+}
+
+// Register aggiunge alla tabella le funzioni Go indicate
+func Register(table *Table, funcs map[string]func(*State, []Value) ([]Value, error)) {
+	for name, fn := range funcs {
+		table.Set(name, &GoFunction{Name: name, Fn: fn})
+	}
+}
+
+func argError(i int, fname, msg string) error {
+	return fmt.Errorf("bad argument #%d to '%s' (%s)", i+1, fname, msg)
+}
+
+func arg(args []Value, i int) Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+func typeError(args []Value, i int, fname, expected string) error {
+	got := "no value"
+	if i < len(args) {
+		got = TypeName(args[i])
+	}
+	return argError(i, fname, expected+" expected, got "+got)
+}
+
+// checkNumber restituisce l'argomento i come numero, convertendo le stringhe numeriche
+func checkNumber(args []Value, i int, fname string) (float64, error) {
+	if n, ok := ToNumber(arg(args, i)); ok {
+		return n, nil
+	}
+	return 0, typeError(args, i, fname, "number")
+}
+
+// checkString restituisce l'argomento i come stringa, convertendo i numeri
+func checkString(args []Value, i int, fname string) (string, error) {
+	if s, ok := ToString(arg(args, i)); ok {
+		return s, nil
+	}
+	return "", typeError(args, i, fname, "string")
+}
+
+func checkInt(args []Value, i int, fname string) (int, error) {
+	n, err := checkNumber(args, i, fname)
+	return int(n), err
+}
+
+func optInt(args []Value, i int, fname string, def int) (int, error) {
+	if arg(args, i) == nil {
+		return def, nil
+	}
+	return checkInt(args, i, fname)
+}
+
+func checkTable(args []Value, i int, fname string) (*Table, error) {
+	if t, ok := arg(args, i).(*Table); ok {
+		return t, nil
+	}
+	return nil, typeError(args, i, fname, "table")
+}
+
+func checkTwoNumbers(args []Value, fname string) (float64, float64, error) {
+	a, err := checkNumber(args, 0, fname)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := checkNumber(args, 1, fname)
+	return a, b, err
+}
+
+// ----- funzioni di base -----
+
+func baseAssert(_ *State, args []Value) ([]Value, error) {
+	if Truthy(arg(args, 0)) {
+		return args, nil
+	}
+	if len(args) > 1 {
+		return nil, &Error{Value: args[1]}
+	}
+	return nil, fmt.Errorf("assertion failed!")
+}
+
+func baseError(s *State, args []Value) ([]Value, error) {
+	value := arg(args, 0)
+	level, err := optInt(args, 1, "error", 1)
+	if err != nil {
+		return nil, err
+	}
+	if msg, ok := value.(string); ok && level > 0 {
+		value = s.Where() + msg
+	}
+	return nil, &Error{Value: value}
+}
+
+func baseIpairs(_ *State, args []Value) ([]Value, error) {
+	if _, err := checkTable(args, 0, "ipairs"); err != nil {
+		return nil, err
+	}
+	iter := &GoFunction{Name: "ipairs_aux", Fn: func(_ *State, args []Value) ([]Value, error) {
+		t := args[0].(*Table)
+		i := args[1].(float64) + 1
+		v := t.Get(i)
+		if v == nil {
+			return []Value{nil}, nil
+		}
+		return []Value{i, v}, nil
+	}}
+	return []Value{iter, args[0], 0.0}, nil
+}
+
+func baseNext(_ *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "next")
+	if err != nil {
+		return nil, err
+	}
+	k, v, err := t.Next(arg(args, 1))
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return []Value{nil}, nil
+	}
+	return []Value{k, v}, nil
+}
+
+func basePairs(s *State, args []Value) ([]Value, error) {
+	if _, err := checkTable(args, 0, "pairs"); err != nil {
+		return nil, err
+	}
+	return []Value{s.Globals.Get("next"), args[0], nil}, nil
+}
+
+func basePcall(s *State, args []Value) ([]Value, error) {
+	if len(args) == 0 {
+		return nil, argError(0, "pcall", "value expected")
+	}
+	depth := s.depth
+	rets, err := s.Call(args[0], args[1:])
+	if err != nil {
+		luaErr, ok := err.(*Error)
+		if !ok {
+			return nil, err
+		}
+		s.depth = depth
+		return []Value{false, luaErr.Value}, nil
+	}
+	return append([]Value{true}, rets...), nil
+}
+
+func baseRawequal(_ *State, args []Value) ([]Value, error) {
+	return []Value{arg(args, 0) == arg(args, 1)}, nil
+}
+
+func baseRawget(_ *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "rawget")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{t.Get(arg(args, 1))}, nil
+}
+
+func baseRawset(_ *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "rawset")
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Set(arg(args, 1), arg(args, 2)); err != nil {
+		return nil, err
+	}
+	return []Value{t}, nil
+}
+
+func baseSelect(_ *State, args []Value) ([]Value, error) {
+	if s, ok := arg(args, 0).(string); ok && s == "#" {
+		return []Value{float64(len(args) - 1)}, nil
+	}
+	n, err := checkInt(args, 0, "select")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case n < 0:
+		n = len(args) + n
+	case n == 0:
+		return nil, argError(0, "select", "index out of range")
+	}
+	if n < 1 {
+		return nil, argError(0, "select", "index out of range")
+	}
+	if n >= len(args) {
+		return nil, nil
+	}
+	return args[n:], nil
+}
+
+func baseTonumber(_ *State, args []Value) ([]Value, error) {
+	base, err := optInt(args, 1, "tonumber", 10)
+	if err != nil {
+		return nil, err
+	}
+	if base == 10 {
+		if n, ok := ToNumber(arg(args, 0)); ok {
+			return []Value{n}, nil
+		}
+		return []Value{nil}, nil
+	}
+	if base < 2 || base > 36 {
+		return nil, argError(1, "tonumber", "base out of range")
+	}
+	str, err := checkString(args, 0, "tonumber")
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(str), base, 64)
+	if err != nil {
+		return []Value{nil}, nil
+	}
+	return []Value{float64(n)}, nil
+}
+
+func baseTostring(_ *State, args []Value) ([]Value, error) {
+	if len(args) == 0 {
+		return nil, argError(0, "tostring", "value expected")
+	}
+	return []Value{tostring(args[0])}, nil
+}
+
+func baseType(_ *State, args []Value) ([]Value, error) {
+	if len(args) == 0 {
+		return nil, argError(0, "type", "value expected")
+	}
+	return []Value{TypeName(args[0])}, nil
+}
+
+// ----- libreria table -----
+
+func tableConcat(_ *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "concat")
+	if err != nil {
+		return nil, err
+	}
+	sep := ""
+	if arg(args, 1) != nil {
+		if sep, err = checkString(args, 1, "concat"); err != nil {
+			return nil, err
+		}
+	}
+	i, err := optInt(args, 2, "concat", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optInt(args, 3, "concat", t.Len())
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	for k := i; k <= j; k++ {
+		s, ok := ToString(t.Get(float64(k)))
+		if !ok {
+			return nil, fmt.Errorf("invalid value (at index %d) in table for 'concat'", k)
+		}
+		b.WriteString(s)
+		if k < j {
+			b.WriteString(sep)
+		}
+	}
+	return []Value{b.String()}, nil
+}
+
+func tableGetn(_ *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "getn")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{float64(t.Len())}, nil
+}
+
+func tableInsert(_ *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "insert")
+	if err != nil {
+		return nil, err
+	}
+	n := t.Len()
+	switch len(args) {
+	case 2:
+		t.Set(float64(n+1), args[1])
+	case 3:
+		pos, err := checkInt(args, 1, "insert")
+		if err != nil {
+			return nil, err
+		}
+		for i := n; i >= pos; i-- {
+			t.Set(float64(i+1), t.Get(float64(i)))
+		}
+		t.Set(float64(pos), args[2])
+	default:
+		return nil, fmt.Errorf("wrong number of arguments to 'insert'")
+	}
+	return nil, nil
+}
+
+func tableRemove(_ *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "remove")
+	if err != nil {
+		return nil, err
+	}
+	n := t.Len()
+	pos, err := optInt(args, 1, "remove", n)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return []Value{nil}, nil
+	}
+	removed := t.Get(float64(pos))
+	for i := pos; i < n; i++ {
+		t.Set(float64(i), t.Get(float64(i+1)))
+	}
+	t.Set(float64(n), nil)
+	return []Value{removed}, nil
+}
+
+func tableSort(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "sort")
+	if err != nil {
+		return nil, err
+	}
+	less := arg(args, 1)
+
+	n := t.Len()
+	values := make([]Value, n)
+	for i := range values {
+		values[i] = t.Get(float64(i + 1))
+	}
+
+	var sortErr error
+	sort.SliceStable(values, func(i, j int) bool {
+		if sortErr != nil {
+			return false
+		}
+		if less != nil {
+			rets, err := s.Call(less, []Value{values[i], values[j]})
+			if err != nil {
+				sortErr = err
+				return false
+			}
+			return len(rets) > 0 && Truthy(rets[0])
+		}
+		switch a := values[i].(type) {
+		case float64:
+			if b, ok := values[j].(float64); ok {
+				return a < b
+			}
+		case string:
+			if b, ok := values[j].(string); ok {
+				return a < b
+			}
+		}
+		sortErr = fmt.Errorf("attempt to compare %s with %s", TypeName(values[i]), TypeName(values[j]))
+		return false
+	})
+	if sortErr != nil {
+		return nil, sortErr
+	}
+	for i, v := range values {
+		t.Set(float64(i+1), v)
+	}
+	return nil, nil
+}
+
+func tableUnpack(_ *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "unpack")
+	if err != nil {
+		return nil, err
+	}
+	i, err := optInt(args, 1, "unpack", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optInt(args, 2, "unpack", t.Len())
+	if err != nil {
+		return nil, err
+	}
+	if j-i >= 8000 {
+		return nil, fmt.Errorf("too many results to unpack")
+	}
+	var values []Value
+	for k := i; k <= j; k++ {
+		values = append(values, t.Get(float64(k)))
+	}
+	return values, nil
+}
+
+// ----- libreria math -----
+
+func mathFunc(name string, fn func(float64) float64) func(*State, []Value) ([]Value, error) {
+	return func(_ *State, args []Value) ([]Value, error) {
+		n, err := checkNumber(args, 0, name)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{fn(n)}, nil
+	}
+}
+
+func mathMinMax(name string, better func(a, b float64) bool) func(*State, []Value) ([]Value, error) {
+	return func(_ *State, args []Value) ([]Value, error) {
+		result, err := checkNumber(args, 0, name)
+		if err != nil {
+			return nil, err
+		}
+		for i := 1; i < len(args); i++ {
+			n, err := checkNumber(args, i, name)
+			if err != nil {
+				return nil, err
+			}
+			if better(n, result) {
+				result = n
+			}
+		}
+		return []Value{result}, nil
+	}
+}
+
+// ----- libreria string -----
+
+// strRange converte gli indici Lua (1-based, negativi dalla fine) in un intervallo Go
+func strRange(length, i, j int) (int, int) {
+	if i < 0 {
+		i = max(length+i+1, 1)
+	} else if i == 0 {
+		i = 1
+	}
+	if j < 0 {
+		j = length + j + 1
+	} else if j > length {
+		j = length
+	}
+	return i, j
+}
+
+func strLen(_ *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 0, "len")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{float64(len(str))}, nil
+}
+
+func strSub(_ *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 0, "sub")
+	if err != nil {
+		return nil, err
+	}
+	i, err := optInt(args, 1, "sub", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optInt(args, 2, "sub", -1)
+	if err != nil {
+		return nil, err
+	}
+	i, j = strRange(len(str), i, j)
+	if i > j {
+		return []Value{""}, nil
+	}
+	return []Value{str[i-1 : j]}, nil
+}
+
+func strUpper(_ *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 0, "upper")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{strings.ToUpper(str)}, nil
+}
+
+func strLower(_ *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 0, "lower")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{strings.ToLower(str)}, nil
+}
+
+func strRep(_ *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 0, "rep")
+	if err != nil {
+		return nil, err
+	}
+	n, err := checkInt(args, 1, "rep")
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return []Value{""}, nil
+	}
+	if len(str)*n > 512*1024*1024 {
+		return nil, fmt.Errorf("resulting string too large")
+	}
+	return []Value{strings.Repeat(str, n)}, nil
+}
+
+func strByte(_ *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 0, "byte")
+	if err != nil {
+		return nil, err
+	}
+	i, err := optInt(args, 1, "byte", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optInt(args, 2, "byte", i)
+	if err != nil {
+		return nil, err
+	}
+	i, j = strRange(len(str), i, j)
+	var values []Value
+	for k := i; k <= j; k++ {
+		values = append(values, float64(str[k-1]))
+	}
+	return values, nil
+}
+
+func strChar(_ *State, args []Value) ([]Value, error) {
+	b := make([]byte, len(args))
+	for i := range args {
+		c, err := checkInt(args, i, "char")
+		if err != nil {
+			return nil, err
+		}
+		if c < 0 || c > 255 {
+			return nil, argError(i, "char", "invalid value")
+		}
+		b[i] = byte(c)
+	}
+	return []Value{string(b)}, nil
+}
+
+func strFind(_ *State, args []Value) ([]Value, error) {
+	return strFindAux(args, true)
+}
+
+func strMatch(_ *State, args []Value) ([]Value, error) {
+	return strFindAux(args, false)
+}
+
+func strFindAux(args []Value, find bool) ([]Value, error) {
+	fname := "match"
+	if find {
+		fname = "find"
+	}
+	str, err := checkString(args, 0, fname)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := checkString(args, 1, fname)
+	if err != nil {
+		return nil, err
+	}
+	init, err := optInt(args, 2, fname, 1)
+	if err != nil {
+		return nil, err
+	}
+	if init < 0 {
+		init = max(len(str)+init+1, 1)
+	} else if init == 0 {
+		init = 1
+	}
+	if init > len(str)+1 {
+		return []Value{nil}, nil
+	}
+
+	if find && (Truthy(arg(args, 3)) || !hasSpecials(pattern)) {
+		pos := strings.Index(str[init-1:], pattern)
+		if pos < 0 {
+			return []Value{nil}, nil
+		}
+		start := init + pos
+		return []Value{float64(start), float64(start + len(pattern) - 1)}, nil
+	}
+
+	anchor := len(pattern) > 0 && pattern[0] == '^'
+	p := 0
+	if anchor {
+		p = 1
+	}
+	ms := &matchState{src: str, pattern: pattern}
+	for s := init - 1; s <= len(str); s++ {
+		ms.level = 0
+		e, err := ms.match(s, p)
+		if err != nil {
+			return nil, err
+		}
+		if e != -1 {
+			if find {
+				captures, err := ms.captureValues(s, e, false)
+				if err != nil {
+					return nil, err
+				}
+				return append([]Value{float64(s + 1), float64(e)}, captures...), nil
+			}
+			return ms.captureValues(s, e, true)
+		}
+		if anchor {
+			break
+		}
+	}
+	return []Value{nil}, nil
+}
+
+func strGmatch(_ *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 0, "gmatch")
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := checkString(args, 1, "gmatch")
+	if err != nil {
+		return nil, err
+	}
+	pos := 0
+	iter := &GoFunction{Name: "gmatch_aux", Fn: func(_ *State, _ []Value) ([]Value, error) {
+		ms := &matchState{src: str, pattern: pattern}
+		for s := pos; s <= len(str); s++ {
+			ms.level = 0
+			e, err := ms.match(s, 0)
+			if err != nil {
+				return nil, err
+			}
+			if e != -1 {
+				// una corrispondenza vuota fa comunque avanzare di un carattere
+				pos = e
+				if e == s {
+					pos++
+				}
+				return ms.captureValues(s, e, true)
+			}
+		}
+		pos = len(str) + 1
+		return []Value{nil}, nil
+	}}
+	return []Value{iter}, nil
+}
+
+func strGsub(s *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 0, "gsub")
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := checkString(args, 1, "gsub")
+	if err != nil {
+		return nil, err
+	}
+	repl := arg(args, 2)
+	switch repl.(type) {
+	case string, float64, *Table, *Closure, *GoFunction:
+	default:
+		return nil, typeError(args, 2, "gsub", "string/function/table")
+	}
+	maxN, err := optInt(args, 3, "gsub", len(str)+1)
+	if err != nil {
+		return nil, err
+	}
+
+	anchor := len(pattern) > 0 && pattern[0] == '^'
+	p := 0
+	if anchor {
+		p = 1
+	}
+	ms := &matchState{src: str, pattern: pattern}
+	var b strings.Builder
+	n := 0
+	src := 0
+	for n < maxN {
+		ms.level = 0
+		e, err := ms.match(src, p)
+		if err != nil {
+			return nil, err
+		}
+		if e != -1 {
+			n++
+			if err := addReplacement(s, ms, &b, src, e, repl); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case e != -1 && e > src:
+			src = e
+		case src < len(str):
+			b.WriteByte(str[src])
+			src++
+		default:
+			src = len(str) + 1
+		}
+		if src > len(str) || anchor {
+			break
+		}
+	}
+	if src <= len(str) {
+		b.WriteString(str[src:])
+	}
+	return []Value{b.String(), float64(n)}, nil
+}
+
+func addReplacement(s *State, ms *matchState, b *strings.Builder, start, end int, repl Value) error {
+	whole := ms.src[start:end]
+	var value Value
+	switch r := repl.(type) {
+	case string, float64:
+		text, _ := ToString(r)
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			if c != patternEscape {
+				b.WriteByte(c)
+				continue
+			}
+			i++
+			if i >= len(text) {
+				return fmt.Errorf("invalid use of '%%' in replacement string")
+			}
+			switch d := text[i]; {
+			case d == '0':
+				b.WriteString(whole)
+			case isDigit(d):
+				v, err := ms.captureValue(int(d-'1'), start, end)
+				if err != nil {
+					return err
+				}
+				str, _ := ToString(v)
+				b.WriteString(str)
+			default:
+				b.WriteByte(d)
+			}
+		}
+		return nil
+	case *Table:
+		key, err := ms.captureValue(0, start, end)
+		if err != nil {
+			return err
+		}
+		value = r.Get(key)
+	default:
+		captures, err := ms.captureValues(start, end, true)
+		if err != nil {
+			return err
+		}
+		rets, err := s.Call(r, captures)
+		if err != nil {
+			return err
+		}
+		if len(rets) > 0 {
+			value = rets[0]
+		}
+	}
+
+	if !Truthy(value) {
+		b.WriteString(whole)
+		return nil
+	}
+	str, ok := ToString(value)
+	if !ok {
+		return fmt.Errorf("invalid replacement value (a %s)", TypeName(value))
+	}
+	b.WriteString(str)
+	return nil
+}
+
+// strFormat implementa string.format con le direttive di printf supportate da Lua
+func strFormat(_ *State, args []Value) ([]Value, error) {
+	format, err := checkString(args, 0, "format")
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	argi := 1
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(format) {
+			return nil, fmt.Errorf("invalid option '%%' to 'format'")
+		}
+		if format[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+
+		start := i
+		for i < len(format) && strings.IndexByte("-+ #0", format[i]) >= 0 {
+			i++
+		}
+		for i < len(format) && (isDigit(format[i]) || format[i] == '.') {
+			i++
+		}
+		if i >= len(format) {
+			return nil, fmt.Errorf("invalid option '%%' to 'format'")
+		}
+		spec := "%" + format[start:i]
+		verb := format[i]
+
+		if argi >= len(args) {
+			return nil, argError(argi, "format", "no value")
+		}
+		switch verb {
+		case 'd', 'i':
+			n, err := checkNumber(args, argi, "format")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+"d", int64(n))
+		case 'c':
+			n, err := checkNumber(args, argi, "format")
+			if err != nil {
+				return nil, err
+			}
+			b.WriteByte(byte(n))
+		case 'x', 'X', 'o':
+			n, err := checkNumber(args, argi, "format")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+string(verb), int64(n))
+		case 'e', 'E', 'f', 'g', 'G':
+			n, err := checkNumber(args, argi, "format")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+string(verb), n)
+		case 'q':
+			str, err := checkString(args, argi, "format")
+			if err != nil {
+				return nil, err
+			}
+			b.WriteString(quoteString(str))
+		case 's':
+			v := args[argi]
+			fmt.Fprintf(&b, spec+"s", tostring(v))
+		default:
+			return nil, fmt.Errorf("invalid option '%%%c' to 'format'", verb)
+		}
+		argi++
+	}
+	return []Value{b.String()}, nil
+}
+
+func quoteString(str string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString("\\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case 0:
+			b.WriteString("\\000")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,790 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// maxCallDepth limita la ricorsione degli script prima di "stack overflow"
+	maxCallDepth = 200
+	// maxExprDepth limita l'annidamento delle espressioni valutate, comprese
+	// le catene come 1+1+...+1 che il parser costruisce senza ricorsione
+	maxExprDepth = 10000
+	// interruptEvery è ogni quante istruzioni viene interrogato Interrupt
+	interruptEvery = 1000
+)
+
+// State è un interprete: variabili globali, librerie e stato di esecuzione.
+// Non è sicuro per l'uso concorrente.
+type State struct {
+	Globals *Table
+
+	// Interrupt, se impostata, viene chiamata periodicamente durante
+	// l'esecuzione: un errore interrompe lo script e non è intercettabile da pcall
+	Interrupt func() error
+
+	// Strict impedisce di creare o leggere variabili globali non definite
+	Strict bool
+
+	stringLib *Table
+	chunk     string
+	line      int
+	depth     int
+	exprDepth int
+	steps     int
+}
+
+func NewState() *State {
+	return &State{Globals: NewTable(), chunk: "?"}
+}
+
+// scope lega i nomi locali: ogni dichiarazione local apre uno scope figlio,
+// così le closure vedono esattamente le variabili visibili alla definizione
+type scope struct {
+	names  []string
+	values []Value
+	parent *scope
+}
+
+func (sc *scope) lookup(name string) (*scope, int) {
+	for s := sc; s != nil; s = s.parent {
+		for i := len(s.names) - 1; i >= 0; i-- {
+			if s.names[i] == name {
+				return s, i
+			}
+		}
+	}
+	return nil, -1
+}
+
+// frame è lo stato di una singola invocazione di funzione Lua
+type frame struct {
+	varargs []Value
+}
+
+type control int
+
+const (
+	ctrlNone control = iota
+	ctrlBreak
+	ctrlReturn
+)
+
+// Compile compila il sorgente restituendo la funzione principale del chunk.
+// Il risultato non dipende da uno State e può essere eseguito più volte.
+func Compile(src, chunk string) (*Closure, error) {
+	proto, err := Parse(src, chunk)
+	if err != nil {
+		return nil, err
+	}
+	return &Closure{proto: proto, chunk: chunk}, nil
+}
+
+// DoString compila ed esegue il sorgente
+func (s *State) DoString(src, chunk string) ([]Value, error) {
+	fn, err := Compile(src, chunk)
+	if err != nil {
+		return nil, err
+	}
+	return s.Call(fn, nil)
+}
+
+func (s *State) SetGlobal(name string, value Value) {
+	s.Globals.Set(name, value)
+}
+
+func (s *State) GetGlobal(name string) Value {
+	return s.Globals.Get(name)
+}
+
+// runtimeError costruisce un errore con la posizione corrente nello script
+func (s *State) runtimeError(line int, format string, args ...any) error {
+	return &Error{Value: fmt.Sprintf("%s:%d: %s", s.chunk, line, fmt.Sprintf(format, args...))}
+}
+
+// Where restituisce il prefisso "chunk:riga: " dell'istruzione in esecuzione
+func (s *State) Where() string {
+	return fmt.Sprintf("%s:%d: ", s.chunk, s.line)
+}
+
+// Call invoca una funzione Lua o Go con gli argomenti dati
+func (s *State) Call(fn Value, args []Value) ([]Value, error) {
+	switch f := fn.(type) {
+	case *Closure:
+		return s.callClosure(f, args)
+	case *GoFunction:
+		rets, err := f.Fn(s, args)
+		if err != nil {
+			if _, ok := err.(*Error); !ok {
+				if _, interrupted := err.(*interruptError); !interrupted {
+					err = &Error{Value: s.Where() + err.Error()}
+				}
+			}
+		}
+		return rets, err
+	}
+	return nil, s.runtimeError(s.line, "attempt to call a %s value", TypeName(fn))
+}
+
+// interruptError avvolge l'errore di Interrupt perché pcall non lo intercetti
+type interruptError struct {
+	err error
+}
+
+func (e *interruptError) Error() string { return e.err.Error() }
+func (e *interruptError) Unwrap() error { return e.err }
+
+func (s *State) callClosure(c *Closure, args []Value) ([]Value, error) {
+	if s.depth >= maxCallDepth {
+		return nil, s.runtimeError(s.line, "stack overflow")
+	}
+	s.depth++
+	line, chunk := s.line, s.chunk
+	s.chunk = c.chunk
+	defer func() {
+		s.depth--
+		s.line, s.chunk = line, chunk
+	}()
+
+	proto := c.proto
+	sc := &scope{names: proto.Params, values: make([]Value, len(proto.Params)), parent: c.env}
+	copy(sc.values, args)
+	f := &frame{}
+	if proto.IsVararg && len(args) > len(proto.Params) {
+		f.varargs = args[len(proto.Params):]
+	}
+
+	ctrl, rets, err := s.execBlock(f, sc, proto.Body)
+	if err != nil || ctrl != ctrlReturn {
+		return nil, err
+	}
+	return rets, nil
+}
+
+func (s *State) step(line int) error {
+	s.line = line
+	s.steps++
+	if s.Interrupt != nil && s.steps%interruptEvery == 0 {
+		if err := s.Interrupt(); err != nil {
+			return &interruptError{err: err}
+		}
+	}
+	return nil
+}
+
+func (s *State) execBlock(f *frame, sc *scope, block *Block) (control, []Value, error) {
+	ctrl, rets, _, err := s.execBlockScope(f, sc, block)
+	return ctrl, rets, err
+}
+
+// execBlockScope restituisce anche lo scope finale, che repeat-until usa per la condizione
+func (s *State) execBlockScope(f *frame, sc *scope, block *Block) (control, []Value, *scope, error) {
+	for _, stmt := range block.Stmts {
+		switch st := stmt.(type) {
+		case *LocalStmt:
+			values, err := s.evalList(f, sc, st.Exprs)
+			if err != nil {
+				return ctrlNone, nil, sc, err
+			}
+			inner := &scope{names: st.Names, values: make([]Value, len(st.Names)), parent: sc}
+			copy(inner.values, values)
+			sc = inner
+		case *LocalFunctionStmt:
+			// la funzione vede se stessa, per permettere la ricorsione
+			sc = &scope{names: []string{st.Name}, values: []Value{nil}, parent: sc}
+			sc.values[0] = &Closure{proto: st.Func, env: sc, chunk: s.chunk}
+		default:
+			ctrl, rets, err := s.exec(f, sc, stmt)
+			if err != nil || ctrl != ctrlNone {
+				return ctrl, rets, sc, err
+			}
+		}
+	}
+	return ctrlNone, nil, sc, nil
+}
+
+func (s *State) exec(f *frame, sc *scope, stmt Stmt) (control, []Value, error) {
+	switch st := stmt.(type) {
+	case *AssignStmt:
+		if err := s.step(st.Line); err != nil {
+			return ctrlNone, nil, err
+		}
+		return ctrlNone, nil, s.assign(f, sc, st)
+
+	case *CallStmt:
+		_, err := s.evalMulti(f, sc, st.Call)
+		return ctrlNone, nil, err
+
+	case *DoStmt:
+		return s.execBlock(f, sc, st.Body)
+
+	case *WhileStmt:
+		for {
+			cond, err := s.eval(f, sc, st.Cond)
+			if err != nil {
+				return ctrlNone, nil, err
+			}
+			if !Truthy(cond) {
+				return ctrlNone, nil, nil
+			}
+			ctrl, rets, err := s.execBlock(f, sc, st.Body)
+			if err != nil || ctrl == ctrlReturn {
+				return ctrl, rets, err
+			}
+			if ctrl == ctrlBreak {
+				return ctrlNone, nil, nil
+			}
+			if err := s.step(s.line); err != nil {
+				return ctrlNone, nil, err
+			}
+		}
+
+	case *RepeatStmt:
+		for {
+			ctrl, rets, inner, err := s.execBlockScope(f, sc, st.Body)
+			if err != nil || ctrl == ctrlReturn {
+				return ctrl, rets, err
+			}
+			if ctrl == ctrlBreak {
+				return ctrlNone, nil, nil
+			}
+			cond, err := s.eval(f, inner, st.Cond)
+			if err != nil {
+				return ctrlNone, nil, err
+			}
+			if Truthy(cond) {
+				return ctrlNone, nil, nil
+			}
+			if err := s.step(s.line); err != nil {
+				return ctrlNone, nil, err
+			}
+		}
+
+	case *IfStmt:
+		for i, condExpr := range st.Conds {
+			cond, err := s.eval(f, sc, condExpr)
+			if err != nil {
+				return ctrlNone, nil, err
+			}
+			if Truthy(cond) {
+				return s.execBlock(f, sc, st.Blocks[i])
+			}
+		}
+		if st.Else != nil {
+			return s.execBlock(f, sc, st.Else)
+		}
+		return ctrlNone, nil, nil
+
+	case *NumericForStmt:
+		return s.numericFor(f, sc, st)
+
+	case *GenericForStmt:
+		return s.genericFor(f, sc, st)
+
+	case *ReturnStmt:
+		rets, err := s.evalList(f, sc, st.Exprs)
+		return ctrlReturn, rets, err
+
+	case *BreakStmt:
+		return ctrlBreak, nil, nil
+	}
+	return ctrlNone, nil, fmt.Errorf("unsupported statement %T", stmt)
+}
+
+func (s *State) numericFor(f *frame, sc *scope, st *NumericForStmt) (control, []Value, error) {
+	if err := s.step(st.Line); err != nil {
+		return ctrlNone, nil, err
+	}
+	bound := func(expr Expr, what string) (float64, error) {
+		v, err := s.eval(f, sc, expr)
+		if err != nil {
+			return 0, err
+		}
+		n, ok := ToNumber(v)
+		if !ok {
+			return 0, s.runtimeError(st.Line, "'for' %s must be a number", what)
+		}
+		return n, nil
+	}
+	start, err := bound(st.Start, "initial value")
+	if err != nil {
+		return ctrlNone, nil, err
+	}
+	limit, err := bound(st.Limit, "limit")
+	if err != nil {
+		return ctrlNone, nil, err
+	}
+	step := 1.0
+	if st.Step != nil {
+		if step, err = bound(st.Step, "step"); err != nil {
+			return ctrlNone, nil, err
+		}
+	}
+
+	for i := start; (step > 0 && i <= limit) || (step <= 0 && i >= limit); i += step {
+		inner := &scope{names: []string{st.Var}, values: []Value{i}, parent: sc}
+		ctrl, rets, err := s.execBlock(f, inner, st.Body)
+		if err != nil || ctrl == ctrlReturn {
+			return ctrl, rets, err
+		}
+		if ctrl == ctrlBreak {
+			break
+		}
+		if err := s.step(st.Line); err != nil {
+			return ctrlNone, nil, err
+		}
+	}
+	return ctrlNone, nil, nil
+}
+
+func (s *State) genericFor(f *frame, sc *scope, st *GenericForStmt) (control, []Value, error) {
+	if err := s.step(st.Line); err != nil {
+		return ctrlNone, nil, err
+	}
+	init, err := s.evalList(f, sc, st.Exprs)
+	if err != nil {
+		return ctrlNone, nil, err
+	}
+	init = append(init, nil, nil, nil)
+	iter, state, control := init[0], init[1], init[2]
+
+	for {
+		s.line = st.Line
+		rets, err := s.Call(iter, []Value{state, control})
+		if err != nil {
+			return ctrlNone, nil, err
+		}
+		if len(rets) == 0 || rets[0] == nil {
+			return ctrlNone, nil, nil
+		}
+		control = rets[0]
+
+		inner := &scope{names: st.Names, values: make([]Value, len(st.Names)), parent: sc}
+		copy(inner.values, rets)
+		ctrl, rets, err := s.execBlock(f, inner, st.Body)
+		if err != nil || ctrl == ctrlReturn {
+			return ctrl, rets, err
+		}
+		if ctrl == ctrlBreak {
+			return ctrlNone, nil, nil
+		}
+		if err := s.step(st.Line); err != nil {
+			return ctrlNone, nil, err
+		}
+	}
+}
+
+func (s *State) assign(f *frame, sc *scope, st *AssignStmt) error {
+	// gli oggetti e le chiavi a sinistra si valutano prima dei valori a destra
+	type slot struct {
+		obj Value
+		key Value
+	}
+	slots := make([]slot, len(st.Targets))
+	for i, target := range st.Targets {
+		if index, ok := target.(*IndexExpr); ok {
+			obj, err := s.eval(f, sc, index.Obj)
+			if err != nil {
+				return err
+			}
+			key, err := s.eval(f, sc, index.Key)
+			if err != nil {
+				return err
+			}
+			slots[i] = slot{obj, key}
+		}
+	}
+
+	values, err := s.evalList(f, sc, st.Exprs)
+	if err != nil {
+		return err
+	}
+	for i, target := range st.Targets {
+		var value Value
+		if i < len(values) {
+			value = values[i]
+		}
+		switch t := target.(type) {
+		case *NameExpr:
+			if err := s.setName(sc, t, value); err != nil {
+				return err
+			}
+		case *IndexExpr:
+			table, ok := slots[i].obj.(*Table)
+			if !ok {
+				return s.operandError(t.Line, "index", sc, t.Obj, slots[i].obj)
+			}
+			if err := table.Set(slots[i].key, value); err != nil {
+				return s.runtimeError(t.Line, "%s", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *State) setName(sc *scope, name *NameExpr, value Value) error {
+	if owner, i := sc.lookup(name.Name); owner != nil {
+		owner.values[i] = value
+		return nil
+	}
+	if s.Strict && s.Globals.Get(name.Name) == nil {
+		return s.runtimeError(name.Line, "Script attempted to create global variable '%s'", name.Name)
+	}
+	return s.Globals.Set(name.Name, value)
+}
+
+// describe nomina l'espressione nei messaggi d'errore come fa Lua
+func (s *State) describe(sc *scope, expr Expr) string {
+	switch e := expr.(type) {
+	case *NameExpr:
+		if owner, _ := sc.lookup(e.Name); owner != nil {
+			return fmt.Sprintf("local '%s'", e.Name)
+		}
+		return fmt.Sprintf("global '%s'", e.Name)
+	case *IndexExpr:
+		if key, ok := e.Key.(*StringExpr); ok {
+			return fmt.Sprintf("field '%s'", key.Value)
+		}
+	case *MethodCallExpr:
+		return fmt.Sprintf("method '%s'", e.Method)
+	}
+	return ""
+}
+
+// operandError segnala un'operazione su un valore del tipo sbagliato
+func (s *State) operandError(line int, op string, sc *scope, expr Expr, v Value) error {
+	if desc := s.describe(sc, expr); desc != "" {
+		return s.runtimeError(line, "attempt to %s %s (a %s value)", op, desc, TypeName(v))
+	}
+	return s.runtimeError(line, "attempt to %s a %s value", op, TypeName(v))
+}
+
+// evalList valuta una lista di espressioni espandendo i valori multipli dell'ultima
+func (s *State) evalList(f *frame, sc *scope, exprs []Expr) ([]Value, error) {
+	values := make([]Value, 0, len(exprs))
+	for i, expr := range exprs {
+		if i == len(exprs)-1 {
+			rest, err := s.evalMulti(f, sc, expr)
+			if err != nil {
+				return nil, err
+			}
+			return append(values, rest...), nil
+		}
+		v, err := s.eval(f, sc, expr)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// evalMulti valuta un'espressione che può produrre più valori (chiamate e ...)
+func (s *State) evalMulti(f *frame, sc *scope, expr Expr) ([]Value, error) {
+	switch e := expr.(type) {
+	case *CallExpr:
+		fn, err := s.eval(f, sc, e.Fn)
+		if err != nil {
+			return nil, err
+		}
+		args, err := s.evalList(f, sc, e.Args)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.step(e.Line); err != nil {
+			return nil, err
+		}
+		switch fn.(type) {
+		case *Closure, *GoFunction:
+		default:
+			return nil, s.operandError(e.Line, "call", sc, e.Fn, fn)
+		}
+		return s.Call(fn, args)
+
+	case *MethodCallExpr:
+		obj, err := s.eval(f, sc, e.Obj)
+		if err != nil {
+			return nil, err
+		}
+		fn, err := s.index(sc, e.Obj, obj, e.Method, e.Line)
+		if err != nil {
+			return nil, err
+		}
+		args, err := s.evalList(f, sc, e.Args)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.step(e.Line); err != nil {
+			return nil, err
+		}
+		switch fn.(type) {
+		case *Closure, *GoFunction:
+		default:
+			return nil, s.operandError(e.Line, "call", sc, e, fn)
+		}
+		return s.Call(fn, append([]Value{obj}, args...))
+
+	case *VarargExpr:
+		return f.varargs, nil
+	}
+
+	v, err := s.eval(f, sc, expr)
+	if err != nil {
+		return nil, err
+	}
+	return []Value{v}, nil
+}
+
+func (s *State) index(sc *scope, objExpr Expr, obj, key Value, line int) (Value, error) {
+	switch o := obj.(type) {
+	case *Table:
+		return o.Get(key), nil
+	case string:
+		// le stringhe hanno come metatabella la libreria string
+		if s.stringLib != nil {
+			return s.stringLib.Get(key), nil
+		}
+	}
+	return nil, s.operandError(line, "index", sc, objExpr, obj)
+}
+
+func (s *State) eval(f *frame, sc *scope, expr Expr) (Value, error) {
+	if s.exprDepth >= maxExprDepth {
+		return nil, s.runtimeError(s.line, "expression too complex")
+	}
+	s.exprDepth++
+	v, err := s.evalExpr(f, sc, expr)
+	s.exprDepth--
+	return v, err
+}
+
+func (s *State) evalExpr(f *frame, sc *scope, expr Expr) (Value, error) {
+	switch e := expr.(type) {
+	case *NilExpr:
+		return nil, nil
+	case *TrueExpr:
+		return true, nil
+	case *FalseExpr:
+		return false, nil
+	case *NumberExpr:
+		return e.Value, nil
+	case *StringExpr:
+		return e.Value, nil
+
+	case *VarargExpr:
+		if len(f.varargs) == 0 {
+			return nil, nil
+		}
+		return f.varargs[0], nil
+
+	case *NameExpr:
+		if owner, i := sc.lookup(e.Name); owner != nil {
+			return owner.values[i], nil
+		}
+		v := s.Globals.Get(e.Name)
+		if v == nil && s.Strict {
+			return nil, s.runtimeError(e.Line, "Script attempted to access nonexistent global variable '%s'", e.Name)
+		}
+		return v, nil
+
+	case *IndexExpr:
+		obj, err := s.eval(f, sc, e.Obj)
+		if err != nil {
+			return nil, err
+		}
+		key, err := s.eval(f, sc, e.Key)
+		if err != nil {
+			return nil, err
+		}
+		return s.index(sc, e.Obj, obj, key, e.Line)
+
+	case *CallExpr, *MethodCallExpr:
+		rets, err := s.evalMulti(f, sc, expr)
+		if err != nil || len(rets) == 0 {
+			return nil, err
+		}
+		return rets[0], nil
+
+	case *ParenExpr:
+		return s.eval(f, sc, e.Expr)
+
+	case *FunctionExpr:
+		return &Closure{proto: e, env: sc, chunk: s.chunk}, nil
+
+	case *TableExpr:
+		return s.evalTable(f, sc, e)
+
+	case *UnOpExpr:
+		return s.evalUnOp(f, sc, e)
+
+	case *BinOpExpr:
+		return s.evalBinOp(f, sc, e)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", expr)
+}
+
+func (s *State) evalTable(f *frame, sc *scope, e *TableExpr) (Value, error) {
+	table := NewTable()
+	n := 0
+	for i, field := range e.Fields {
+		if field.Key != nil {
+			key, err := s.eval(f, sc, field.Key)
+			if err != nil {
+				return nil, err
+			}
+			value, err := s.eval(f, sc, field.Value)
+			if err != nil {
+				return nil, err
+			}
+			if err := table.Set(key, value); err != nil {
+				return nil, s.runtimeError(s.line, "%s", err)
+			}
+			continue
+		}
+		// l'ultimo elemento posizionale espande tutti i valori di una chiamata
+		if i == len(e.Fields)-1 {
+			values, err := s.evalMulti(f, sc, field.Value)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range values {
+				n++
+				table.Set(float64(n), v)
+			}
+			continue
+		}
+		v, err := s.eval(f, sc, field.Value)
+		if err != nil {
+			return nil, err
+		}
+		n++
+		table.Set(float64(n), v)
+	}
+	return table, nil
+}
+
+func (s *State) evalUnOp(f *frame, sc *scope, e *UnOpExpr) (Value, error) {
+	v, err := s.eval(f, sc, e.Expr)
+	if err != nil {
+		return nil, err
+	}
+	switch e.Op {
+	case tokNot:
+		return !Truthy(v), nil
+	case tokMinus:
+		n, ok := ToNumber(v)
+		if !ok {
+			return nil, s.operandError(e.Line, "perform arithmetic on", sc, e.Expr, v)
+		}
+		return -n, nil
+	case tokHash:
+		switch x := v.(type) {
+		case string:
+			return float64(len(x)), nil
+		case *Table:
+			return float64(x.Len()), nil
+		}
+		return nil, s.operandError(e.Line, "get length of", sc, e.Expr, v)
+	}
+	return nil, fmt.Errorf("unsupported unary operator %s", e.Op)
+}
+
+func (s *State) evalBinOp(f *frame, sc *scope, e *BinOpExpr) (Value, error) {
+	left, err := s.eval(f, sc, e.Left)
+	if err != nil {
+		return nil, err
+	}
+	switch e.Op {
+	case tokAnd:
+		if !Truthy(left) {
+			return left, nil
+		}
+		return s.eval(f, sc, e.Right)
+	case tokOr:
+		if Truthy(left) {
+			return left, nil
+		}
+		return s.eval(f, sc, e.Right)
+	}
+
+	right, err := s.eval(f, sc, e.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Op {
+	case tokEq:
+		return left == right, nil
+	case tokNe:
+		return left != right, nil
+	case tokLt, tokLe, tokGt, tokGe:
+		return s.compare(e, left, right)
+	case tokConcat:
+		a, okA := ToString(left)
+		b, okB := ToString(right)
+		if !okA || !okB {
+			bad, badExpr := left, e.Left
+			if okA {
+				bad, badExpr = right, e.Right
+			}
+			return nil, s.operandError(e.Line, "concatenate", sc, badExpr, bad)
+		}
+		return a + b, nil
+	}
+
+	a, okA := ToNumber(left)
+	b, okB := ToNumber(right)
+	if !okA || !okB {
+		bad, badExpr := left, e.Left
+		if okA {
+			bad, badExpr = right, e.Right
+		}
+		return nil, s.operandError(e.Line, "perform arithmetic on", sc, badExpr, bad)
+	}
+	switch e.Op {
+	case tokPlus:
+		return a + b, nil
+	case tokMinus:
+		return a - b, nil
+	case tokStar:
+		return a * b, nil
+	case tokSlash:
+		return a / b, nil
+	case tokPercent:
+		return a - math.Floor(a/b)*b, nil
+	case tokCaret:
+		return math.Pow(a, b), nil
+	}
+	return nil, fmt.Errorf("unsupported binary operator %s", e.Op)
+}
+
+func (s *State) compare(e *BinOpExpr, left, right Value) (Value, error) {
+	// a > b equivale a b < a, come in Lua
+	if e.Op == tokGt || e.Op == tokGe {
+		left, right = right, left
+	}
+	strict := e.Op == tokLt || e.Op == tokGt
+
+	switch a := left.(type) {
+	case float64:
+		if b, ok := right.(float64); ok {
+			if strict {
+				return a < b, nil
+			}
+			return a <= b, nil
+		}
+	case string:
+		if b, ok := right.(string); ok {
+			if strict {
+				return a < b, nil
+			}
+			return a <= b, nil
+		}
+	}
+	ta, tb := TypeName(left), TypeName(right)
+	if ta == tb {
+		return nil, s.runtimeError(e.Line, "attempt to compare two %s values", ta)
+	}
+	return nil, s.runtimeError(e.Line, "attempt to compare %s with %s", ta, tb)
+}
@@ -0,0 +1,416 @@
+package lua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokName
+	tokNumber
+	tokString
+
+	// parole chiave
+	tokAnd
+	tokBreak
+	tokDo
+	tokElse
+	tokElseif
+	tokEnd
+	tokFalse
+	tokFor
+	tokFunction
+	tokIf
+	tokIn
+	tokLocal
+	tokNil
+	tokNot
+	tokOr
+	tokRepeat
+	tokReturn
+	tokThen
+	tokTrue
+	tokUntil
+	tokWhile
+
+	// operatori e punteggiatura
+	tokPlus
+	tokMinus
+	tokStar
+	tokSlash
+	tokPercent
+	tokCaret
+	tokHash
+	tokEq
+	tokNe
+	tokLe
+	tokGe
+	tokLt
+	tokGt
+	tokAssign
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokLBracket
+	tokRBracket
+	tokSemicolon
+	tokColon
+	tokComma
+	tokDot
+	tokConcat
+	tokDots
+)
+
+var keywords = map[string]tokenKind{
+	"and": tokAnd, "break": tokBreak, "do": tokDo, "else": tokElse, "elseif": tokElseif,
+	"end": tokEnd, "false": tokFalse, "for": tokFor, "function": tokFunction, "if": tokIf,
+	"in": tokIn, "local": tokLocal, "nil": tokNil, "not": tokNot, "or": tokOr,
+	"repeat": tokRepeat, "return": tokReturn, "then": tokThen, "true": tokTrue,
+	"until": tokUntil, "while": tokWhile,
+}
+
+var singleTokens = map[byte]tokenKind{
+	'+': tokPlus, '-': tokMinus, '*': tokStar, '/': tokSlash, '%': tokPercent, '^': tokCaret,
+	'#': tokHash, '<': tokLt, '>': tokGt, '=': tokAssign, '(': tokLParen, ')': tokRParen,
+	'{': tokLBrace, '}': tokRBrace, '[': tokLBracket, ']': tokRBracket, ';': tokSemicolon,
+	':': tokColon, ',': tokComma, '.': tokDot,
+}
+
+var tokenNames = map[tokenKind]string{
+	tokEOF: "<eof>", tokPlus: "+", tokMinus: "-", tokStar: "*", tokSlash: "/", tokPercent: "%",
+	tokCaret: "^", tokHash: "#", tokEq: "==", tokNe: "~=", tokLe: "<=", tokGe: ">=", tokLt: "<",
+	tokGt: ">", tokAssign: "=", tokLParen: "(", tokRParen: ")", tokLBrace: "{", tokRBrace: "}",
+	tokLBracket: "[", tokRBracket: "]", tokSemicolon: ";", tokColon: ":", tokComma: ",",
+	tokDot: ".", tokConcat: "..", tokDots: "...",
+}
+
+func (k tokenKind) String() string {
+	if name, ok := tokenNames[k]; ok {
+		return name
+	}
+	for word, kind := range keywords {
+		if kind == k {
+			return word
+		}
+	}
+	switch k {
+	case tokName:
+		return "<name>"
+	case tokNumber:
+		return "<number>"
+	case tokString:
+		return "<string>"
+	}
+	return "?"
+}
+
+type token struct {
+	kind tokenKind
+	text string // nome, stringa già decodificata o testo del numero
+	num  float64
+	line int
+}
+
+type lexer struct {
+	src   string
+	pos   int
+	line  int
+	chunk string
+}
+
+func (l *lexer) errorf(format string, args ...any) error {
+	return &Error{Value: fmt.Sprintf("%s:%d: %s", l.chunk, l.line, fmt.Sprintf(format, args...))}
+}
+
+func (l *lexer) peekByte(offset int) byte {
+	if l.pos+offset < len(l.src) {
+		return l.src[l.pos+offset]
+	}
+	return 0
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skipSpaceAndComments(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, line: l.line}, nil
+	}
+
+	line := l.line
+	c := l.src[l.pos]
+	switch {
+	case isAlpha(c):
+		start := l.pos
+		for l.pos < len(l.src) && isAlnum(l.src[l.pos]) {
+			l.pos++
+		}
+		word := l.src[start:l.pos]
+		if kind, ok := keywords[word]; ok {
+			return token{kind: kind, text: word, line: line}, nil
+		}
+		return token{kind: tokName, text: word, line: line}, nil
+	case isDigit(c) || (c == '.' && isDigit(l.peekByte(1))):
+		return l.readNumber()
+	case c == '"' || c == '\'':
+		s, err := l.readString(c)
+		return token{kind: tokString, text: s, line: line}, err
+	case c == '[' && (l.peekByte(1) == '[' || l.peekByte(1) == '='):
+		if level, ok := l.longBracketLevel(); ok {
+			s, err := l.readLongString(level)
+			return token{kind: tokString, text: s, line: line}, err
+		}
+	}
+
+	three := l.src[l.pos:min(l.pos+3, len(l.src))]
+	two := l.src[l.pos:min(l.pos+2, len(l.src))]
+	switch {
+	case three == "...":
+		l.pos += 3
+		return token{kind: tokDots, line: line}, nil
+	case two == "..":
+		l.pos += 2
+		return token{kind: tokConcat, line: line}, nil
+	case two == "==":
+		l.pos += 2
+		return token{kind: tokEq, line: line}, nil
+	case two == "~=":
+		l.pos += 2
+		return token{kind: tokNe, line: line}, nil
+	case two == "<=":
+		l.pos += 2
+		return token{kind: tokLe, line: line}, nil
+	case two == ">=":
+		l.pos += 2
+		return token{kind: tokGe, line: line}, nil
+	}
+
+	if kind, ok := singleTokens[c]; ok {
+		l.pos++
+		return token{kind: kind, line: line}, nil
+	}
+	return token{}, l.errorf("unexpected symbol near '%c'", c)
+}
+
+func (l *lexer) skipSpaceAndComments() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			l.pos++
+		case c == '-' && l.peekByte(1) == '-':
+			l.pos += 2
+			if l.peekByte(0) == '[' {
+				if level, ok := l.longBracketLevel(); ok {
+					if _, err := l.readLongString(level); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case c == '#' && l.pos == 0 && l.peekByte(1) == '!':
+			// riga shebang iniziale, come fa l'interprete standalone
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) readNumber() (token, error) {
+	line := l.line
+	start := l.pos
+	if l.src[l.pos] == '0' && (l.peekByte(1) == 'x' || l.peekByte(1) == 'X') {
+		l.pos += 2
+		for l.pos < len(l.src) && isHexDigit(l.src[l.pos]) {
+			l.pos++
+		}
+	} else {
+		for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+			l.pos++
+			if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+				l.pos++
+			}
+			for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+				l.pos++
+			}
+		}
+	}
+	for l.pos < len(l.src) && isAlnum(l.src[l.pos]) {
+		l.pos++
+	}
+
+	text := l.src[start:l.pos]
+	n, ok := parseNumber(text)
+	if !ok {
+		return token{}, l.errorf("malformed number near '%s'", text)
+	}
+	return token{kind: tokNumber, text: text, num: n, line: line}, nil
+}
+
+func (l *lexer) readString(quote byte) (string, error) {
+	l.pos++
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return "", l.errorf("unfinished string")
+		}
+		c := l.src[l.pos]
+		switch c {
+		case quote:
+			l.pos++
+			return b.String(), nil
+		case '\n':
+			return "", l.errorf("unfinished string")
+		case '\\':
+			l.pos++
+			if l.pos >= len(l.src) {
+				return "", l.errorf("unfinished string")
+			}
+			e := l.src[l.pos]
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'a':
+				b.WriteByte('\a')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'v':
+				b.WriteByte('\v')
+			case '\\', '"', '\'':
+				b.WriteByte(e)
+			case '\n':
+				b.WriteByte('\n')
+				l.line++
+			default:
+				if !isDigit(e) {
+					return "", l.errorf("invalid escape sequence '\\%c'", e)
+				}
+				n := 0
+				for i := 0; i < 3 && l.pos < len(l.src) && isDigit(l.src[l.pos]); i++ {
+					n = n*10 + int(l.src[l.pos]-'0')
+					l.pos++
+				}
+				if n > 255 {
+					return "", l.errorf("escape sequence too large")
+				}
+				b.WriteByte(byte(n))
+				continue
+			}
+			l.pos++
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+// longBracketLevel riconosce l'apertura [[ o [==[ e ne restituisce il livello
+func (l *lexer) longBracketLevel() (int, bool) {
+	p := l.pos + 1
+	level := 0
+	for p < len(l.src) && l.src[p] == '=' {
+		level++
+		p++
+	}
+	if p < len(l.src) && l.src[p] == '[' {
+		return level, true
+	}
+	return 0, false
+}
+
+func (l *lexer) readLongString(level int) (string, error) {
+	l.pos += level + 2
+	// il primo a capo subito dopo l'apertura non fa parte della stringa
+	if l.peekByte(0) == '\r' {
+		l.pos++
+	}
+	if l.peekByte(0) == '\n' {
+		l.pos++
+		l.line++
+	}
+
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(l.src[l.pos:], closing)
+	if end < 0 {
+		return "", l.errorf("unfinished long string")
+	}
+	s := l.src[l.pos : l.pos+end]
+	l.line += strings.Count(s, "\n")
+	l.pos += end + len(closing)
+	return s, nil
+}
+
+// parseNumber converte un numero Lua (decimale o esadecimale) ammettendo spazi ai lati
+func parseNumber(text string) (float64, bool) {
+	s := strings.TrimSpace(text)
+	if s == "" {
+		return 0, false
+	}
+	neg := false
+	body := s
+	if body[0] == '-' || body[0] == '+' {
+		neg = body[0] == '-'
+		body = body[1:]
+	}
+	if len(body) > 2 && body[0] == '0' && (body[1] == 'x' || body[1] == 'X') {
+		n, err := strconv.ParseUint(body[2:], 16, 64)
+		if err != nil {
+			return 0, false
+		}
+		if neg {
+			return -float64(n), true
+		}
+		return float64(n), true
+	}
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if !isDigit(c) && c != '.' && c != 'e' && c != 'E' && c != '+' && c != '-' {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+func isAlpha(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlnum(c byte) bool {
+	return isAlpha(c) || isDigit(c)
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
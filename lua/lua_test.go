@@ -0,0 +1,322 @@
+package lua
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, src string) []Value {
+	t.Helper()
+	s := NewState()
+	s.OpenLibs()
+	rets, err := s.DoString(src, "user_script")
+	if err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	return rets
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want Value
+	}{
+		{"return 1 + 2 * 3", 7.0},
+		{"return 2 ^ 3 ^ 2", 512.0},
+		{"return -2 ^ 2", -4.0},
+		{"return 7 % -3", -2.0},
+		{"return '10' + 5", 15.0},
+		{"return 1 .. 2", "12"},
+		{"return 'a' .. 'b' .. 'c'", "abc"},
+		{"return 10 / 4", 2.5},
+		{"return 1e15", 1e15},
+		{"return tostring(1e15)", "1e+15"},
+		{"return tostring(0.1)", "0.1"},
+		{"return nil or false", false},
+		{"return false or 'x'", "x"},
+		{"return 1 and 2", 2.0},
+		{"return not nil", true},
+		{"return 1 < 2 and 'a' < 'b'", true},
+		{"return 1 == '1'", false},
+		{"return #'hello'", 5.0},
+		{"return #{1, 2, 3, nil}", 3.0},
+		{"local t = {10, 20, x = 'y'} return t[2] .. t.x", "20y"},
+		{"local a, b = 1 return b", nil},
+		{"local a, b = (function() return 1, 2 end)() return b", 2.0},
+		{"local a, b = ((function() return 1, 2 end)()) return b", nil},
+		{"local function f(...) return select('#', ...) end return f(1, nil, 3)", 3.0},
+		{"local function f(...) local a, b = ... return b end return f(1, 2)", 2.0},
+		{"local t = {(function() return 1, 2, 3 end)()} return #t", 3.0},
+		{"local s = 0 for i = 10, 1, -2 do s = s + i end return s", 30.0},
+		{"local s = 0 for i = 1, 3 do for j = 1, 3 do if j == 2 then break end s = s + 1 end end return s", 3.0},
+		{"local i = 0 while true do i = i + 1 if i > 4 then break end end return i", 5.0},
+		{"local i = 0 repeat local j = i i = i + 1 until j >= 3 return i", 4.0},
+		{"local x = 1 if x == 0 then return 'a' elseif x == 1 then return 'b' else return 'c' end", "b"},
+		{"local t = {} t.a = {} t.a.b = 5 return t.a.b", 5.0},
+		{"local a, b = 1, 2 a, b = b, a return a - b", 1.0},
+		{"local function fact(n) if n <= 1 then return 1 end return n * fact(n - 1) end return fact(10)", 3628800.0},
+		{"local fs = {} for i = 1, 3 do fs[i] = function() return i end end return fs[1]() + fs[3]()", 4.0},
+		{"local x = 1 local function get() return x end local x = 2 return get()", 1.0},
+		{"local obj = {n = 3} function obj:inc(d) self.n = self.n + d return self.n end return obj:inc(2)", 5.0},
+		{"local s = 0 for k, v in pairs({a = 1, b = 2, 3}) do s = s + v end return s", 6.0},
+		{"local s = '' for i, v in ipairs({'a', 'b', nil, 'c'}) do s = s .. v end return s", "ab"},
+		{"local t = {} t[1.0] = 'x' return t[1]", "x"},
+		{"return [[\nline]] .. [==[]]]==]", "line]]"},
+		{"return '\\65\\t\\n'", "A\t\n"},
+		{"return 0x1F", 31.0},
+		{"-- commento\nreturn --[[ blocco ]] 1", 1.0},
+		{"return type(nil) .. type({}) .. type(print or type)", "niltablefunction"},
+		{"return tonumber('0x10') + tonumber('  7  ') + tonumber('z', 36)", 58.0},
+		{"return tonumber('abc')", nil},
+	}
+
+	for _, tt := range tests {
+		rets := run(t, tt.src)
+		var got Value
+		if len(rets) > 0 {
+			got = rets[0]
+		}
+		if got != tt.want {
+			t.Errorf("%q = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestStringLib(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"return string.sub('hello', 2, -2)", "ell"},
+		{"return ('hello'):sub(-3)", "llo"},
+		{"return ('abc'):upper() .. ('DEF'):lower()", "ABCdef"},
+		{"return string.rep('ab', 3)", "ababab"},
+		{"return string.char(72, 105) .. string.byte('A')", "Hi65"},
+		{"return string.format('%d|%5.2f|%s|%x|%q|%%', 42.9, 3.14159, 'x', 255, 'a\"b')", `42| 3.14|x|ff|"a\"b"|%`},
+		{"return string.format('%-5s|%05d', 'ab', 42)", "ab   |00042"},
+		{"return tostring(string.find('hello world', 'o w'))", "5"},
+		{"return tostring(select(2, string.find('hello world', 'o w')))", "7"},
+		{"return tostring(string.find('a.b', '.', 1, true))", "2"},
+		{"return string.match('key=value', '(%w+)=(%w+)')", "key"},
+		{"return select(2, string.match('key=value', '(%w+)=(%w+)'))", "value"},
+		{"return string.match('  trim  ', '^%s*(.-)%s*$')", "trim"},
+		{"return string.match('[tag]', '%b[]')", "[tag]"},
+		{"return string.match('THE (quick) fox', '%f[%a]%a+', 5)", "quick"},
+		{"return tostring(string.match('abc', '()b()'))", "2"},
+		{"return string.match('aaa', 'a-b') or 'none'", "none"},
+		{"return string.match('x = 10', '^(%a+)%s*=%s*(%d+)$')", "x"},
+		{"return string.gsub('hello world', 'o', '0')", "hell0 w0rld"},
+		{"return string.gsub('hello world', '(%w+)', '<%1>')", "<hello> <world>"},
+		{"return string.gsub('abc', '', '-')", "-a-b-c-"},
+		{"return string.gsub('$name is $age', '%$(%w+)', {name = 'bob', age = 7})", "bob is 7"},
+		{"return string.gsub('a b', '%a', function(c) return c:upper() end)", "A B"},
+		{"return string.gsub('aaa', 'a', 'b', 2)", "bba"},
+		{"local r = {} for k, v in string.gmatch('a=1, b=2', '(%w+)=(%w+)') do r[#r + 1] = k .. v end return table.concat(r, ';')", "a1;b2"},
+		{"return string.match('2024-01-15', '(%d+)-(%d+)-(%d+)')", "2024"},
+		{"return string.match('hello', '[^aeiou]+')", "h"},
+		{"return string.match('a]b', '[]]')", "]"},
+	}
+
+	for _, tt := range tests {
+		rets := run(t, tt.src)
+		if len(rets) == 0 || rets[0] != tt.want {
+			t.Errorf("%q = %v, want %q", tt.src, rets, tt.want)
+		}
+	}
+}
+
+func TestTableAndMathLib(t *testing.T) {
+	tests := []struct {
+		src  string
+		want Value
+	}{
+		{"local t = {3, 1, 2} table.sort(t) return table.concat(t, ',')", "1,2,3"},
+		{"local t = {'b', 'a', 'c'} table.sort(t, function(a, b) return a > b end) return table.concat(t)", "cba"},
+		{"local t = {1, 2} table.insert(t, 3) table.insert(t, 1, 0) return table.concat(t, ',')", "0,1,2,3"},
+		{"local t = {1, 2, 3} local r = table.remove(t, 1) return r .. ':' .. table.concat(t, ',')", "1:2,3"},
+		{"local t = {1, 2, 3} table.remove(t) return #t", 2.0},
+		{"return select(3, unpack({1, 2, 3}))", 3.0},
+		{"return math.floor(3.7) + math.ceil(3.2) + math.abs(-1)", 8.0},
+		{"return math.max(1, 5, 3) - math.min(4, 2, 8)", 3.0},
+		{"return math.huge > 1e308", true},
+		{"return math.fmod(7, 3) + math.sqrt(16)", 5.0},
+		{"local t = {} t[1] = 1 t[2] = 2 t[2] = nil return #t", 1.0},
+		{"local t = {} for i = 1, 100 do t[i] = i end for i = 1, 100 do t[i] = nil end return next(t)", nil},
+		{"local t = {a = 1, b = 2} t.a = nil local k = next(t) return k", "b"},
+	}
+
+	for _, tt := range tests {
+		rets := run(t, tt.src)
+		var got Value
+		if len(rets) > 0 {
+			got = rets[0]
+		}
+		if got != tt.want {
+			t.Errorf("%q = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"return 1 +", "user_script:1: unexpected symbol near '<eof>'"},
+		{"if true then", "user_script:1: 'end' expected near '<eof>'"},
+		{"while true do\n\nx = 1", "user_script:3: 'end' expected (to close 'while' at line 1) near '<eof>'"},
+		{"x = = 1", "user_script:1: unexpected symbol near '='"},
+		{"return 'abc", "user_script:1: unfinished string"},
+		{"local t = nil\nreturn t.x", "user_script:2: attempt to index local 't' (a nil value)"},
+		{"return undefined.x", "user_script:1: attempt to index global 'undefined' (a nil value)"},
+		{"local t = {}\nreturn t.a.b", "user_script:2: attempt to index field 'a' (a nil value)"},
+		{"return nofunc()", "user_script:1: attempt to call global 'nofunc' (a nil value)"},
+		{"return 1 + {}", "user_script:1: attempt to perform arithmetic on a table value"},
+		{"local s\nreturn 'a' .. s", "user_script:2: attempt to concatenate local 's' (a nil value)"},
+		{"return 1 < 'x'", "user_script:1: attempt to compare number with string"},
+		{"return {} < {}", "user_script:1: attempt to compare two table values"},
+		{"error('boom')", "user_script:1: boom"},
+		{"error('boom', 0)", "boom"},
+		{"return math.floor('x')", "user_script:1: bad argument #1 to 'floor' (number expected, got string)"},
+		{"local function f() return f() + 1 end return f()", "stack overflow"},
+		{"local t = {} t[nil] = 1", "user_script:1: table index is nil"},
+	}
+
+	for _, tt := range tests {
+		s := NewState()
+		s.OpenLibs()
+		_, err := s.DoString(tt.src, "user_script")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+// TestSyntaxLevels verifica che l'annidamento oltre maxSyntaxLevels sia un
+// errore di sintassi e non esaurisca lo stack del parser
+func TestSyntaxLevels(t *testing.T) {
+	nested := func(open, inner, close string, n int) string {
+		return strings.Repeat(open, n) + inner + strings.Repeat(close, n)
+	}
+	for _, src := range []string{
+		"return " + nested("(", "1", ")", 100000),
+		"return " + nested("{", "1", "}", 100000),
+		"return " + strings.Repeat("not ", 100000) + "1",
+		"return " + nested("'a' .. ", "'b'", "", 100000),
+		nested("do ", "", " end", 100000),
+		nested("if true then ", "", " end", 100000),
+		"return " + nested("function() return ", "1", " end", 100000),
+	} {
+		s := NewState()
+		s.OpenLibs()
+		_, err := s.DoString(src, "user_script")
+		if err == nil || !strings.Contains(err.Error(), "user_script:1: chunk has too many syntax levels") {
+			t.Errorf("%.30q...: error = %v, want too many syntax levels", src, err)
+		}
+	}
+
+	// le catene di operatori non sono annidate nel parser ma lo sono nella valutazione
+	for _, src := range []string{
+		"return 1" + strings.Repeat(" + 1", 1000000),
+		"local t = {} t.t = t return t" + strings.Repeat(".t", 1000000),
+	} {
+		s := NewState()
+		s.OpenLibs()
+		_, err := s.DoString(src, "user_script")
+		if err == nil || !strings.Contains(err.Error(), "expression too complex") {
+			t.Errorf("%.30q...: error = %v, want expression too complex", src, err)
+		}
+	}
+	if rets := run(t, "return 1"+strings.Repeat(" + 1", 5000)); rets[0] != 5001.0 {
+		t.Errorf("chain of 5000 additions = %v, want 5001", rets[0])
+	}
+
+	// sotto il limite l'annidamento funziona
+	if rets := run(t, "return "+nested("(", "1", ")", 150)); rets[0] != 1.0 {
+		t.Errorf("150 nested parentheses = %v, want 1", rets[0])
+	}
+	if rets := run(t, nested("do ", "x = 2", " end", 150)+" return x"); rets[0] != 2.0 {
+		t.Errorf("150 nested blocks = %v, want 2", rets[0])
+	}
+}
+
+func TestPcall(t *testing.T) {
+	rets := run(t, `
+		local ok, err = pcall(function() error({code = 42}) end)
+		local ok2, err2 = pcall(error, 'plain', 0)
+		local ok3, a, b = pcall(function(x, y) return x + y, x * y end, 3, 4)
+		return ok, err.code, ok2, err2, ok3, a, b`)
+	want := []Value{false, 42.0, false, "plain", true, 7.0, 12.0}
+	for i := range want {
+		if rets[i] != want[i] {
+			t.Fatalf("pcall results = %v, want %v", rets, want)
+		}
+	}
+}
+
+func TestStrictGlobals(t *testing.T) {
+	s := NewState()
+	s.OpenLibs()
+	s.SetGlobal("KEYS", NewTable())
+	s.Strict = true
+
+	if _, err := s.DoString("x = 1", "user_script"); err == nil || !strings.Contains(err.Error(), "Script attempted to create global variable 'x'") {
+		t.Fatalf("global write error = %v", err)
+	}
+	if _, err := s.DoString("return y", "user_script"); err == nil || !strings.Contains(err.Error(), "Script attempted to access nonexistent global variable 'y'") {
+		t.Fatalf("global read error = %v", err)
+	}
+	if _, err := s.DoString("local x = 1 return #KEYS + x", "user_script"); err != nil {
+		t.Fatalf("locals and existing globals: %v", err)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	s := NewState()
+	s.OpenLibs()
+	stop := errors.New("killed")
+	calls := 0
+	s.Interrupt = func() error {
+		calls++
+		if calls == 3 {
+			return stop
+		}
+		return nil
+	}
+
+	// pcall non deve poter intercettare l'interruzione
+	_, err := s.DoString("pcall(function() while true do end end) return 1", "user_script")
+	if !errors.Is(err, stop) {
+		t.Fatalf("error = %v, want interrupt", err)
+	}
+}
+
+func TestGoFunction(t *testing.T) {
+	s := NewState()
+	s.OpenLibs()
+	lib := NewTable()
+	Register(lib, map[string]func(*State, []Value) ([]Value, error){
+		"double": func(_ *State, args []Value) ([]Value, error) {
+			n, err := checkNumber(args, 0, "double")
+			if err != nil {
+				return nil, err
+			}
+			return []Value{n * 2}, nil
+		},
+		"fail": func(_ *State, args []Value) ([]Value, error) {
+			t := NewTable()
+			t.Set("err", "custom")
+			return nil, &Error{Value: t}
+		},
+	})
+	s.SetGlobal("lib", lib)
+
+	rets, err := s.DoString("local ok, e = pcall(lib.fail) return lib.double(21), e.err", "user_script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rets[0] != 42.0 || rets[1] != "custom" {
+		t.Fatalf("results = %v", rets)
+	}
+}
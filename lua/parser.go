@@ -0,0 +1,784 @@
+package lua
+
+// parser a discesa ricorsiva per il sottoinsieme di Lua 5.1 supportato
+
+// maxSyntaxLevels limita l'annidamento di blocchi ed espressioni, come
+// LUAI_MAXCCALLS in Lua: oltre, la ricorsione del parser esaurirebbe lo stack
+const maxSyntaxLevels = 200
+
+type parser struct {
+	lex   *lexer
+	tok   token
+	ahead *token
+	level int
+}
+
+// Parse compila il sorgente nell'albero sintattico del chunk
+func Parse(src, chunk string) (*FunctionExpr, error) {
+	p := &parser{lex: &lexer{src: src, line: 1, chunk: chunk}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("'<eof>' expected near '%s'", p.tokText())
+	}
+	return &FunctionExpr{Name: "main chunk", IsVararg: true, Body: body, Line: 0}, nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	p.lex.line = p.tok.line
+	return p.lex.errorf(format, args...)
+}
+
+func (p *parser) tokText() string {
+	switch p.tok.kind {
+	case tokName, tokNumber:
+		return p.tok.text
+	case tokString:
+		return p.tok.text
+	}
+	return p.tok.kind.String()
+}
+
+// enterLevel conta un livello di annidamento; il caller chiama leaveLevel all'uscita
+func (p *parser) enterLevel() error {
+	p.level++
+	if p.level > maxSyntaxLevels {
+		return p.errorf("chunk has too many syntax levels")
+	}
+	return nil
+}
+
+func (p *parser) leaveLevel() {
+	p.level--
+}
+
+func (p *parser) advance() error {
+	if p.ahead != nil {
+		p.tok = *p.ahead
+		p.ahead = nil
+		return nil
+	}
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek() (token, error) {
+	if p.ahead == nil {
+		tok, err := p.lex.next()
+		if err != nil {
+			return token{}, err
+		}
+		p.ahead = &tok
+	}
+	return *p.ahead, nil
+}
+
+func (p *parser) check(kind tokenKind) error {
+	if p.tok.kind != kind {
+		return p.errorf("'%s' expected near '%s'", kind, p.tokText())
+	}
+	return nil
+}
+
+func (p *parser) expect(kind tokenKind) error {
+	if err := p.check(kind); err != nil {
+		return err
+	}
+	return p.advance()
+}
+
+// expectMatch segnala la riga dell'apertura quando la chiusura manca
+func (p *parser) expectMatch(kind, open tokenKind, line int) error {
+	if p.tok.kind == kind {
+		return p.advance()
+	}
+	if line == p.tok.line {
+		return p.errorf("'%s' expected near '%s'", kind, p.tokText())
+	}
+	return p.errorf("'%s' expected (to close '%s' at line %d) near '%s'", kind, open, line, p.tokText())
+}
+
+func (p *parser) name() (string, error) {
+	if err := p.check(tokName); err != nil {
+		return "", err
+	}
+	name := p.tok.text
+	return name, p.advance()
+}
+
+func blockEnd(kind tokenKind) bool {
+	switch kind {
+	case tokEOF, tokEnd, tokElse, tokElseif, tokUntil:
+		return true
+	}
+	return false
+}
+
+func (p *parser) block() (*Block, error) {
+	if err := p.enterLevel(); err != nil {
+		return nil, err
+	}
+	defer p.leaveLevel()
+
+	block := &Block{}
+	for !blockEnd(p.tok.kind) {
+		if p.tok.kind == tokReturn {
+			stmt, err := p.returnStmt()
+			if err != nil {
+				return nil, err
+			}
+			block.Stmts = append(block.Stmts, stmt)
+			break
+		}
+		if p.tok.kind == tokBreak {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if p.tok.kind == tokSemicolon {
+				if err := p.advance(); err != nil {
+					return nil, err
+				}
+			}
+			block.Stmts = append(block.Stmts, &BreakStmt{})
+			// in Lua 5.1 break deve essere l'ultima istruzione del blocco
+			if !blockEnd(p.tok.kind) {
+				return nil, p.errorf("'end' expected near '%s'", p.tokText())
+			}
+			break
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		if stmt != nil {
+			block.Stmts = append(block.Stmts, stmt)
+		}
+		if p.tok.kind == tokSemicolon {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return block, nil
+}
+
+func (p *parser) returnStmt() (Stmt, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	stmt := &ReturnStmt{}
+	if !blockEnd(p.tok.kind) && p.tok.kind != tokSemicolon {
+		exprs, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Exprs = exprs
+	}
+	if p.tok.kind == tokSemicolon {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if !blockEnd(p.tok.kind) {
+		return nil, p.errorf("'<eof>' expected near '%s'", p.tokText())
+	}
+	return stmt, nil
+}
+
+func (p *parser) statement() (Stmt, error) {
+	line := p.tok.line
+	switch p.tok.kind {
+	case tokSemicolon:
+		return nil, nil
+	case tokIf:
+		return p.ifStmt(line)
+	case tokWhile:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokDo); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &WhileStmt{Cond: cond, Body: body}, p.expectMatch(tokEnd, tokWhile, line)
+	case tokDo:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &DoStmt{Body: body}, p.expectMatch(tokEnd, tokDo, line)
+	case tokFor:
+		return p.forStmt(line)
+	case tokRepeat:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectMatch(tokUntil, tokRepeat, line); err != nil {
+			return nil, err
+		}
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return &RepeatStmt{Body: body, Cond: cond}, nil
+	case tokFunction:
+		return p.functionStmt(line)
+	case tokLocal:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokFunction {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			fn, err := p.funcBody(name, false, line)
+			if err != nil {
+				return nil, err
+			}
+			return &LocalFunctionStmt{Name: name, Func: fn}, nil
+		}
+		return p.localStmt()
+	}
+	return p.exprStmt(line)
+}
+
+func (p *parser) ifStmt(line int) (Stmt, error) {
+	stmt := &IfStmt{}
+	for {
+		// consuma "if" o "elseif"
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokThen); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Conds = append(stmt.Conds, cond)
+		stmt.Blocks = append(stmt.Blocks, body)
+		if p.tok.kind != tokElseif {
+			break
+		}
+	}
+	if p.tok.kind == tokElse {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Else = body
+	}
+	return stmt, p.expectMatch(tokEnd, tokIf, line)
+}
+
+func (p *parser) forStmt(line int) (Stmt, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	first, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokAssign {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		stmt := &NumericForStmt{Var: first, Line: line}
+		if stmt.Start, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokComma); err != nil {
+			return nil, err
+		}
+		if stmt.Limit, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokComma {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if stmt.Step, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect(tokDo); err != nil {
+			return nil, err
+		}
+		if stmt.Body, err = p.block(); err != nil {
+			return nil, err
+		}
+		return stmt, p.expectMatch(tokEnd, tokFor, line)
+	}
+
+	stmt := &GenericForStmt{Names: []string{first}, Line: line}
+	for p.tok.kind == tokComma {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Names = append(stmt.Names, name)
+	}
+	if p.tok.kind != tokIn {
+		return nil, p.errorf("'=' or 'in' expected near '%s'", p.tokText())
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if stmt.Exprs, err = p.exprList(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokDo); err != nil {
+		return nil, err
+	}
+	if stmt.Body, err = p.block(); err != nil {
+		return nil, err
+	}
+	return stmt, p.expectMatch(tokEnd, tokFor, line)
+}
+
+// functionStmt traduce "function a.b:c() end" nell'assegnamento equivalente
+func (p *parser) functionStmt(line int) (Stmt, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	var target Expr = &NameExpr{Name: name, Line: line}
+	fullName := name
+	isMethod := false
+	for p.tok.kind == tokDot || p.tok.kind == tokColon {
+		isMethod = p.tok.kind == tokColon
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		key, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		target = &IndexExpr{Obj: target, Key: &StringExpr{Value: key}, Line: line}
+		fullName += "." + key
+		if isMethod {
+			break
+		}
+	}
+	fn, err := p.funcBody(fullName, isMethod, line)
+	if err != nil {
+		return nil, err
+	}
+	return &AssignStmt{Targets: []Expr{target}, Exprs: []Expr{fn}, Line: line}, nil
+}
+
+func (p *parser) localStmt() (Stmt, error) {
+	stmt := &LocalStmt{}
+	for {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Names = append(stmt.Names, name)
+		if p.tok.kind != tokComma {
+			break
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if p.tok.kind == tokAssign {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		exprs, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Exprs = exprs
+	}
+	return stmt, nil
+}
+
+func (p *parser) exprStmt(line int) (Stmt, error) {
+	expr, err := p.suffixedExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokAssign && p.tok.kind != tokComma {
+		switch expr.(type) {
+		case *CallExpr, *MethodCallExpr:
+			return &CallStmt{Call: expr}, nil
+		}
+		return nil, p.errorf("syntax error near '%s'", p.tokText())
+	}
+
+	targets := []Expr{expr}
+	for p.tok.kind == tokComma {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		target, err := p.suffixedExpr()
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	for _, target := range targets {
+		switch target.(type) {
+		case *NameExpr, *IndexExpr:
+		default:
+			return nil, p.errorf("syntax error near '%s'", p.tokText())
+		}
+	}
+	if err := p.expect(tokAssign); err != nil {
+		return nil, err
+	}
+	exprs, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return &AssignStmt{Targets: targets, Exprs: exprs, Line: line}, nil
+}
+
+func (p *parser) funcBody(name string, isMethod bool, line int) (*FunctionExpr, error) {
+	fn := &FunctionExpr{Name: name, Line: line}
+	if isMethod {
+		fn.Params = append(fn.Params, "self")
+	}
+	if err := p.expect(tokLParen); err != nil {
+		return nil, err
+	}
+	for p.tok.kind != tokRParen {
+		if p.tok.kind == tokDots {
+			fn.IsVararg = true
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			break
+		}
+		param, err := p.name()
+		if err != nil {
+			return nil, p.errorf("<name> expected near '%s'", p.tokText())
+		}
+		fn.Params = append(fn.Params, param)
+		if p.tok.kind != tokComma {
+			break
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(tokRParen); err != nil {
+		return nil, err
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	fn.Body = body
+	return fn, p.expectMatch(tokEnd, tokFunction, line)
+}
+
+func (p *parser) exprList() ([]Expr, error) {
+	var exprs []Expr
+	for {
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if p.tok.kind != tokComma {
+			return exprs, nil
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// priorità sinistra e destra degli operatori binari, come in lparser.c
+var binaryPriority = map[tokenKind][2]int{
+	tokOr: {1, 1}, tokAnd: {2, 2},
+	tokLt: {3, 3}, tokGt: {3, 3}, tokLe: {3, 3}, tokGe: {3, 3}, tokNe: {3, 3}, tokEq: {3, 3},
+	tokConcat: {5, 4},
+	tokPlus:   {6, 6}, tokMinus: {6, 6},
+	tokStar: {7, 7}, tokSlash: {7, 7}, tokPercent: {7, 7},
+	tokCaret: {10, 9},
+}
+
+const unaryPriority = 8
+
+func (p *parser) expr() (Expr, error) {
+	return p.subExpr(0)
+}
+
+func (p *parser) subExpr(limit int) (Expr, error) {
+	if err := p.enterLevel(); err != nil {
+		return nil, err
+	}
+	defer p.leaveLevel()
+
+	var left Expr
+	switch p.tok.kind {
+	case tokNot, tokMinus, tokHash:
+		op, line := p.tok.kind, p.tok.line
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := p.subExpr(unaryPriority)
+		if err != nil {
+			return nil, err
+		}
+		// piega le costanti negative, frequenti negli script
+		if num, ok := operand.(*NumberExpr); ok && op == tokMinus {
+			left = &NumberExpr{Value: -num.Value}
+		} else {
+			left = &UnOpExpr{Op: op, Expr: operand, Line: line}
+		}
+	default:
+		var err error
+		if left, err = p.simpleExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		prio, ok := binaryPriority[p.tok.kind]
+		if !ok || prio[0] <= limit {
+			return left, nil
+		}
+		op, line := p.tok.kind, p.tok.line
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.subExpr(prio[1])
+		if err != nil {
+			return nil, err
+		}
+		left = &BinOpExpr{Op: op, Left: left, Right: right, Line: line}
+	}
+}
+
+func (p *parser) simpleExpr() (Expr, error) {
+	var expr Expr
+	switch p.tok.kind {
+	case tokNumber:
+		expr = &NumberExpr{Value: p.tok.num}
+	case tokString:
+		expr = &StringExpr{Value: p.tok.text}
+	case tokNil:
+		expr = &NilExpr{}
+	case tokTrue:
+		expr = &TrueExpr{}
+	case tokFalse:
+		expr = &FalseExpr{}
+	case tokDots:
+		expr = &VarargExpr{}
+	case tokLBrace:
+		return p.tableConstructor()
+	case tokFunction:
+		line := p.tok.line
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return p.funcBody("anonymous", false, line)
+	default:
+		return p.suffixedExpr()
+	}
+	return expr, p.advance()
+}
+
+func (p *parser) primaryExpr() (Expr, error) {
+	switch p.tok.kind {
+	case tokName:
+		expr := &NameExpr{Name: p.tok.text, Line: p.tok.line}
+		return expr, p.advance()
+	case tokLParen:
+		line := p.tok.line
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		inner, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return &ParenExpr{Expr: inner}, p.expectMatch(tokRParen, tokLParen, line)
+	}
+	return nil, p.errorf("unexpected symbol near '%s'", p.tokText())
+}
+
+func (p *parser) suffixedExpr() (Expr, error) {
+	expr, err := p.primaryExpr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		line := p.tok.line
+		switch p.tok.kind {
+		case tokDot:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			key, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			expr = &IndexExpr{Obj: expr, Key: &StringExpr{Value: key}, Line: line}
+		case tokLBracket:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			key, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokRBracket); err != nil {
+				return nil, err
+			}
+			expr = &IndexExpr{Obj: expr, Key: key, Line: line}
+		case tokColon:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			method, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			expr = &MethodCallExpr{Obj: expr, Method: method, Args: args, Line: line}
+		case tokLParen, tokString, tokLBrace:
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			expr = &CallExpr{Fn: expr, Args: args, Line: line}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+func (p *parser) callArgs() ([]Expr, error) {
+	switch p.tok.kind {
+	case tokString:
+		arg := &StringExpr{Value: p.tok.text}
+		return []Expr{arg}, p.advance()
+	case tokLBrace:
+		table, err := p.tableConstructor()
+		if err != nil {
+			return nil, err
+		}
+		return []Expr{table}, nil
+	case tokLParen:
+		line := p.tok.line
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		var args []Expr
+		if p.tok.kind != tokRParen {
+			var err error
+			if args, err = p.exprList(); err != nil {
+				return nil, err
+			}
+		}
+		return args, p.expectMatch(tokRParen, tokLParen, line)
+	}
+	return nil, p.errorf("function arguments expected near '%s'", p.tokText())
+}
+
+func (p *parser) tableConstructor() (Expr, error) {
+	line := p.tok.line
+	if err := p.expect(tokLBrace); err != nil {
+		return nil, err
+	}
+	table := &TableExpr{}
+	for p.tok.kind != tokRBrace {
+		var field TableField
+		switch p.tok.kind {
+		case tokLBracket:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			key, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokRBracket); err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokAssign); err != nil {
+				return nil, err
+			}
+			field.Key = key
+		case tokName:
+			next, err := p.peek()
+			if err != nil {
+				return nil, err
+			}
+			if next.kind == tokAssign {
+				field.Key = &StringExpr{Value: p.tok.text}
+				if err := p.advance(); err != nil {
+					return nil, err
+				}
+				if err := p.advance(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		value, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		field.Value = value
+		table.Fields = append(table.Fields, field)
+
+		if p.tok.kind != tokComma && p.tok.kind != tokSemicolon {
+			break
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	return table, p.expectMatch(tokRBrace, tokLBrace, line)
+}
@@ -0,0 +1,392 @@
+package lua
+
+import (
+	"fmt"
+)
+
+// Pattern matching di Lua, tradotto da lstrlib.c di Lua 5.1
+
+const (
+	patternEscape   = '%'
+	maxCaptures     = 32
+	capUnfinished   = -1
+	capPosition     = -2
+	maxMatchCalls   = 200000
+	patternSpecials = "^$*+?.([%-"
+)
+
+type capture struct {
+	start int
+	len   int
+}
+
+type matchState struct {
+	src      string
+	pattern  string
+	level    int
+	captures [maxCaptures]capture
+	calls    int
+}
+
+func (ms *matchState) classEnd(p int) (int, error) {
+	if p >= len(ms.pattern) {
+		return 0, fmt.Errorf("malformed pattern (ends with '%%')")
+	}
+	c := ms.pattern[p]
+	p++
+	if c == patternEscape {
+		if p >= len(ms.pattern) {
+			return 0, fmt.Errorf("malformed pattern (ends with '%%')")
+		}
+		return p + 1, nil
+	}
+	if c == '[' {
+		if p < len(ms.pattern) && ms.pattern[p] == '^' {
+			p++
+		}
+		// il primo ']' dopo '[' o '[^' fa parte dell'insieme
+		for {
+			if p >= len(ms.pattern) {
+				return 0, fmt.Errorf("malformed pattern (missing ']')")
+			}
+			c := ms.pattern[p]
+			p++
+			if c == patternEscape && p < len(ms.pattern) {
+				p++
+			}
+			if p >= len(ms.pattern) {
+				return 0, fmt.Errorf("malformed pattern (missing ']')")
+			}
+			if ms.pattern[p] == ']' {
+				return p + 1, nil
+			}
+		}
+	}
+	return p, nil
+}
+
+func matchClass(c byte, class byte) bool {
+	var res bool
+	lower := class | 0x20
+	switch lower {
+	case 'a':
+		res = isAlpha(c) && c != '_'
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = isDigit(c)
+	case 'l':
+		res = c >= 'a' && c <= 'z'
+	case 'p':
+		res = (c >= 33 && c <= 47) || (c >= 58 && c <= 64) || (c >= 91 && c <= 96) || (c >= 123 && c <= 126)
+	case 's':
+		res = c == ' ' || (c >= '\t' && c <= '\r')
+	case 'u':
+		res = c >= 'A' && c <= 'Z'
+	case 'w':
+		res = isDigit(c) || (isAlpha(c) && c != '_')
+	case 'x':
+		res = isHexDigit(c)
+	case 'z':
+		res = c == 0
+	default:
+		return class == c
+	}
+	if class >= 'A' && class <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+// matchBracketClass verifica c contro l'insieme [..] tra p (sulla '[') ed ec (sulla ']')
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	p++
+	if ms.pattern[p] == '^' {
+		sig = false
+		p++
+	}
+	for ; p < ec; p++ {
+		switch {
+		case ms.pattern[p] == patternEscape:
+			p++
+			if matchClass(c, ms.pattern[p]) {
+				return sig
+			}
+		case p+2 < ec && ms.pattern[p+1] == '-':
+			if ms.pattern[p] <= c && c <= ms.pattern[p+2] {
+				return sig
+			}
+			p += 2
+		case ms.pattern[p] == c:
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pattern[p] {
+	case '.':
+		return true
+	case patternEscape:
+		return matchClass(c, ms.pattern[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	}
+	return ms.pattern[p] == c
+}
+
+// match restituisce la fine della corrispondenza o -1
+func (ms *matchState) match(s, p int) (int, error) {
+	ms.calls++
+	if ms.calls > maxMatchCalls {
+		return -1, fmt.Errorf("pattern too complex")
+	}
+	for {
+		if p >= len(ms.pattern) {
+			return s, nil
+		}
+		switch ms.pattern[p] {
+		case '(':
+			if p+1 < len(ms.pattern) && ms.pattern[p+1] == ')' {
+				return ms.startCapture(s, p+2, capPosition)
+			}
+			return ms.startCapture(s, p+1, capUnfinished)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case patternEscape:
+			if p+1 < len(ms.pattern) {
+				switch next := ms.pattern[p+1]; {
+				case next == 'b':
+					var err error
+					if s, err = ms.matchBalance(s, p+2); err != nil {
+						return -1, err
+					}
+					if s == -1 {
+						return -1, nil
+					}
+					p += 4
+					continue
+				case next == 'f':
+					p += 2
+					if p >= len(ms.pattern) || ms.pattern[p] != '[' {
+						return -1, fmt.Errorf("missing '[' after '%%f' in pattern")
+					}
+					ep, err := ms.classEnd(p)
+					if err != nil {
+						return -1, err
+					}
+					var prev, cur byte
+					if s > 0 {
+						prev = ms.src[s-1]
+					}
+					if s < len(ms.src) {
+						cur = ms.src[s]
+					}
+					if ms.matchBracketClass(prev, p, ep-1) || !ms.matchBracketClass(cur, p, ep-1) {
+						return -1, nil
+					}
+					p = ep
+					continue
+				case isDigit(next):
+					var err error
+					if s, err = ms.matchCapture(s, next); err != nil {
+						return -1, err
+					}
+					if s == -1 {
+						return -1, nil
+					}
+					p += 2
+					continue
+				}
+			}
+		case '$':
+			if p+1 == len(ms.pattern) {
+				if s == len(ms.src) {
+					return s, nil
+				}
+				return -1, nil
+			}
+		}
+
+		ep, err := ms.classEnd(p)
+		if err != nil {
+			return -1, err
+		}
+		m := ms.singleMatch(s, p, ep)
+		var next byte
+		if ep < len(ms.pattern) {
+			next = ms.pattern[ep]
+		}
+		switch next {
+		case '?':
+			if m {
+				res, err := ms.match(s+1, ep+1)
+				if err != nil || res != -1 {
+					return res, err
+				}
+			}
+			p = ep + 1
+			continue
+		case '*':
+			return ms.maxExpand(s, p, ep)
+		case '+':
+			if !m {
+				return -1, nil
+			}
+			return ms.maxExpand(s+1, p, ep)
+		case '-':
+			return ms.minExpand(s, p, ep)
+		}
+		if !m {
+			return -1, nil
+		}
+		s++
+		p = ep
+	}
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) (int, error) {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for i >= 0 {
+		res, err := ms.match(s+i, ep+1)
+		if err != nil || res != -1 {
+			return res, err
+		}
+		i--
+	}
+	return -1, nil
+}
+
+func (ms *matchState) minExpand(s, p, ep int) (int, error) {
+	for {
+		res, err := ms.match(s, ep+1)
+		if err != nil || res != -1 {
+			return res, err
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1, nil
+		}
+		s++
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) (int, error) {
+	if ms.level >= maxCaptures {
+		return -1, fmt.Errorf("too many captures")
+	}
+	ms.captures[ms.level] = capture{start: s, len: what}
+	ms.level++
+	res, err := ms.match(s, p)
+	if res == -1 {
+		ms.level--
+	}
+	return res, err
+}
+
+func (ms *matchState) endCapture(s, p int) (int, error) {
+	l := -1
+	for i := ms.level - 1; i >= 0; i-- {
+		if ms.captures[i].len == capUnfinished {
+			l = i
+			break
+		}
+	}
+	if l < 0 {
+		return -1, fmt.Errorf("invalid pattern capture")
+	}
+	ms.captures[l].len = s - ms.captures[l].start
+	res, err := ms.match(s, p)
+	if res == -1 {
+		ms.captures[l].len = capUnfinished
+	}
+	return res, err
+}
+
+func (ms *matchState) matchBalance(s, p int) (int, error) {
+	if p+1 >= len(ms.pattern) {
+		return -1, fmt.Errorf("unbalanced pattern")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pattern[p] {
+		return -1, nil
+	}
+	open, close := ms.pattern[p], ms.pattern[p+1]
+	depth := 1
+	for i := s + 1; i < len(ms.src); i++ {
+		switch ms.src[i] {
+		case close:
+			depth--
+			if depth == 0 {
+				return i + 1, nil
+			}
+		case open:
+			depth++
+		}
+	}
+	return -1, nil
+}
+
+func (ms *matchState) matchCapture(s int, digit byte) (int, error) {
+	l := int(digit - '1')
+	if l < 0 || l >= ms.level || ms.captures[l].len == capUnfinished {
+		return -1, fmt.Errorf("invalid capture index")
+	}
+	c := ms.captures[l]
+	if len(ms.src)-s >= c.len && ms.src[c.start:c.start+c.len] == ms.src[s:s+c.len] {
+		return s + c.len, nil
+	}
+	return -1, nil
+}
+
+// captureValue restituisce la cattura i; senza catture esplicite l'intera corrispondenza
+func (ms *matchState) captureValue(i, s, e int) (Value, error) {
+	if i >= ms.level {
+		if i == 0 {
+			return ms.src[s:e], nil
+		}
+		return nil, fmt.Errorf("invalid capture index")
+	}
+	c := ms.captures[i]
+	switch c.len {
+	case capUnfinished:
+		return nil, fmt.Errorf("unfinished capture")
+	case capPosition:
+		return float64(c.start + 1), nil
+	}
+	return ms.src[c.start : c.start+c.len], nil
+}
+
+func (ms *matchState) captureValues(s, e int, wholeIfNone bool) ([]Value, error) {
+	n := ms.level
+	if n == 0 && wholeIfNone {
+		n = 1
+	}
+	values := make([]Value, n)
+	for i := 0; i < n; i++ {
+		v, err := ms.captureValue(i, s, e)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func hasSpecials(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		for j := 0; j < len(patternSpecials); j++ {
+			if pattern[i] == patternSpecials[j] {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,312 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Value è un valore Lua: nil, bool, float64, string, *Table, *Closure o *GoFunction
+type Value = any
+
+// Error è un errore Lua: trasporta il valore passato a error() o il messaggio
+// di un errore di esecuzione
+type Error struct {
+	Value Value
+}
+
+func (e *Error) Error() string {
+	if s, ok := e.Value.(string); ok {
+		return s
+	}
+	if n, ok := e.Value.(float64); ok {
+		return formatNumber(n)
+	}
+	return fmt.Sprintf("(error object is a %s value)", TypeName(e.Value))
+}
+
+// GoFunction è una funzione di libreria implementata in Go
+type GoFunction struct {
+	Name string
+	Fn   func(state *State, args []Value) ([]Value, error)
+}
+
+// Closure è una funzione Lua con il suo ambiente di definizione
+type Closure struct {
+	proto *FunctionExpr
+	env   *scope
+	chunk string
+}
+
+// Table ha una parte array per le chiavi intere 1..n e una parte hash che
+// conserva l'ordine di inserimento, così next() è deterministico
+type Table struct {
+	array   []Value
+	keys    []Value
+	values  []Value
+	index   map[Value]int
+	removed int
+}
+
+func NewTable() *Table {
+	return &Table{}
+}
+
+// normalizeKey fa coincidere 1 e 1.0 e rifiuta le chiavi non valide
+func normalizeKey(key Value) (Value, error) {
+	switch k := key.(type) {
+	case nil:
+		return nil, fmt.Errorf("table index is nil")
+	case float64:
+		if math.IsNaN(k) {
+			return nil, fmt.Errorf("table index is NaN")
+		}
+	}
+	return key, nil
+}
+
+func arrayIndex(key Value) (int, bool) {
+	f, ok := key.(float64)
+	if !ok || f < 1 || f != math.Trunc(f) || f > math.MaxInt32 {
+		return 0, false
+	}
+	return int(f), true
+}
+
+func (t *Table) Get(key Value) Value {
+	if i, ok := arrayIndex(key); ok && i <= len(t.array) {
+		return t.array[i-1]
+	}
+	if t.index == nil || key == nil {
+		return nil
+	}
+	if pos, ok := t.index[key]; ok {
+		return t.values[pos]
+	}
+	return nil
+}
+
+func (t *Table) Set(key, value Value) error {
+	key, err := normalizeKey(key)
+	if err != nil {
+		return err
+	}
+	if i, ok := arrayIndex(key); ok {
+		if i <= len(t.array) {
+			t.array[i-1] = value
+			if value == nil && i == len(t.array) {
+				t.shrinkArray()
+			}
+			return nil
+		}
+		if i == len(t.array)+1 && value != nil {
+			t.array = append(t.array, value)
+			t.deleteHash(key)
+			t.migrateFromHash()
+			return nil
+		}
+	}
+	if value == nil {
+		t.deleteHash(key)
+		return nil
+	}
+	if t.index == nil {
+		t.index = make(map[Value]int)
+	}
+	if pos, ok := t.index[key]; ok {
+		t.values[pos] = value
+		return nil
+	}
+	t.index[key] = len(t.keys)
+	t.keys = append(t.keys, key)
+	t.values = append(t.values, value)
+	return nil
+}
+
+// Append aggiunge un valore in coda alla parte array
+func (t *Table) Append(value Value) {
+	t.Set(float64(len(t.array)+1), value)
+}
+
+func (t *Table) shrinkArray() {
+	for len(t.array) > 0 && t.array[len(t.array)-1] == nil {
+		t.array = t.array[:len(t.array)-1]
+	}
+}
+
+// migrateFromHash sposta nella parte array le chiavi intere diventate contigue
+func (t *Table) migrateFromHash() {
+	for t.index != nil {
+		key := float64(len(t.array) + 1)
+		pos, ok := t.index[key]
+		if !ok {
+			return
+		}
+		t.array = append(t.array, t.values[pos])
+		t.deleteHash(key)
+	}
+}
+
+func (t *Table) deleteHash(key Value) {
+	if t.index == nil {
+		return
+	}
+	pos, ok := t.index[key]
+	if !ok {
+		return
+	}
+	// lascia un buco per non invalidare un'iterazione next() in corso
+	delete(t.index, key)
+	t.values[pos] = nil
+	t.removed++
+	if t.removed > 32 && t.removed > len(t.keys)/2 {
+		t.compact()
+	}
+}
+
+func (t *Table) compact() {
+	keys := t.keys[:0:0]
+	values := t.values[:0:0]
+	for i, key := range t.keys {
+		if pos, ok := t.index[key]; ok && pos == i {
+			t.index[key] = len(keys)
+			keys = append(keys, key)
+			values = append(values, t.values[i])
+		}
+	}
+	t.keys, t.values, t.removed = keys, values, 0
+}
+
+// Len restituisce il bordo della tabella come l'operatore #
+func (t *Table) Len() int {
+	n := len(t.array)
+	for n > 0 && t.array[n-1] == nil {
+		n--
+	}
+	return n
+}
+
+// Next restituisce la coppia successiva a key; la fine è segnalata da una chiave nil
+func (t *Table) Next(key Value) (Value, Value, error) {
+	start := 0
+	if key != nil {
+		if i, ok := arrayIndex(key); ok && i <= len(t.array) {
+			start = i
+		} else {
+			pos, ok := -1, false
+			if t.index != nil {
+				pos, ok = t.index[key]
+			}
+			if !ok {
+				return nil, nil, fmt.Errorf("invalid key to 'next'")
+			}
+			return t.nextHash(pos + 1)
+		}
+	}
+	for i := start; i < len(t.array); i++ {
+		if t.array[i] != nil {
+			return float64(i + 1), t.array[i], nil
+		}
+	}
+	return t.nextHash(0)
+}
+
+func (t *Table) nextHash(from int) (Value, Value, error) {
+	for i := from; i < len(t.keys); i++ {
+		if t.values[i] != nil {
+			return t.keys[i], t.values[i], nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// TypeName restituisce il nome del tipo come la funzione type()
+func TypeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Closure, *GoFunction:
+		return "function"
+	}
+	return "userdata"
+}
+
+// Truthy applica la regola di Lua: solo nil e false sono falsi
+func Truthy(v Value) bool {
+	switch b := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return b
+	}
+	return true
+}
+
+func formatNumber(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatInt(int64(n), 10)
+	}
+	switch {
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
+	case math.IsNaN(n):
+		return "nan"
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// ToString converte numeri e stringhe come fa Lua nelle concatenazioni
+func ToString(v Value) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case float64:
+		return formatNumber(x), true
+	}
+	return "", false
+}
+
+// ToNumber converte numeri e stringhe numeriche come fa Lua nell'aritmetica
+func ToNumber(v Value) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case string:
+		return parseNumber(x)
+	}
+	return 0, false
+}
+
+// tostring è la rappresentazione usata da tostring() e print()
+func tostring(v Value) string {
+	switch x := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		if x {
+			return "true"
+		}
+		return "false"
+	case float64:
+		return formatNumber(x)
+	case string:
+		return x
+	case *Table:
+		return fmt.Sprintf("table: %p", x)
+	case *Closure:
+		return fmt.Sprintf("function: %p", x)
+	case *GoFunction:
+		return fmt.Sprintf("function: builtin: %p", x)
+	}
+	return fmt.Sprint(v)
+}
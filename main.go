@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"mi0772/podcache/cache"
+	"mi0772/podcache/config"
 	"mi0772/podcache/logging"
 	"mi0772/podcache/server"
 	"os"
@@ -29,9 +32,30 @@ var logger logging.Logger
 type CacheConfiguration struct {
 	partition uint8
 	capacity  uint64
+	dir       string
+}
+
+// commandLine holds the options that are not configuration parameters
+type commandLine struct {
+	configFile  string
+	checkConfig bool
+	overrides   config.Overrides
 }
 
 func main() {
+	cfg := newConfiguration()
+	cmd, err := parseCommandLine(cfg, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(2)
+	}
+
+	if cmd.checkConfig {
+		checkConfiguration(cfg, cmd)
+		return
+	}
+
 	// Initialize logger
 	logger = logging.NewDebugLogger()
 
@@ -47,21 +71,25 @@ func main() {
 	setupTickerCacheShrink()
 
 	// Read configuration
-	config, err := readCacheConfiguration()
-	if err != nil {
+	if err := loadConfiguration(cfg, cmd); err != nil {
 		logger.Fatal("Failed to read configuration", "error", err)
 	}
+	setLogLevel(cfg.String("loglevel"))
 
-	displayConfiguration(config)
+	cacheConfig := readCacheConfiguration(cfg)
+	displayConfiguration(cacheConfig)
 
 	// Initialize cache
-	podcache, err = initializeCache(config)
+	podcache, err = initializeCache(cacheConfig)
 	if err != nil {
 		logger.Fatal("Failed to initialize cache", "error", err)
 	}
+	policy, _ := cache.ParseEvictionPolicy(cfg.String("maxmemory-policy"))
+	podcache.SetEvictionPolicy(policy)
+	watchCacheConfiguration(cfg, podcache)
 
 	// Start server
-	if err := startServer(ctx, podcache); err != nil {
+	if err := startServer(ctx, podcache, cfg); err != nil {
 		logger.Fatal("Server failed", "error", err)
 	}
 
@@ -124,52 +152,149 @@ func setupGracefulShutdown(cancel context.CancelFunc) {
 	}()
 }
 
-func readCacheConfiguration() (*CacheConfiguration, error) {
-	config := &CacheConfiguration{}
+// setupReload reloads the configuration file, the ACL file and the TLS
+// certificates on SIGHUP; an invalid reload changes nothing
+func setupReload(srv *server.PodCacheServer) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
 
-	// Read partitions
-	partition, err := readEnvUint8("PODCACHE_PARTITIONS", DefaultPartitions)
-	if err != nil {
-		return nil, fmt.Errorf("invalid PODCACHE_PARTITIONS: %w", err)
-	}
-	config.partition = partition
+	go func() {
+		for range sigChan {
+			logger.Info("Reloading configuration", "signal", "SIGHUP")
+			if err := srv.Reload(); err != nil {
+				logger.Error("Configuration reload failed, keeping the current configuration", "error", err)
+				continue
+			}
+			logger.Info("Configuration reloaded")
+		}
+	}()
+}
 
-	// Read capacity
-	capacityMB, err := readEnvUint64("PODCACHE_CAPACITY_MB", DefaultCapacityMB)
-	if err != nil {
-		return nil, fmt.Errorf("invalid PODCACHE_CAPACITY_MB: %w", err)
+// newConfiguration builds the configuration registry with the cache and server parameters
+func newConfiguration() *config.Registry {
+	cfg := config.New()
+	cfg.Register(
+		config.Param{Name: "partitions", Env: "PODCACHE_PARTITIONS", Type: config.Int, Default: strconv.Itoa(DefaultPartitions), Min: 1, Max: 255, Mutable: true},
+		config.Param{Name: "maxmemory", Env: "PODCACHE_CAPACITY_MB", EnvUnit: "mb", Type: config.Memory, Default: strconv.Itoa(DefaultCapacityMB) + "mb", Min: 1, Mutable: true},
+		config.Param{Name: "maxmemory-policy", Env: "PODCACHE_MAXMEMORY_POLICY", Type: config.Enum, Default: cache.PolicyAllKeysLRU.String(), Values: cache.EvictionPolicyNames(), Mutable: true},
+		config.Param{Name: "loglevel", Env: "PODCACHE_LOGLEVEL", Type: config.Enum, Default: "debug", Values: []string{"debug", "verbose", "notice", "warning"}, Mutable: true},
+	)
+	server.RegisterConfig(cfg)
+	return cfg
+}
+
+// parseCommandLine reads the options and a --<parameter> flag for every
+// configuration parameter; like redis-server, the configuration file can also
+// be given as the only positional argument
+func parseCommandLine(cfg *config.Registry, args []string) (*commandLine, error) {
+	cmd := &commandLine{}
+	fs := flag.NewFlagSet("podcache", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: podcache [options] [/path/to/podcache.conf]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cmd.configFile, "config", "", "path of the configuration file (env PODCACHE_CONFIG_FILE)")
+	fs.BoolVar(&cmd.checkConfig, "check-config", false, "validate the configuration, print the effective values and exit")
+	cfg.BindFlags(fs, &cmd.overrides)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
 	}
-	config.capacity = capacityMB * 1024 * 1024
 
-	return config, nil
+	switch {
+	case fs.NArg() > 1:
+		err := errors.New("only the configuration file can be given as an argument")
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return nil, err
+	case fs.NArg() == 1 && cmd.configFile != "":
+		err := errors.New("the configuration file is given both with --config and as an argument")
+		fmt.Fprintln(fs.Output(), err)
+		return nil, err
+	case fs.NArg() == 1:
+		cmd.configFile = fs.Arg(0)
+	case cmd.configFile == "":
+		cmd.configFile = os.Getenv("PODCACHE_CONFIG_FILE")
+	}
+	return cmd, nil
 }
 
-func readEnvUint8(key string, defaultValue uint8) (uint8, error) {
-	valueStr, exists := os.LookupEnv(key)
-	if !exists {
-		return defaultValue, nil
+// loadConfiguration reads the configuration file, the environment and the
+// command-line flags, in increasing order of precedence, then checks the
+// parameters that depend on each other
+func loadConfiguration(cfg *config.Registry, cmd *commandLine) error {
+	if cmd.configFile != "" {
+		if _, err := os.Stat(cmd.configFile); os.IsNotExist(err) {
+			// CONFIG REWRITE will create it
+			logger.Info("Configuration file not found, it will be created by CONFIG REWRITE", "path", cmd.configFile)
+			cfg.SetFile(cmd.configFile)
+		} else if err := cfg.LoadFile(cmd.configFile); err != nil {
+			return err
+		}
 	}
-
-	value, err := strconv.ParseUint(valueStr, 10, 8)
-	if err != nil {
-		return 0, fmt.Errorf("must be a valid 8-bit unsigned integer: %s", valueStr)
+	if err := cfg.LoadEnv(); err != nil {
+		return err
+	}
+	if err := cfg.Apply(cmd.overrides); err != nil {
+		return err
 	}
+	return server.CheckConfig(cfg)
+}
 
-	return uint8(value), nil
+// checkConfiguration implements --check-config: it prints the effective
+// configuration and the origin of every value, or the first error found
+func checkConfiguration(cfg *config.Registry, cmd *commandLine) {
+	logger = logging.NewNoOpLogger()
+	if err := loadConfiguration(cfg, cmd); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if path := cfg.File(); path != "" {
+		fmt.Printf("# Configuration file: %s\n", path)
+	}
+	if err := cfg.Dump(os.Stdout); err != nil {
+		os.Exit(1)
+	}
 }
 
-func readEnvUint64(key string, defaultValue uint64) (uint64, error) {
-	valueStr, exists := os.LookupEnv(key)
-	if !exists {
-		return defaultValue, nil
+func readCacheConfiguration(cfg *config.Registry) *CacheConfiguration {
+	return &CacheConfiguration{
+		partition: uint8(cfg.Int("partitions")),
+		capacity:  uint64(cfg.Int("maxmemory")),
+		dir:       cfg.String("dir"),
 	}
+}
+
+// watchCacheConfiguration applies CONFIG SET changes to the cache and the logger
+func watchCacheConfiguration(cfg *config.Registry, podcache *cache.PodCache) {
+	cfg.OnChange("maxmemory", func(string) error {
+		podcache.SetCapacity(uint64(cfg.Int("maxmemory")))
+		return nil
+	})
+	cfg.OnChange("partitions", func(string) error {
+		return podcache.SetPartitions(uint8(cfg.Int("partitions")))
+	})
+	cfg.OnChange("maxmemory-policy", func(value string) error {
+		policy, err := cache.ParseEvictionPolicy(value)
+		if err != nil {
+			return err
+		}
+		podcache.SetEvictionPolicy(policy)
+		return nil
+	})
+	cfg.OnChange("loglevel", func(value string) error {
+		return setLogLevel(value)
+	})
+}
 
-	value, err := strconv.ParseUint(valueStr, 10, 64)
+func setLogLevel(name string) error {
+	level, err := logging.ParseLevel(name)
 	if err != nil {
-		return 0, fmt.Errorf("must be a valid positive integer: %s", valueStr)
+		return err
 	}
-
-	return value, nil
+	if setter, ok := logger.(logging.LevelSetter); ok {
+		setter.SetLevel(level)
+	}
+	return nil
 }
 
 func displayConfiguration(config *CacheConfiguration) {
@@ -177,19 +302,22 @@ func displayConfiguration(config *CacheConfiguration) {
 		"partitions", config.partition,
 		"capacity_mb", config.capacity/(1024*1024),
 		"capacity_bytes", config.capacity,
+		"dir", config.dir,
 	)
 }
 
 func initializeCache(config *CacheConfiguration) (*cache.PodCache, error) {
-	cache, err := cache.NewPodCache(config.partition, config.capacity, logger)
+	cache, err := cache.NewPodCache(config.partition, config.capacity, config.dir, logger)
 	if err != nil {
 		return nil, err
 	}
 	return cache, nil
 }
 
-func startServer(ctx context.Context, cache *cache.PodCache) error {
-	server := server.NewPodCacheServer(cache, logger)
+func startServer(ctx context.Context, cache *cache.PodCache, cfg *config.Registry) error {
+	server.Version = AppVersion
+	server := server.NewPodCacheServer(cache, cfg, logger)
+	setupReload(server)
 
 	// Start server in the context (blocking call)
 	if err := server.Start(ctx); err != nil {
@@ -0,0 +1,82 @@
+// Package metrics contiene gli istogrammi di latenza e la scrittura delle
+// metriche nel formato testuale di Prometheus, senza dipendenze esterne.
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets sono i limiti superiori, in secondi, usati per le latenze:
+// da 10µs a 10s, come i bucket di default dei client Prometheus estesi verso
+// il basso perché un comando in RAM dura pochi microsecondi
+var LatencyBuckets = []float64{
+	0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05,
+	0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// Histogram conta le osservazioni per bucket; è sicuro per l'uso concorrente
+// senza lock. Il valore zero non è utilizzabile: va creato con NewHistogram.
+type Histogram struct {
+	bounds []float64
+	counts []atomic.Uint64 // non cumulativi; l'ultimo è +Inf
+	sum    atomic.Uint64   // in nanosecondi
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+// Observe registra una durata
+func (h *Histogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := 0
+	for i < len(h.bounds) && seconds > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(uint64(d.Nanoseconds()))
+}
+
+// Since registra il tempo trascorso da start
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start))
+}
+
+// Reset azzera le osservazioni
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+	h.sum.Store(0)
+}
+
+// Snapshot è una copia dei valori di un Histogram, con i conteggi cumulativi
+// come li espone Prometheus
+type Snapshot struct {
+	Bounds     []float64
+	Cumulative []uint64 // uno per bound, più +Inf
+	Sum        time.Duration
+	Count      uint64
+}
+
+func (h *Histogram) Snapshot() Snapshot {
+	s := Snapshot{
+		Bounds:     h.bounds,
+		Cumulative: make([]uint64, len(h.counts)),
+	}
+	var total uint64
+	for i := range h.counts {
+		total += h.counts[i].Load()
+		s.Cumulative[i] = total
+	}
+	// il conteggio è la somma dei bucket, così resta uguale a +Inf anche con
+	// osservazioni concorrenti
+	s.Count = total
+	s.Sum = time.Duration(h.sum.Load())
+	return s
+}
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencySamples è il numero di campioni conservati per evento, come
+// LATENCY_TS_LEN in Redis
+const latencySamples = 160
+
+// LatencySample è il picco massimo di un evento in un secondo
+type LatencySample struct {
+	Time    time.Time // troncato al secondo
+	Latency time.Duration
+}
+
+// LatencyEvent riassume un evento per LATENCY LATEST
+type LatencyEvent struct {
+	Name   string
+	Latest LatencySample
+	Max    time.Duration
+}
+
+// LatencyMonitor registra i picchi di latenza per evento come il latency
+// monitor di Redis: solo le durate almeno pari alla soglia, un campione al
+// secondo con il massimo del secondo, gli ultimi 160 campioni. Con soglia 0
+// non registra nulla; un monitor nil ignora le chiamate.
+type LatencyMonitor struct {
+	threshold atomic.Int64 // nanosecondi
+
+	mu     sync.Mutex
+	events map[string]*latencySeries
+}
+
+type latencySeries struct {
+	samples []LatencySample // dal più vecchio al più recente
+	max     time.Duration
+}
+
+func NewLatencyMonitor() *LatencyMonitor {
+	return &LatencyMonitor{events: make(map[string]*latencySeries)}
+}
+
+func (m *LatencyMonitor) SetThreshold(threshold time.Duration) {
+	m.threshold.Store(int64(threshold))
+}
+
+func (m *LatencyMonitor) Threshold() time.Duration {
+	return time.Duration(m.threshold.Load())
+}
+
+// Add registra latency per event se supera la soglia
+func (m *LatencyMonitor) Add(event string, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	threshold := m.threshold.Load()
+	if threshold == 0 || int64(latency) < threshold {
+		return
+	}
+	now := time.Now().Truncate(time.Second)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.events[event]
+	if !ok {
+		series = &latencySeries{}
+		m.events[event] = series
+	}
+	series.max = max(series.max, latency)
+	if n := len(series.samples); n > 0 && series.samples[n-1].Time.Equal(now) {
+		series.samples[n-1].Latency = max(series.samples[n-1].Latency, latency)
+		return
+	}
+	if len(series.samples) == latencySamples {
+		series.samples = append(series.samples[:0], series.samples[1:]...)
+	}
+	series.samples = append(series.samples, LatencySample{Time: now, Latency: latency})
+}
+
+// Since registra il tempo trascorso da start
+func (m *LatencyMonitor) Since(event string, start time.Time) {
+	if m != nil {
+		m.Add(event, time.Since(start))
+	}
+}
+
+// Latest restituisce l'ultimo campione e il massimo di ogni evento, in ordine di nome
+func (m *LatencyMonitor) Latest() []LatencyEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := make([]LatencyEvent, 0, len(m.events))
+	for name, series := range m.events {
+		events = append(events, LatencyEvent{Name: name, Latest: series.samples[len(series.samples)-1], Max: series.max})
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Name < events[j].Name })
+	return events
+}
+
+// History restituisce i campioni di un evento dal più vecchio
+func (m *LatencyMonitor) History(event string) []LatencySample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if series, ok := m.events[event]; ok {
+		return append([]LatencySample(nil), series.samples...)
+	}
+	return nil
+}
+
+// Reset cancella gli eventi indicati, o tutti senza argomenti, e restituisce
+// quanti ne ha cancellati
+func (m *LatencyMonitor) Reset(events ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(events) == 0 {
+		n := len(m.events)
+		m.events = make(map[string]*latencySeries)
+		return n
+	}
+	n := 0
+	for _, event := range events {
+		if _, ok := m.events[event]; ok {
+			delete(m.events, event)
+			n++
+		}
+	}
+	return n
+}
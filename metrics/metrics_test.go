@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{0.001, 0.01})
+	h.Observe(500 * time.Microsecond)
+	h.Observe(time.Millisecond) // i limiti sono inclusivi
+	h.Observe(5 * time.Millisecond)
+	h.Observe(time.Second)
+
+	s := h.Snapshot()
+	if want := []uint64{2, 3, 4}; len(s.Cumulative) != 3 || s.Cumulative[0] != want[0] || s.Cumulative[1] != want[1] || s.Cumulative[2] != want[2] {
+		t.Fatalf("Cumulative = %v, want %v", s.Cumulative, want)
+	}
+	if s.Count != 4 || s.Sum != time.Second+6500*time.Microsecond {
+		t.Fatalf("Count = %d, Sum = %v", s.Count, s.Sum)
+	}
+
+	h.Reset()
+	if s := h.Snapshot(); s.Count != 0 || s.Sum != 0 {
+		t.Fatalf("after Reset Count = %d, Sum = %v", s.Count, s.Sum)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var b strings.Builder
+	w := NewWriter(&b)
+	w.Family("podcache_calls_total", "counter", "Calls\nper command")
+	w.Sample("podcache_calls_total", 3, Label{"cmd", `a"b\c`})
+	w.Family("podcache_latency_seconds", "histogram", "Latency")
+	h := NewHistogram([]float64{0.5})
+	h.Observe(250 * time.Millisecond)
+	w.Histogram("podcache_latency_seconds", h, Label{"cmd", "get"})
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		`# HELP podcache_calls_total Calls\nper command`,
+		`# TYPE podcache_calls_total counter`,
+		`podcache_calls_total{cmd="a\"b\\c"} 3`,
+		`# HELP podcache_latency_seconds Latency`,
+		`# TYPE podcache_latency_seconds histogram`,
+		`podcache_latency_seconds_bucket{cmd="get",le="0.5"} 1`,
+		`podcache_latency_seconds_bucket{cmd="get",le="+Inf"} 1`,
+		`podcache_latency_seconds_sum{cmd="get"} 0.25`,
+		`podcache_latency_seconds_count{cmd="get"} 1`,
+	}, "\n") + "\n"
+	if b.String() != want {
+		t.Fatalf("output:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestLatencyMonitor(t *testing.T) {
+	m := NewLatencyMonitor()
+	m.Add("command", time.Second)
+	if len(m.Latest()) != 0 {
+		t.Fatal("the monitor recorded an event with threshold 0")
+	}
+
+	m.SetThreshold(10 * time.Millisecond)
+	m.Add("command", 5*time.Millisecond)
+	m.Add("command", 20*time.Millisecond)
+	m.Add("command", 15*time.Millisecond) // stesso secondo: resta il massimo
+	m.Add("shrink", 30*time.Millisecond)
+	latest := m.Latest()
+	if len(latest) != 2 || latest[0].Name != "command" || latest[1].Name != "shrink" {
+		t.Fatalf("Latest() = %+v", latest)
+	}
+	if history := m.History("command"); len(history) != 1 || history[0].Latency != 20*time.Millisecond || latest[0].Max != 20*time.Millisecond {
+		t.Fatalf("History(command) = %+v, max %v", history, latest[0].Max)
+	}
+
+	// oltre 160 campioni si scartano i più vecchi
+	series := m.events["command"]
+	for i := 0; i < latencySamples+5; i++ {
+		series.samples[len(series.samples)-1].Time = series.samples[len(series.samples)-1].Time.Add(-time.Second)
+		m.Add("command", 11*time.Millisecond)
+	}
+	if n := len(m.History("command")); n != latencySamples {
+		t.Fatalf("History(command) has %d samples, want %d", n, latencySamples)
+	}
+
+	if n := m.Reset("shrink", "missing"); n != 1 || len(m.Latest()) != 1 {
+		t.Fatalf("Reset(shrink, missing) = %d, events left %+v", n, m.Latest())
+	}
+	if n := m.Reset(); n != 1 || len(m.Latest()) != 0 {
+		t.Fatalf("Reset() = %d", n)
+	}
+	var nilMonitor *LatencyMonitor
+	nilMonitor.Add("command", time.Second)
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ContentType è il Content-Type del formato testuale di Prometheus
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Label è una coppia nome/valore di una serie
+type Label struct {
+	Name, Value string
+}
+
+// Writer scrive metriche nel formato testuale di Prometheus. Ogni famiglia va
+// aperta con Family prima dei suoi campioni; gli errori di scrittura sono
+// restituiti da Flush.
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Family scrive le righe HELP e TYPE; kind è counter, gauge o histogram
+func (w *Writer) Family(name, kind, help string) {
+	w.w.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+	w.w.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// Sample scrive un campione della famiglia aperta
+func (w *Writer) Sample(name string, value float64, labels ...Label) {
+	w.w.WriteString(name)
+	w.labels(labels)
+	w.w.WriteByte(' ')
+	w.w.WriteString(formatFloat(value))
+	w.w.WriteByte('\n')
+}
+
+// Histogram scrive i bucket, la somma e il conteggio di un istogramma di
+// latenze, in secondi
+func (w *Writer) Histogram(name string, h *Histogram, labels ...Label) {
+	s := h.Snapshot()
+	bucketLabels := append(append([]Label(nil), labels...), Label{Name: "le"})
+	for i, count := range s.Cumulative {
+		le := "+Inf"
+		if i < len(s.Bounds) {
+			le = formatFloat(s.Bounds[i])
+		}
+		bucketLabels[len(labels)].Value = le
+		w.Sample(name+"_bucket", float64(count), bucketLabels...)
+	}
+	w.Sample(name+"_sum", s.Sum.Seconds(), labels...)
+	w.Sample(name+"_count", float64(s.Count), labels...)
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *Writer) labels(labels []Label) {
+	if len(labels) == 0 {
+		return
+	}
+	w.w.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			w.w.WriteByte(',')
+		}
+		w.w.WriteString(l.Name + `="` + escapeLabel(l.Value) + `"`)
+	}
+	w.w.WriteByte('}')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -54,6 +54,62 @@ func (c *Cache[T]) ItemCount() int {
 	return len(c.buckets)
 }
 
+// Keys restituisce le chiavi presenti senza modificare l'ordine LRU né le statistiche
+func (c *Cache[T]) Keys() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	keys := make([]string, 0, len(c.buckets))
+	for k := range c.buckets {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// KeysLRU restituisce le chiavi dalla meno alla più recentemente usata, senza
+// modificare l'ordine LRU né le statistiche
+func (c *Cache[T]) KeysLRU() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	keys := make([]string, 0, len(c.buckets))
+	for node := c.Tail; node != nil; node = node.Previous {
+		keys = append(keys, node.Key)
+	}
+	return keys
+}
+
+// OldestKeys restituisce al più n chiavi dalla meno recentemente usata, senza
+// modificare l'ordine LRU né le statistiche
+func (c *Cache[T]) OldestKeys(n int) []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	keys := make([]string, 0, min(n, len(c.buckets)))
+	for node := c.Tail; node != nil && len(keys) < n; node = node.Previous {
+		keys = append(keys, node.Key)
+	}
+	return keys
+}
+
+// Peek restituisce il valore senza modificare l'ordine LRU né le statistiche
+func (c *Cache[T]) Peek(key string) (T, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if v, ok := c.buckets[key]; ok {
+		return v.Value, true
+	}
+	var zero T
+	return zero, false
+}
+
+// Oldest restituisce la chiave meno recentemente usata
+func (c *Cache[T]) Oldest() (string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.Tail == nil {
+		return "", false
+	}
+	return c.Tail.Key, true
+}
+
 func (c *Cache[T]) Stats() (hits, misses uint64, hitRatio float64) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
@@ -65,12 +121,29 @@ func (c *Cache[T]) Stats() (hits, misses uint64, hitRatio float64) {
 	return c.Hits, c.Misses, float64(c.Hits) / float64(total)
 }
 
+// ResetStats azzera hits e misses
+func (c *Cache[T]) ResetStats() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Hits = 0
+	c.Misses = 0
+}
+
 func (c *Cache[T]) Capacity() (current, max uint64) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 	return c.CurrentCapacity, c.MaxCapacity
 }
 
+// SetMaxCapacity cambia la capacità massima; gli elementi già presenti restano
+// anche se la superano, e le Put successive falliscono finché non si libera spazio
+// (PodCache li sposta su disco)
+func (c *Cache[T]) SetMaxCapacity(max uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.MaxCapacity = max
+}
+
 func (c *Cache[T]) Shrink() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -97,6 +97,24 @@ func TestEvict(t *testing.T) {
 	}
 }
 
+func TestOldestKeys(t *testing.T) {
+	cache := New[string](1024)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		cache.Put(k, k, 1)
+	}
+	cache.Get("a")
+
+	if got := cache.OldestKeys(3); fmt.Sprint(got) != "[b c d]" {
+		t.Errorf("OldestKeys(3) = %v, want [b c d]", got)
+	}
+	if got := cache.OldestKeys(10); fmt.Sprint(got) != "[b c d a]" {
+		t.Errorf("OldestKeys(10) = %v, want [b c d a]", got)
+	}
+	if got := New[string](1024).OldestKeys(3); len(got) != 0 {
+		t.Errorf("OldestKeys() of an empty cache = %v", got)
+	}
+}
+
 func secureRandomString(length int) (string, error) {
 	bytes := make([]byte, length)
 	if _, err := rand.Read(bytes); err != nil {
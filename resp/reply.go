@@ -0,0 +1,94 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type ReplyKind int
+
+const (
+	ReplyStatus ReplyKind = iota
+	ReplyError
+	ReplyInteger
+	ReplyBulk
+	ReplyArray
+	ReplyNil
+)
+
+// Reply è una risposta RESP decodificata
+type Reply struct {
+	Kind     ReplyKind
+	Str      string // testo di status, errori e bulk string
+	Int      int64
+	Elements []Reply
+}
+
+// ReadReply legge una risposta completa. Le risposte RESP3 vengono ricondotte
+// ai tipi RESP2: le mappe e i set diventano array, i double bulk string.
+func ReadReply(r *bufio.Reader) (Reply, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			return Reply{}, ErrParseIncomplete
+		}
+		return Reply{}, err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if len(line) == 0 {
+		return Reply{}, ErrParseSyntax
+	}
+
+	payload := line[1:]
+	switch line[0] {
+	case '+':
+		return Reply{Kind: ReplyStatus, Str: payload}, nil
+	case '-':
+		return Reply{Kind: ReplyError, Str: payload}, nil
+	case ':':
+		n, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			return Reply{}, fmt.Errorf("invalid integer reply: %w", err)
+		}
+		return Reply{Kind: ReplyInteger, Int: n}, nil
+	case ',':
+		return Reply{Kind: ReplyBulk, Str: payload}, nil
+	case '_':
+		return Reply{Kind: ReplyNil}, nil
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return Reply{}, fmt.Errorf("invalid bulk length: %w", err)
+		}
+		if n < 0 {
+			return Reply{Kind: ReplyNil}, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return Reply{}, ErrParseIncomplete
+		}
+		return Reply{Kind: ReplyBulk, Str: string(buf[:n])}, nil
+	case '*', '%', '~', '>':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return Reply{}, fmt.Errorf("invalid array length: %w", err)
+		}
+		if n < 0 {
+			return Reply{Kind: ReplyNil}, nil
+		}
+		if line[0] == '%' {
+			n *= 2
+		}
+		elements := make([]Reply, n)
+		for i := range elements {
+			if elements[i], err = ReadReply(r); err != nil {
+				return Reply{}, err
+			}
+		}
+		return Reply{Kind: ReplyArray, Elements: elements}, nil
+	}
+	return Reply{}, ErrParseSyntax
+}
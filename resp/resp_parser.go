@@ -23,10 +23,71 @@ const (
 	RESP_PING   RespCommand = "PING"
 	RESP_QUIT   RespCommand = "QUIT"
 	RESP_CLIENT RespCommand = "CLIENT"
+	RESP_AUTH   RespCommand = "AUTH"
+	RESP_ACL    RespCommand = "ACL"
+	RESP_INFO   RespCommand = "INFO"
+	RESP_CONFIG RespCommand = "CONFIG"
 	RESP_UNKNOW RespCommand = "UNKNOW"
 	RESP_INCR   RespCommand = "INCR"
 	RESP_UNLINK RespCommand = "UNLINK"
 	RESP_INCRBY RespCommand = "INCRBY"
+
+	RESP_SELECT   RespCommand = "SELECT"
+	RESP_SWAPDB   RespCommand = "SWAPDB"
+	RESP_FLUSHDB  RespCommand = "FLUSHDB"
+	RESP_FLUSHALL RespCommand = "FLUSHALL"
+	RESP_DBSIZE   RespCommand = "DBSIZE"
+	RESP_MOVE     RespCommand = "MOVE"
+
+	RESP_PFADD   RespCommand = "PFADD"
+	RESP_PFCOUNT RespCommand = "PFCOUNT"
+	RESP_PFMERGE RespCommand = "PFMERGE"
+
+	RESP_XADD       RespCommand = "XADD"
+	RESP_XRANGE     RespCommand = "XRANGE"
+	RESP_XREVRANGE  RespCommand = "XREVRANGE"
+	RESP_XLEN       RespCommand = "XLEN"
+	RESP_XTRIM      RespCommand = "XTRIM"
+	RESP_XREAD      RespCommand = "XREAD"
+	RESP_XGROUP     RespCommand = "XGROUP"
+	RESP_XREADGROUP RespCommand = "XREADGROUP"
+	RESP_XACK       RespCommand = "XACK"
+	RESP_XPENDING   RespCommand = "XPENDING"
+	RESP_XCLAIM     RespCommand = "XCLAIM"
+
+	RESP_GEOADD         RespCommand = "GEOADD"
+	RESP_GEOPOS         RespCommand = "GEOPOS"
+	RESP_GEODIST        RespCommand = "GEODIST"
+	RESP_GEOHASH        RespCommand = "GEOHASH"
+	RESP_GEOSEARCH      RespCommand = "GEOSEARCH"
+	RESP_GEOSEARCHSTORE RespCommand = "GEOSEARCHSTORE"
+	RESP_ZREM           RespCommand = "ZREM"
+	RESP_ZCARD          RespCommand = "ZCARD"
+
+	RESP_MULTI   RespCommand = "MULTI"
+	RESP_EXEC    RespCommand = "EXEC"
+	RESP_DISCARD RespCommand = "DISCARD"
+	RESP_WATCH   RespCommand = "WATCH"
+	RESP_UNWATCH RespCommand = "UNWATCH"
+
+	RESP_SUBSCRIBE    RespCommand = "SUBSCRIBE"
+	RESP_UNSUBSCRIBE  RespCommand = "UNSUBSCRIBE"
+	RESP_PSUBSCRIBE   RespCommand = "PSUBSCRIBE"
+	RESP_PUNSUBSCRIBE RespCommand = "PUNSUBSCRIBE"
+	RESP_PUBLISH      RespCommand = "PUBLISH"
+	RESP_PUBSUB       RespCommand = "PUBSUB"
+	RESP_HELLO        RespCommand = "HELLO"
+
+	RESP_EVAL    RespCommand = "EVAL"
+	RESP_EVALSHA RespCommand = "EVALSHA"
+	RESP_SCRIPT  RespCommand = "SCRIPT"
+
+	RESP_FUNCTION RespCommand = "FUNCTION"
+	RESP_FCALL    RespCommand = "FCALL"
+	RESP_FCALL_RO RespCommand = "FCALL_RO"
+
+	RESP_SLOWLOG RespCommand = "SLOWLOG"
+	RESP_LATENCY RespCommand = "LATENCY"
 )
 
 type RespCommand string
@@ -45,12 +106,116 @@ func convert(r string) RespCommand {
 		return RESP_QUIT
 	case "CLIENT":
 		return RESP_CLIENT
+	case "AUTH":
+		return RESP_AUTH
+	case "ACL":
+		return RESP_ACL
+	case "INFO":
+		return RESP_INFO
+	case "CONFIG":
+		return RESP_CONFIG
 	case "INCR":
 		return RESP_INCR
 	case "UNLINK":
 		return RESP_UNLINK
 	case "INCRBY":
 		return RESP_INCRBY
+	case "SELECT":
+		return RESP_SELECT
+	case "SWAPDB":
+		return RESP_SWAPDB
+	case "FLUSHDB":
+		return RESP_FLUSHDB
+	case "FLUSHALL":
+		return RESP_FLUSHALL
+	case "DBSIZE":
+		return RESP_DBSIZE
+	case "MOVE":
+		return RESP_MOVE
+	case "PFADD":
+		return RESP_PFADD
+	case "PFCOUNT":
+		return RESP_PFCOUNT
+	case "PFMERGE":
+		return RESP_PFMERGE
+	case "XADD":
+		return RESP_XADD
+	case "XRANGE":
+		return RESP_XRANGE
+	case "XREVRANGE":
+		return RESP_XREVRANGE
+	case "XLEN":
+		return RESP_XLEN
+	case "XTRIM":
+		return RESP_XTRIM
+	case "XREAD":
+		return RESP_XREAD
+	case "XGROUP":
+		return RESP_XGROUP
+	case "XREADGROUP":
+		return RESP_XREADGROUP
+	case "XACK":
+		return RESP_XACK
+	case "XPENDING":
+		return RESP_XPENDING
+	case "XCLAIM":
+		return RESP_XCLAIM
+	case "GEOADD":
+		return RESP_GEOADD
+	case "GEOPOS":
+		return RESP_GEOPOS
+	case "GEODIST":
+		return RESP_GEODIST
+	case "GEOHASH":
+		return RESP_GEOHASH
+	case "GEOSEARCH":
+		return RESP_GEOSEARCH
+	case "GEOSEARCHSTORE":
+		return RESP_GEOSEARCHSTORE
+	case "ZREM":
+		return RESP_ZREM
+	case "ZCARD":
+		return RESP_ZCARD
+	case "MULTI":
+		return RESP_MULTI
+	case "EXEC":
+		return RESP_EXEC
+	case "DISCARD":
+		return RESP_DISCARD
+	case "WATCH":
+		return RESP_WATCH
+	case "UNWATCH":
+		return RESP_UNWATCH
+	case "SUBSCRIBE":
+		return RESP_SUBSCRIBE
+	case "UNSUBSCRIBE":
+		return RESP_UNSUBSCRIBE
+	case "PSUBSCRIBE":
+		return RESP_PSUBSCRIBE
+	case "PUNSUBSCRIBE":
+		return RESP_PUNSUBSCRIBE
+	case "PUBLISH":
+		return RESP_PUBLISH
+	case "PUBSUB":
+		return RESP_PUBSUB
+	case "HELLO":
+		return RESP_HELLO
+	case "EVAL":
+		return RESP_EVAL
+	case "EVALSHA":
+		return RESP_EVALSHA
+	case "SCRIPT":
+		return RESP_SCRIPT
+	case "FUNCTION":
+		return RESP_FUNCTION
+	case "FCALL":
+		return RESP_FCALL
+	case "FCALL_RO":
+		return RESP_FCALL_RO
+	case "SLOWLOG":
+		return RESP_SLOWLOG
+	case "LATENCY":
+		return RESP_LATENCY
 	default:
 		return RESP_UNKNOW
 	}
@@ -61,6 +226,12 @@ type Command struct {
 	Arguments []string
 }
 
+// NewCommand costruisce un comando da nome e argomenti, come se fosse stato
+// letto dalla rete (usato ad esempio dagli script)
+func NewCommand(name string, args ...string) *Command {
+	return &Command{Type: convert(strings.ToUpper(name)), Arguments: args}
+}
+
 type CommandBuffer struct {
 	data   []byte
 	pos    int
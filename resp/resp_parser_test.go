@@ -1,8 +1,10 @@
 package resp_test
 
 import (
+	"bufio"
 	"fmt"
 	"mi0772/podcache/resp"
+	"strings"
 	"testing"
 )
 
@@ -96,3 +98,36 @@ func TestCommandUnlink(t *testing.T) {
 	}
 	fmt.Println(c)
 }
+
+func TestReadReply(t *testing.T) {
+	input := "*5\r\n+OK\r\n-ERR boom\r\n:42\r\n$3\r\nfoo\r\n*2\r\n$-1\r\n*-1\r\n"
+	reply, err := resp.ReadReply(bufio.NewReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Kind != resp.ReplyArray || len(reply.Elements) != 5 {
+		t.Fatalf("reply = %+v", reply)
+	}
+	e := reply.Elements
+	if e[0].Kind != resp.ReplyStatus || e[0].Str != "OK" ||
+		e[1].Kind != resp.ReplyError || e[1].Str != "ERR boom" ||
+		e[2].Kind != resp.ReplyInteger || e[2].Int != 42 ||
+		e[3].Kind != resp.ReplyBulk || e[3].Str != "foo" {
+		t.Fatalf("elements = %+v", e)
+	}
+	nested := e[4].Elements
+	if len(nested) != 2 || nested[0].Kind != resp.ReplyNil || nested[1].Kind != resp.ReplyNil {
+		t.Fatalf("nested = %+v", nested)
+	}
+
+	if _, err := resp.ReadReply(bufio.NewReader(strings.NewReader("$5\r\nab"))); err != resp.ErrParseIncomplete {
+		t.Fatalf("truncated bulk error = %v", err)
+	}
+}
+
+func TestNewCommand(t *testing.T) {
+	cmd := resp.NewCommand("set", "k", "v")
+	if cmd.Type != resp.RESP_SET || len(cmd.Arguments) != 2 || cmd.Arguments[1] != "v" {
+		t.Fatalf("cmd = %+v", cmd)
+	}
+}
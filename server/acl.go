@@ -0,0 +1,985 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mi0772/podcache/logging"
+	"mi0772/podcache/resp"
+	"mi0772/podcache/util"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aclCategory è l'insieme delle categorie ACL di un comando (@read, @write...)
+type aclCategory uint32
+
+const (
+	catKeyspace aclCategory = 1 << iota
+	catRead
+	catWrite
+	catSet
+	catSortedSet
+	catList
+	catHash
+	catString
+	catBitmap
+	catHyperLogLog
+	catGeo
+	catStream
+	catPubSub
+	catAdmin
+	catFast
+	catSlow
+	catBlocking
+	catDangerous
+	catConnection
+	catTransaction
+	catScripting
+)
+
+// aclCategoryNames elenca le categorie di Redis nell'ordine di ACL CAT; quelle
+// dei tipi che PodCache non ha sono accettate ma non contengono comandi
+var aclCategoryNames = []struct {
+	name string
+	cat  aclCategory
+}{
+	{"keyspace", catKeyspace},
+	{"read", catRead},
+	{"write", catWrite},
+	{"set", catSet},
+	{"sortedset", catSortedSet},
+	{"list", catList},
+	{"hash", catHash},
+	{"string", catString},
+	{"bitmap", catBitmap},
+	{"hyperloglog", catHyperLogLog},
+	{"geo", catGeo},
+	{"stream", catStream},
+	{"pubsub", catPubSub},
+	{"admin", catAdmin},
+	{"fast", catFast},
+	{"slow", catSlow},
+	{"blocking", catBlocking},
+	{"dangerous", catDangerous},
+	{"connection", catConnection},
+	{"transaction", catTransaction},
+	{"scripting", catScripting},
+}
+
+func lookupCategory(name string) (aclCategory, bool) {
+	for _, c := range aclCategoryNames {
+		if c.name == name {
+			return c.cat, true
+		}
+	}
+	return 0, false
+}
+
+// categories restituisce le categorie del comando: quelle dichiarate nella
+// tabella più quelle che discendono dai flag
+func (spec *commandSpec) categories() aclCategory {
+	cats := spec.acl
+	if spec.flags&flagReadOnly != 0 {
+		cats |= catRead
+	}
+	if spec.flags&flagWrite != 0 {
+		cats |= catWrite
+	}
+	if spec.flags&flagFast != 0 {
+		cats |= catFast
+	} else {
+		cats |= catSlow
+	}
+	if spec.flags&flagBlocking != 0 {
+		cats |= catBlocking
+	}
+	if spec.flags&flagPubSub != 0 {
+		cats |= catPubSub
+	}
+	return cats
+}
+
+// lookupCommandName cerca un comando per nome, come nelle regole +get
+func lookupCommandName(name string) *commandSpec {
+	return lookupCommand(resp.NewCommand(name))
+}
+
+// aclKeyPattern è un pattern di chiavi con i permessi di lettura e scrittura (~, %R~, %W~)
+type aclKeyPattern struct {
+	pattern     string
+	read, write bool
+}
+
+func (p aclKeyPattern) String() string {
+	switch {
+	case p.read && p.write:
+		return "~" + p.pattern
+	case p.read:
+		return "%R~" + p.pattern
+	default:
+		return "%W~" + p.pattern
+	}
+}
+
+// aclUser è un utente ACL. È immutabile: ACL SETUSER ne costruisce una copia
+// modificata e la sostituisce nel registro, così i controlli non prendono lock.
+type aclUser struct {
+	name      string
+	enabled   bool
+	nopass    bool
+	passwords []string // digest SHA-256 esadecimali
+
+	// commandRules sono le regole sui comandi nell'ordine in cui sono state
+	// date, da cui sono calcolati allowed e subcommands
+	commandRules []string
+	allowed      map[string]bool
+	subcommands  map[string]bool
+
+	keys     []aclKeyPattern
+	channels []string
+}
+
+func newACLUser(name string) *aclUser {
+	u := &aclUser{name: name}
+	u.applyCommandRule("-@all")
+	return u
+}
+
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = slices.Clone(u.passwords)
+	c.commandRules = slices.Clone(u.commandRules)
+	c.allowed = make(map[string]bool, len(u.allowed))
+	for k, v := range u.allowed {
+		c.allowed[k] = v
+	}
+	c.subcommands = make(map[string]bool, len(u.subcommands))
+	for k, v := range u.subcommands {
+		c.subcommands[k] = v
+	}
+	c.keys = slices.Clone(u.keys)
+	c.channels = slices.Clone(u.channels)
+	return &c
+}
+
+func passwordDigest(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkPassword confronta la password con tutti i digest in tempo costante
+func (u *aclUser) checkPassword(password string) bool {
+	if u.nopass {
+		return true
+	}
+	digest := []byte(passwordDigest(password))
+	match := false
+	for _, p := range u.passwords {
+		if subtle.ConstantTimeCompare(digest, []byte(p)) == 1 {
+			match = true
+		}
+	}
+	return match
+}
+
+func isPasswordHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !(s[i] >= '0' && s[i] <= '9' || s[i] >= 'a' && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRule applica una regola di ACL SETUSER
+func (u *aclUser) applyRule(rule string) error {
+	lower := strings.ToLower(rule)
+	switch lower {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass = true
+		u.passwords = nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = nil
+	case "allkeys":
+		u.keys = []aclKeyPattern{{pattern: "*", read: true, write: true}}
+	case "resetkeys":
+		u.keys = nil
+	case "allchannels":
+		u.channels = []string{"*"}
+	case "resetchannels":
+		u.channels = nil
+	case "allcommands":
+		u.applyCommandRule("+@all")
+	case "nocommands":
+		u.applyCommandRule("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			u.applyRule(r)
+		}
+	default:
+		return u.applyPatternRule(rule)
+	}
+	return nil
+}
+
+func (u *aclUser) applyPatternRule(rule string) error {
+	switch {
+	case strings.HasPrefix(rule, ">"):
+		u.addPassword(passwordDigest(rule[1:]))
+	case strings.HasPrefix(rule, "<"):
+		return u.removePassword(passwordDigest(rule[1:]))
+	case strings.HasPrefix(rule, "#"):
+		if !isPasswordHash(rule[1:]) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.addPassword(rule[1:])
+	case strings.HasPrefix(rule, "!"):
+		if !isPasswordHash(rule[1:]) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		return u.removePassword(rule[1:])
+	case strings.HasPrefix(rule, "~"):
+		u.addKeyPattern(aclKeyPattern{pattern: rule[1:], read: true, write: true})
+	case strings.HasPrefix(rule, "%"):
+		perms, pattern, ok := strings.Cut(rule[1:], "~")
+		if !ok || perms == "" {
+			return errors.New("Syntax error")
+		}
+		p := aclKeyPattern{pattern: pattern}
+		for _, c := range strings.ToUpper(perms) {
+			switch c {
+			case 'R':
+				p.read = true
+			case 'W':
+				p.write = true
+			default:
+				return errors.New("Syntax error")
+			}
+		}
+		u.addKeyPattern(p)
+	case strings.HasPrefix(rule, "&"):
+		if !slices.Contains(u.channels, rule[1:]) {
+			u.channels = append(u.channels, rule[1:])
+		}
+	case strings.HasPrefix(rule, "+"), strings.HasPrefix(rule, "-"):
+		return u.applyCommandRule(rule)
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+func (u *aclUser) addPassword(digest string) {
+	u.nopass = false
+	if !slices.Contains(u.passwords, digest) {
+		u.passwords = append(u.passwords, digest)
+	}
+}
+
+func (u *aclUser) removePassword(digest string) error {
+	i := slices.Index(u.passwords, digest)
+	if i < 0 {
+		return errors.New("The password you are trying to remove from the user does not exist")
+	}
+	u.passwords = slices.Delete(u.passwords, i, i+1)
+	return nil
+}
+
+func (u *aclUser) addKeyPattern(p aclKeyPattern) {
+	for i, existing := range u.keys {
+		if existing.pattern == p.pattern {
+			u.keys[i].read = existing.read || p.read
+			u.keys[i].write = existing.write || p.write
+			return
+		}
+	}
+	u.keys = append(u.keys, p)
+}
+
+// applyCommandRule applica +cmd, -cmd, +cmd|sub, +@cat, -@cat
+func (u *aclUser) applyCommandRule(rule string) error {
+	allow := rule[0] == '+'
+	name := strings.ToLower(rule[1:])
+
+	switch {
+	case name == "@all":
+		u.allowed = make(map[string]bool)
+		u.subcommands = make(map[string]bool)
+		for _, spec := range commandTable {
+			u.allowed[spec.name] = allow
+		}
+		// +@all/-@all azzerano le regole precedenti, come in Redis
+		u.commandRules = nil
+	case strings.HasPrefix(name, "@"):
+		cat, ok := lookupCategory(name[1:])
+		if !ok {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		for _, spec := range commandTable {
+			if spec.categories()&cat != 0 {
+				u.allowed[spec.name] = allow
+				u.clearSubcommands(spec.name)
+			}
+		}
+	default:
+		cmd, sub, isSub := strings.Cut(name, "|")
+		spec := lookupCommandName(cmd)
+		if spec == nil {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		if isSub {
+			if !spec.subcommands || sub == "" || strings.Contains(sub, "|") {
+				return errors.New("Unknown command or category name in ACL")
+			}
+			u.subcommands[name] = allow
+		} else {
+			u.allowed[spec.name] = allow
+			u.clearSubcommands(spec.name)
+		}
+	}
+	u.commandRules = append(u.commandRules, string(rule[0])+name)
+	return nil
+}
+
+func (u *aclUser) clearSubcommands(command string) {
+	for key := range u.subcommands {
+		if strings.HasPrefix(key, command+"|") {
+			delete(u.subcommands, key)
+		}
+	}
+}
+
+// canRun verifica il permesso sul comando e, per i contenitori, sul sottocomando
+func (u *aclUser) canRun(spec *commandSpec, sub string) bool {
+	if sub != "" {
+		if allowed, ok := u.subcommands[spec.name+"|"+sub]; ok {
+			return allowed
+		}
+	}
+	return u.allowed[spec.name]
+}
+
+// canAccessKey verifica che un pattern conceda il tipo di accesso richiesto
+func (u *aclUser) canAccessKey(key string, write bool) bool {
+	for _, p := range u.keys {
+		if (write && !p.write) || (!write && !p.read) {
+			continue
+		}
+		if util.GlobMatch(p.pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessChannel verifica un canale; i pattern di PSUBSCRIBE devono
+// coincidere letteralmente con un pattern dell'utente, come in Redis
+func (u *aclUser) canAccessChannel(channel string, isPattern bool) bool {
+	for _, p := range u.channels {
+		if p == "*" || (isPattern && p == channel) || (!isPattern && util.GlobMatch(p, channel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// flags restituisce i flag dell'utente come li riporta ACL GETUSER
+func (u *aclUser) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+func (u *aclUser) keysString() string {
+	parts := make([]string, len(u.keys))
+	for i, p := range u.keys {
+		parts[i] = p.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+func (u *aclUser) channelsString() string {
+	parts := make([]string, len(u.channels))
+	for i, c := range u.channels {
+		parts[i] = "&" + c
+	}
+	return strings.Join(parts, " ")
+}
+
+func (u *aclUser) commandsString() string {
+	if len(u.commandRules) == 0 {
+		return "-@all"
+	}
+	return strings.Join(u.commandRules, " ")
+}
+
+// describe restituisce le regole che ricreano l'utente, come ACL LIST e il file ACL
+func (u *aclUser) describe() string {
+	parts := []string{"user", u.name}
+	parts = append(parts, u.flags()...)
+	for _, p := range u.passwords {
+		parts = append(parts, "#"+p)
+	}
+	if keys := u.keysString(); keys != "" {
+		parts = append(parts, keys)
+	}
+	if channels := u.channelsString(); channels != "" {
+		parts = append(parts, channels)
+	} else {
+		parts = append(parts, "resetchannels")
+	}
+	parts = append(parts, u.commandsString())
+	return strings.Join(parts, " ")
+}
+
+// aclRegistry contiene gli utenti ACL e il log dei rifiuti
+type aclRegistry struct {
+	path   string
+	logger logging.Logger
+
+	mu    sync.RWMutex
+	users map[string]*aclUser
+
+	log aclLog
+}
+
+// newDefaultUser crea l'utente default: tutti i permessi, e la password di
+// requirepass se impostata
+func newDefaultUser(password string) *aclUser {
+	u := newACLUser(defaultUser)
+	for _, rule := range []string{"on", "allkeys", "allchannels", "allcommands"} {
+		u.applyRule(rule)
+	}
+	if password == "" {
+		u.applyRule("nopass")
+	} else {
+		u.applyRule(">" + password)
+	}
+	return u
+}
+
+func newACLRegistry(path, password string, logger logging.Logger) *aclRegistry {
+	r := &aclRegistry{
+		path:   path,
+		logger: logger,
+		users:  map[string]*aclUser{defaultUser: newDefaultUser(password)},
+	}
+	if path == "" {
+		return r
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		logger.Info("ACL file not found, it will be created by ACL SAVE", "path", path)
+		return r
+	}
+	if password != "" {
+		logger.Warn("Both an ACL file and a password are configured, the default user of the ACL file wins", "path", path)
+	}
+	if _, err := r.load(); err != nil {
+		logger.Fatal("Failed to load ACL file", "path", path, "error", err)
+	}
+	return r
+}
+
+func (r *aclRegistry) user(name string) *aclUser {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.users[name]
+}
+
+func (r *aclRegistry) names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.users))
+	for name := range r.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setUser applica le regole a una copia dell'utente, creandolo se non esiste
+func (r *aclRegistry) setUser(name string, rules []string) error {
+	if strings.ContainsAny(name, " \x00") || name == "" {
+		return errors.New("Usernames can't contain spaces or null characters")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var u *aclUser
+	if existing, ok := r.users[name]; ok {
+		u = existing.clone()
+	} else {
+		u = newACLUser(name)
+	}
+	for _, rule := range rules {
+		if err := u.applyRule(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %s", sanitizeLine(rule), err)
+		}
+	}
+	r.users[name] = u
+	return nil
+}
+
+// deleteUsers rimuove gli utenti e restituisce quelli effettivamente rimossi
+func (r *aclRegistry) deleteUsers(names []string) ([]string, error) {
+	if slices.Contains(names, defaultUser) {
+		return nil, errors.New("The 'default' user cannot be removed")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deleted []string
+	for _, name := range names {
+		if _, ok := r.users[name]; ok {
+			delete(r.users, name)
+			deleted = append(deleted, name)
+		}
+	}
+	return deleted, nil
+}
+
+// parseACLFile legge un file nel formato di ACL LIST: una riga "user <nome> <regole...>" per utente
+func parseACLFile(path string) (map[string]*aclUser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]*aclUser)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: line should start with user keyword", path, line)
+		}
+		if _, dup := users[fields[1]]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate user '%s' found", path, line, fields[1])
+		}
+		u := newACLUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := u.applyRule(rule); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s. Error in user declaration '%s'", path, line, err, fields[1])
+			}
+		}
+		users[fields[1]] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// load sostituisce gli utenti con quelli del file; se il file non definisce
+// default viene mantenuto quello corrente. Restituisce gli utenti spariti.
+func (r *aclRegistry) load() ([]string, error) {
+	users, err := parseACLFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	return r.replace(users), nil
+}
+
+// replace sostituisce gli utenti con quelli già letti da parseACLFile
+func (r *aclRegistry) replace(users map[string]*aclUser) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := users[defaultUser]; !ok {
+		users[defaultUser] = r.users[defaultUser]
+	}
+	var removed []string
+	for name := range r.users {
+		if _, ok := users[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	r.users = users
+	return removed
+}
+
+// save riscrive il file ACL con un file temporaneo e rename
+func (r *aclRegistry) save() error {
+	var b strings.Builder
+	for _, name := range r.names() {
+		if u := r.user(name); u != nil {
+			b.WriteString(u.describe())
+			b.WriteByte('\n')
+		}
+	}
+	tmp := filepath.Join(filepath.Dir(r.path), "."+filepath.Base(r.path)+".tmp")
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// aclDenial è il motivo per cui un comando è stato rifiutato
+type aclDenial struct {
+	reason string // command, key, channel
+	object string
+}
+
+// checkACL verifica comando, chiavi e canali rispetto ai permessi dell'utente
+func (s *PodCacheServer) checkACL(u *aclUser, spec *commandSpec, cmd *resp.Command) *aclDenial {
+	if u == nil {
+		return &aclDenial{reason: "command", object: spec.name}
+	}
+	sub := ""
+	if spec.subcommands && len(cmd.Arguments) > 0 {
+		sub = strings.ToLower(cmd.Arguments[0])
+	}
+	if !u.canRun(spec, sub) {
+		object := spec.name
+		if sub != "" {
+			object += "|" + sub
+		}
+		return &aclDenial{reason: "command", object: object}
+	}
+
+	write := spec.flags&flagWrite != 0
+	for _, key := range spec.extractKeys(cmd.Arguments) {
+		if !u.canAccessKey(key, write) {
+			return &aclDenial{reason: "key", object: key}
+		}
+	}
+
+	var channels []string
+	isPattern := false
+	switch cmd.Type {
+	case resp.RESP_SUBSCRIBE:
+		channels = cmd.Arguments
+	case resp.RESP_PSUBSCRIBE:
+		channels, isPattern = cmd.Arguments, true
+	case resp.RESP_PUBLISH:
+		if len(cmd.Arguments) > 0 {
+			channels = cmd.Arguments[:1]
+		}
+	}
+	for _, channel := range channels {
+		if !u.canAccessChannel(channel, isPattern) {
+			return &aclDenial{reason: "channel", object: channel}
+		}
+	}
+	return nil
+}
+
+// rejectIfDenied applica le ACL a un comando del client; i comandi consentiti
+// prima dell'autenticazione (AUTH, HELLO, QUIT) non sono soggetti alle ACL
+func (s *PodCacheServer) rejectIfDenied(client *Client, spec *commandSpec, cmd *resp.Command) bool {
+	context := "toplevel"
+	if client.multi {
+		context = "multi"
+	}
+	message := s.aclDenied(client, spec, cmd, context)
+	if message == "" {
+		return false
+	}
+	if client.multi {
+		client.txFailed = true
+	}
+	client.writer.WriteString("-" + message + "\r\n")
+	client.flush()
+	return true
+}
+
+// aclDenied verifica le ACL e, se il comando è rifiutato, lo registra in ACL
+// LOG e restituisce il messaggio d'errore; vuoto se il comando è consentito
+func (s *PodCacheServer) aclDenied(client *Client, spec *commandSpec, cmd *resp.Command, context string) string {
+	if spec == nil || spec.flags&flagNoAuth != 0 {
+		return ""
+	}
+	username := client.username()
+	denial := s.checkACL(s.acl.user(username), spec, cmd)
+	if denial == nil {
+		return ""
+	}
+
+	s.acl.log.add(denial.reason, context, denial.object, username, client.info())
+	switch denial.reason {
+	case "command":
+		return fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", username, denial.object)
+	case "key":
+		return "NOPERM No permissions to access a key"
+	default:
+		return "NOPERM No permissions to access a channel"
+	}
+}
+
+// disconnectUsers chiude le connessioni autenticate con gli utenti indicati
+func (s *PodCacheServer) disconnectUsers(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	for _, client := range s.clients.all() {
+		if slices.Contains(names, client.username()) {
+			client.kill()
+		}
+	}
+}
+
+// handleAcl implementa ACL SETUSER|GETUSER|DELUSER|USERS|LIST|WHOAMI|CAT|LOG|GENPASS|DRYRUN|SAVE|LOAD
+func (s *PodCacheServer) handleAcl(client *Client, args []string) error {
+	if len(args) == 0 {
+		return client.sendError("wrong number of arguments for 'acl' command")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "SETUSER":
+		if len(args) < 2 {
+			return client.sendError("wrong number of arguments for 'acl|setuser' command")
+		}
+		if err := s.acl.setUser(args[1], args[2:]); err != nil {
+			return client.sendError(err.Error())
+		}
+		return client.sendOK("OK")
+	case "GETUSER":
+		if len(args) != 2 {
+			return client.sendError("wrong number of arguments for 'acl|getuser' command")
+		}
+		return s.handleAclGetUser(client, args[1])
+	case "DELUSER":
+		if len(args) < 2 {
+			return client.sendError("wrong number of arguments for 'acl|deluser' command")
+		}
+		deleted, err := s.acl.deleteUsers(args[1:])
+		if err != nil {
+			return client.sendError(err.Error())
+		}
+		s.disconnectUsers(deleted)
+		return client.sendInteger(len(deleted))
+	case "USERS":
+		names := s.acl.names()
+		client.writeArray(len(names))
+		for _, name := range names {
+			client.writeBulk(name)
+		}
+		return client.flush()
+	case "LIST":
+		names := s.acl.names()
+		client.writeArray(len(names))
+		for _, name := range names {
+			if u := s.acl.user(name); u != nil {
+				client.writeBulk(u.describe())
+			} else {
+				client.writeNullBulk()
+			}
+		}
+		return client.flush()
+	case "WHOAMI":
+		return client.sendBulkString(client.username())
+	case "CAT":
+		return s.handleAclCat(client, args[1:])
+	case "LOG":
+		return s.handleAclLog(client, args[1:])
+	case "GENPASS":
+		return s.handleAclGenPass(client, args[1:])
+	case "DRYRUN":
+		if len(args) < 3 {
+			return client.sendError("wrong number of arguments for 'acl|dryrun' command")
+		}
+		return s.handleAclDryRun(client, args[1], args[2:])
+	case "SAVE":
+		if s.acl.path == "" {
+			return client.sendError("This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+		}
+		if err := s.acl.save(); err != nil {
+			s.logger.Error("Failed to save ACL file", "path", s.acl.path, "error", err)
+			return client.sendError("There was an error trying to save the ACLs. Please check the server logs for more information")
+		}
+		return client.sendOK("OK")
+	case "LOAD":
+		if s.acl.path == "" {
+			return client.sendError("This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+		}
+		removed, err := s.acl.load()
+		if err != nil {
+			return client.sendError(sanitizeLine(err.Error()))
+		}
+		s.disconnectUsers(removed)
+		return client.sendOK("OK")
+	default:
+		return client.sendError(fmt.Sprintf("unknown subcommand '%s'. Try ACL HELP.", args[0]))
+	}
+}
+
+func (s *PodCacheServer) handleAclGetUser(client *Client, name string) error {
+	u := s.acl.user(name)
+	if u == nil {
+		return client.sendNullBulkString()
+	}
+	client.writeMap(6)
+	client.writeBulk("flags")
+	flags := u.flags()
+	client.writeArray(len(flags))
+	for _, flag := range flags {
+		client.writeBulk(flag)
+	}
+	client.writeBulk("passwords")
+	client.writeArray(len(u.passwords))
+	for _, p := range u.passwords {
+		client.writeBulk(p)
+	}
+	client.writeBulk("commands")
+	client.writeBulk(u.commandsString())
+	client.writeBulk("keys")
+	client.writeBulk(u.keysString())
+	client.writeBulk("channels")
+	client.writeBulk(u.channelsString())
+	// i selettori di Redis 7 non sono supportati
+	client.writeBulk("selectors")
+	client.writeArray(0)
+	return client.flush()
+}
+
+// handleAclCat implementa ACL CAT [category]
+func (s *PodCacheServer) handleAclCat(client *Client, args []string) error {
+	switch len(args) {
+	case 0:
+		client.writeArray(len(aclCategoryNames))
+		for _, c := range aclCategoryNames {
+			client.writeBulk(c.name)
+		}
+		return client.flush()
+	case 1:
+		cat, ok := lookupCategory(strings.ToLower(args[0]))
+		if !ok {
+			return client.sendError(fmt.Sprintf("Unknown category '%s'", args[0]))
+		}
+		var names []string
+		for _, spec := range commandTable {
+			if spec.categories()&cat != 0 {
+				names = append(names, spec.name)
+			}
+		}
+		sort.Strings(names)
+		client.writeArray(len(names))
+		for _, name := range names {
+			client.writeBulk(name)
+		}
+		return client.flush()
+	default:
+		return client.sendError("wrong number of arguments for 'acl|cat' command")
+	}
+}
+
+// handleAclLog implementa ACL LOG [count | RESET]
+func (s *PodCacheServer) handleAclLog(client *Client, args []string) error {
+	count := 10
+	switch len(args) {
+	case 0:
+	case 1:
+		if strings.ToUpper(args[0]) == "RESET" {
+			s.acl.log.reset()
+			return client.sendOK("OK")
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return client.sendError("value is out of range, must be positive")
+		}
+		count = n
+	default:
+		return client.sendError("wrong number of arguments for 'acl|log' command")
+	}
+
+	now := time.Now()
+	entries := s.acl.log.recent(count)
+	client.writeArray(len(entries))
+	for _, e := range entries {
+		client.writeMap(10)
+		client.writeBulk("count")
+		client.writeInt(int64(e.count))
+		client.writeBulk("reason")
+		client.writeBulk(e.reason)
+		client.writeBulk("context")
+		client.writeBulk(e.context)
+		client.writeBulk("object")
+		client.writeBulk(e.object)
+		client.writeBulk("username")
+		client.writeBulk(e.username)
+		client.writeBulk("age-seconds")
+		client.writeBulk(strconv.FormatFloat(now.Sub(e.created).Seconds(), 'f', 3, 64))
+		client.writeBulk("client-info")
+		client.writeBulk(e.clientInfo)
+		client.writeBulk("entry-id")
+		client.writeInt(int64(e.id))
+		client.writeBulk("timestamp-created")
+		client.writeInt(e.created.UnixMilli())
+		client.writeBulk("timestamp-last-updated")
+		client.writeInt(e.updated.UnixMilli())
+	}
+	return client.flush()
+}
+
+// handleAclGenPass implementa ACL GENPASS [bits]: bit casuali in esadecimale
+func (s *PodCacheServer) handleAclGenPass(client *Client, args []string) error {
+	bits := 256
+	if len(args) > 1 {
+		return client.sendError("wrong number of arguments for 'acl|genpass' command")
+	}
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 || n > 4096 {
+			return client.sendError("ACL GENPASS argument must be the number of bits for the output password, a positive number up to 4096")
+		}
+		bits = n
+	}
+	buf := make([]byte, (bits+7)/8)
+	if _, err := rand.Read(buf); err != nil {
+		return client.sendError(err.Error())
+	}
+	// un carattere esadecimale ogni 4 bit, arrotondando per eccesso
+	return client.sendBulkString(hex.EncodeToString(buf)[:(bits+3)/4])
+}
+
+// handleAclDryRun implementa ACL DRYRUN username command [arg ...]
+func (s *PodCacheServer) handleAclDryRun(client *Client, username string, args []string) error {
+	u := s.acl.user(username)
+	if u == nil {
+		return client.sendError(fmt.Sprintf("User '%s' not found", username))
+	}
+	cmd := resp.NewCommand(args[0], args[1:]...)
+	spec := lookupCommand(cmd)
+	if spec == nil {
+		return client.sendError(fmt.Sprintf("Command '%s' not found", args[0]))
+	}
+	if !spec.checkArity(cmd.Arguments) {
+		return client.sendError(fmt.Sprintf("wrong number of arguments for '%s' command", spec.name))
+	}
+	denial := s.checkACL(u, spec, cmd)
+	switch {
+	case denial == nil:
+		return client.sendOK("OK")
+	case denial.reason == "command":
+		return client.sendBulkString(fmt.Sprintf("User %s has no permissions to run the '%s' command", username, denial.object))
+	case denial.reason == "key":
+		return client.sendBulkString(fmt.Sprintf("User %s has no permissions to access the '%s' key", username, denial.object))
+	default:
+		return client.sendBulkString(fmt.Sprintf("User %s has no permissions to access the '%s' channel", username, denial.object))
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// Il log ACL conserva gli ultimi rifiuti come acllog-max-len di Redis; rifiuti
+// uguali entro aclLogMergeWindow incrementano count invece di aggiungere voci
+const (
+	aclLogMaxLen      = 128
+	aclLogMergeWindow = 60 * time.Second
+)
+
+type aclLogEntry struct {
+	id         uint64
+	count      int
+	reason     string // command, key, channel, auth
+	context    string // toplevel, multi, lua
+	object     string
+	username   string
+	clientInfo string
+	created    time.Time
+	updated    time.Time
+}
+
+type aclLog struct {
+	mu      sync.Mutex
+	entries []*aclLogEntry // dalla più recente
+	nextID  uint64
+}
+
+func (l *aclLog) add(reason, context, object, username, clientInfo string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for i, e := range l.entries {
+		if e.reason == reason && e.context == context && e.object == object && e.username == username &&
+			now.Sub(e.updated) < aclLogMergeWindow {
+			e.count++
+			e.updated = now
+			e.clientInfo = clientInfo
+			// la voce aggiornata torna in testa
+			copy(l.entries[1:i+1], l.entries[:i])
+			l.entries[0] = e
+			return
+		}
+	}
+
+	entry := &aclLogEntry{
+		id:         l.nextID,
+		count:      1,
+		reason:     reason,
+		context:    context,
+		object:     object,
+		username:   username,
+		clientInfo: clientInfo,
+		created:    now,
+		updated:    now,
+	}
+	l.nextID++
+	l.entries = append([]*aclLogEntry{entry}, l.entries...)
+	if len(l.entries) > aclLogMaxLen {
+		l.entries = l.entries[:aclLogMaxLen]
+	}
+}
+
+// recent restituisce una copia delle ultime count voci (tutte se count < 0)
+func (l *aclLog) recent(count int) []aclLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if count < 0 || count > len(l.entries) {
+		count = len(l.entries)
+	}
+	entries := make([]aclLogEntry, count)
+	for i := range entries {
+		entries[i] = *l.entries[i]
+	}
+	return entries
+}
+
+func (l *aclLog) reset() {
+	l.mu.Lock()
+	l.entries = nil
+	l.mu.Unlock()
+}
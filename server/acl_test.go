@@ -0,0 +1,193 @@
+package server
+
+import (
+	"mi0772/podcache/resp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// authAs collega un nuovo client autenticato come user
+func authAs(t *testing.T, addr, user, password string) *testClient {
+	t.Helper()
+	c := dialTest(t, "tcp", addr)
+	c.ok("AUTH", user, password)
+	return c
+}
+
+func TestACLCommandRules(t *testing.T) {
+	s := newTestServer(t)
+	admin := newTestClient(t, s)
+	addr := admin.conn.RemoteAddr().String()
+
+	admin.ok("ACL", "SETUSER", "alice", "on", ">secret", "allkeys", "+@read", "-xlen", "+set", "+client|id")
+	alice := authAs(t, addr, "alice", "secret")
+
+	alice.ok("SET", "k", "v")
+	if got := alice.bulk("GET", "k"); got != "v" {
+		t.Fatalf("GET = %q, want v", got)
+	}
+	alice.fails("NOPERM User alice has no permissions to run the 'xlen' command", "XLEN", "k")
+	// DEL è in @keyspace e @write, non in @read
+	alice.fails("NOPERM User alice has no permissions to run the 'del' command", "DEL", "k")
+	alice.integer("CLIENT", "ID")
+	alice.fails("NOPERM User alice has no permissions to run the 'client|list' command", "CLIENT", "LIST")
+
+	// le modifiche valgono subito per i client già autenticati
+	admin.ok("ACL", "SETUSER", "alice", "-@read")
+	alice.fails("NOPERM", "GET", "k")
+	admin.ok("ACL", "SETUSER", "alice", "+@string")
+	if got := alice.bulk("GET", "k"); got != "v" {
+		t.Fatalf("GET after +@string = %q, want v", got)
+	}
+
+	entry := admin.do("ACL", "LOG", "1").Elements[0]
+	if field(entry, "reason").Str != "command" || field(entry, "object").Str != "get" || field(entry, "username").Str != "alice" {
+		t.Fatalf("ACL LOG entry = %+v", entry)
+	}
+}
+
+func TestACLKeyPatterns(t *testing.T) {
+	s := newTestServer(t)
+	admin := newTestClient(t, s)
+	addr := admin.conn.RemoteAddr().String()
+
+	admin.ok("ACL", "SETUSER", "bob", "on", ">pw", "+@all", "~app:*", "%R~ro:*")
+	bob := authAs(t, addr, "bob", "pw")
+
+	bob.ok("SET", "app:1", "x")
+	bob.fails("NOPERM No permissions to access a key", "SET", "other", "x")
+	bob.fails("NOPERM No permissions to access a key", "GET", "other")
+	// %R~ concede solo la lettura
+	if reply := bob.do("GET", "ro:1"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("GET ro:1 = %+v, want nil", reply)
+	}
+	bob.fails("NOPERM No permissions to access a key", "SET", "ro:1", "x")
+
+	// le KEYS di EVAL e FCALL sono controllate prima di eseguire lo script
+	if got := bob.integer("EVAL", "return 1", "1", "app:1"); got != 1 {
+		t.Fatalf("EVAL on app:1 = %d, want 1", got)
+	}
+	bob.fails("NOPERM No permissions to access a key", "EVAL", "return 1", "1", "other")
+	bob.fails("NOPERM No permissions to access a key", "FCALL", "missing", "1", "other")
+	// e lo sono anche le chiavi dei comandi eseguiti dallo script
+	bob.fails("", "EVAL", "return redis.call('GET', 'other')", "0")
+
+	// un comando rifiutato dentro MULTI fa fallire EXEC
+	bob.ok("MULTI")
+	if reply := bob.do("SET", "app:2", "y"); reply.Str != "QUEUED" {
+		t.Fatalf("SET in MULTI = %+v, want QUEUED", reply)
+	}
+	bob.fails("NOPERM No permissions to access a key", "SET", "other", "y")
+	bob.fails("EXECABORT", "EXEC")
+	if reply := bob.do("GET", "app:2"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("GET app:2 after EXECABORT = %+v, want nil", reply)
+	}
+
+	// i permessi tolti dopo l'accodamento sono verificati di nuovo da EXEC
+	bob.ok("MULTI")
+	bob.do("SET", "app:3", "z")
+	admin.ok("ACL", "SETUSER", "bob", "resetkeys", "~other")
+	bob.fails("EXECABORT Transaction discarded because of: NOPERM No permissions to access a key", "EXEC")
+	if reply := admin.do("GET", "app:3"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("GET app:3 after EXECABORT = %+v, want nil", reply)
+	}
+	entry := admin.do("ACL", "LOG", "1").Elements[0]
+	if field(entry, "reason").Str != "key" || field(entry, "context").Str != "multi" || field(entry, "object").Str != "app:3" {
+		t.Fatalf("ACL LOG entry = %+v", entry)
+	}
+}
+
+func TestACLChannels(t *testing.T) {
+	s := newTestServer(t)
+	admin := newTestClient(t, s)
+	addr := admin.conn.RemoteAddr().String()
+
+	admin.ok("ACL", "SETUSER", "carol", "on", ">pw", "+@all", "&news.*")
+	carol := authAs(t, addr, "carol", "pw")
+
+	if got := carol.integer("PUBLISH", "news.it", "hello"); got != 0 {
+		t.Fatalf("PUBLISH news.it = %d, want 0", got)
+	}
+	carol.fails("NOPERM No permissions to access a channel", "PUBLISH", "sport", "goal")
+	carol.fails("NOPERM No permissions to access a channel", "SUBSCRIBE", "sport")
+	// i pattern di PSUBSCRIBE devono coincidere con quelli dell'utente
+	carol.fails("NOPERM No permissions to access a channel", "PSUBSCRIBE", "news.i*")
+	if reply := carol.do("PSUBSCRIBE", "news.*"); reply.Kind != resp.ReplyArray || reply.Elements[0].Str != "psubscribe" {
+		t.Fatalf("PSUBSCRIBE news.* = %+v", reply)
+	}
+	if reply := carol.do("SUBSCRIBE", "news.it"); reply.Kind != resp.ReplyArray || reply.Elements[0].Str != "subscribe" {
+		t.Fatalf("SUBSCRIBE news.it = %+v", reply)
+	}
+}
+
+func TestACLPasswords(t *testing.T) {
+	s := newTestServer(t)
+	admin := newTestClient(t, s)
+	addr := admin.conn.RemoteAddr().String()
+
+	admin.ok("ACL", "SETUSER", "dave", "on", "nopass", "+ping")
+	dave := authAs(t, addr, "dave", "anything")
+	if reply := dave.do("PING"); reply.Str != "PONG" {
+		t.Fatalf("PING = %+v, want PONG", reply)
+	}
+
+	// resetpass toglie nopass e le password: nessuna password è più valida
+	admin.ok("ACL", "SETUSER", "dave", "resetpass")
+	c := dialTest(t, "tcp", addr)
+	c.fails("WRONGPASS", "AUTH", "dave", "anything")
+	c.fails("WRONGPASS", "AUTH", "dave", "")
+
+	admin.ok("ACL", "SETUSER", "dave", ">one", ">two")
+	c.ok("AUTH", "dave", "two")
+	admin.ok("ACL", "SETUSER", "dave", "<two")
+	c.fails("WRONGPASS", "AUTH", "dave", "two")
+	c.ok("AUTH", "dave", "one")
+
+	// un utente disabilitato non può autenticarsi
+	admin.ok("ACL", "SETUSER", "dave", "off")
+	c.fails("WRONGPASS", "AUTH", "dave", "one")
+}
+
+func TestACLFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	initial := "user default on nopass ~* &* +@all\nuser erin on >pw ~cache:* resetchannels -@all +get\n"
+	if err := os.WriteFile(path, []byte(initial), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t, "aclfile", path)
+	admin := newTestClient(t, s)
+	addr := admin.conn.RemoteAddr().String()
+
+	erin := authAs(t, addr, "erin", "pw")
+	if reply := erin.do("GET", "cache:1"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("GET cache:1 = %+v, want nil", reply)
+	}
+	erin.fails("NOPERM", "SET", "cache:1", "x")
+
+	admin.ok("ACL", "SETUSER", "frank", "on", ">pw2", "%R~logs:*", "&events", "+@read", "-xlen")
+	admin.ok("ACL", "SAVE")
+	saved := admin.array("ACL", "LIST")
+
+	// un altro server che carica il file salvato ha gli stessi utenti
+	other := newTestClient(t, newTestServer(t, "aclfile", path))
+	if loaded := other.array("ACL", "LIST"); !slices.Equal(loaded, saved) {
+		t.Fatalf("ACL LIST after reload =\n%s\nwant\n%s", strings.Join(loaded, "\n"), strings.Join(saved, "\n"))
+	}
+	authAs(t, other.conn.RemoteAddr().String(), "frank", "pw2")
+
+	// ACL LOAD sostituisce gli utenti e disconnette quelli rimossi
+	if err := os.WriteFile(path, []byte("user default on nopass ~* &* +@all\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	admin.ok("ACL", "LOAD")
+	if users := admin.array("ACL", "USERS"); !slices.Equal(users, []string{"default"}) {
+		t.Fatalf("ACL USERS after LOAD = %v", users)
+	}
+	if !erin.closed() {
+		t.Fatal("client of a removed user still connected after ACL LOAD")
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"mi0772/podcache/config"
+	"mi0772/podcache/logging"
+	"os"
+)
+
+// defaultUser è l'utente con cui si autenticano le nuove connessioni, come in Redis
+const defaultUser = "default"
+
+// getRequirePass restituisce il valore di requirepass, che il registro della
+// configurazione ha già letto dal file, da PODCACHE_REQUIREPASS o dal file
+// indicato da PODCACHE_REQUIREPASS_FILE; qui si segnala solo nel log se le due
+// variabili sono impostate insieme e se l'autenticazione è attiva.
+func getRequirePass(cfg *config.Registry, logger logging.Logger) string {
+	_, fromFile := os.LookupEnv("PODCACHE_REQUIREPASS_FILE")
+	if _, both := os.LookupEnv("PODCACHE_REQUIREPASS"); fromFile && both {
+		logger.Warn("Both PODCACHE_REQUIREPASS_FILE and PODCACHE_REQUIREPASS are set, using the file")
+	}
+	password := cfg.String("requirepass")
+	if password != "" {
+		logger.Info("Authentication enabled")
+	}
+	return password
+}
+
+// rejectIfUnauthenticated risponde NOAUTH ai comandi che richiedono
+// autenticazione finché il client non ha eseguito AUTH o HELLO AUTH
+func (s *PodCacheServer) rejectIfUnauthenticated(client *Client, spec *commandSpec) bool {
+	if client.authenticated || (spec != nil && spec.flags&flagNoAuth != 0) {
+		return false
+	}
+	client.writer.WriteString("-NOAUTH Authentication required.\r\n")
+	client.flush()
+	return true
+}
+
+// authenticate verifica le credenziali di un utente ACL e, se valide, autentica
+// il client con quell'utente; altrimenti risponde WRONGPASS
+func (s *PodCacheServer) authenticate(client *Client, username, password string) bool {
+	u := s.acl.user(username)
+	if u == nil || !u.enabled || !u.checkPassword(password) {
+		s.logger.Warn("Authentication failed", "addr", client.conn.RemoteAddr().String(), "user", username)
+		s.acl.log.add("auth", "toplevel", "AUTH", username, client.info())
+		client.writer.WriteString("-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+		client.flush()
+		return false
+	}
+	client.authenticated = true
+	client.setUser(username)
+	return true
+}
+
+// handleAuth implementa AUTH [username] password
+func (s *PodCacheServer) handleAuth(client *Client, args []string) error {
+	username, password := defaultUser, ""
+	switch len(args) {
+	case 1:
+		if u := s.acl.user(defaultUser); u != nil && u.nopass {
+			return client.sendError("AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		}
+		password = args[0]
+	case 2:
+		username, password = args[0], args[1]
+	default:
+		return client.sendError(errSyntax.Error())
+	}
+
+	if !s.authenticate(client, username, password) {
+		return nil
+	}
+	return client.sendOK("OK")
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"mi0772/podcache/resp"
+	"testing"
+)
+
+func TestRequirePass(t *testing.T) {
+	s := newTestServer(t, "requirepass", "s3cret")
+	c := newTestClient(t, s)
+	addr := c.conn.RemoteAddr().String()
+
+	c.fails("NOAUTH Authentication required.", "GET", "k")
+	c.fails("NOAUTH Authentication required.", "PING")
+	c.fails("WRONGPASS invalid username-password pair or user is disabled.", "AUTH", "wrong")
+	c.fails("WRONGPASS", "AUTH", "default", "wrong")
+	c.fails("NOAUTH", "GET", "k")
+	c.ok("AUTH", "s3cret")
+	c.ok("SET", "k", "v")
+
+	// HELLO AUTH autentica e sceglie il protocollo in un solo comando
+	h := dialTest(t, "tcp", addr)
+	h.fails("NOAUTH HELLO must be called with the client already authenticated", "HELLO", "3")
+	h.fails("WRONGPASS", "HELLO", "3", "AUTH", "default", "wrong")
+	if reply := h.do("HELLO", "3", "AUTH", "default", "s3cret"); reply.Kind != resp.ReplyArray || field(reply, "proto").Int != 3 {
+		t.Fatalf("HELLO 3 AUTH = %+v", reply)
+	}
+	if got := h.bulk("GET", "k"); got != "v" {
+		t.Fatalf("GET after HELLO AUTH = %q, want v", got)
+	}
+
+	// CONFIG SET requirepass vale per le nuove autenticazioni; "" la disattiva
+	c.ok("CONFIG", "SET", "requirepass", "other")
+	n := dialTest(t, "tcp", addr)
+	n.fails("WRONGPASS", "AUTH", "s3cret")
+	n.ok("AUTH", "other")
+	c.ok("CONFIG", "SET", "requirepass", "")
+	anon := dialTest(t, "tcp", addr)
+	if got := anon.bulk("GET", "k"); got != "v" {
+		t.Fatalf("GET without requirepass = %q, want v", got)
+	}
+	anon.fails("ERR AUTH <password> called without any password configured for the default user", "AUTH", "anything")
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// keyWaiters tiene traccia dei client bloccati in attesa di scritture su una chiave
+// (XREAD/XREADGROUP con BLOCK). Ogni attesa è un canale chiuso alla prima scrittura.
+type keyWaiters struct {
+	mutex   sync.Mutex
+	waiters map[waitKey]map[*waiter]struct{}
+}
+
+// waitKey identifica una chiave attesa nel suo DB: la stessa chiave in un
+// altro DB è un'altra chiave
+type waitKey struct {
+	db  int
+	key string
+}
+
+type waiter struct {
+	ch   chan struct{}
+	keys []waitKey
+}
+
+func newKeyWaiters() *keyWaiters {
+	return &keyWaiters{waiters: make(map[waitKey]map[*waiter]struct{})}
+}
+
+// register va chiamata prima di leggere lo stato, così una scrittura concorrente
+// tra lettura e attesa non va persa; cancel rimuove la registrazione.
+func (w *keyWaiters) register(db int, keys []string) (wait <-chan struct{}, cancel func()) {
+	wt := &waiter{ch: make(chan struct{}), keys: make([]waitKey, len(keys))}
+	for i, key := range keys {
+		wt.keys[i] = waitKey{db: db, key: key}
+	}
+
+	w.mutex.Lock()
+	for _, key := range wt.keys {
+		if w.waiters[key] == nil {
+			w.waiters[key] = make(map[*waiter]struct{})
+		}
+		w.waiters[key][wt] = struct{}{}
+	}
+	w.mutex.Unlock()
+
+	return wt.ch, func() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		w.remove(wt)
+	}
+}
+
+// signal sveglia tutti i client in attesa sulla chiave del DB
+func (w *keyWaiters) signal(db int, key string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for wt := range w.waiters[waitKey{db: db, key: key}] {
+		close(wt.ch)
+		w.remove(wt)
+	}
+}
+
+// signalDB sveglia tutti i client in attesa su chiavi del DB, per i comandi
+// che ne cambiano l'intero contenuto come SWAPDB
+func (w *keyWaiters) signalDB(db int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for key, waiters := range w.waiters {
+		if key.db != db {
+			continue
+		}
+		for wt := range waiters {
+			close(wt.ch)
+			w.remove(wt)
+		}
+	}
+}
+
+func (w *keyWaiters) remove(wt *waiter) {
+	for _, key := range wt.keys {
+		delete(w.waiters[key], wt)
+		if len(w.waiters[key]) == 0 {
+			delete(w.waiters, key)
+		}
+	}
+}
+
+// blockingTimeout converte il valore BLOCK in un canale di timeout; 0 significa attesa infinita
+func blockingTimeout(ms int64) (<-chan time.Time, func()) {
+	if ms == 0 {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(time.Duration(ms) * time.Millisecond)
+	return timer.C, func() { timer.Stop() }
+}
+
+// waitBlocked attende una scrittura, il timeout o la chiusura del client con
+// CLIENT KILL; per tutta l'attesa il client ha il flag b in CLIENT LIST
+func (c *Client) waitBlocked(wait <-chan struct{}, timeout <-chan time.Time) (woken bool, err error) {
+	c.blocked.Store(true)
+	defer c.blocked.Store(false)
+	start := time.Now()
+	defer func() { c.blockedFor += time.Since(start) }()
+	select {
+	case <-wait:
+		return true, nil
+	case <-timeout:
+		return false, nil
+	case <-c.killed:
+		return false, errClientKilled
+	}
+}
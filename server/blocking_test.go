@@ -0,0 +1,51 @@
+package server
+
+import "testing"
+
+func TestKeyWaitersByDB(t *testing.T) {
+	w := newKeyWaiters()
+	wait, cancel := w.register(0, []string{"events", "audit"})
+	defer cancel()
+
+	// la stessa chiave in un altro DB non sveglia il client
+	w.signal(1, "events")
+	select {
+	case <-wait:
+		t.Fatal("waiter on DB 0 woken by a write on DB 1")
+	default:
+	}
+
+	w.signal(0, "audit")
+	select {
+	case <-wait:
+	default:
+		t.Fatal("waiter on DB 0 not woken by a write on DB 0")
+	}
+	if len(w.waiters) != 0 {
+		t.Fatalf("waiters left after signal: %v", w.waiters)
+	}
+}
+
+func TestKeyWaitersSignalDB(t *testing.T) {
+	w := newKeyWaiters()
+	first, cancelFirst := w.register(0, []string{"a", "b"})
+	defer cancelFirst()
+	second, cancelSecond := w.register(1, []string{"a"})
+	defer cancelSecond()
+
+	// signalDB sveglia solo i client in attesa su chiavi di quel DB
+	w.signalDB(0)
+	select {
+	case <-first:
+	default:
+		t.Fatal("waiter on DB 0 not woken by signalDB(0)")
+	}
+	select {
+	case <-second:
+		t.Fatal("waiter on DB 1 woken by signalDB(0)")
+	default:
+	}
+	if len(w.waiters) != 1 {
+		t.Fatalf("waiters after signalDB(0): %v, want only DB 1", w.waiters)
+	}
+}
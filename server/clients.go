@@ -0,0 +1,508 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mi0772/podcache/resp"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errClientKilled interrompe la connessione di un client chiuso con CLIENT KILL
+var errClientKilled = errors.New("client killed")
+
+// clientRegistry tiene traccia delle connessioni attive per ID
+type clientRegistry struct {
+	mu   sync.RWMutex
+	byID map[uint64]*Client
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{byID: make(map[uint64]*Client)}
+}
+
+func (r *clientRegistry) add(client *Client) {
+	r.mu.Lock()
+	r.byID[client.id] = client
+	r.mu.Unlock()
+}
+
+func (r *clientRegistry) remove(client *Client) {
+	r.mu.Lock()
+	delete(r.byID, client.id)
+	r.mu.Unlock()
+}
+
+func (r *clientRegistry) get(id uint64) *Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byID[id]
+}
+
+// all restituisce i client connessi in ordine di ID
+func (r *clientRegistry) all() []*Client {
+	r.mu.RLock()
+	clients := make([]*Client, 0, len(r.byID))
+	for _, client := range r.byID {
+		clients = append(clients, client)
+	}
+	r.mu.RUnlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// clientState è lo stato di un client visibile alle altre connessioni (CLIENT
+// LIST, CLIENT KILL). Lo pubblica la goroutine della connessione a ogni
+// comando, così chi legge non tocca i campi che lei modifica.
+type clientState struct {
+	db              int
+	sub, psub       int
+	multi           int // comandi in coda, -1 fuori da MULTI
+	tracking        bool
+	redirect        int64
+	protocol        int
+	qbuf            int
+	cmd             string
+	lastInteraction time.Time
+}
+
+// publishState aggiorna lo stato visibile agli altri client
+func (c *Client) publishState() {
+	state := &clientState{
+		db:              c.view.DB(),
+		sub:             len(c.channels),
+		psub:            len(c.patterns),
+		multi:           -1,
+		redirect:        -1,
+		protocol:        c.protocolVersion(),
+		qbuf:            c.reader.Buffered(),
+		cmd:             c.lastCmd,
+		lastInteraction: c.lastInteraction,
+	}
+	if c.multi {
+		state.multi = len(c.queued)
+	}
+	if c.tracking != nil {
+		state.tracking = true
+		state.redirect = int64(c.tracking.redirect)
+	}
+	c.state.Store(state)
+}
+
+// clientName restituisce il nome impostato con CLIENT SETNAME o HELLO SETNAME
+func (c *Client) clientName() string {
+	if name := c.name.Load(); name != nil {
+		return *name
+	}
+	return ""
+}
+
+// validClientName accetta solo caratteri ASCII stampabili senza spazi, come Redis
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// addrs restituisce indirizzo remoto e locale; per i socket Unix Redis usa il
+// percorso del socket con porta 0
+func (c *Client) addrs() (string, string) {
+	if addr, ok := c.conn.LocalAddr().(*net.UnixAddr); ok {
+		return addr.Name + ":0", addr.Name + ":0"
+	}
+	return c.conn.RemoteAddr().String(), c.conn.LocalAddr().String()
+}
+
+func (c *Client) isUnixSocket() bool {
+	_, ok := c.conn.LocalAddr().(*net.UnixAddr)
+	return ok
+}
+
+// clientType è il tipo usato dai filtri TYPE di CLIENT LIST e CLIENT KILL
+func (state *clientState) clientType() string {
+	if state.sub+state.psub > 0 {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// info descrive il client come una riga di CLIENT LIST
+func (c *Client) info() string {
+	state := c.state.Load()
+	if state == nil {
+		state = &clientState{multi: -1, redirect: -1, protocol: 2}
+	}
+	now := time.Now()
+
+	flags := ""
+	if state.sub+state.psub > 0 {
+		flags += "P"
+	}
+	if state.multi >= 0 {
+		flags += "x"
+	}
+	if c.blocked.Load() {
+		flags += "b"
+	}
+	if state.tracking {
+		flags += "t"
+	}
+	if c.isUnixSocket() {
+		flags += "U"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+
+	addr, laddr := c.addrs()
+	omem := c.out.size()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d multi=%d qbuf=%d obl=0 oll=%d omem=%d cmd=%s user=%s redir=%d resp=%d",
+		c.id, addr, laddr, c.clientName(),
+		int64(now.Sub(c.created).Seconds()), int64(now.Sub(state.lastInteraction).Seconds()),
+		flags, state.db, state.sub, state.psub, state.multi, state.qbuf,
+		min(omem, 1), omem, state.cmd, c.username(), state.redirect, state.protocol)
+}
+
+// kill chiude la connessione del client e ne sblocca le attese; la goroutine
+// della connessione esce alla prima lettura o attesa
+func (c *Client) kill() {
+	c.killOnce.Do(func() {
+		close(c.killed)
+		c.conn.Close()
+	})
+}
+
+// commandName è il nome del comando come in CLIENT LIST, con il sottocomando
+func commandName(cmd *resp.Command) string {
+	name := strings.ToLower(string(cmd.Type))
+	if spec := lookupCommand(cmd); spec != nil && spec.subcommands && len(cmd.Arguments) > 0 {
+		name += "|" + strings.ToLower(cmd.Arguments[0])
+	}
+	return name
+}
+
+// handleClientList implementa CLIENT LIST [TYPE type] [ID id [id ...]]
+func (s *PodCacheServer) handleClientList(client *Client, args []string) error {
+	clientType := ""
+	var ids map[uint64]bool
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "TYPE":
+			if i+1 >= len(args) {
+				return client.sendError(errSyntax.Error())
+			}
+			i++
+			clientType = strings.ToLower(args[i])
+			if !validClientType(clientType) {
+				return client.sendError(fmt.Sprintf("Unknown client type '%s'", args[i]))
+			}
+		case "ID":
+			if i+1 >= len(args) {
+				return client.sendError(errSyntax.Error())
+			}
+			ids = make(map[uint64]bool)
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseUint(args[i], 10, 64)
+				if err != nil || id == 0 {
+					return client.sendError("Invalid client ID")
+				}
+				ids[id] = true
+			}
+		default:
+			return client.sendError(errSyntax.Error())
+		}
+	}
+
+	client.publishState()
+	var b strings.Builder
+	for _, c := range s.clients.all() {
+		if ids != nil && !ids[c.id] {
+			continue
+		}
+		if clientType != "" && !c.hasType(clientType) {
+			continue
+		}
+		b.WriteString(c.info())
+		b.WriteByte('\n')
+	}
+	return client.sendBulkString(b.String())
+}
+
+func validClientType(t string) bool {
+	switch t {
+	case "normal", "pubsub", "master", "replica", "slave":
+		return true
+	}
+	return false
+}
+
+// hasType confronta il tipo del client; PodCache non ha repliche, quindi
+// master e replica non corrispondono a nessun client
+func (c *Client) hasType(t string) bool {
+	state := c.state.Load()
+	return state != nil && state.clientType() == t
+}
+
+// clientKillFilter sono i filtri di CLIENT KILL; i campi vuoti non filtrano
+type clientKillFilter struct {
+	id         uint64
+	addr       string
+	laddr      string
+	user       string
+	clientType string
+	skipMe     bool
+	maxAge     int64
+}
+
+func (f *clientKillFilter) match(self, c *Client) bool {
+	addr, laddr := c.addrs()
+	switch {
+	case f.skipMe && c == self:
+		return false
+	case f.id != 0 && c.id != f.id:
+		return false
+	case f.addr != "" && addr != f.addr:
+		return false
+	case f.laddr != "" && laddr != f.laddr:
+		return false
+	case f.user != "" && c.username() != f.user:
+		return false
+	case f.clientType != "" && !c.hasType(f.clientType):
+		return false
+	case f.maxAge != 0 && int64(time.Since(c.created).Seconds()) < f.maxAge:
+		return false
+	}
+	return true
+}
+
+// handleClientKill implementa CLIENT KILL ip:port e CLIENT KILL <filtro> <valore> ...
+func (s *PodCacheServer) handleClientKill(client *Client, args []string) error {
+	if len(args) == 0 {
+		return client.sendError("wrong number of arguments for 'client|kill' command")
+	}
+	// forma storica: un solo indirizzo, risponde OK e può chiudere anche il client stesso
+	if len(args) == 1 {
+		filter := &clientKillFilter{addr: args[0]}
+		if s.killClients(client, filter) == 0 {
+			return client.sendError("No such client")
+		}
+		return client.sendOK("OK")
+	}
+	if len(args)%2 != 0 {
+		return client.sendError(errSyntax.Error())
+	}
+
+	filter := &clientKillFilter{skipMe: true}
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || id == 0 {
+				return client.sendError("client-id should be greater than 0")
+			}
+			filter.id = id
+		case "ADDR":
+			filter.addr = value
+		case "LADDR":
+			filter.laddr = value
+		case "USER":
+			if s.acl.user(value) == nil {
+				return client.sendError(fmt.Sprintf("No such user '%s'", value))
+			}
+			filter.user = value
+		case "TYPE":
+			filter.clientType = strings.ToLower(value)
+			if !validClientType(filter.clientType) {
+				return client.sendError(fmt.Sprintf("Unknown client type '%s'", value))
+			}
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return client.sendError(errSyntax.Error())
+			}
+		case "MAXAGE":
+			age, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || age <= 0 {
+				return client.sendError(errSyntax.Error())
+			}
+			filter.maxAge = age
+		default:
+			return client.sendError(errSyntax.Error())
+		}
+	}
+	return client.sendInteger(s.killClients(client, filter))
+}
+
+// killClients chiude i client che soddisfano il filtro; il client che esegue
+// il comando viene chiuso solo dopo aver ricevuto la risposta
+func (s *PodCacheServer) killClients(self *Client, filter *clientKillFilter) int {
+	killed := 0
+	for _, c := range s.clients.all() {
+		if !filter.match(self, c) {
+			continue
+		}
+		killed++
+		if c == self {
+			self.closeAfterReply = true
+			continue
+		}
+		s.logger.Info("Client killed", "id", c.id, "addr", c.conn.RemoteAddr().String())
+		c.kill()
+	}
+	return killed
+}
+
+// handleClientSetName implementa CLIENT SETNAME; un nome vuoto lo rimuove
+func (s *PodCacheServer) handleClientSetName(client *Client, args []string) error {
+	if len(args) != 1 {
+		return client.sendError("wrong number of arguments for 'client|setname' command")
+	}
+	if !validClientName(args[0]) {
+		return client.sendError("Client names cannot contain spaces, newlines or special characters.")
+	}
+	client.name.Store(&args[0])
+	return client.sendOK("OK")
+}
+
+func (s *PodCacheServer) handleClientGetName(client *Client) error {
+	name := client.clientName()
+	if name == "" {
+		return client.sendNullBulkString()
+	}
+	return client.sendBulkString(name)
+}
+
+// clientPause è lo stato di CLIENT PAUSE: fino a until i comandi in scrittura
+// (o tutti, con ALL) attendono; changed viene chiuso a ogni PAUSE/UNPAUSE per
+// svegliare chi attende e fargli rileggere lo stato
+type clientPause struct {
+	mu      sync.Mutex
+	until   time.Time
+	all     bool
+	changed chan struct{}
+}
+
+func newClientPause() *clientPause {
+	return &clientPause{changed: make(chan struct{})}
+}
+
+// pause estende la pausa; come in Redis una pausa più breve o più debole di
+// quella in corso non la riduce
+func (p *clientPause) pause(d time.Duration, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if now.After(p.until) {
+		p.all = false
+	}
+	if until := now.Add(d); until.After(p.until) {
+		p.until = until
+	}
+	p.all = p.all || all
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *clientPause) unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.until = time.Time{}
+	p.all = false
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// wait blocca finché la pausa riguarda il comando
+func (p *clientPause) wait(client *Client, write bool) error {
+	for {
+		p.mu.Lock()
+		remaining := time.Until(p.until)
+		active := remaining > 0 && (p.all || write)
+		changed := p.changed
+		p.mu.Unlock()
+		if !active {
+			return nil
+		}
+
+		timer := time.NewTimer(remaining)
+		client.blocked.Store(true)
+		select {
+		case <-timer.C:
+		case <-changed:
+		case <-client.killed:
+			timer.Stop()
+			client.blocked.Store(false)
+			return errClientKilled
+		}
+		timer.Stop()
+		client.blocked.Store(false)
+	}
+}
+
+// pausedByWrite riporta se il comando attende in CLIENT PAUSE WRITE: oltre
+// alle scritture, come in Redis, gli script che possono scrivere, PUBLISH e
+// PFCOUNT; EXEC attende se in coda c'è uno di questi
+func pausedByWrite(client *Client, spec *commandSpec, cmd *resp.Command) bool {
+	switch cmd.Type {
+	case resp.RESP_EVAL, resp.RESP_EVALSHA, resp.RESP_FCALL, resp.RESP_PUBLISH, resp.RESP_PFCOUNT:
+		return true
+	case resp.RESP_EXEC:
+		for _, queued := range client.queued {
+			if pausedByWrite(client, lookupCommand(queued), queued) {
+				return true
+			}
+		}
+		return false
+	}
+	return spec != nil && spec.flags&flagWrite != 0
+}
+
+// waitIfPaused applica CLIENT PAUSE; i comandi CLIENT restano sempre
+// disponibili, così si può controllare e sbloccare il server
+func (s *PodCacheServer) waitIfPaused(client *Client, spec *commandSpec, cmd *resp.Command) error {
+	if cmd.Type == resp.RESP_CLIENT || cmd.Type == resp.RESP_QUIT {
+		return nil
+	}
+	if client.multi && cmd.Type != resp.RESP_EXEC {
+		return nil
+	}
+	return s.pause.wait(client, pausedByWrite(client, spec, cmd))
+}
+
+// handleClientPause implementa CLIENT PAUSE timeout [WRITE|ALL]
+func (s *PodCacheServer) handleClientPause(client *Client, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return client.sendError("wrong number of arguments for 'client|pause' command")
+	}
+	ms, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return client.sendError("timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return client.sendError("timeout is negative")
+	}
+	all := true
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1]) {
+		case "WRITE":
+			all = false
+		case "ALL":
+		default:
+			return client.sendError(errSyntax.Error())
+		}
+	}
+	s.pause.pause(time.Duration(ms)*time.Millisecond, all)
+	return client.sendOK("OK")
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bufio"
+	"mi0772/podcache/resp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientLines esegue CLIENT LIST con i filtri indicati e ne restituisce le righe
+func clientLines(c *testClient, filters ...string) []string {
+	c.t.Helper()
+	list := c.bulk(append([]string{"CLIENT", "LIST"}, filters...)...)
+	return strings.Split(strings.TrimSuffix(list, "\n"), "\n")
+}
+
+func TestClientListFilters(t *testing.T) {
+	s := newTestServer(t)
+	admin := newTestClient(t, s)
+	addr := admin.conn.RemoteAddr().String()
+
+	admin.ok("CLIENT", "SETNAME", "admin")
+	adminID := admin.integer("CLIENT", "ID")
+	sub := dialTest(t, "tcp", addr)
+	sub.do("SUBSCRIBE", "news")
+	dialTest(t, "tcp", addr).ok("SET", "k", "v")
+
+	if lines := clientLines(admin); len(lines) != 3 {
+		t.Fatalf("CLIENT LIST = %q, want 3 clients", lines)
+	}
+	lines := clientLines(admin, "TYPE", "pubsub")
+	if len(lines) != 1 || !strings.Contains(lines[0], " flags=P ") || !strings.Contains(lines[0], " sub=1 ") {
+		t.Fatalf("CLIENT LIST TYPE pubsub = %q", lines)
+	}
+	lines = clientLines(admin, "ID", strconv.FormatInt(adminID, 10))
+	if len(lines) != 1 || !strings.Contains(lines[0], " name=admin ") || !strings.Contains(lines[0], " cmd=client|list ") {
+		t.Fatalf("CLIENT LIST ID = %q", lines)
+	}
+	if info := admin.bulk("CLIENT", "INFO"); !strings.HasPrefix(info, "id="+strconv.FormatInt(adminID, 10)+" ") {
+		t.Fatalf("CLIENT INFO = %q", info)
+	}
+	admin.fails("ERR Unknown client type 'bogus'", "CLIENT", "LIST", "TYPE", "bogus")
+	admin.fails("ERR Invalid client ID", "CLIENT", "LIST", "ID", "x")
+}
+
+func TestClientKillFilters(t *testing.T) {
+	s := newTestServer(t)
+	admin := newTestClient(t, s)
+	addr := admin.conn.RemoteAddr().String()
+	admin.ok("ACL", "SETUSER", "eve", "on", "nopass", "+@all", "allkeys")
+
+	byUser := authAs(t, addr, "eve", "")
+	byID := dialTest(t, "tcp", addr)
+	id := byID.integer("CLIENT", "ID")
+	byAddr := dialTest(t, "tcp", addr)
+	byAddr.ok("SET", "k", "v")
+
+	admin.fails("ERR No such user 'nobody'", "CLIENT", "KILL", "USER", "nobody")
+	if n := admin.integer("CLIENT", "KILL", "USER", "eve"); n != 1 {
+		t.Fatalf("CLIENT KILL USER = %d, want 1", n)
+	}
+	if !byUser.closed() {
+		t.Fatal("client of eve still connected")
+	}
+	if n := admin.integer("CLIENT", "KILL", "ID", strconv.FormatInt(id, 10)); n != 1 {
+		t.Fatalf("CLIENT KILL ID = %d, want 1", n)
+	}
+	if !byID.closed() {
+		t.Fatal("client killed by ID still connected")
+	}
+	// la forma storica con il solo indirizzo risponde OK
+	admin.ok("CLIENT", "KILL", byAddr.conn.LocalAddr().String())
+	if !byAddr.closed() {
+		t.Fatal("client killed by address still connected")
+	}
+	admin.fails("ERR No such client", "CLIENT", "KILL", "127.0.0.1:1")
+
+	// SKIPME è yes per default: il client che esegue il comando resta connesso
+	self := admin.conn.LocalAddr().String()
+	if n := admin.integer("CLIENT", "KILL", "ADDR", self); n != 0 {
+		t.Fatalf("CLIENT KILL ADDR self = %d, want 0", n)
+	}
+	if n := admin.integer("CLIENT", "KILL", "ADDR", self, "SKIPME", "no"); n != 1 {
+		t.Fatalf("CLIENT KILL ADDR self SKIPME no = %d, want 1", n)
+	}
+	if !admin.closed() {
+		t.Fatal("client still connected after killing itself")
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	s := newTestServer(t)
+	admin := newTestClient(t, s)
+	addr := admin.conn.RemoteAddr().String()
+	writer := dialTest(t, "tcp", addr)
+	reader := dialTest(t, "tcp", addr)
+	writerID := writer.integer("CLIENT", "ID")
+
+	admin.ok("CLIENT", "PAUSE", "10000", "WRITE")
+	writer.send("SET", "k", "v")
+	replies := make(chan resp.Reply, 1)
+	go func(r *bufio.Reader) {
+		reply, _ := resp.ReadReply(r)
+		replies <- reply
+	}(writer.reader)
+
+	// le letture non attendono, le scritture sì e il client ha il flag b
+	if reply := reader.do("GET", "k"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("GET during CLIENT PAUSE WRITE = %+v, want nil", reply)
+	}
+	select {
+	case reply := <-replies:
+		t.Fatalf("SET answered during CLIENT PAUSE WRITE: %+v", reply)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if lines := clientLines(admin, "ID", strconv.FormatInt(writerID, 10)); !strings.Contains(lines[0], " flags=b ") {
+		t.Fatalf("paused client in CLIENT LIST = %q, want flags=b", lines)
+	}
+
+	admin.ok("CLIENT", "UNPAUSE")
+	select {
+	case reply := <-replies:
+		if reply.Str != "OK" {
+			t.Fatalf("SET after CLIENT UNPAUSE = %+v, want OK", reply)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SET still waiting after CLIENT UNPAUSE")
+	}
+
+	// con ALL attendono anche le letture, fino alla scadenza della pausa
+	admin.ok("CLIENT", "PAUSE", "200", "ALL")
+	start := time.Now()
+	if got := reader.bulk("GET", "k"); got != "v" {
+		t.Fatalf("GET after CLIENT PAUSE ALL = %q, want v", got)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("GET answered after %v during CLIENT PAUSE ALL", elapsed)
+	}
+}
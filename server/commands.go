@@ -0,0 +1,165 @@
+package server
+
+import (
+	"mi0772/podcache/resp"
+	"strings"
+)
+
+type commandFlags uint32
+
+const (
+	flagWrite commandFlags = 1 << iota
+	flagReadOnly
+	flagBlocking
+	flagFast
+	flagPubSub
+	// flagNoScript vieta il comando dentro gli script, come CMD_NOSCRIPT in Redis
+	flagNoScript
+	// flagKeyspace indica un comando sull'intero keyspace (SWAPDB, FLUSHDB):
+	// richiede i lock di tutte le partizioni
+	flagKeyspace
+	// flagNoAuth consente il comando prima dell'autenticazione, come CMD_NO_AUTH
+	flagNoAuth
+)
+
+// commandSpec descrive un comando come la command table di Redis: arity
+// (negativa = numero minimo di argomenti, nome incluso), flag e posizione
+// delle chiavi. Le posizioni contano il nome del comando come 0; keys, se
+// presente, sostituisce firstKey/lastKey/step per le sintassi non regolari.
+type commandSpec struct {
+	name     string
+	arity    int
+	flags    commandFlags
+	firstKey int
+	lastKey  int
+	step     int
+	keys     func(args []string) []string
+	// acl sono le categorie ACL oltre a quelle ricavate dai flag; subcommands
+	// indica un comando contenitore, i cui permessi si possono dare per
+	// sottocomando (es. +client|id)
+	acl         aclCategory
+	subcommands bool
+}
+
+var commandTable = map[resp.RespCommand]*commandSpec{
+	resp.RESP_PING:   {name: "ping", arity: -1, flags: flagFast, acl: catConnection},
+	resp.RESP_CLIENT: {name: "client", arity: -2, flags: flagNoScript, acl: catConnection, subcommands: true},
+	resp.RESP_QUIT:   {name: "quit", arity: -1, flags: flagFast | flagNoScript | flagNoAuth, acl: catConnection},
+	resp.RESP_AUTH:   {name: "auth", arity: -2, flags: flagFast | flagNoScript | flagNoAuth, acl: catConnection},
+
+	resp.RESP_GET:    {name: "get", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, acl: catString},
+	resp.RESP_SET:    {name: "set", arity: -3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1, acl: catString},
+	resp.RESP_INCR:   {name: "incr", arity: 2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, acl: catString},
+	resp.RESP_INCRBY: {name: "incrby", arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, acl: catString},
+	resp.RESP_DEL:    {name: "del", arity: -2, flags: flagWrite, firstKey: 1, lastKey: -1, step: 1, acl: catKeyspace},
+	resp.RESP_UNLINK: {name: "unlink", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: -1, step: 1, acl: catKeyspace},
+
+	// gli script tengono solo i lock delle proprie KEYS, per questo non possono
+	// eseguire i comandi sull'intero keyspace
+	resp.RESP_SELECT:   {name: "select", arity: 2, flags: flagFast, acl: catConnection},
+	resp.RESP_SWAPDB:   {name: "swapdb", arity: 3, flags: flagWrite | flagFast | flagKeyspace | flagNoScript, acl: catKeyspace | catDangerous},
+	resp.RESP_FLUSHDB:  {name: "flushdb", arity: -1, flags: flagWrite | flagKeyspace | flagNoScript, acl: catKeyspace | catDangerous},
+	resp.RESP_FLUSHALL: {name: "flushall", arity: -1, flags: flagWrite | flagKeyspace | flagNoScript, acl: catKeyspace | catDangerous},
+	resp.RESP_DBSIZE:   {name: "dbsize", arity: 1, flags: flagReadOnly | flagFast, acl: catKeyspace},
+	resp.RESP_MOVE:     {name: "move", arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, acl: catKeyspace},
+
+	resp.RESP_PFADD:   {name: "pfadd", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, acl: catHyperLogLog},
+	resp.RESP_PFCOUNT: {name: "pfcount", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: -1, step: 1, acl: catHyperLogLog},
+	resp.RESP_PFMERGE: {name: "pfmerge", arity: -2, flags: flagWrite, firstKey: 1, lastKey: -1, step: 1, acl: catHyperLogLog},
+
+	resp.RESP_XADD:       {name: "xadd", arity: -5, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, acl: catStream},
+	resp.RESP_XRANGE:     {name: "xrange", arity: -4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, acl: catStream},
+	resp.RESP_XREVRANGE:  {name: "xrevrange", arity: -4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, acl: catStream},
+	resp.RESP_XLEN:       {name: "xlen", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, acl: catStream},
+	resp.RESP_XTRIM:      {name: "xtrim", arity: -4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1, acl: catStream},
+	resp.RESP_XREAD:      {name: "xread", arity: -4, flags: flagReadOnly | flagBlocking, keys: streamsKeys, acl: catStream},
+	resp.RESP_XGROUP:     {name: "xgroup", arity: -2, flags: flagWrite, firstKey: 2, lastKey: 2, step: 1, acl: catStream, subcommands: true},
+	resp.RESP_XREADGROUP: {name: "xreadgroup", arity: -7, flags: flagWrite | flagBlocking, keys: streamsKeys, acl: catStream},
+	resp.RESP_XACK:       {name: "xack", arity: -4, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, acl: catStream},
+	resp.RESP_XPENDING:   {name: "xpending", arity: -3, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, acl: catStream},
+	resp.RESP_XCLAIM:     {name: "xclaim", arity: -6, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, acl: catStream},
+
+	resp.RESP_GEOADD:         {name: "geoadd", arity: -5, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1, acl: catGeo},
+	resp.RESP_GEOPOS:         {name: "geopos", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, acl: catGeo},
+	resp.RESP_GEODIST:        {name: "geodist", arity: -4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, acl: catGeo},
+	resp.RESP_GEOHASH:        {name: "geohash", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, acl: catGeo},
+	resp.RESP_GEOSEARCH:      {name: "geosearch", arity: -7, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, acl: catGeo},
+	resp.RESP_GEOSEARCHSTORE: {name: "geosearchstore", arity: -8, flags: flagWrite, firstKey: 1, lastKey: 2, step: 1, acl: catGeo},
+	resp.RESP_ZREM:           {name: "zrem", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, acl: catSortedSet},
+	resp.RESP_ZCARD:          {name: "zcard", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, acl: catSortedSet},
+
+	resp.RESP_MULTI:   {name: "multi", arity: 1, flags: flagFast | flagNoScript, acl: catTransaction},
+	resp.RESP_EXEC:    {name: "exec", arity: 1, flags: flagNoScript, acl: catTransaction},
+	resp.RESP_DISCARD: {name: "discard", arity: 1, flags: flagFast | flagNoScript, acl: catTransaction},
+	resp.RESP_WATCH:   {name: "watch", arity: -2, flags: flagFast | flagNoScript, firstKey: 1, lastKey: -1, step: 1, acl: catTransaction},
+	resp.RESP_UNWATCH: {name: "unwatch", arity: 1, flags: flagFast | flagNoScript, acl: catTransaction},
+
+	resp.RESP_SUBSCRIBE:    {name: "subscribe", arity: -2, flags: flagPubSub | flagNoScript, acl: catPubSub},
+	resp.RESP_UNSUBSCRIBE:  {name: "unsubscribe", arity: -1, flags: flagPubSub | flagNoScript, acl: catPubSub},
+	resp.RESP_PSUBSCRIBE:   {name: "psubscribe", arity: -2, flags: flagPubSub | flagNoScript, acl: catPubSub},
+	resp.RESP_PUNSUBSCRIBE: {name: "punsubscribe", arity: -1, flags: flagPubSub | flagNoScript, acl: catPubSub},
+	resp.RESP_PUBLISH:      {name: "publish", arity: 3, flags: flagPubSub | flagFast, acl: catPubSub},
+	resp.RESP_PUBSUB:       {name: "pubsub", arity: -2, acl: catPubSub, subcommands: true},
+	resp.RESP_HELLO:        {name: "hello", arity: -1, flags: flagFast | flagNoScript | flagNoAuth, acl: catConnection},
+
+	resp.RESP_EVAL:    {name: "eval", arity: -3, flags: flagNoScript, keys: evalKeys, acl: catScripting},
+	resp.RESP_EVALSHA: {name: "evalsha", arity: -3, flags: flagNoScript, keys: evalKeys, acl: catScripting},
+	resp.RESP_SCRIPT:  {name: "script", arity: -2, flags: flagNoScript, acl: catScripting, subcommands: true},
+
+	resp.RESP_FUNCTION: {name: "function", arity: -2, flags: flagNoScript, acl: catScripting, subcommands: true},
+	resp.RESP_FCALL:    {name: "fcall", arity: -3, flags: flagNoScript, keys: evalKeys, acl: catScripting},
+	resp.RESP_FCALL_RO: {name: "fcall_ro", arity: -3, flags: flagReadOnly | flagNoScript, keys: evalKeys, acl: catScripting},
+
+	resp.RESP_ACL: {name: "acl", arity: -2, flags: flagNoScript, acl: catAdmin | catDangerous, subcommands: true},
+
+	resp.RESP_INFO:   {name: "info", arity: -1, acl: catDangerous},
+	resp.RESP_CONFIG: {name: "config", arity: -2, flags: flagNoScript, acl: catAdmin | catDangerous, subcommands: true},
+
+	resp.RESP_SLOWLOG: {name: "slowlog", arity: -2, flags: flagNoScript, acl: catAdmin | catDangerous, subcommands: true},
+	resp.RESP_LATENCY: {name: "latency", arity: -2, flags: flagNoScript, acl: catAdmin | catDangerous, subcommands: true},
+}
+
+func lookupCommand(cmd *resp.Command) *commandSpec {
+	return commandTable[cmd.Type]
+}
+
+// checkArity verifica il numero di argomenti, contando anche il nome del comando
+func (spec *commandSpec) checkArity(args []string) bool {
+	n := len(args) + 1
+	if spec.arity > 0 {
+		return n == spec.arity
+	}
+	return n >= -spec.arity
+}
+
+// extractKeys restituisce le chiavi toccate dal comando
+func (spec *commandSpec) extractKeys(args []string) []string {
+	if spec.keys != nil {
+		return spec.keys(args)
+	}
+	if spec.firstKey == 0 {
+		return nil
+	}
+
+	last := spec.lastKey
+	if last < 0 {
+		last = len(args) + 1 + last
+	}
+
+	var keys []string
+	for i := spec.firstKey; i <= last && i <= len(args); i += spec.step {
+		keys = append(keys, args[i-1])
+	}
+	return keys
+}
+
+// streamsKeys estrae le chiavi di XREAD/XREADGROUP: la prima metà degli argomenti dopo STREAMS
+func streamsKeys(args []string) []string {
+	for i, arg := range args {
+		if strings.ToUpper(arg) == "STREAMS" {
+			rest := args[i+1:]
+			return rest[:len(rest)/2]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mi0772/podcache/cache"
+	"mi0772/podcache/config"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RegisterConfig aggiunge al registro i parametri del server, con i nomi di
+// redis.conf e le variabili PODCACHE_* come sorgente d'ambiente
+func RegisterConfig(r *config.Registry) {
+	r.Register(
+		config.Param{Name: "port", Env: "PODCACHE_PORT", Type: config.Int, Default: "6379", Max: 65535},
+		config.Param{Name: "tls-port", Env: "PODCACHE_TLS_PORT", Type: config.Int, Default: "0", Max: 65535},
+		config.Param{Name: "tls-cert-file", Env: "PODCACHE_TLS_CERT_FILE"},
+		config.Param{Name: "tls-key-file", Env: "PODCACHE_TLS_KEY_FILE"},
+		config.Param{Name: "tls-ca-cert-file", Env: "PODCACHE_TLS_CA_CERT_FILE"},
+		config.Param{Name: "tls-auth-clients", Env: "PODCACHE_TLS_AUTH_CLIENTS", Type: config.Enum, Default: "yes", Values: []string{"yes", "optional", "no"}},
+		config.Param{Name: "tls-min-version", Env: "PODCACHE_TLS_MIN_VERSION", Default: "TLSv1.2", Normalize: normalizeTLSVersion},
+		config.Param{Name: "tls-ciphers", Env: "PODCACHE_TLS_CIPHERS", Normalize: normalizeCipherSuites},
+		config.Param{Name: "unixsocket", Env: "PODCACHE_UNIXSOCKET"},
+		config.Param{Name: "unixsocketperm", Env: "PODCACHE_UNIXSOCKETPERM", Default: "0", Normalize: normalizeSocketPerm},
+		config.Param{Name: "dir", Env: "CAS_BASE_PATH", Default: ".cas", Normalize: normalizeDir},
+		config.Param{Name: "databases", Env: "PODCACHE_DATABASES", Type: config.Int, Default: strconv.Itoa(cache.DefaultDatabases), Min: 1},
+		config.Param{Name: "aclfile", Env: "PODCACHE_ACLFILE"},
+		config.Param{Name: "requirepass", Env: "PODCACHE_REQUIREPASS", EnvFile: "PODCACHE_REQUIREPASS_FILE", Mutable: true, Sensitive: true},
+		config.Param{Name: "maxclients", Env: "PODCACHE_MAXCLIENTS", Type: config.Int, Default: strconv.Itoa(defaultMaxClients), Min: 1, Mutable: true},
+		config.Param{Name: "timeout", Env: "PODCACHE_TIMEOUT", Type: config.Int, Default: "0", Mutable: true},
+		config.Param{Name: "tcp-keepalive", Env: "PODCACHE_TCP_KEEPALIVE", Type: config.Int, Default: strconv.Itoa(int(defaultTCPKeepAlive.Seconds())), Mutable: true},
+		config.Param{Name: "client-output-buffer-limit", Env: "PODCACHE_CLIENT_OUTPUT_BUFFER_LIMIT", Default: defaultOutputLimits.String(), Mutable: true, Normalize: normalizeOutputLimits},
+		config.Param{Name: "busy-reply-threshold", Alias: "lua-time-limit", Env: "PODCACHE_LUA_TIME_LIMIT", Type: config.Int, Default: strconv.Itoa(int(defaultScriptTimeLimit.Milliseconds())), Min: 1, Mutable: true},
+		config.Param{Name: "notify-keyspace-events", Env: "PODCACHE_NOTIFY_KEYSPACE_EVENTS", Mutable: true, Normalize: normalizeKeyspaceEvents},
+		config.Param{Name: "slowlog-log-slower-than", Env: "PODCACHE_SLOWLOG_LOG_SLOWER_THAN", Type: config.Int, Default: strconv.Itoa(defaultSlowlogSlowerThan), Min: -1, Mutable: true},
+		config.Param{Name: "slowlog-max-len", Env: "PODCACHE_SLOWLOG_MAX_LEN", Type: config.Int, Default: strconv.Itoa(defaultSlowlogMaxLen), Mutable: true},
+		config.Param{Name: "latency-monitor-threshold", Env: "PODCACHE_LATENCY_MONITOR_THRESHOLD", Type: config.Int, Default: "0", Mutable: true},
+		config.Param{Name: "metrics-port", Env: "PODCACHE_METRICS_PORT", Type: config.Int, Default: "0", Max: 65535},
+		config.Param{Name: "tracing-endpoint", Env: "PODCACHE_TRACING_ENDPOINT", Normalize: normalizeTracingEndpoint},
+		config.Param{Name: "tracing-sample-ratio", Env: "PODCACHE_TRACING_SAMPLE_RATIO", Default: "0.01", Mutable: true, Normalize: normalizeSampleRatio},
+	)
+}
+
+func normalizeTLSVersion(value, _ string) (string, error) {
+	version, err := parseTLSVersion(value)
+	if err != nil {
+		return "", err
+	}
+	if version == tls.VersionTLS13 {
+		return "TLSv1.3", nil
+	}
+	return "TLSv1.2", nil
+}
+
+func normalizeCipherSuites(value, _ string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if _, err := parseCipherSuites(value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+func normalizeDir(value, _ string) (string, error) {
+	if value == "" {
+		return "", errors.New("must not be empty")
+	}
+	return filepath.Clean(value), nil
+}
+
+func normalizeSocketPerm(value, _ string) (string, error) {
+	perm, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || perm > 0o777 {
+		return "", errors.New("must be an octal permission such as 770")
+	}
+	return strconv.FormatUint(perm, 8), nil
+}
+
+// normalizeOutputLimits applica le classi indicate ai limiti correnti: come in
+// Redis, "pubsub 64mb 16mb 60" lascia invariate le altre classi
+func normalizeOutputLimits(value, current string) (string, error) {
+	base := defaultOutputLimits
+	if current != "" {
+		base, _ = parseOutputLimits(current, defaultOutputLimits)
+	}
+	limits, err := parseOutputLimits(value, base)
+	if err != nil {
+		return "", err
+	}
+	return limits.String(), nil
+}
+
+func normalizeKeyspaceEvents(value, _ string) (string, error) {
+	flags, err := parseKeyspaceEvents(value)
+	if err != nil {
+		return "", err
+	}
+	return keyspaceEventsString(flags), nil
+}
+
+// CheckConfig controlla le combinazioni di parametri che i singoli valori non
+// bastano a validare; main la usa all'avvio e con --check-config
+func CheckConfig(cfg *config.Registry) error {
+	if cfg.Int("port") == 0 && cfg.Int("tls-port") == 0 && cfg.String("unixsocket") == "" {
+		return errors.New("no listener configured: set port, tls-port or unixsocket")
+	}
+	if cfg.Int("tls-port") == 0 {
+		return nil
+	}
+	if cfg.String("tls-cert-file") == "" || cfg.String("tls-key-file") == "" {
+		return errors.New("tls-cert-file and tls-key-file are required when tls-port is set")
+	}
+	if cfg.String("tls-ca-cert-file") == "" && cfg.IsSet("tls-auth-clients") && cfg.String("tls-auth-clients") != "no" {
+		return errors.New("tls-auth-clients requires tls-ca-cert-file")
+	}
+	return nil
+}
+
+// watchConfig collega i parametri modificabili con CONFIG SET allo stato del server
+func (s *PodCacheServer) watchConfig() {
+	s.config.OnChange("requirepass", func(value string) error {
+		rules := []string{"resetpass", "nopass"}
+		if value != "" {
+			rules = []string{"resetpass", ">" + value}
+		}
+		return s.acl.setUser(defaultUser, rules)
+	})
+	s.config.OnChange("timeout", func(string) error {
+		s.idleTimeout.Store(int64(s.configSeconds("timeout")))
+		return nil
+	})
+	s.config.OnChange("client-output-buffer-limit", func(value string) error {
+		limits, err := parseOutputLimits(value, defaultOutputLimits)
+		if err != nil {
+			return err
+		}
+		s.outputLimits.Store(&limits)
+		return nil
+	})
+	s.config.OnChange("busy-reply-threshold", func(string) error {
+		s.scripting.setTimeLimit(time.Duration(s.config.Int("busy-reply-threshold")) * time.Millisecond)
+		return nil
+	})
+	s.config.OnChange("slowlog-log-slower-than", func(string) error {
+		s.slowlog.slowerThan.Store(s.config.Int("slowlog-log-slower-than"))
+		return nil
+	})
+	s.config.OnChange("slowlog-max-len", func(string) error {
+		s.slowlog.setMaxLen(s.config.Int("slowlog-max-len"))
+		return nil
+	})
+	s.config.OnChange("latency-monitor-threshold", func(string) error {
+		s.cache.LatencyMonitor().SetThreshold(time.Duration(s.config.Int("latency-monitor-threshold")) * time.Millisecond)
+		return nil
+	})
+	s.config.OnChange("tracing-sample-ratio", func(value string) error {
+		ratio, _ := strconv.ParseFloat(value, 64)
+		s.tracer.SetSampleRatio(ratio)
+		return nil
+	})
+	s.config.OnChange("notify-keyspace-events", func(value string) error {
+		flags, err := parseKeyspaceEvents(value)
+		if err != nil {
+			return err
+		}
+		s.notifyFlags.Store(flags)
+		return nil
+	})
+}
+
+// configSeconds restituisce un parametro espresso in secondi come durata
+func (s *PodCacheServer) configSeconds(name string) time.Duration {
+	return time.Duration(s.config.Int(name)) * time.Second
+}
+
+// handleConfig implementa CONFIG GET, SET, REWRITE e RESETSTAT
+func (s *PodCacheServer) handleConfig(client *Client, args []string) error {
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if len(args) < 2 {
+			return client.sendError("wrong number of arguments for 'config|get' command")
+		}
+		return s.handleConfigGet(client, args[1:])
+	case "SET":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return client.sendError("wrong number of arguments for 'config|set' command")
+		}
+		return s.handleConfigSet(client, args[1:])
+	case "REWRITE":
+		if err := s.config.Rewrite(); err != nil {
+			if errors.Is(err, config.ErrNoFile) {
+				return client.sendError(err.Error())
+			}
+			s.logger.Error("CONFIG REWRITE failed", "file", s.config.File(), "error", err)
+			return client.sendError("Rewriting config file: " + err.Error())
+		}
+		s.logger.Info("Configuration rewritten", "file", s.config.File())
+		return client.sendOK("OK")
+	case "RESETSTAT":
+		s.stats.reset()
+		s.cache.ResetStats()
+		return client.sendOK("OK")
+	default:
+		return client.sendError(fmt.Sprintf("unknown subcommand '%s'. Try CONFIG HELP.", args[0]))
+	}
+}
+
+// handleConfigGet risponde con i parametri che corrispondono ad almeno un pattern
+func (s *PodCacheServer) handleConfigGet(client *Client, patterns []string) error {
+	seen := make(map[string]bool)
+	var entries []config.Entry
+	for _, pattern := range patterns {
+		for _, entry := range s.config.Match(pattern) {
+			if !seen[entry.Name] {
+				seen[entry.Name] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+	client.writeMap(len(entries))
+	for _, entry := range entries {
+		client.writeBulk(entry.Name)
+		client.writeBulk(entry.Value)
+	}
+	return client.flush()
+}
+
+// handleConfigSet applica tutte le coppie o nessuna
+func (s *PodCacheServer) handleConfigSet(client *Client, args []string) error {
+	changes := make([]config.Entry, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		changes = append(changes, config.Entry{Name: args[i], Value: args[i+1]})
+	}
+	if err := s.config.Set(changes); err != nil {
+		var cfgErr *config.Error
+		if !errors.As(err, &cfgErr) {
+			return client.sendError(err.Error())
+		}
+		if errors.Is(err, config.ErrUnknown) {
+			return client.sendError(fmt.Sprintf("Unknown option or number of arguments for CONFIG SET - '%s'", cfgErr.Name))
+		}
+		return client.sendError(fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - %s", cfgErr.Name, cfgErr.Err))
+	}
+	for _, change := range changes {
+		s.logger.Info("Configuration changed", "parameter", strings.ToLower(change.Name))
+	}
+	return client.sendOK("OK")
+}
+
+// configFile è il percorso assoluto del file di configurazione, per INFO server
+func (s *PodCacheServer) configFile() string {
+	path := s.config.File()
+	if path == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
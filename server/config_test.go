@@ -0,0 +1,110 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigGet(t *testing.T) {
+	c := newTestClient(t, newTestServer(t, "slowlog-max-len", "64"))
+
+	// le coppie nome, valore sono ordinate per nome
+	if got := c.array("CONFIG", "GET", "slowlog-*"); strings.Join(got, " ") != "slowlog-log-slower-than 10000 slowlog-max-len 64" {
+		t.Fatalf("CONFIG GET slowlog-* = %q", got)
+	}
+	// un parametro che corrisponde a più pattern compare una sola volta
+	if got := c.array("CONFIG", "GET", "maxclients", "maxc*"); strings.Join(got, " ") != "maxclients 10000" {
+		t.Fatalf("CONFIG GET maxclients maxc* = %q", got)
+	}
+	if got := c.array("CONFIG", "GET", "LUA-TIME-LIMIT"); strings.Join(got, " ") != "lua-time-limit 5000" {
+		t.Fatalf("CONFIG GET of an alias = %q", got)
+	}
+	if got := c.array("CONFIG", "GET", "nosuch*"); len(got) != 0 {
+		t.Fatalf("CONFIG GET nosuch* = %q, want an empty list", got)
+	}
+	c.fails("ERR wrong number of arguments for 'config|get' command", "CONFIG", "GET")
+	c.fails("ERR unknown subcommand 'BOGUS'. Try CONFIG HELP.", "CONFIG", "BOGUS")
+}
+
+func TestConfigSet(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	c.ok("CONFIG", "SET", "maxclients", "50", "slowlog-max-len", "10")
+	if got := c.array("CONFIG", "GET", "maxclients", "slowlog-max-len"); strings.Join(got, " ") != "maxclients 50 slowlog-max-len 10" {
+		t.Fatalf("CONFIG GET after SET = %q", got)
+	}
+
+	// un parametro non valido annulla l'intera SET
+	c.fails("ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config", "CONFIG", "SET", "maxclients", "60", "port", "7000")
+	c.fails("ERR CONFIG SET failed (possibly related to argument 'maxclients') - argument couldn't be parsed into an integer", "CONFIG", "SET", "slowlog-max-len", "20", "maxclients", "x")
+	c.fails("ERR Unknown option or number of arguments for CONFIG SET - 'bogus'", "CONFIG", "SET", "bogus", "1")
+	c.fails("ERR CONFIG SET failed (possibly related to argument 'maxclients') - duplicate parameter", "CONFIG", "SET", "maxclients", "1", "maxclients", "2")
+	if got := c.array("CONFIG", "GET", "maxclients", "slowlog-max-len"); strings.Join(got, " ") != "maxclients 50 slowlog-max-len 10" {
+		t.Fatalf("CONFIG GET after rejected SETs = %q, want the values of the last SET", got)
+	}
+	c.fails("ERR wrong number of arguments for 'config|set' command", "CONFIG", "SET", "maxclients")
+}
+
+func TestConfigRewrite(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+	c.fails("ERR The server is running without a config file", "CONFIG", "REWRITE")
+
+	// requirepass arriva da un file segreto indicato nell'ambiente
+	secret := filepath.Join(t.TempDir(), "requirepass")
+	if err := os.WriteFile(secret, []byte("envsecret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PODCACHE_REQUIREPASS_FILE", secret)
+	t.Setenv("PODCACHE_TIMEOUT", "30")
+	s, path := newFileTestServer(t, "# podcache\nmaxclients 100\n")
+	c = newTestClient(t, s)
+	c.ok("AUTH", "envsecret")
+
+	c.ok("CONFIG", "SET", "maxclients", "50", "slowlog-max-len", "10")
+	c.ok("CONFIG", "REWRITE")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# podcache\nmaxclients 50\n\n# Generated by CONFIG REWRITE\nslowlog-max-len 10\n"
+	if string(data) != want {
+		t.Fatalf("rewritten file:\n%s\nwant:\n%s", data, want)
+	}
+
+	// un valore cambiato con CONFIG SET viene scritto anche se veniva dall'ambiente
+	c.ok("CONFIG", "SET", "requirepass", "setsecret")
+	c.ok("CONFIG", "REWRITE")
+	data, _ = os.ReadFile(path)
+	if got := string(data); !strings.Contains(got, "requirepass setsecret\n") || strings.Contains(got, "envsecret") || strings.Contains(got, "timeout") {
+		t.Fatalf("rewritten file after CONFIG SET requirepass:\n%s", got)
+	}
+}
+
+func TestConfigResetStat(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	c.ok("SET", "k", "v")
+	c.bulk("GET", "k")
+	c.do("GET", "missing")
+	c.fails("ERR", "NOSUCHCOMMAND")
+	c.ok("CONFIG", "RESETSTAT")
+
+	_, info := infoReply(c, "stats", "commandstats", "errorstats")
+	for _, name := range []string{"keyspace_hits", "keyspace_misses", "total_error_replies"} {
+		if got := info["stats"][name]; got != "0" {
+			t.Errorf("%s after CONFIG RESETSTAT = %s, want 0", name, got)
+		}
+	}
+	// un comando è contato dopo l'esecuzione, quindi resta solo RESETSTAT
+	if got := info["stats"]["total_commands_processed"]; got != "1" {
+		t.Errorf("total_commands_processed after CONFIG RESETSTAT = %s, want 1", got)
+	}
+	if got, ok := info["commandstats"]["cmdstat_get"]; ok && !strings.HasPrefix(got, "calls=0,") {
+		t.Errorf("cmdstat_get after CONFIG RESETSTAT = %q, want no calls", got)
+	}
+	if len(info["errorstats"]) != 0 {
+		t.Errorf("errorstats after CONFIG RESETSTAT = %v, want none", info["errorstats"])
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+)
+
+// handleSelect cambia il DB del client; dentro MULTI o in uno script cambia
+// anche la vista con i lock già acquisiti, così i comandi successivi la usano
+func (s *PodCacheServer) handleSelect(client *Client, args []string) error {
+	if len(args) != 1 {
+		return client.sendError("wrong number of arguments for 'select' command")
+	}
+	db, err := strconv.Atoi(args[0])
+	if err != nil {
+		return client.sendError("value is not an integer or out of range")
+	}
+	view, err := client.view.Select(db)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	client.view = view
+	if client.txView != nil {
+		client.txView, _ = client.txView.Select(db)
+	}
+	return client.sendOK("OK")
+}
+
+func (s *PodCacheServer) handleSwapDB(client *Client, args []string) error {
+	if len(args) != 2 {
+		return client.sendError("wrong number of arguments for 'swapdb' command")
+	}
+	a, err := strconv.Atoi(args[0])
+	if err != nil {
+		return client.sendError("invalid first DB index")
+	}
+	b, err := strconv.Atoi(args[1])
+	if err != nil {
+		return client.sendError("invalid second DB index")
+	}
+	if err := s.db(client).SwapDB(a, b); err != nil {
+		return client.sendCacheError(err)
+	}
+	// i client bloccati su chiavi dei due DB ricontrollano i dati scambiati
+	s.waiters.signalDB(a)
+	s.waiters.signalDB(b)
+	return client.sendOK("OK")
+}
+
+// handleMove sposta una chiave nel DB indicato e risponde 1, oppure 0 se la
+// chiave non esiste o esiste già nel DB di destinazione
+func (s *PodCacheServer) handleMove(client *Client, args []string) error {
+	if len(args) != 2 {
+		return client.sendError("wrong number of arguments for 'move' command")
+	}
+	db, err := strconv.Atoi(args[1])
+	if err != nil {
+		return client.sendError("value is not an integer or out of range")
+	}
+	moved, err := s.db(client).Move(args[0], db)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	if moved {
+		s.waiters.signal(db, args[0])
+		return client.sendInteger(1)
+	}
+	return client.sendInteger(0)
+}
+
+// handleFlush implementa FLUSHDB e FLUSHALL [ASYNC|SYNC]; la rimozione è
+// sempre sincrona
+func (s *PodCacheServer) handleFlush(client *Client, args []string, all bool) error {
+	if len(args) > 1 {
+		name := "flushdb"
+		if all {
+			name = "flushall"
+		}
+		return client.sendError("wrong number of arguments for '" + name + "' command")
+	}
+	if len(args) == 1 {
+		mode := strings.ToUpper(args[0])
+		if mode != "ASYNC" && mode != "SYNC" {
+			return client.sendError("syntax error")
+		}
+	}
+
+	var err error
+	if all {
+		err = s.db(client).FlushAll()
+	} else {
+		err = s.db(client).FlushDB()
+	}
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	return client.sendOK("OK")
+}
+
+func (s *PodCacheServer) handleDBSize(client *Client) error {
+	return client.sendInteger(s.db(client).DBSize())
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"mi0772/podcache/resp"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	c.fails("ERR DB index is out of range", "SELECT", "16")
+	c.fails("ERR DB index is out of range", "SELECT", "-1")
+	c.fails("ERR value is not an integer or out of range", "SELECT", "x")
+
+	// un SELECT rifiutato lascia il client sul DB corrente
+	c.ok("SET", "k", "db0")
+	if v := c.bulk("GET", "k"); v != "db0" {
+		t.Fatalf("GET k after a rejected SELECT = %q, want db0", v)
+	}
+	c.ok("SELECT", "15")
+	if reply := c.do("GET", "k"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("GET k in DB 15 = %+v, want nil", reply)
+	}
+}
+
+func TestFlushDBAndFlushAll(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	c.ok("SET", "a", "1")
+	c.ok("SET", "b", "2")
+	c.ok("SELECT", "1")
+	c.ok("SET", "a", "1")
+
+	// FLUSHDB svuota solo il DB del client
+	c.ok("FLUSHDB")
+	if n := c.integer("DBSIZE"); n != 0 {
+		t.Fatalf("DBSIZE after FLUSHDB = %d, want 0", n)
+	}
+	c.ok("SELECT", "0")
+	if n := c.integer("DBSIZE"); n != 2 {
+		t.Fatalf("DBSIZE of DB 0 after FLUSHDB in DB 1 = %d, want 2", n)
+	}
+
+	c.ok("SELECT", "1")
+	c.ok("SET", "a", "1")
+	c.ok("FLUSHALL", "SYNC")
+	for _, db := range []string{"0", "1"} {
+		c.ok("SELECT", db)
+		if n := c.integer("DBSIZE"); n != 0 {
+			t.Fatalf("DBSIZE of DB %s after FLUSHALL = %d, want 0", db, n)
+		}
+	}
+	c.fails("ERR syntax error", "FLUSHDB", "LATER")
+	c.fails("ERR wrong number of arguments for 'flushall' command", "FLUSHALL", "ASYNC", "SYNC")
+}
+
+func TestSwapDB(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	other := dialTest(t, "tcp", c.conn.RemoteAddr().String())
+
+	c.ok("SET", "k", "db0")
+	other.ok("SELECT", "1")
+	other.ok("SET", "k", "db1")
+	other.ok("SET", "only1", "x")
+
+	// SWAPDB invalida le chiavi in WATCH di entrambi i DB
+	other.ok("WATCH", "k")
+	c.ok("SWAPDB", "0", "1")
+	other.ok("MULTI")
+	other.queued("GET", "k")
+	if reply := other.do("EXEC"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("EXEC after SWAPDB = %+v, want a null array", reply)
+	}
+
+	// i client connessi vedono subito i dati dell'altro DB
+	if v := c.bulk("GET", "k"); v != "db1" {
+		t.Fatalf("GET k in DB 0 after SWAPDB = %q, want db1", v)
+	}
+	if v := other.bulk("GET", "k"); v != "db0" {
+		t.Fatalf("GET k in DB 1 after SWAPDB = %q, want db0", v)
+	}
+	if n := c.integer("DBSIZE"); n != 2 {
+		t.Fatalf("DBSIZE of DB 0 after SWAPDB = %d, want 2", n)
+	}
+
+	c.fails("ERR DB index is out of range", "SWAPDB", "0", "16")
+	c.fails("ERR invalid first DB index", "SWAPDB", "x", "1")
+	c.fails("ERR invalid second DB index", "SWAPDB", "0", "x")
+}
+
+func TestSwapDBWakesBlockedClients(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	blocked := dialTest(t, "tcp", c.conn.RemoteAddr().String())
+
+	c.ok("SELECT", "1")
+	c.bulk("XADD", "s", "1-1", "f", "v")
+
+	// lo stream compare nel DB 0 senza scritture sulla chiave
+	blocked.send("XREAD", "BLOCK", "0", "STREAMS", "s", "0-0")
+	blocked.silent()
+	c.ok("SWAPDB", "0", "1")
+	reply := blocked.read()
+	if reply.Kind != resp.ReplyArray || len(reply.Elements) != 1 || reply.Elements[0].Elements[0].Str != "s" {
+		t.Fatalf("XREAD after SWAPDB = %+v, want the entries of s", reply)
+	}
+	if entries := reply.Elements[0].Elements[1].Elements; len(entries) != 1 || entries[0].Elements[0].Str != "1-1" {
+		t.Fatalf("XREAD entries after SWAPDB = %+v, want [1-1]", entries)
+	}
+}
+
+func TestMove(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+
+	c.ok("SET", "k", "v")
+	if n := c.integer("MOVE", "k", "1"); n != 1 {
+		t.Fatalf("MOVE = %d, want 1", n)
+	}
+	if reply := c.do("GET", "k"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("GET k after MOVE = %+v, want nil", reply)
+	}
+	if n := c.integer("MOVE", "k", "1"); n != 0 {
+		t.Fatalf("MOVE of a missing key = %d, want 0", n)
+	}
+
+	// una chiave già presente nella destinazione non viene sovrascritta
+	c.ok("SET", "k", "other")
+	if n := c.integer("MOVE", "k", "1"); n != 0 {
+		t.Fatalf("MOVE onto an existing key = %d, want 0", n)
+	}
+	if v := c.bulk("GET", "k"); v != "other" {
+		t.Fatalf("GET k after a refused MOVE = %q, want other", v)
+	}
+	c.ok("SELECT", "1")
+	if v := c.bulk("GET", "k"); v != "v" {
+		t.Fatalf("GET k in DB 1 = %q, want v", v)
+	}
+
+	c.fails("ERR source and destination objects are the same", "MOVE", "k", "1")
+	c.fails("ERR DB index is out of range", "MOVE", "k", "16")
+	c.fails("ERR value is not an integer or out of range", "MOVE", "k", "x")
+}
+
+func TestMoveParts(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+
+	// stream e insiemi geo sono memorizzati in parti che seguono la chiave
+	for i := 1; i <= 300; i++ {
+		c.bulk("XADD", "s", "*", "n", "v")
+	}
+	c.integer("GEOADD", "g", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+	if n := c.integer("MOVE", "s", "2"); n != 1 {
+		t.Fatalf("MOVE s = %d, want 1", n)
+	}
+	if n := c.integer("MOVE", "g", "2"); n != 1 {
+		t.Fatalf("MOVE g = %d, want 1", n)
+	}
+	if n := c.integer("DBSIZE"); n != 0 {
+		t.Fatalf("DBSIZE of DB 0 after MOVE = %d, want 0", n)
+	}
+
+	c.ok("SELECT", "2")
+	if n := c.integer("XLEN", "s"); n != 300 {
+		t.Fatalf("XLEN s in DB 2 = %d, want 300", n)
+	}
+	if entries := c.do("XRANGE", "s", "-", "+"); len(entries.Elements) != 300 {
+		t.Fatalf("XRANGE s in DB 2 returned %d entries, want 300", len(entries.Elements))
+	}
+	if n := c.integer("ZCARD", "g"); n != 2 {
+		t.Fatalf("ZCARD g in DB 2 = %d, want 2", n)
+	}
+	reply := c.do("GEOPOS", "g", "Palermo", "Catania")
+	if len(reply.Elements) != 2 || reply.Elements[0].Kind == resp.ReplyNil || reply.Elements[1].Kind == resp.ReplyNil {
+		t.Fatalf("GEOPOS in DB 2 = %+v, want both positions", reply)
+	}
+
+	// il DB di origine non conserva parti: una nuova chiave omonima parte vuota
+	c.ok("SELECT", "0")
+	c.bulk("XADD", "s", "*", "n", "v")
+	if n := c.integer("XLEN", "s"); n != 1 {
+		t.Fatalf("XLEN of a new s in DB 0 = %d, want 1", n)
+	}
+}
@@ -0,0 +1,639 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mi0772/podcache/config"
+	"mi0772/podcache/logging"
+	"mi0772/podcache/lua"
+	"mi0772/podcache/util"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// functionChunk è il nome del chunk delle librerie nei messaggi d'errore
+const functionChunk = "user_function"
+
+// functionLoadTimeout limita l'esecuzione del codice di primo livello di una libreria
+const functionLoadTimeout = 500 * time.Millisecond
+
+// functionsFile è il file delle librerie nella radice di dir: la
+// sottodirectory dei dati su disco cambia a ogni avvio, questo file no
+const functionsFile = "functions.json"
+
+// functionFlags sono i flag accettati da register_function come in Redis;
+// solo no-writes cambia il comportamento
+var functionFlags = map[string]bool{
+	"no-writes":             true,
+	"allow-oom":             true,
+	"allow-stale":           true,
+	"no-cluster":            true,
+	"allow-cross-slot-keys": true,
+}
+
+var errFunctionLoadTimeout = errors.New("FUNCTION LOAD timeout")
+
+// scriptFunction è una funzione registrata da una libreria
+type scriptFunction struct {
+	name        string
+	description string
+	flags       []string
+	library     *functionLibrary
+}
+
+func (f *scriptFunction) readOnly() bool {
+	return slices.Contains(f.flags, "no-writes")
+}
+
+// functionLibrary è una libreria caricata con FUNCTION LOAD: il chunk viene
+// rieseguito a ogni FCALL per ottenere la callback in un interprete nuovo
+type functionLibrary struct {
+	name      string
+	code      string
+	chunk     *lua.Closure
+	functions []*scriptFunction
+}
+
+// functionDump è il payload di FUNCTION DUMP/RESTORE e il formato del file su disco
+type functionDump struct {
+	Version   int      `json:"version"`
+	Libraries []string `json:"libraries"`
+}
+
+// functionRegistry conserva le librerie per nome e le funzioni per nome
+// globale; ogni modifica viene riscritta su disco
+type functionRegistry struct {
+	path   string
+	logger logging.Logger
+
+	mu        sync.RWMutex
+	libraries map[string]*functionLibrary
+	functions map[string]*scriptFunction
+}
+
+func newFunctionRegistry(path string, logger logging.Logger) *functionRegistry {
+	return &functionRegistry{
+		path:      path,
+		logger:    logger,
+		libraries: make(map[string]*functionLibrary),
+		functions: make(map[string]*scriptFunction),
+	}
+}
+
+// getFunctionsPath restituisce il percorso del file delle librerie
+func getFunctionsPath(cfg *config.Registry) string {
+	return filepath.Join(cfg.String("dir"), functionsFile)
+}
+
+func validFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLibraryHeader legge la riga "#!lua name=<libreria>" in testa al codice
+func parseLibraryHeader(code string) (string, error) {
+	if !strings.HasPrefix(code, "#!") {
+		return "", errors.New("Missing library metadata")
+	}
+	line, _, _ := strings.Cut(code[2:], "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", errors.New("Missing library metadata")
+	}
+	if !strings.EqualFold(fields[0], "lua") {
+		return "", fmt.Errorf("Engine '%s' not found", fields[0])
+	}
+	var name string
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key != "name" {
+			return "", fmt.Errorf("Invalid metadata value given: %s", field)
+		}
+		name = value
+	}
+	if name == "" {
+		return "", errors.New("Library name was not given")
+	}
+	if !validFunctionName(name) {
+		return "", errors.New("Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, nil
+}
+
+// compileLibrary compila la libreria e ne esegue il chunk per raccogliere le
+// funzioni registrate; in questa fase redis.call non è disponibile
+func compileLibrary(code string, logger logging.Logger) (*functionLibrary, error) {
+	name, err := parseLibraryHeader(code)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := lua.Compile(code, functionChunk)
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling function: %s", err)
+	}
+
+	state := lua.NewState()
+	state.OpenLibs()
+	state.Strict = true
+	deadline := time.Now().Add(functionLoadTimeout)
+	state.Interrupt = func() error {
+		if time.Now().After(deadline) {
+			return errFunctionLoadTimeout
+		}
+		return nil
+	}
+	redis := lua.NewTable()
+	lua.Register(redis, map[string]func(*lua.State, []lua.Value) ([]lua.Value, error){
+		"log": scriptLog(logger),
+	})
+	setLogLevels(redis)
+	state.SetGlobal("redis", redis)
+
+	functions, _, err := registerFunctions(state, redis, chunk)
+	if err != nil {
+		if errors.Is(err, errFunctionLoadTimeout) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Error registering functions: %s", err)
+	}
+	if len(functions) == 0 {
+		return nil, errors.New("No functions registered")
+	}
+
+	lib := &functionLibrary{name: name, code: code, chunk: chunk, functions: functions}
+	for _, fn := range functions {
+		fn.library = lib
+	}
+	return lib, nil
+}
+
+// registerFunctions esegue il chunk con redis.register_function e restituisce
+// le funzioni registrate e le loro callback; a chunk eseguito
+// register_function viene rimossa, come in Redis
+func registerFunctions(state *lua.State, redis *lua.Table, chunk *lua.Closure) ([]*scriptFunction, map[string]lua.Value, error) {
+	var functions []*scriptFunction
+	callbacks := make(map[string]lua.Value)
+
+	lua.Register(redis, map[string]func(*lua.State, []lua.Value) ([]lua.Value, error){
+		"register_function": func(_ *lua.State, args []lua.Value) ([]lua.Value, error) {
+			fn, callback, err := parseRegisterFunction(args)
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := callbacks[fn.name]; exists {
+				return nil, errors.New("Function already exists in the library")
+			}
+			functions = append(functions, fn)
+			callbacks[fn.name] = callback
+			return nil, nil
+		},
+	})
+	defer redis.Set("register_function", nil)
+
+	if _, err := state.Call(chunk, nil); err != nil {
+		return nil, nil, err
+	}
+	return functions, callbacks, nil
+}
+
+// parseRegisterFunction accetta sia register_function(nome, callback) sia la
+// forma con tabella {function_name=, callback=, flags=, description=}
+func parseRegisterFunction(args []lua.Value) (*scriptFunction, lua.Value, error) {
+	var (
+		fn       = &scriptFunction{}
+		callback lua.Value
+		ok       bool
+	)
+	switch len(args) {
+	case 2:
+		if fn.name, ok = args[0].(string); !ok {
+			return nil, nil, errors.New("first argument to redis.register_function must be a string")
+		}
+		callback = args[1]
+	case 1:
+		t, isTable := args[0].(*lua.Table)
+		if !isTable {
+			return nil, nil, errors.New("calling redis.register_function with a single argument is only applicable to Lua table (representing named arguments).")
+		}
+		var key, value lua.Value
+		for {
+			var err error
+			if key, value, err = t.Next(key); err != nil {
+				return nil, nil, err
+			}
+			if key == nil {
+				break
+			}
+			switch key {
+			case "function_name":
+				if fn.name, ok = value.(string); !ok {
+					return nil, nil, errors.New("function_name argument given to redis.register_function must be a string")
+				}
+			case "description":
+				if fn.description, ok = value.(string); !ok {
+					return nil, nil, errors.New("description argument given to redis.register_function must be a string")
+				}
+			case "callback":
+				callback = value
+			case "flags":
+				flags, isTable := value.(*lua.Table)
+				if !isTable {
+					return nil, nil, errors.New("flags argument to redis.register_function must be a table representing function flags")
+				}
+				for i := 1; i <= flags.Len(); i++ {
+					flag, isString := flags.Get(float64(i)).(string)
+					if !isString || !functionFlags[flag] {
+						return nil, nil, errors.New("unknown flag given")
+					}
+					if !slices.Contains(fn.flags, flag) {
+						fn.flags = append(fn.flags, flag)
+					}
+				}
+			default:
+				return nil, nil, errors.New("unknown argument given to redis.register_function")
+			}
+		}
+	default:
+		return nil, nil, errors.New("wrong number of arguments to redis.register_function")
+	}
+
+	if !validFunctionName(fn.name) {
+		return nil, nil, errors.New("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	switch callback.(type) {
+	case *lua.Closure, *lua.GoFunction:
+	default:
+		return nil, nil, errors.New("callback argument given to redis.register_function must be a function")
+	}
+	return fn, callback, nil
+}
+
+// install aggiunge la libreria alle mappe date; in caso d'errore le mappe
+// restano sporche, per questo i chiamanti lavorano su una copia
+func install(libraries map[string]*functionLibrary, functions map[string]*scriptFunction, lib *functionLibrary, replace bool) error {
+	if old, exists := libraries[lib.name]; exists {
+		if !replace {
+			return fmt.Errorf("Library '%s' already exists", lib.name)
+		}
+		for _, fn := range old.functions {
+			delete(functions, fn.name)
+		}
+		delete(libraries, lib.name)
+	}
+	for _, fn := range lib.functions {
+		if _, exists := functions[fn.name]; exists {
+			return fmt.Errorf("Function %s already exists", fn.name)
+		}
+	}
+	libraries[lib.name] = lib
+	for _, fn := range lib.functions {
+		functions[fn.name] = fn
+	}
+	return nil
+}
+
+// update applica change a una copia delle mappe e, se riesce, la sostituisce
+// e la salva su disco
+func (r *functionRegistry) update(change func(libraries map[string]*functionLibrary, functions map[string]*scriptFunction) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	libraries := make(map[string]*functionLibrary, len(r.libraries))
+	for name, lib := range r.libraries {
+		libraries[name] = lib
+	}
+	functions := make(map[string]*scriptFunction, len(r.functions))
+	for name, fn := range r.functions {
+		functions[name] = fn
+	}
+	if err := change(libraries, functions); err != nil {
+		return err
+	}
+	r.libraries, r.functions = libraries, functions
+	r.save()
+	return nil
+}
+
+func (r *functionRegistry) load(lib *functionLibrary, replace bool) error {
+	return r.update(func(libraries map[string]*functionLibrary, functions map[string]*scriptFunction) error {
+		return install(libraries, functions, lib, replace)
+	})
+}
+
+func (r *functionRegistry) delete(name string) error {
+	return r.update(func(libraries map[string]*functionLibrary, functions map[string]*scriptFunction) error {
+		lib, exists := libraries[name]
+		if !exists {
+			return errors.New("Library not found")
+		}
+		for _, fn := range lib.functions {
+			delete(functions, fn.name)
+		}
+		delete(libraries, name)
+		return nil
+	})
+}
+
+func (r *functionRegistry) flush() {
+	r.update(func(libraries map[string]*functionLibrary, functions map[string]*scriptFunction) error {
+		clear(libraries)
+		clear(functions)
+		return nil
+	})
+}
+
+func (r *functionRegistry) function(name string) *scriptFunction {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.functions[name]
+}
+
+// list restituisce le librerie il cui nome corrisponde al pattern, ordinate per nome
+func (r *functionRegistry) list(pattern string) []*functionLibrary {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var libs []*functionLibrary
+	for name, lib := range r.libraries {
+		if pattern == "" || util.GlobMatch(pattern, name) {
+			libs = append(libs, lib)
+		}
+	}
+	sort.Slice(libs, func(i, j int) bool { return libs[i].name < libs[j].name })
+	return libs
+}
+
+// dump serializza il codice delle librerie
+func (r *functionRegistry) dump() []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.encode()
+}
+
+// encode produce il payload di dump; va chiamata con il lock preso
+func (r *functionRegistry) encode() []byte {
+	dump := functionDump{Version: 1, Libraries: []string{}}
+	names := make([]string, 0, len(r.libraries))
+	for name := range r.libraries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dump.Libraries = append(dump.Libraries, r.libraries[name].code)
+	}
+	payload, _ := json.Marshal(dump)
+	return payload
+}
+
+// parseDump ricompila le librerie di un payload di dump
+func parseDump(payload []byte, logger logging.Logger) ([]*functionLibrary, error) {
+	var dump functionDump
+	if err := json.Unmarshal(payload, &dump); err != nil || dump.Version != 1 {
+		return nil, errors.New("payload version or checksum are wrong")
+	}
+	libs := make([]*functionLibrary, 0, len(dump.Libraries))
+	for _, code := range dump.Libraries {
+		lib, err := compileLibrary(code, logger)
+		if err != nil {
+			return nil, err
+		}
+		libs = append(libs, lib)
+	}
+	return libs, nil
+}
+
+// restore carica le librerie di un dump secondo la policy FLUSH, APPEND o REPLACE
+func (r *functionRegistry) restore(payload []byte, policy string) error {
+	libs, err := parseDump(payload, r.logger)
+	if err != nil {
+		return err
+	}
+	return r.update(func(libraries map[string]*functionLibrary, functions map[string]*scriptFunction) error {
+		if policy == "FLUSH" {
+			clear(libraries)
+			clear(functions)
+		}
+		for _, lib := range libs {
+			if err := install(libraries, functions, lib, policy == "REPLACE"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// save riscrive il file delle librerie; va chiamata con il lock preso
+func (r *functionRegistry) save() {
+	payload := r.encode()
+
+	// scrittura su file temporaneo e rename, per non lasciare un file troncato
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		r.logger.Error("Failed to save functions", "path", r.path, "error", err)
+		return
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, payload, 0o644); err != nil {
+		r.logger.Error("Failed to save functions", "path", r.path, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		r.logger.Error("Failed to save functions", "path", r.path, "error", err)
+	}
+}
+
+// loadFromDisk ricarica le librerie salvate all'avvio precedente
+func (r *functionRegistry) loadFromDisk() {
+	payload, err := os.ReadFile(r.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			r.logger.Error("Failed to read functions", "path", r.path, "error", err)
+		}
+		return
+	}
+	libs, err := parseDump(payload, r.logger)
+	if err != nil {
+		r.logger.Error("Failed to load functions", "path", r.path, "error", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, lib := range libs {
+		if err := install(r.libraries, r.functions, lib, false); err != nil {
+			r.logger.Error("Failed to load functions", "path", r.path, "error", err)
+		}
+	}
+	r.logger.Info("Functions loaded", "path", r.path, "libraries", len(r.libraries))
+}
+
+// handleFunction implementa FUNCTION LOAD|LIST|DELETE|DUMP|RESTORE|FLUSH|KILL
+func (s *PodCacheServer) handleFunction(client *Client, args []string) error {
+	if len(args) == 0 {
+		return client.sendError("wrong number of arguments for 'function' command")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "LOAD":
+		replace := false
+		rest := args[1:]
+		if len(rest) == 2 && strings.ToUpper(rest[0]) == "REPLACE" {
+			replace = true
+			rest = rest[1:]
+		}
+		if len(rest) != 1 {
+			return client.sendError("wrong number of arguments for 'function|load' command")
+		}
+		lib, err := compileLibrary(rest[0], s.logger)
+		if err != nil {
+			return client.sendError(sanitizeLine(err.Error()))
+		}
+		if err := s.functions.load(lib, replace); err != nil {
+			return client.sendError(err.Error())
+		}
+		return client.sendBulkString(lib.name)
+	case "LIST":
+		return s.handleFunctionList(client, args[1:])
+	case "DELETE":
+		if len(args) != 2 {
+			return client.sendError("wrong number of arguments for 'function|delete' command")
+		}
+		if err := s.functions.delete(args[1]); err != nil {
+			return client.sendError(err.Error())
+		}
+		return client.sendOK("OK")
+	case "DUMP":
+		if len(args) != 1 {
+			return client.sendError("wrong number of arguments for 'function|dump' command")
+		}
+		client.writeBulk(string(s.functions.dump()))
+		return client.flush()
+	case "RESTORE":
+		if len(args) < 2 || len(args) > 3 {
+			return client.sendError("wrong number of arguments for 'function|restore' command")
+		}
+		policy := "APPEND"
+		if len(args) == 3 {
+			policy = strings.ToUpper(args[2])
+			if policy != "FLUSH" && policy != "APPEND" && policy != "REPLACE" {
+				return client.sendError("Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+			}
+		}
+		if err := s.functions.restore([]byte(args[1]), policy); err != nil {
+			return client.sendError(sanitizeLine(err.Error()))
+		}
+		return client.sendOK("OK")
+	case "FLUSH":
+		if len(args) > 2 {
+			return client.sendError("wrong number of arguments for 'function|flush' command")
+		}
+		if len(args) == 2 {
+			mode := strings.ToUpper(args[1])
+			if mode != "ASYNC" && mode != "SYNC" {
+				return client.sendError("FUNCTION FLUSH only supports SYNC|ASYNC option")
+			}
+		}
+		s.functions.flush()
+		return client.sendOK("OK")
+	case "KILL":
+		if len(args) != 1 {
+			return client.sendError("wrong number of arguments for 'function|kill' command")
+		}
+		return s.killScripts(client)
+	default:
+		return client.sendError(fmt.Sprintf("unknown subcommand '%s'. Try FUNCTION HELP.", args[0]))
+	}
+}
+
+// handleFunctionList implementa FUNCTION LIST [WITHCODE] [LIBRARYNAME pattern]
+func (s *PodCacheServer) handleFunctionList(client *Client, args []string) error {
+	withCode := false
+	pattern := ""
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "WITHCODE":
+			withCode = true
+		case "LIBRARYNAME":
+			if i+1 >= len(args) {
+				return client.sendError("library name argument was not given")
+			}
+			i++
+			pattern = args[i]
+		default:
+			return client.sendError("Unknown argument " + args[i])
+		}
+	}
+
+	libs := s.functions.list(pattern)
+	client.writeArray(len(libs))
+	for _, lib := range libs {
+		fields := 3
+		if withCode {
+			fields++
+		}
+		client.writeMap(fields)
+		client.writeBulk("library_name")
+		client.writeBulk(lib.name)
+		client.writeBulk("engine")
+		client.writeBulk("LUA")
+		client.writeBulk("functions")
+		client.writeArray(len(lib.functions))
+		for _, fn := range lib.functions {
+			client.writeMap(3)
+			client.writeBulk("name")
+			client.writeBulk(fn.name)
+			client.writeBulk("description")
+			if fn.description == "" {
+				client.writeNullBulk()
+			} else {
+				client.writeBulk(fn.description)
+			}
+			client.writeBulk("flags")
+			client.writeArray(len(fn.flags))
+			for _, flag := range fn.flags {
+				client.writeBulk(flag)
+			}
+		}
+		if withCode {
+			client.writeBulk("library_code")
+			client.writeBulk(lib.code)
+		}
+	}
+	return client.flush()
+}
+
+// handleFcall implementa FCALL e FCALL_RO function numkeys [key ...] [arg ...]
+func (s *PodCacheServer) handleFcall(client *Client, args []string, readOnly bool) error {
+	keys, argv, err := parseNumKeys(args)
+	if err != nil {
+		return client.sendError(err.Error())
+	}
+	fn := s.functions.function(args[0])
+	if fn == nil {
+		return client.sendError("Function not found")
+	}
+	if readOnly && !fn.readOnly() {
+		return client.sendError("Can not execute a script with write flag using *_ro command.")
+	}
+
+	return s.runScript(client, keys, fn.readOnly(), func(state *lua.State) ([]lua.Value, error) {
+		redis, _ := state.GetGlobal("redis").(*lua.Table)
+		_, callbacks, err := registerFunctions(state, redis, fn.library.chunk)
+		if err != nil {
+			return nil, err
+		}
+		return state.Call(callbacks[fn.name], []lua.Value{stringsTable(keys), stringsTable(argv)})
+	})
+}
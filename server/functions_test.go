@@ -0,0 +1,69 @@
+package server
+
+import (
+	"mi0772/podcache/resp"
+	"testing"
+)
+
+const testLibrary = `#!lua name=counters
+redis.register_function('incr_by', function(keys, args)
+  return redis.call('INCRBY', keys[1], args[1])
+end)
+redis.register_function{function_name='peek', callback=function(keys)
+  return redis.call('GET', keys[1])
+end, flags={'no-writes'}}
+`
+
+func TestFunctionLoadAndCall(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestClient(t, newTestServer(t, "dir", dir))
+
+	if name := c.bulk("FUNCTION", "LOAD", testLibrary); name != "counters" {
+		t.Fatalf("FUNCTION LOAD = %q, want counters", name)
+	}
+	c.fails("ERR Library 'counters' already exists", "FUNCTION", "LOAD", testLibrary)
+	c.bulk("FUNCTION", "LOAD", "REPLACE", testLibrary)
+	c.fails("ERR Missing library metadata", "FUNCTION", "LOAD", "return 1")
+
+	if got := c.integer("FCALL", "incr_by", "1", "hits", "5"); got != 5 {
+		t.Fatalf("FCALL incr_by = %d, want 5", got)
+	}
+	if got := c.bulk("FCALL_RO", "peek", "1", "hits"); got != "5" {
+		t.Fatalf("FCALL_RO peek = %q, want 5", got)
+	}
+	c.fails("ERR Can not execute a script with write flag using *_ro command.", "FCALL_RO", "incr_by", "1", "hits", "1")
+	c.fails("ERR Function not found", "FCALL", "missing", "0")
+
+	// le librerie salvate in dir sono ricaricate all'avvio
+	restarted := newTestClient(t, newTestServer(t, "dir", dir))
+	if got := restarted.integer("FCALL", "incr_by", "1", "hits", "2"); got != 2 {
+		t.Fatalf("FCALL after restart = %d, want 2", got)
+	}
+}
+
+func TestFunctionDumpRestore(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+	c.bulk("FUNCTION", "LOAD", testLibrary)
+	dump := c.bulk("FUNCTION", "DUMP")
+
+	c.ok("FUNCTION", "FLUSH")
+	c.fails("ERR Function not found", "FCALL", "incr_by", "1", "k", "1")
+
+	c.ok("FUNCTION", "RESTORE", dump)
+	if got := c.integer("FCALL", "incr_by", "1", "k", "3"); got != 3 {
+		t.Fatalf("FCALL after RESTORE = %d, want 3", got)
+	}
+	// APPEND, il default, rifiuta una libreria già presente; REPLACE la sostituisce
+	c.fails("ERR Library 'counters' already exists", "FUNCTION", "RESTORE", dump)
+	c.ok("FUNCTION", "RESTORE", dump, "REPLACE")
+	c.ok("FUNCTION", "RESTORE", dump, "FLUSH")
+	c.fails("ERR Wrong restore policy", "FUNCTION", "RESTORE", dump, "MERGE")
+	c.fails("ERR payload version or checksum are wrong", "FUNCTION", "RESTORE", dump[:len(dump)-1]+"x")
+
+	// un altro server riceve le stesse librerie dal dump
+	other := newTestClient(t, newTestServer(t))
+	other.ok("FUNCTION", "RESTORE", dump)
+	if reply := other.do("FCALL_RO", "peek", "1", "missing"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("FCALL_RO on a missing key = %+v, want nil", reply)
+	}
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mi0772/podcache/cache"
+	"strconv"
+	"strings"
+)
+
+var errGeoUnit = errors.New("unsupported unit provided. please use M, KM, FT, MI")
+
+// geoUnit restituisce il fattore di conversione dall'unità indicata ai metri
+func geoUnit(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	default:
+		return 0, errGeoUnit
+	}
+}
+
+func parseFloat(value string) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, errors.New("value is not a valid float")
+	}
+	return f, nil
+}
+
+func formatCoordinate(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func formatDistance(meters, unit float64) string {
+	return fmt.Sprintf("%.4f", meters/unit)
+}
+
+func (s *PodCacheServer) handleGeoAdd(client *Client, args []string) error {
+	if len(args) < 4 {
+		return client.sendError("wrong number of arguments for 'geoadd' command")
+	}
+
+	key := args[0]
+	var nx, xx, ch bool
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+			continue
+		case "XX":
+			xx = true
+			continue
+		case "CH":
+			ch = true
+			continue
+		}
+		break
+	}
+	if nx && xx {
+		return client.sendError("XX and NX options at the same time are not compatible")
+	}
+
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%3 != 0 {
+		return client.sendError(errSyntax.Error())
+	}
+
+	members := make([]cache.GeoMember, 0, len(rest)/3)
+	for j := 0; j < len(rest); j += 3 {
+		longitude, err := parseFloat(rest[j])
+		if err != nil {
+			return client.sendError(err.Error())
+		}
+		latitude, err := parseFloat(rest[j+1])
+		if err != nil {
+			return client.sendError(err.Error())
+		}
+		members = append(members, cache.GeoMember{
+			Member: rest[j+2],
+			Point:  cache.GeoPoint{Longitude: longitude, Latitude: latitude},
+		})
+	}
+
+	added, err := s.db(client).GeoAdd(key, members, nx, xx, ch)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	return client.sendInteger(added)
+}
+
+func (s *PodCacheServer) handleGeoPos(client *Client, args []string) error {
+	if len(args) < 1 {
+		return client.sendError("wrong number of arguments for 'geopos' command")
+	}
+
+	positions, err := s.db(client).GeoPos(args[0], args[1:]...)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+
+	client.writeArray(len(positions))
+	for _, p := range positions {
+		if p == nil {
+			client.writeNullArray()
+			continue
+		}
+		client.writeArray(2)
+		client.writeBulk(formatCoordinate(p.Longitude))
+		client.writeBulk(formatCoordinate(p.Latitude))
+	}
+	return client.flush()
+}
+
+func (s *PodCacheServer) handleGeoDist(client *Client, args []string) error {
+	if len(args) != 3 && len(args) != 4 {
+		return client.sendError("wrong number of arguments for 'geodist' command")
+	}
+
+	unit := 1.0
+	if len(args) == 4 {
+		var err error
+		if unit, err = geoUnit(args[3]); err != nil {
+			return client.sendError(err.Error())
+		}
+	}
+
+	distance, found, err := s.db(client).GeoDist(args[0], args[1], args[2])
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	if !found {
+		return client.sendNullBulkString()
+	}
+	return client.sendBulkString(formatDistance(distance, unit))
+}
+
+func (s *PodCacheServer) handleGeoHash(client *Client, args []string) error {
+	if len(args) < 1 {
+		return client.sendError("wrong number of arguments for 'geohash' command")
+	}
+
+	hashes, err := s.db(client).GeoHash(args[0], args[1:]...)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+
+	client.writeArray(len(hashes))
+	for _, h := range hashes {
+		if h == "" {
+			client.writeNullBulk()
+			continue
+		}
+		client.writeBulk(h)
+	}
+	return client.flush()
+}
+
+// geoSearchOptions è il risultato del parsing di GEOSEARCH/GEOSEARCHSTORE
+type geoSearchOptions struct {
+	query     cache.GeoQuery
+	unit      float64
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	storeDist bool
+}
+
+func parseGeoSearch(args []string, store bool) (geoSearchOptions, error) {
+	opts := geoSearchOptions{unit: 1}
+	q := &opts.query
+	var hasFrom, hasBy bool
+
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch strings.ToUpper(args[i]) {
+		case "FROMMEMBER":
+			if remaining < 1 || hasFrom {
+				return opts, errSyntax
+			}
+			q.UseMember = true
+			q.FromMember = args[i+1]
+			hasFrom = true
+			i++
+		case "FROMLONLAT":
+			if remaining < 2 || hasFrom {
+				return opts, errSyntax
+			}
+			longitude, err := parseFloat(args[i+1])
+			if err != nil {
+				return opts, err
+			}
+			latitude, err := parseFloat(args[i+2])
+			if err != nil {
+				return opts, err
+			}
+			q.Center = cache.GeoPoint{Longitude: longitude, Latitude: latitude}
+			if err := cache.ValidateGeoPoint(q.Center); err != nil {
+				return opts, err
+			}
+			hasFrom = true
+			i += 2
+		case "BYRADIUS":
+			if remaining < 2 || hasBy {
+				return opts, errSyntax
+			}
+			radius, err := parseFloat(args[i+1])
+			if err != nil {
+				return opts, err
+			}
+			if radius < 0 {
+				return opts, errors.New("radius cannot be negative")
+			}
+			if opts.unit, err = geoUnit(args[i+2]); err != nil {
+				return opts, err
+			}
+			q.Radius = radius * opts.unit
+			hasBy = true
+			i += 2
+		case "BYBOX":
+			if remaining < 3 || hasBy {
+				return opts, errSyntax
+			}
+			width, err := parseFloat(args[i+1])
+			if err != nil {
+				return opts, err
+			}
+			height, err := parseFloat(args[i+2])
+			if err != nil {
+				return opts, err
+			}
+			if width < 0 || height < 0 {
+				return opts, errors.New("height or width cannot be negative")
+			}
+			if opts.unit, err = geoUnit(args[i+3]); err != nil {
+				return opts, err
+			}
+			q.ByBox = true
+			q.Width = width * opts.unit
+			q.Height = height * opts.unit
+			hasBy = true
+			i += 3
+		case "ASC":
+			q.Sort = cache.GeoSortAsc
+		case "DESC":
+			q.Sort = cache.GeoSortDesc
+		case "COUNT":
+			if remaining < 1 {
+				return opts, errSyntax
+			}
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil || count <= 0 {
+				return opts, errors.New("COUNT must be > 0")
+			}
+			q.Count = count
+			i++
+			if i+1 < len(args) && strings.ToUpper(args[i+1]) == "ANY" {
+				q.Any = true
+				i++
+			}
+		case "WITHCOORD", "WITHDIST", "WITHHASH":
+			if store {
+				return opts, errSyntax
+			}
+			switch strings.ToUpper(args[i]) {
+			case "WITHCOORD":
+				opts.withCoord = true
+			case "WITHDIST":
+				opts.withDist = true
+			default:
+				opts.withHash = true
+			}
+		case "STOREDIST":
+			if !store {
+				return opts, errSyntax
+			}
+			opts.storeDist = true
+		default:
+			return opts, errSyntax
+		}
+	}
+
+	if !hasFrom {
+		return opts, errors.New("exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	}
+	if !hasBy {
+		return opts, errors.New("exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	}
+	return opts, nil
+}
+
+func (s *PodCacheServer) handleGeoSearch(client *Client, args []string) error {
+	if len(args) < 1 {
+		return client.sendError("wrong number of arguments for 'geosearch' command")
+	}
+
+	opts, err := parseGeoSearch(args[1:], false)
+	if err != nil {
+		return client.sendError(err.Error())
+	}
+
+	results, err := s.db(client).GeoSearch(args[0], opts.query)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+
+	extra := boolToInt(opts.withCoord) + boolToInt(opts.withDist) + boolToInt(opts.withHash)
+	client.writeArray(len(results))
+	for _, r := range results {
+		if extra == 0 {
+			client.writeBulk(r.Member)
+			continue
+		}
+		client.writeArray(1 + extra)
+		client.writeBulk(r.Member)
+		if opts.withDist {
+			client.writeBulk(formatDistance(r.Distance, opts.unit))
+		}
+		if opts.withHash {
+			client.writeInt(int64(r.Hash))
+		}
+		if opts.withCoord {
+			client.writeArray(2)
+			client.writeBulk(formatCoordinate(r.Point.Longitude))
+			client.writeBulk(formatCoordinate(r.Point.Latitude))
+		}
+	}
+	return client.flush()
+}
+
+func (s *PodCacheServer) handleGeoSearchStore(client *Client, args []string) error {
+	if len(args) < 2 {
+		return client.sendError("wrong number of arguments for 'geosearchstore' command")
+	}
+
+	opts, err := parseGeoSearch(args[2:], true)
+	if err != nil {
+		return client.sendError(err.Error())
+	}
+
+	stored, err := s.db(client).GeoSearchStore(args[0], args[1], opts.query, opts.storeDist, opts.unit)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	return client.sendInteger(stored)
+}
+
+func (s *PodCacheServer) handleZRem(client *Client, args []string) error {
+	if len(args) < 2 {
+		return client.sendError("wrong number of arguments for 'zrem' command")
+	}
+
+	removed, err := s.db(client).ZRem(args[0], args[1:]...)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	return client.sendInteger(removed)
+}
+
+func (s *PodCacheServer) handleZCard(client *Client, args []string) error {
+	if len(args) != 1 {
+		return client.sendError("wrong number of arguments for 'zcard' command")
+	}
+
+	card, err := s.db(client).ZCard(args[0])
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	return client.sendInteger(card)
+}
@@ -0,0 +1,44 @@
+package server
+
+func (s *PodCacheServer) handlePFAdd(client *Client, args []string) error {
+	if len(args) < 1 {
+		return client.sendError("wrong number of arguments for 'pfadd' command")
+	}
+
+	elements := make([][]byte, 0, len(args)-1)
+	for _, element := range args[1:] {
+		elements = append(elements, []byte(element))
+	}
+
+	updated, err := s.db(client).PFAdd(args[0], elements...)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	if updated {
+		return client.sendInteger(1)
+	}
+	return client.sendInteger(0)
+}
+
+func (s *PodCacheServer) handlePFCount(client *Client, args []string) error {
+	if len(args) < 1 {
+		return client.sendError("wrong number of arguments for 'pfcount' command")
+	}
+
+	count, err := s.db(client).PFCount(args...)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	return client.sendInteger(int(count))
+}
+
+func (s *PodCacheServer) handlePFMerge(client *Client, args []string) error {
+	if len(args) < 1 {
+		return client.sendError("wrong number of arguments for 'pfmerge' command")
+	}
+
+	if err := s.db(client).PFMerge(args[0], args[1:]...); err != nil {
+		return client.sendCacheError(err)
+	}
+	return client.sendOK("OK")
+}
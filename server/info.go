@@ -0,0 +1,270 @@
+package server
+
+import (
+	"fmt"
+	"mi0772/podcache/cache"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// redisCompatVersion è la versione di Redis riportata in redis_version, per i
+// client e gli exporter che abilitano funzioni in base a quella
+const redisCompatVersion = "7.2.0"
+
+// infoSection è una sezione di INFO: scrive le proprie righe "campo:valore"
+type infoSection struct {
+	name  string
+	write func(s *PodCacheServer, b *infoBuilder)
+}
+
+// infoSections elenca le sezioni nell'ordine di INFO all; come in Redis
+// commandstats non fa parte di quelle di default
+var infoSections = []infoSection{
+	{"server", (*PodCacheServer).infoServer},
+	{"clients", (*PodCacheServer).infoClients},
+	{"memory", (*PodCacheServer).infoMemory},
+	{"persistence", (*PodCacheServer).infoPersistence},
+	{"stats", (*PodCacheServer).infoStats},
+	{"replication", (*PodCacheServer).infoReplication},
+	{"cpu", (*PodCacheServer).infoCPU},
+	{"commandstats", (*PodCacheServer).infoCommandStats},
+	{"errorstats", (*PodCacheServer).infoErrorStats},
+	{"keyspace", (*PodCacheServer).infoKeyspace},
+	{"tiers", (*PodCacheServer).infoTiers},
+}
+
+func isDefaultInfoSection(name string) bool {
+	return name != "commandstats"
+}
+
+type infoBuilder struct {
+	strings.Builder
+}
+
+func (b *infoBuilder) field(name string, value any) {
+	fmt.Fprintf(b, "%s:%v\r\n", name, value)
+}
+
+// bytesToHuman formatta una dimensione come used_memory_human di Redis
+func bytesToHuman(n uint64) string {
+	value := float64(n)
+	for _, unit := range []string{"B", "K", "M", "G", "T"} {
+		if value < 1024 || unit == "T" {
+			if unit == "B" {
+				return fmt.Sprintf("%dB", n)
+			}
+			return fmt.Sprintf("%.2f%s", value, unit)
+		}
+		value /= 1024
+	}
+	return ""
+}
+
+// handleInfo implementa INFO [section ...]: default, all, everything o nomi di sezione
+func (s *PodCacheServer) handleInfo(client *Client, args []string) error {
+	wanted := make(map[string]bool)
+	all, defaults := false, len(args) == 0
+	for _, arg := range args {
+		switch name := strings.ToLower(arg); name {
+		case "all", "everything":
+			all = true
+		case "default":
+			defaults = true
+		default:
+			wanted[name] = true
+		}
+	}
+
+	var b infoBuilder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] && !(defaults && isDefaultInfoSection(section.name)) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.ToUpper(section.name[:1])+section.name[1:])
+		section.write(s, &b)
+	}
+	// una sezione sconosciuta dà una stringa vuota, non nil
+	client.writeBulk(b.String())
+	return client.writer.Flush()
+}
+
+func (s *PodCacheServer) infoServer(b *infoBuilder) {
+	uptime := time.Since(s.stats.started)
+	executable, _ := os.Executable()
+	b.field("redis_version", redisCompatVersion)
+	b.field("podcache_version", Version)
+	b.field("redis_mode", "standalone")
+	b.field("os", runtime.GOOS+" "+runtime.GOARCH)
+	b.field("arch_bits", 32<<(^uint(0)>>63))
+	b.field("go_version", runtime.Version())
+	b.field("process_id", os.Getpid())
+	b.field("run_id", s.stats.runID)
+	b.field("tcp_port", s.port)
+	b.field("server_time_usec", time.Now().UnixMicro())
+	b.field("uptime_in_seconds", int64(uptime.Seconds()))
+	b.field("uptime_in_days", int64(uptime.Hours()/24))
+	b.field("executable", executable)
+	b.field("config_file", s.configFile())
+}
+
+func (s *PodCacheServer) infoClients(b *infoBuilder) {
+	clients := s.clients.all()
+	blocked, pubsub := 0, 0
+	for _, c := range clients {
+		if c.blocked.Load() {
+			blocked++
+		}
+		if state := c.state.Load(); state != nil && state.sub+state.psub > 0 {
+			pubsub++
+		}
+	}
+	tracking, _, _ := s.tracking.counts()
+	b.field("connected_clients", s.connected.Load())
+	b.field("maxclients", s.config.Int("maxclients"))
+	b.field("blocked_clients", blocked)
+	b.field("tracking_clients", tracking)
+	b.field("pubsub_clients", pubsub)
+}
+
+func (s *PodCacheServer) infoMemory(b *infoBuilder) {
+	stats := s.cache.Stats()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	// used_memory è la memoria dei valori nelle partizioni RAM, quella su cui
+	// si applica maxmemory; il runtime Go è riportato a parte
+	b.field("used_memory", stats.Used)
+	b.field("used_memory_human", bytesToHuman(stats.Used))
+	b.field("used_memory_rss", mem.Sys)
+	b.field("used_memory_rss_human", bytesToHuman(mem.Sys))
+	b.field("maxmemory", stats.Capacity)
+	b.field("maxmemory_human", bytesToHuman(stats.Capacity))
+	b.field("maxmemory_policy", s.cache.EvictionPolicy())
+	b.field("go_heap_alloc", mem.HeapAlloc)
+	b.field("go_heap_sys", mem.HeapSys)
+	b.field("go_num_gc", mem.NumGC)
+	b.field("go_goroutines", runtime.NumGoroutine())
+}
+
+func (s *PodCacheServer) infoPersistence(b *infoBuilder) {
+	stats := s.cache.Stats()
+	// PodCache non ha snapshot né AOF: i valori che non stanno in RAM vanno sul
+	// disco, che non sopravvive al riavvio
+	b.field("loading", 0)
+	b.field("rdb_changes_since_last_save", 0)
+	b.field("rdb_bgsave_in_progress", 0)
+	b.field("rdb_last_save_time", s.stats.started.Unix())
+	b.field("aof_enabled", 0)
+	b.field("disk_tier_keys", stats.Disk.Entries)
+	b.field("disk_tier_bytes", stats.Disk.Used)
+}
+
+func (s *PodCacheServer) infoStats(b *infoBuilder) {
+	stats := s.cache.Stats()
+	// gli hit e i miss sono quelli delle partizioni RAM: una lettura servita dal
+	// disco conta come miss della RAM
+	var hits, misses uint64
+	for _, p := range stats.Partitions {
+		hits += p.Hits
+		misses += p.Misses
+	}
+	channels, patterns := s.pubsub.counts()
+	_, trackedKeys, trackedPrefixes := s.tracking.counts()
+	b.field("total_connections_received", s.stats.connectionsReceived.Load())
+	b.field("total_commands_processed", s.stats.commandsProcessed.Load())
+	b.field("rejected_connections", s.stats.rejectedConnections.Load())
+	b.field("keyspace_hits", hits)
+	b.field("keyspace_misses", misses)
+	b.field("pubsub_channels", channels)
+	b.field("pubsub_patterns", patterns)
+	b.field("tracking_total_keys", trackedKeys)
+	b.field("tracking_total_prefixes", trackedPrefixes)
+	b.field("total_error_replies", s.stats.errorReplies.Load())
+}
+
+func (s *PodCacheServer) infoReplication(b *infoBuilder) {
+	b.field("role", "master")
+	b.field("connected_slaves", 0)
+	b.field("master_replid", s.stats.runID)
+	b.field("master_repl_offset", 0)
+}
+
+func (s *PodCacheServer) infoCPU(b *infoBuilder) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return
+	}
+	seconds := func(tv syscall.Timeval) string {
+		return fmt.Sprintf("%.6f", float64(tv.Sec)+float64(tv.Usec)/1e6)
+	}
+	b.field("used_cpu_sys", seconds(usage.Stime))
+	b.field("used_cpu_user", seconds(usage.Utime))
+}
+
+func (s *PodCacheServer) infoCommandStats(b *infoBuilder) {
+	names := make([]string, 0, len(s.stats.commands))
+	for name := range s.stats.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stat := s.stats.commands[name]
+		calls, rejected := stat.calls.Load(), stat.rejected.Load()
+		if calls == 0 && rejected == 0 {
+			continue
+		}
+		usec := stat.usec.Load()
+		perCall := 0.0
+		if calls > 0 {
+			perCall = float64(usec) / float64(calls)
+		}
+		b.field("cmdstat_"+name, fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+			calls, usec, perCall, rejected, stat.failed.Load()))
+	}
+}
+
+func (s *PodCacheServer) infoErrorStats(b *infoBuilder) {
+	codes, counts := s.stats.errorCounts()
+	for _, code := range codes {
+		b.field("errorstat_"+code, fmt.Sprintf("count=%d", counts[code]))
+	}
+}
+
+func (s *PodCacheServer) infoKeyspace(b *infoBuilder) {
+	for _, db := range s.cache.Keyspace() {
+		b.field(fmt.Sprintf("db%d", db.DB), fmt.Sprintf("keys=%d,expires=0,avg_ttl=0", db.Keys))
+	}
+}
+
+// infoTiers è la sezione propria di PodCache: uso di ogni partizione RAM e del disco
+func (s *PodCacheServer) infoTiers(b *infoBuilder) {
+	stats := s.cache.Stats()
+	b.field("partitions", len(stats.Partitions))
+	for i, p := range stats.Partitions {
+		b.field(fmt.Sprintf("partition_%d", i), partitionInfo(p))
+	}
+	if r := stats.Rehash; r != nil {
+		b.field("rehashing", 1)
+		b.field("rehash_from_partitions", r.From)
+		b.field("rehash_keys_moved", r.Moved)
+		b.field("rehash_keys_total", r.Total)
+		b.field("rehash_keys_failed", r.Failed)
+	} else {
+		b.field("rehashing", 0)
+	}
+	b.field("rehash_keys_dropped", stats.RehashDropped)
+	b.field("disk_keys", stats.Disk.Entries)
+	b.field("disk_bytes", stats.Disk.Used)
+	b.field("disk_bytes_human", bytesToHuman(stats.Disk.Used))
+}
+
+func partitionInfo(p cache.PartitionStats) string {
+	return fmt.Sprintf("keys=%d,used=%d,capacity=%d,free=%d,hits=%d,misses=%d,hit_ratio=%.4f",
+		p.Entries, p.Used, p.Capacity, p.Free, p.Hits, p.Misses, p.HitRatio)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// infoReply esegue INFO e ne restituisce le sezioni, nell'ordine, con i rispettivi campi
+func infoReply(c *testClient, args ...string) ([]string, map[string]map[string]string) {
+	c.t.Helper()
+	var names []string
+	sections := make(map[string]map[string]string)
+	var current map[string]string
+	for _, line := range strings.Split(c.bulk(append([]string{"INFO"}, args...)...), "\r\n") {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# "):
+			name := strings.ToLower(line[2:])
+			names = append(names, name)
+			current = make(map[string]string)
+			sections[name] = current
+		default:
+			name, value, ok := strings.Cut(line, ":")
+			if !ok || current == nil {
+				c.t.Fatalf("INFO line %q is not a field of a section", line)
+			}
+			current[name] = value
+		}
+	}
+	return names, sections
+}
+
+func TestInfoSections(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	names, _ := infoReply(c)
+	want := "server clients memory persistence stats replication cpu errorstats keyspace tiers"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("INFO sections = %q, want %q", got, want)
+	}
+	if names, _ = infoReply(c, "default"); strings.Join(names, " ") != want {
+		t.Errorf("INFO default sections = %v, want %q", names, want)
+	}
+
+	for _, arg := range []string{"all", "everything", "ALL"} {
+		names, _ = infoReply(c, arg)
+		if len(names) != len(infoSections) || names[7] != "commandstats" {
+			t.Errorf("INFO %s sections = %v, want all %d", arg, names, len(infoSections))
+		}
+	}
+
+	if names, _ = infoReply(c, "server"); strings.Join(names, " ") != "server" {
+		t.Errorf("INFO server sections = %v, want [server]", names)
+	}
+	// le sezioni escono nell'ordine di INFO all, non in quello richiesto
+	if names, _ = infoReply(c, "Keyspace", "server", "commandstats"); strings.Join(names, " ") != "server commandstats keyspace" {
+		t.Errorf("INFO keyspace server commandstats sections = %v", names)
+	}
+	if names, _ = infoReply(c, "default", "commandstats"); len(names) != len(infoSections) {
+		t.Errorf("INFO default commandstats sections = %v, want all %d", names, len(infoSections))
+	}
+
+	if got := c.bulk("INFO", "bogus"); got != "" {
+		t.Errorf("INFO bogus = %q, want an empty string", got)
+	}
+}
+
+func TestInfoFields(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+	other := dialTest(t, "tcp", c.conn.RemoteAddr().String())
+	other.do("PING")
+
+	_, info := infoReply(c, "server", "clients", "memory", "stats", "replication", "tiers")
+	for section, fields := range map[string][]string{
+		"server":      {"redis_version", "process_id", "run_id", "tcp_port", "uptime_in_seconds"},
+		"clients":     {"connected_clients", "maxclients", "blocked_clients", "pubsub_clients"},
+		"memory":      {"used_memory", "maxmemory", "maxmemory_policy"},
+		"stats":       {"total_connections_received", "total_commands_processed", "rejected_connections", "keyspace_hits", "keyspace_misses", "total_error_replies"},
+		"replication": {"role"},
+		"tiers":       {"partitions", "partition_0", "partition_1", "rehashing", "rehash_keys_dropped", "disk_keys", "disk_bytes"},
+	} {
+		for _, name := range fields {
+			if _, ok := info[section][name]; !ok {
+				t.Errorf("INFO %s has no %s field", section, name)
+			}
+		}
+	}
+	if got := info["server"]["redis_version"]; got != redisCompatVersion {
+		t.Errorf("redis_version = %q, want %q", got, redisCompatVersion)
+	}
+	if got := info["clients"]["connected_clients"]; got != "2" {
+		t.Errorf("connected_clients = %q, want 2", got)
+	}
+	if got := info["replication"]["role"]; got != "master" {
+		t.Errorf("role = %q, want master", got)
+	}
+	if got := info["tiers"]["partitions"]; got != "2" {
+		t.Errorf("partitions = %q, want 2", got)
+	}
+	if got := info["tiers"]["partition_0"]; !strings.HasPrefix(got, "keys=0,used=0,capacity=") {
+		t.Errorf("partition_0 = %q, want an empty partition", got)
+	}
+}
+
+func TestInfoCounters(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	c.ok("SET", "key", "value")
+	c.fails("WRONGTYPE", "PFADD", "key", "x")
+	_, before := infoReply(c, "stats")
+	c.bulk("GET", "key")
+	c.do("GET", "missing")
+	c.fails("ERR", "NOSUCHCOMMAND")
+	_, after := infoReply(c, "stats", "commandstats", "errorstats")
+
+	count := func(section map[string]string, name string) int {
+		n, err := strconv.Atoi(section[name])
+		if err != nil {
+			t.Fatalf("%s = %q, want a number", name, section[name])
+		}
+		return n
+	}
+	for name, delta := range map[string]int{"keyspace_hits": 1, "keyspace_misses": 1, "total_error_replies": 1} {
+		if got := count(after["stats"], name) - count(before["stats"], name); got != delta {
+			t.Errorf("%s grew by %d, want %d", name, got, delta)
+		}
+	}
+	if got := count(after["stats"], "total_commands_processed") - count(before["stats"], "total_commands_processed"); got < 3 {
+		t.Errorf("total_commands_processed grew by %d, want at least 3", got)
+	}
+
+	if got := after["commandstats"]["cmdstat_set"]; !strings.HasPrefix(got, "calls=1,") {
+		t.Errorf("cmdstat_set = %q, want calls=1", got)
+	}
+	if got := after["commandstats"]["cmdstat_get"]; !strings.HasPrefix(got, "calls=2,") {
+		t.Errorf("cmdstat_get = %q, want calls=2", got)
+	}
+	if got := after["commandstats"]["cmdstat_pfadd"]; !strings.HasSuffix(got, ",failed_calls=1") {
+		t.Errorf("cmdstat_pfadd = %q, want failed_calls=1", got)
+	}
+	if got := after["errorstats"]["errorstat_WRONGTYPE"]; got != "count=1" {
+		t.Errorf("errorstat_WRONGTYPE = %q, want count=1", got)
+	}
+	if got := after["errorstats"]["errorstat_ERR"]; got != "count=1" {
+		t.Errorf("errorstat_ERR = %q, want count=1", got)
+	}
+}
+
+func TestInfoKeyspace(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	if _, info := infoReply(c, "keyspace"); len(info["keyspace"]) != 0 {
+		t.Errorf("INFO keyspace on an empty cache = %v, want no databases", info["keyspace"])
+	}
+
+	c.ok("SET", "a", "1")
+	c.ok("SET", "b", "1")
+	c.ok("SELECT", "2")
+	c.ok("SET", "a", "2")
+
+	_, info := infoReply(c, "keyspace")
+	want := map[string]string{"db0": "keys=2,expires=0,avg_ttl=0", "db2": "keys=1,expires=0,avg_ttl=0"}
+	if len(info["keyspace"]) != len(want) {
+		t.Errorf("INFO keyspace = %v, want %v", info["keyspace"], want)
+	}
+	for db, value := range want {
+		if got := info["keyspace"][db]; got != value {
+			t.Errorf("%s = %q, want %q", db, got, value)
+		}
+	}
+}
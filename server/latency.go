@@ -0,0 +1,224 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"mi0772/podcache/cache"
+	"mi0772/podcache/metrics"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Eventi registrati dal server nel latency monitor, come in Redis: i comandi
+// con flag fast separati dagli altri
+const (
+	latencyCommand     = "command"
+	latencyFastCommand = "fast-command"
+)
+
+func commandLatencyEvent(spec *commandSpec) string {
+	if spec.flags&flagFast != 0 {
+		return latencyFastCommand
+	}
+	return latencyCommand
+}
+
+// handleLatency implementa LATENCY LATEST, HISTORY, RESET, HISTOGRAM e DOCTOR
+func (s *PodCacheServer) handleLatency(client *Client, args []string) error {
+	monitor := s.cache.LatencyMonitor()
+	switch strings.ToUpper(args[0]) {
+	case "LATEST":
+		if len(args) != 1 {
+			return client.sendError("wrong number of arguments for 'latency|latest' command")
+		}
+		events := monitor.Latest()
+		client.writeArray(len(events))
+		for _, event := range events {
+			client.writeArray(4)
+			client.writeBulk(event.Name)
+			client.writeInt(event.Latest.Time.Unix())
+			client.writeInt(event.Latest.Latency.Milliseconds())
+			client.writeInt(event.Max.Milliseconds())
+		}
+		return client.flush()
+	case "HISTORY":
+		if len(args) != 2 {
+			return client.sendError("wrong number of arguments for 'latency|history' command")
+		}
+		samples := monitor.History(args[1])
+		client.writeArray(len(samples))
+		for _, sample := range samples {
+			client.writeArray(2)
+			client.writeInt(sample.Time.Unix())
+			client.writeInt(sample.Latency.Milliseconds())
+		}
+		return client.flush()
+	case "RESET":
+		return client.sendInteger(monitor.Reset(args[1:]...))
+	case "HISTOGRAM":
+		return s.sendLatencyHistogram(client, args[1:])
+	case "DOCTOR":
+		if len(args) != 1 {
+			return client.sendError("wrong number of arguments for 'latency|doctor' command")
+		}
+		client.writeBulk(latencyDoctor(monitor))
+		return client.flush()
+	default:
+		return client.sendError(fmt.Sprintf("unknown subcommand '%s'. Try LATENCY HELP.", args[0]))
+	}
+}
+
+// sendLatencyHistogram risponde a LATENCY HISTOGRAM con la distribuzione dei
+// tempi di esecuzione dei comandi indicati, o di tutti quelli eseguiti: per
+// ogni bucket il limite superiore in microsecondi e il conteggio cumulativo
+func (s *PodCacheServer) sendLatencyHistogram(client *Client, names []string) error {
+	var selected []string
+	if len(names) == 0 {
+		for name := range s.stats.commands {
+			selected = append(selected, name)
+		}
+	} else {
+		for _, name := range names {
+			selected = append(selected, strings.ToLower(name))
+		}
+	}
+	sort.Strings(selected)
+
+	type histogram struct {
+		name     string
+		snapshot metrics.Snapshot
+	}
+	var histograms []histogram
+	for i, name := range selected {
+		stat, ok := s.stats.commands[name]
+		if !ok || (i > 0 && selected[i-1] == name) {
+			continue
+		}
+		if snapshot := stat.latency.Snapshot(); snapshot.Count > 0 {
+			histograms = append(histograms, histogram{name, snapshot})
+		}
+	}
+
+	client.writeMap(len(histograms))
+	for _, h := range histograms {
+		client.writeBulk(h.name)
+		client.writeMap(2)
+		client.writeBulk("calls")
+		client.writeInt(int64(h.snapshot.Count))
+		client.writeBulk("histogram_usec")
+		// i bucket vuoti iniziali e quelli dopo l'ultima osservazione sono omessi
+		var bounds []int64
+		var counts []uint64
+		for i, bound := range h.snapshot.Bounds {
+			count := h.snapshot.Cumulative[i]
+			if count == 0 {
+				continue
+			}
+			bounds = append(bounds, int64(math.Round(bound*1e6)))
+			counts = append(counts, count)
+			if count == h.snapshot.Count {
+				break
+			}
+		}
+		if last := len(h.snapshot.Bounds); h.snapshot.Cumulative[last-1] < h.snapshot.Count {
+			// osservazioni oltre l'ultimo bucket: il limite è il doppio del precedente
+			bounds = append(bounds, int64(math.Round(h.snapshot.Bounds[last-1]*2e6)))
+			counts = append(counts, h.snapshot.Count)
+		}
+		client.writeMap(len(bounds))
+		for i := range bounds {
+			client.writeInt(bounds[i])
+			client.writeInt(int64(counts[i]))
+		}
+	}
+	return client.flush()
+}
+
+// latencyAdvice sono i consigli di LATENCY DOCTOR per ogni evento
+var latencyAdvice = map[string]string{
+	latencyCommand: "Some commands are slow. SLOWLOG GET WITHTIER shows which ones and whether they were served from RAM or from the disk tier; " +
+		"commands touching many keys, such as DEL or MGET with many arguments, FLUSHDB and scripts, hold the locks of all their keys while they run.",
+	latencyFastCommand: "Commands that are normally O(1) were slow. The process may be short of CPU or swapping, " +
+		"or these commands waited for the locks of keys held by slower commands.",
+	cache.LatencyDiskRead: "Keys were read from the disk tier. Raise maxmemory so the working set fits in RAM, " +
+		"or move the cache directory to faster storage.",
+	cache.LatencyDiskWrite: "Writes to the disk tier are slow. Check the storage of the cache directory.",
+	cache.LatencySpill: "Writes waited for the least recently used keys to be moved to disk to make room in RAM. " +
+		"Raise maxmemory, or set maxmemory-policy noeviction to reject writes instead of waiting.",
+	cache.LatencyShrink: "The shrink run every 5 minutes rebuilds the index of each RAM partition while holding its lock. " +
+		"More partitions make each run shorter.",
+}
+
+// latencyDoctor scrive il report di LATENCY DOCTOR: statistiche dei picchi
+// per evento, picchi dei comandi vicini a quelli della cache e consigli
+func latencyDoctor(monitor *metrics.LatencyMonitor) string {
+	threshold := monitor.Threshold()
+	if threshold == 0 {
+		return "Latency monitoring is disabled. Use CONFIG SET latency-monitor-threshold <milliseconds> to enable it.\n"
+	}
+	events := monitor.Latest()
+	if len(events) == 0 {
+		return fmt.Sprintf("No latency spikes of %dms or more were observed since the monitor was enabled or last reset.\n", threshold.Milliseconds())
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Latency spikes of %dms or more were observed:\n\n", threshold.Milliseconds())
+	histories := make(map[string][]metrics.LatencySample, len(events))
+	for i, event := range events {
+		samples := monitor.History(event.Name)
+		histories[event.Name] = samples
+		var sum time.Duration
+		for _, sample := range samples {
+			sum += sample.Latency
+		}
+		avg := sum / time.Duration(len(samples))
+		var deviation time.Duration
+		for _, sample := range samples {
+			deviation += (sample.Latency - avg).Abs()
+		}
+		deviation /= time.Duration(len(samples))
+		fmt.Fprintf(&b, "%d. %s: %d latency spikes (average %dms, mean deviation %dms", i+1, event.Name, len(samples), avg.Milliseconds(), deviation.Milliseconds())
+		if len(samples) > 1 {
+			period := samples[len(samples)-1].Time.Sub(samples[0].Time) / time.Duration(len(samples)-1)
+			fmt.Fprintf(&b, ", period %s", period)
+		}
+		fmt.Fprintf(&b, "). Worst all time event %dms.\n", event.Max.Milliseconds())
+	}
+
+	var correlations []string
+	for _, symptom := range []string{latencyCommand, latencyFastCommand} {
+		for _, cause := range []string{cache.LatencyShrink, cache.LatencySpill, cache.LatencyDiskRead, cache.LatencyDiskWrite} {
+			if n := correlatedSpikes(histories[symptom], histories[cause]); n > 0 {
+				correlations = append(correlations, fmt.Sprintf("- %d of %d %s spikes happened within a second of a %s spike.", n, len(histories[symptom]), symptom, cause))
+			}
+		}
+	}
+	if len(correlations) > 0 {
+		b.WriteString("\nCorrelated spikes:\n")
+		b.WriteString(strings.Join(correlations, "\n"))
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\nAdvice:\n")
+	for _, event := range events {
+		if advice, ok := latencyAdvice[event.Name]; ok {
+			fmt.Fprintf(&b, "- %s: %s\n", event.Name, advice)
+		}
+	}
+	return b.String()
+}
+
+// correlatedSpikes conta i picchi di symptom a non più di un secondo da un picco di cause
+func correlatedSpikes(symptom, cause []metrics.LatencySample) int {
+	n := 0
+	for _, s := range symptom {
+		for _, c := range cause {
+			if s.Time.Sub(c.Time).Abs() <= time.Second {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"mi0772/podcache/cache"
+	"mi0772/podcache/resp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyLatestHistoryReset(t *testing.T) {
+	s := newTestServer(t, "latency-monitor-threshold", "100")
+	c := newTestClient(t, s)
+	monitor := s.cache.LatencyMonitor()
+
+	if got := c.array("LATENCY", "LATEST"); len(got) != 0 {
+		t.Fatalf("LATENCY LATEST without spikes = %v, want empty", got)
+	}
+
+	// sotto la soglia non viene registrato nulla; nello stesso secondo resta il massimo
+	monitor.Add(latencyCommand, 50*time.Millisecond)
+	monitor.Add(latencyCommand, 300*time.Millisecond)
+	monitor.Add(latencyCommand, 200*time.Millisecond)
+	monitor.Add(cache.LatencyDiskRead, 150*time.Millisecond)
+
+	reply := c.do("LATENCY", "LATEST")
+	if reply.Kind != resp.ReplyArray || len(reply.Elements) != 2 {
+		t.Fatalf("LATENCY LATEST = %+v, want 2 events", reply)
+	}
+	// gli eventi sono in ordine di nome
+	command, disk := reply.Elements[0].Elements, reply.Elements[1].Elements
+	if command[0].Str != latencyCommand || disk[0].Str != cache.LatencyDiskRead {
+		t.Fatalf("LATENCY LATEST events = %q, %q", command[0].Str, disk[0].Str)
+	}
+	if at := time.Unix(command[1].Int, 0); time.Since(at) > time.Minute {
+		t.Errorf("latest %s time = %v, want now", latencyCommand, at)
+	}
+	if command[2].Int != 300 || command[3].Int != 300 {
+		t.Errorf("latest %s = %dms, max %dms, want 300, 300", latencyCommand, command[2].Int, command[3].Int)
+	}
+	if disk[2].Int != 150 || disk[3].Int != 150 {
+		t.Errorf("latest %s = %dms, max %dms, want 150, 150", cache.LatencyDiskRead, disk[2].Int, disk[3].Int)
+	}
+
+	reply = c.do("LATENCY", "HISTORY", latencyCommand)
+	if reply.Kind != resp.ReplyArray || len(reply.Elements) != 1 {
+		t.Fatalf("LATENCY HISTORY %s = %+v, want one sample", latencyCommand, reply)
+	}
+	if sample := reply.Elements[0].Elements; sample[0].Int != command[1].Int || sample[1].Int != 300 {
+		t.Errorf("LATENCY HISTORY sample = %+v, want %d 300", sample, command[1].Int)
+	}
+	if got := c.array("LATENCY", "HISTORY", "missing"); len(got) != 0 {
+		t.Errorf("LATENCY HISTORY missing = %v, want empty", got)
+	}
+
+	if got := c.integer("LATENCY", "RESET", cache.LatencyDiskRead, "missing"); got != 1 {
+		t.Errorf("LATENCY RESET %s missing = %d, want 1", cache.LatencyDiskRead, got)
+	}
+	if got := c.array("LATENCY", "HISTORY", cache.LatencyDiskRead); len(got) != 0 {
+		t.Errorf("LATENCY HISTORY after reset = %v, want empty", got)
+	}
+	monitor.Add(latencyFastCommand, 120*time.Millisecond)
+	if got := c.integer("LATENCY", "RESET"); got != 2 {
+		t.Errorf("LATENCY RESET = %d, want 2", got)
+	}
+	if got := c.array("LATENCY", "LATEST"); len(got) != 0 {
+		t.Errorf("LATENCY LATEST after reset = %v, want empty", got)
+	}
+
+	c.fails("ERR wrong number of arguments for 'latency|latest' command", "LATENCY", "LATEST", "x")
+	c.fails("ERR wrong number of arguments for 'latency|history' command", "LATENCY", "HISTORY")
+	c.fails("ERR wrong number of arguments for 'latency|doctor' command", "LATENCY", "DOCTOR", "x")
+	c.fails("ERR unknown subcommand 'BOGUS'", "LATENCY", "BOGUS")
+}
+
+func TestLatencyCommandEvents(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+
+	// con la soglia 0 il monitor è spento
+	c.do("EVAL", "local n = 0 for i = 1, 200000 do n = n + i end return n", "0")
+	if got := c.array("LATENCY", "LATEST"); len(got) != 0 {
+		t.Fatalf("LATENCY LATEST with the monitor disabled = %v, want empty", got)
+	}
+
+	c.ok("CONFIG", "SET", "latency-monitor-threshold", "1")
+	if got := s.cache.LatencyMonitor().Threshold(); got != time.Millisecond {
+		t.Fatalf("threshold after CONFIG SET = %v, want 1ms", got)
+	}
+	c.do("EVAL", "local n = 0 for i = 1, 200000 do n = n + i end return n", "0")
+	history := c.do("LATENCY", "HISTORY", latencyCommand)
+	if history.Kind != resp.ReplyArray || len(history.Elements) == 0 || history.Elements[len(history.Elements)-1].Elements[1].Int < 1 {
+		t.Fatalf("LATENCY HISTORY %s after a slow EVAL = %+v, want a spike", latencyCommand, history)
+	}
+}
+
+func TestLatencyDoctor(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	monitor := s.cache.LatencyMonitor()
+
+	if got := c.bulk("LATENCY", "DOCTOR"); !strings.HasPrefix(got, "Latency monitoring is disabled.") {
+		t.Errorf("LATENCY DOCTOR with threshold 0 = %q", got)
+	}
+
+	c.ok("CONFIG", "SET", "latency-monitor-threshold", "100")
+	if got := c.bulk("LATENCY", "DOCTOR"); !strings.HasPrefix(got, "No latency spikes of 100ms or more were observed") {
+		t.Errorf("LATENCY DOCTOR without spikes = %q", got)
+	}
+
+	monitor.Add(latencyCommand, 300*time.Millisecond)
+	monitor.Add(cache.LatencyDiskRead, 250*time.Millisecond)
+	report := c.bulk("LATENCY", "DOCTOR")
+	for _, want := range []string{
+		"Latency spikes of 100ms or more were observed:",
+		"1. command: 1 latency spikes (average 300ms, mean deviation 0ms). Worst all time event 300ms.",
+		"2. disk-read: 1 latency spikes (average 250ms, mean deviation 0ms). Worst all time event 250ms.",
+		"Correlated spikes:\n- 1 of 1 command spikes happened within a second of a disk-read spike.",
+		"- command: " + latencyAdvice[latencyCommand],
+		"- disk-read: " + latencyAdvice[cache.LatencyDiskRead],
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("LATENCY DOCTOR report has no %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, latencyFastCommand) {
+		t.Errorf("LATENCY DOCTOR report mentions %s without spikes:\n%s", latencyFastCommand, report)
+	}
+}
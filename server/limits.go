@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mi0772/podcache/config"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxClients   = 10000
+	defaultTCPKeepAlive = 300 * time.Second
+)
+
+// outputClass è la classe del client per client-output-buffer-limit
+type outputClass int
+
+const (
+	classNormal outputClass = iota
+	classReplica
+	classPubSub
+)
+
+var outputClassNames = [...]string{"normal", "replica", "pubsub"}
+
+// outputLimit è il limite di una classe: oltre hard, o sopra soft per più di
+// softDuration, il client viene disconnesso; zero disattiva il limite
+type outputLimit struct {
+	hard, soft   int
+	softDuration time.Duration
+}
+
+// outputLimits sono i limiti per classe; PodCache non ha repliche, il limite
+// replica è accettato per compatibilità con la configurazione di Redis
+type outputLimits [len(outputClassNames)]outputLimit
+
+// defaultOutputLimits è il default di Redis:
+// "normal 0 0 0 replica 256mb 64mb 60 pubsub 32mb 8mb 60"
+var defaultOutputLimits = outputLimits{
+	classNormal:  {},
+	classReplica: {hard: 256 << 20, soft: 64 << 20, softDuration: 60 * time.Second},
+	classPubSub:  {hard: 32 << 20, soft: 8 << 20, softDuration: 60 * time.Second},
+}
+
+// parseOutputLimits applica a base una lista "<classe> <hard> <soft> <secondi>
+// ..." come client-output-buffer-limit; slave è sinonimo di replica
+func parseOutputLimits(value string, base outputLimits) (outputLimits, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields)%4 != 0 {
+		return base, errors.New("wrong number of arguments in buffer limit configuration")
+	}
+	limits := base
+	for i := 0; i < len(fields); i += 4 {
+		name := strings.ToLower(fields[i])
+		if name == "slave" {
+			name = "replica"
+		}
+		class := -1
+		for c, n := range outputClassNames {
+			if n == name {
+				class = c
+			}
+		}
+		if class < 0 {
+			return base, fmt.Errorf("invalid client class specified in buffer limit configuration: %s", fields[i])
+		}
+		hard, err := config.ParseMemory(fields[i+1])
+		if err != nil {
+			return base, err
+		}
+		soft, err := config.ParseMemory(fields[i+2])
+		if err != nil {
+			return base, err
+		}
+		seconds, err := strconv.Atoi(fields[i+3])
+		if err != nil || seconds < 0 {
+			return base, errors.New("error in soft limit seconds in buffer limit configuration")
+		}
+		limits[class] = outputLimit{hard: int(hard), soft: int(soft), softDuration: time.Duration(seconds) * time.Second}
+	}
+	return limits, nil
+}
+
+// String restituisce i limiti nel formato di client-output-buffer-limit
+func (l outputLimits) String() string {
+	parts := make([]string, len(l))
+	for class, limit := range l {
+		parts[class] = fmt.Sprintf("%s %d %d %d", outputClassNames[class], limit.hard, limit.soft, int(limit.softDuration.Seconds()))
+	}
+	return strings.Join(parts, " ")
+}
+
+// setKeepAlive configura i keepalive sulla connessione TCP, anche sotto TLS
+func setKeepAlive(conn net.Conn, period time.Duration) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if period == 0 {
+		tcpConn.SetKeepAlive(false)
+		return
+	}
+	tcpConn.SetKeepAlive(true)
+	tcpConn.SetKeepAlivePeriod(period)
+}
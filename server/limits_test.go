@@ -0,0 +1,127 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputBufferLimitNormal(t *testing.T) {
+	s := newTestServer(t, "client-output-buffer-limit", "normal 1kb 0 0")
+	c := newTestClient(t, s)
+	addr := c.conn.RemoteAddr().String()
+
+	c.ok("SET", "small", "v")
+	c.ok("SET", "big", strings.Repeat("x", 4096))
+	if got := c.bulk("GET", "small"); got != "v" {
+		t.Fatalf("GET small = %q, want v", got)
+	}
+	// una risposta oltre il limite hard chiude la connessione
+	c.send("GET", "big")
+	if !c.closed() {
+		t.Fatal("client still connected after a reply over the hard limit")
+	}
+
+	// CONFIG SET cambia il limite delle connessioni già aperte
+	other := dialTest(t, "tcp", addr)
+	other.ok("CONFIG", "SET", "client-output-buffer-limit", "normal 0 0 0")
+	if got := other.bulk("GET", "big"); len(got) != 4096 {
+		t.Fatalf("GET big without limit returned %d bytes", len(got))
+	}
+}
+
+func TestOutputBufferLimitPubSub(t *testing.T) {
+	s := newTestServer(t, "client-output-buffer-limit", "pubsub 1kb 0 0")
+	publisher := newTestClient(t, s)
+	addr := publisher.conn.RemoteAddr().String()
+
+	subscriber := dialTest(t, "tcp", addr)
+	subscriber.do("SUBSCRIBE", "news")
+	// il limite pubsub vale per il subscriber, non per un client normale
+	publisher.ok("SET", "big", strings.Repeat("x", 4096))
+	publisher.bulk("GET", "big")
+
+	publisher.integer("PUBLISH", "news", strings.Repeat("y", 4096))
+	if !subscriber.closed() {
+		t.Fatal("subscriber still connected after a message over the pubsub hard limit")
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	s := newTestServer(t, "maxclients", "1")
+	c := newTestClient(t, s)
+	c.ok("SET", "k", "v")
+
+	rejected := dialTest(t, "tcp", c.conn.RemoteAddr().String())
+	if reply := rejected.read(); reply.Str != "ERR max number of clients reached" {
+		t.Fatalf("connection over maxclients got %+v", reply)
+	}
+	if !rejected.closed() {
+		t.Fatal("connection over maxclients not closed")
+	}
+	if info := c.bulk("INFO", "stats"); !strings.Contains(info, "rejected_connections:1\r\n") {
+		t.Fatalf("INFO stats without rejected_connections:1:\n%s", info)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s := newTestServer(t, "timeout", "1")
+	idle := newTestClient(t, s)
+	addr := idle.conn.RemoteAddr().String()
+	idle.ok("SET", "k", "v")
+	// i client in pub/sub non scadono
+	subscriber := dialTest(t, "tcp", addr)
+	subscriber.do("SUBSCRIBE", "news")
+
+	start := time.Now()
+	if !idle.closed() {
+		t.Fatal("idle client not closed")
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("idle client closed after %v, before the timeout", elapsed)
+	}
+
+	publisher := dialTest(t, "tcp", addr)
+	if got := publisher.integer("PUBLISH", "news", "still here"); got != 1 {
+		t.Fatalf("PUBLISH reached %d subscribers after the timeout, want 1", got)
+	}
+}
+
+// TestStalledReader verifica che un client che smette di leggere venga
+// disconnesso allo scadere della scrittura e liberi il suo posto in maxclients
+func TestStalledReader(t *testing.T) {
+	s := newTestServer(t, "maxclients", "3", "timeout", "1")
+	s.writeTimeout = 300 * time.Millisecond
+	observer := newTestClient(t, s)
+	addr := observer.conn.RemoteAddr().String()
+	observer.ok("SET", "big", strings.Repeat("x", 1<<20))
+
+	stalled := dialTest(t, "tcp", addr)
+	stalled.conn.(*net.TCPConn).SetReadBuffer(4096)
+	for i := 0; i < 50; i++ {
+		stalled.send("GET", "big")
+	}
+
+	// finché il client è connesso connected_clients e maxclients concordano
+	connected := func() string {
+		_, info := infoReply(observer, "clients")
+		return info["clients"]["connected_clients"]
+	}
+	if got := connected(); got != "2" {
+		t.Fatalf("connected_clients with the stalled client = %s, want 2", got)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for connected() != "1" {
+		if time.Now().After(deadline) {
+			t.Fatalf("connected_clients = %s after the stalled client timed out, want 1", connected())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	for i := 0; i < 2; i++ {
+		if reply := dialTest(t, "tcp", addr).do("PING"); reply.Str != "PONG" {
+			t.Fatalf("new client %d after the stalled one left got %+v", i, reply)
+		}
+	}
+}
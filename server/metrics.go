@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mi0772/podcache/metrics"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// metricsPath è il percorso delle metriche Prometheus sulla porta metrics-port
+const metricsPath = "/metrics"
+
+// startMetrics apre l'endpoint HTTP delle metriche se metrics-port non è 0 e lo
+// chiude quando ctx termina. L'endpoint non richiede autenticazione, come gli
+// exporter Redis: la porta va esposta solo a chi raccoglie le metriche.
+func (s *PodCacheServer) startMetrics(ctx context.Context) (int, error) {
+	port := int(s.config.Int("metrics-port"))
+	if port == 0 {
+		return 0, nil
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return 0, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metrics.ContentType)
+		if err := s.writeMetrics(w); err != nil {
+			s.logger.Debug("Failed to write metrics", "addr", r.RemoteAddr, "error", err)
+		}
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("Metrics endpoint stopped", "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	return port, nil
+}
+
+// writeMetrics scrive le metriche nel formato testuale di Prometheus
+func (s *PodCacheServer) writeMetrics(out io.Writer) error {
+	w := metrics.NewWriter(out)
+	stats := s.cache.Stats()
+
+	w.Family("podcache_build_info", "gauge", "PodCache version.")
+	w.Sample("podcache_build_info", 1, metrics.Label{Name: "version", Value: Version})
+	w.Family("podcache_uptime_seconds", "gauge", "Seconds since the server started.")
+	w.Sample("podcache_uptime_seconds", time.Since(s.stats.started).Seconds())
+
+	// connessioni e comandi
+	w.Family("podcache_connected_clients", "gauge", "Clients currently connected.")
+	w.Sample("podcache_connected_clients", float64(s.connected.Load()))
+	w.Family("podcache_connections_received_total", "counter", "Connections accepted by the server.")
+	w.Sample("podcache_connections_received_total", float64(s.stats.connectionsReceived.Load()))
+	w.Family("podcache_rejected_connections_total", "counter", "Connections rejected because of maxclients.")
+	w.Sample("podcache_rejected_connections_total", float64(s.stats.rejectedConnections.Load()))
+	w.Family("podcache_commands_processed_total", "counter", "Commands processed by the server.")
+	w.Sample("podcache_commands_processed_total", float64(s.stats.commandsProcessed.Load()))
+	s.writeCommandMetrics(w)
+	codes, counts := s.stats.errorCounts()
+	w.Family("podcache_error_replies_total", "counter", "Error replies by error code.")
+	for _, code := range codes {
+		w.Sample("podcache_error_replies_total", float64(counts[code]), metrics.Label{Name: "code", Value: code})
+	}
+
+	// memoria e partizioni RAM
+	w.Family("podcache_memory_capacity_bytes", "gauge", "RAM capacity shared by the partitions (maxmemory).")
+	w.Sample("podcache_memory_capacity_bytes", float64(stats.Capacity))
+	w.Family("podcache_memory_used_bytes", "gauge", "Bytes of values held in RAM.")
+	w.Sample("podcache_memory_used_bytes", float64(stats.Used))
+	partitionFamilies := []struct {
+		name, kind, help string
+		value            func(p int) float64
+	}{
+		{"podcache_partition_entries", "gauge", "Keys held by a RAM partition.", func(p int) float64 { return float64(stats.Partitions[p].Entries) }},
+		{"podcache_partition_used_bytes", "gauge", "Bytes of values held by a RAM partition.", func(p int) float64 { return float64(stats.Partitions[p].Used) }},
+		{"podcache_partition_capacity_bytes", "gauge", "Capacity of a RAM partition.", func(p int) float64 { return float64(stats.Partitions[p].Capacity) }},
+		{"podcache_partition_hits_total", "counter", "Reads served by a RAM partition.", func(p int) float64 { return float64(stats.Partitions[p].Hits) }},
+		{"podcache_partition_misses_total", "counter", "Reads not found in a RAM partition.", func(p int) float64 { return float64(stats.Partitions[p].Misses) }},
+	}
+	for _, family := range partitionFamilies {
+		w.Family(family.name, family.kind, family.help)
+		for i := range stats.Partitions {
+			w.Sample(family.name, family.value(i), metrics.Label{Name: "partition", Value: strconv.Itoa(i)})
+		}
+	}
+	rehashing := 0.0
+	if stats.Rehash != nil {
+		rehashing = 1
+	}
+	w.Family("podcache_rehash_in_progress", "gauge", "Whether keys are being moved after a change of partitions.")
+	w.Sample("podcache_rehash_in_progress", rehashing)
+
+	// disco
+	w.Family("podcache_disk_entries", "gauge", "Keys held by the disk tier.")
+	w.Sample("podcache_disk_entries", float64(stats.Disk.Entries))
+	w.Family("podcache_disk_used_bytes", "gauge", "Bytes of values held by the disk tier.")
+	w.Sample("podcache_disk_used_bytes", float64(stats.Disk.Used))
+	w.Family("podcache_spills_total", "counter", "Keys moved from RAM to disk to make room.")
+	w.Sample("podcache_spills_total", float64(stats.Disk.Spills))
+	w.Family("podcache_disk_evictions_total", "counter", "Keys removed from the disk tier, deleted or rewritten in RAM.")
+	w.Sample("podcache_disk_evictions_total", float64(stats.Disk.Evictions))
+	read, write := s.cache.DiskLatency()
+	w.Family("podcache_disk_read_duration_seconds", "histogram", "Latency of disk tier reads.")
+	w.Histogram("podcache_disk_read_duration_seconds", read)
+	w.Family("podcache_disk_write_duration_seconds", "histogram", "Latency of disk tier writes.")
+	w.Histogram("podcache_disk_write_duration_seconds", write)
+
+	w.Family("podcache_db_keys", "gauge", "Keys in a logical database.")
+	for _, db := range stats.Keyspace {
+		w.Sample("podcache_db_keys", float64(db.Keys), metrics.Label{Name: "db", Value: strconv.Itoa(db.DB)})
+	}
+	return w.Flush()
+}
+
+// writeCommandMetrics scrive i contatori e le latenze dei comandi usati almeno
+// una volta, come la sezione commandstats di INFO
+func (s *PodCacheServer) writeCommandMetrics(w *metrics.Writer) {
+	names := make([]string, 0, len(s.stats.commands))
+	for name, stat := range s.stats.commands {
+		if stat.calls.Load() > 0 || stat.rejected.Load() > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	counters := []struct {
+		name, help string
+		value      func(stat *commandStat) uint64
+	}{
+		{"podcache_command_calls_total", "Executed calls of a command.", func(stat *commandStat) uint64 { return stat.calls.Load() }},
+		{"podcache_command_failed_calls_total", "Executed calls of a command that replied with an error.", func(stat *commandStat) uint64 { return stat.failed.Load() }},
+		{"podcache_command_rejected_calls_total", "Calls of a command rejected before execution.", func(stat *commandStat) uint64 { return stat.rejected.Load() }},
+	}
+	for _, counter := range counters {
+		w.Family(counter.name, "counter", counter.help)
+		for _, name := range names {
+			w.Sample(counter.name, float64(counter.value(s.stats.commands[name])), metrics.Label{Name: "cmd", Value: name})
+		}
+	}
+	w.Family("podcache_command_duration_seconds", "histogram", "Execution time of a command.")
+	for _, name := range names {
+		w.Histogram("podcache_command_duration_seconds", s.stats.commands[name].latency, metrics.Label{Name: "cmd", Value: name})
+	}
+}
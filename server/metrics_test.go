@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"io"
+	"mi0772/podcache/metrics"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freePort restituisce una porta TCP locale libera
+func freePort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() returned an error: %v", err)
+	}
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// scrapeMetrics legge l'endpoint e ne restituisce il Content-Type e i
+// campioni, indicizzati per nome ed etichette
+func scrapeMetrics(t *testing.T, port int) (string, map[string]string) {
+	t.Helper()
+	client := &http.Client{Timeout: 5 * time.Second}
+	res, err := client.Get("http://127.0.0.1:" + strconv.Itoa(port) + metricsPath)
+	if err != nil {
+		t.Fatalf("GET %s returned an error: %v", metricsPath, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s = %s, want 200", metricsPath, res.Status)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading %s: %v", metricsPath, err)
+	}
+	samples := make(map[string]string)
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		series, value, ok := strings.Cut(line, " ")
+		if !ok {
+			t.Fatalf("malformed sample %q", line)
+		}
+		samples[series] = value
+	}
+	return res.Header.Get("Content-Type"), samples
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	s := newTestServer(t, "metrics-port", freePort(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	port, err := s.startMetrics(ctx)
+	if err != nil || port == 0 {
+		t.Fatalf("startMetrics() = %d, %v", port, err)
+	}
+
+	c := newTestClient(t, s)
+	c.ok("SET", "a", "1")
+	c.ok("SET", "b", "2")
+	c.bulk("GET", "a")
+	c.fails("WRONGTYPE", "PFADD", "a", "x")
+	c.ok("SELECT", "1")
+	c.ok("SET", "a", "1")
+	// un comando è contato dopo la risposta: PING garantisce che il precedente lo sia
+	c.do("PING")
+
+	contentType, samples := scrapeMetrics(t, port)
+	if contentType != metrics.ContentType {
+		t.Fatalf("Content-Type = %q, want %q", contentType, metrics.ContentType)
+	}
+	for series, want := range map[string]string{
+		`podcache_connected_clients`:                         "1",
+		`podcache_command_calls_total{cmd="set"}`:            "3",
+		`podcache_command_calls_total{cmd="get"}`:            "1",
+		`podcache_command_failed_calls_total{cmd="pfadd"}`:   "1",
+		`podcache_error_replies_total{code="WRONGTYPE"}`:     "1",
+		`podcache_command_duration_seconds_count{cmd="set"}`: "3",
+		`podcache_db_keys{db="0"}`:                           "2",
+		`podcache_db_keys{db="1"}`:                           "1",
+	} {
+		if got, ok := samples[series]; !ok || got != want {
+			t.Errorf("%s = %q, want %q", series, got, want)
+		}
+	}
+	for _, series := range []string{`podcache_partition_hits_total{partition="1"}`, `podcache_disk_read_duration_seconds_count`, `podcache_uptime_seconds`} {
+		if _, ok := samples[series]; !ok {
+			t.Errorf("no %s sample", series)
+		}
+	}
+	if _, ok := samples[`podcache_command_calls_total{cmd="xadd"}`]; ok {
+		t.Error("metrics of a command never called should be omitted")
+	}
+
+	// i contatori crescono con i comandi successivi
+	c.bulk("GET", "a")
+	c.do("PING")
+	if _, samples = scrapeMetrics(t, port); samples[`podcache_command_calls_total{cmd="get"}`] != "2" {
+		t.Errorf("get calls after another GET = %q, want 2", samples[`podcache_command_calls_total{cmd="get"}`])
+	}
+
+	// metrics-port 0 non apre l'endpoint
+	if port, err := newTestServer(t).startMetrics(ctx); port != 0 || err != nil {
+		t.Fatalf("startMetrics() with metrics-port 0 = %d, %v", port, err)
+	}
+}
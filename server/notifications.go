@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"mi0772/podcache/cache"
+	"strings"
+)
+
+// Flag di notify-keyspace-events: K e E scelgono i canali (__keyspace@<db>__ e
+// __keyevent@<db>__), le altre lettere le classi di eventi come in Redis.
+// La lettera 'p' abilita gli eventi specifici di PodCache sugli spostamenti tra
+// RAM e disco (spill, diskevict); come 'm' e 'n' in Redis non è inclusa in 'A'.
+const (
+	notifyKeyspace uint32 = 1 << (16 + iota)
+	notifyKeyevent
+)
+
+var notifyClasses = []struct {
+	flag  byte
+	class cache.EventClass
+}{
+	{'g', cache.EventGeneric},
+	{'$', cache.EventString},
+	{'t', cache.EventStream},
+	{'z', cache.EventZSet},
+	{'x', cache.EventExpired},
+	{'e', cache.EventEvicted},
+	{'p', cache.EventTier},
+}
+
+// flag di Redis per tipi che PodCache non ha: accettati ma senza effetto
+const unsupportedNotifyFlags = "lshmdn"
+
+const notifyAllClasses = cache.EventGeneric | cache.EventString | cache.EventStream |
+	cache.EventZSet | cache.EventExpired | cache.EventEvicted
+
+// parseKeyspaceEvents converte la stringa di configurazione nei flag interni
+func parseKeyspaceEvents(spec string) (uint32, error) {
+	var flags uint32
+	for i := 0; i < len(spec); i++ {
+		c := spec[i]
+		switch {
+		case c == 'K':
+			flags |= notifyKeyspace
+		case c == 'E':
+			flags |= notifyKeyevent
+		case c == 'A':
+			flags |= uint32(notifyAllClasses)
+		case strings.IndexByte(unsupportedNotifyFlags, c) >= 0:
+		default:
+			found := false
+			for _, nc := range notifyClasses {
+				if nc.flag == c {
+					flags |= uint32(nc.class)
+					found = true
+				}
+			}
+			if !found {
+				return 0, fmt.Errorf("invalid notify-keyspace-events flag '%c'", c)
+			}
+		}
+	}
+	return flags, nil
+}
+
+// keyspaceEventsString è la forma normalizzata dei flag, come la riporta Redis
+func keyspaceEventsString(flags uint32) string {
+	var b strings.Builder
+	all := flags&uint32(notifyAllClasses) == uint32(notifyAllClasses)
+	if all {
+		b.WriteByte('A')
+	}
+	for _, nc := range notifyClasses {
+		if flags&uint32(nc.class) != 0 && !(all && nc.class&notifyAllClasses != 0) {
+			b.WriteByte(nc.flag)
+		}
+	}
+	if flags&notifyKeyspace != 0 {
+		b.WriteByte('K')
+	}
+	if flags&notifyKeyevent != 0 {
+		b.WriteByte('E')
+	}
+	return b.String()
+}
+
+// configureNotifications applica notify-keyspace-events e collega la cache al broker pub/sub
+func (s *PodCacheServer) configureNotifications() {
+	// il valore nel registro è già stato validato
+	flags, _ := parseKeyspaceEvents(s.config.String("notify-keyspace-events"))
+	if flags != 0 {
+		s.notifyFlags.Store(flags)
+		s.logger.Info("Keyspace notifications enabled", "flags", keyspaceEventsString(flags))
+	}
+	s.cache.SetNotifier(s.keyspaceEvent)
+}
+
+// keyspaceEvent pubblica l'evento sui canali di notifica abilitati
+func (s *PodCacheServer) keyspaceEvent(db int, class cache.EventClass, event, key string) {
+	flags := s.notifyFlags.Load()
+	if flags&uint32(class) == 0 {
+		return
+	}
+	if flags&notifyKeyspace != 0 {
+		s.pubsub.publish(fmt.Sprintf("__keyspace@%d__:%s", db, key), event)
+	}
+	if flags&notifyKeyevent != 0 {
+		s.pubsub.publish(fmt.Sprintf("__keyevent@%d__:%s", db, event), key)
+	}
+}
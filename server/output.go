@@ -0,0 +1,186 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clientWriteTimeout è il tempo massimo di una scrittura sul socket: un client
+// che smette di leggere viene disconnesso invece di trattenere per sempre la
+// connessione, la goroutine di scrittura e la coda
+const clientWriteTimeout = 30 * time.Second
+
+var (
+	errOutputClosed = errors.New("client output closed")
+	errOutputLimit  = errors.New("client output buffer limit reached")
+)
+
+// clientOutput separa la composizione delle risposte dalla scrittura sul socket.
+// La goroutine della connessione compone ogni risposta in pending e la accoda
+// intera con commit; i messaggi push (pub/sub) possono arrivare da qualsiasi
+// goroutine tramite push. In coda quindi un messaggio non finisce mai a metà di
+// una risposta, e una goroutine dedicata svuota la coda sul socket, così un
+// subscriber lento non rallenta chi pubblica.
+type clientOutput struct {
+	conn         net.Conn
+	pending      []byte
+	writeTimeout time.Duration
+
+	// limits sono i limiti condivisi dal server, letti a ogni accodamento
+	// così un cambio di configurazione vale subito; class è la classe corrente
+	limits *atomic.Pointer[outputLimits]
+
+	mu        sync.Mutex
+	queue     []byte
+	inFlight  int
+	class     outputClass
+	softSince time.Time
+	closed    bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+func newClientOutput(conn net.Conn, limits *atomic.Pointer[outputLimits], writeTimeout time.Duration) *clientOutput {
+	o := &clientOutput{
+		conn:         conn,
+		writeTimeout: writeTimeout,
+		limits:       limits,
+		wake:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+	go o.run()
+	return o
+}
+
+// Write accumula la risposta in composizione; è usato dal bufio.Writer del client
+func (o *clientOutput) Write(p []byte) (int, error) {
+	o.pending = append(o.pending, p...)
+	return len(p), nil
+}
+
+// commit accoda la risposta composta dall'ultimo commit
+func (o *clientOutput) commit() error {
+	if len(o.pending) == 0 {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	err := o.enqueue(o.pending)
+	o.pending = o.pending[:0]
+	return err
+}
+
+// push accoda un messaggio asincrono; può essere chiamato da altre goroutine
+func (o *clientOutput) push(message []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.enqueue(message)
+}
+
+// setClass cambia la classe del client, es. normal -> pubsub con SUBSCRIBE
+func (o *clientOutput) setClass(class outputClass) {
+	o.mu.Lock()
+	o.class = class
+	o.softSince = time.Time{}
+	o.mu.Unlock()
+}
+
+func (o *clientOutput) enqueue(data []byte) error {
+	if o.closed {
+		return errOutputClosed
+	}
+	o.queue = append(o.queue, data...)
+
+	if o.overLimit() {
+		o.closed = true
+		o.queue = nil
+		// chiudere la connessione sblocca sia la lettura sia una scrittura in corso
+		o.conn.Close()
+		return errOutputLimit
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// overLimit applica il limite della classe: oltre hard, o sopra soft per più
+// di softDuration, il client va disconnesso
+func (o *clientOutput) overLimit() bool {
+	if o.limits == nil {
+		return false
+	}
+	limit := o.limits.Load()[o.class]
+	size := len(o.queue) + o.inFlight
+	if limit.hard > 0 && size > limit.hard {
+		return true
+	}
+	if limit.soft == 0 || size <= limit.soft {
+		o.softSince = time.Time{}
+		return false
+	}
+	if o.softSince.IsZero() {
+		o.softSince = time.Now()
+		return false
+	}
+	return time.Since(o.softSince) > limit.softDuration
+}
+
+func (o *clientOutput) run() {
+	defer close(o.done)
+
+	for range o.wake {
+		o.mu.Lock()
+		buf := o.queue
+		o.queue = nil
+		o.inFlight = len(buf)
+		closed := o.closed
+		o.mu.Unlock()
+
+		if len(buf) > 0 {
+			o.conn.SetWriteDeadline(time.Now().Add(o.writeTimeout))
+			_, err := o.conn.Write(buf)
+
+			o.mu.Lock()
+			o.inFlight = 0
+			if err != nil {
+				o.closed = true
+				o.queue = nil
+				// sblocca la lettura: la connessione non può più ricevere risposte
+				o.conn.Close()
+			}
+			closed = o.closed && len(o.queue) == 0
+			o.mu.Unlock()
+		}
+
+		if closed {
+			return
+		}
+	}
+}
+
+// close attende che la coda sia scritta sul socket e ferma la goroutine di scrittura
+func (o *clientOutput) close() {
+	o.mu.Lock()
+	o.closed = true
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	o.mu.Unlock()
+	<-o.done
+}
+
+// size restituisce i byte accodati e non ancora scritti sul socket
+func (o *clientOutput) size() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.queue) + o.inFlight
+}
@@ -0,0 +1,354 @@
+package server
+
+import (
+	"fmt"
+	"mi0772/podcache/util"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pubSub è il broker dei messaggi: per ogni canale, e per ogni pattern glob,
+// l'insieme dei client iscritti. I messaggi vengono accodati sull'output dei
+// client senza attendere la scrittura sul socket.
+type pubSub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Client]struct{}
+	patterns map[string]map[*Client]struct{}
+}
+
+func newPubSub() *pubSub {
+	return &pubSub{
+		channels: make(map[string]map[*Client]struct{}),
+		patterns: make(map[string]map[*Client]struct{}),
+	}
+}
+
+func (ps *pubSub) add(table map[string]map[*Client]struct{}, name string, client *Client) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	subscribers, ok := table[name]
+	if !ok {
+		subscribers = make(map[*Client]struct{})
+		table[name] = subscribers
+	}
+	subscribers[client] = struct{}{}
+}
+
+func (ps *pubSub) remove(table map[string]map[*Client]struct{}, name string, client *Client) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if subscribers, ok := table[name]; ok {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(table, name)
+		}
+	}
+}
+
+// publish consegna il messaggio agli iscritti al canale e ai pattern che lo
+// contengono; restituisce il numero di consegne, come PUBLISH
+func (ps *pubSub) publish(channel, message string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	receivers := 0
+	for client := range ps.channels[channel] {
+		if client.pushMessage("message", channel, message) == nil {
+			receivers++
+		}
+	}
+	for pattern, subscribers := range ps.patterns {
+		if !util.GlobMatch(pattern, channel) {
+			continue
+		}
+		for client := range subscribers {
+			if client.pushMessage("pmessage", pattern, channel, message) == nil {
+				receivers++
+			}
+		}
+	}
+	return receivers
+}
+
+// activeChannels restituisce i canali con almeno un iscritto che corrispondono al pattern
+func (ps *pubSub) activeChannels(pattern string) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	var channels []string
+	for channel := range ps.channels {
+		if pattern == "" || util.GlobMatch(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// isSubscribed riporta se il client è iscritto al canale
+func (ps *pubSub) isSubscribed(client *Client, channel string) bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	_, ok := ps.channels[channel][client]
+	return ok
+}
+
+func (ps *pubSub) numSub(channel string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.channels[channel])
+}
+
+func (ps *pubSub) numPat() int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.patterns)
+}
+
+// unsubscribeAll rimuove il client da tutti i canali e pattern alla disconnessione
+func (s *PodCacheServer) unsubscribeAll(client *Client) {
+	for channel := range client.channels {
+		s.pubsub.remove(s.pubsub.channels, channel, client)
+	}
+	for pattern := range client.patterns {
+		s.pubsub.remove(s.pubsub.patterns, pattern, client)
+	}
+	client.channels = nil
+	client.patterns = nil
+}
+
+// subscriptions è il conteggio riportato nelle conferme di (P)(UN)SUBSCRIBE
+func (c *Client) subscriptions() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// subscribed riporta se la connessione è in modalità pub/sub
+func (c *Client) subscribed() bool {
+	return c.subscriptions() > 0
+}
+
+// pushMessage accoda un messaggio pub/sub: push type in RESP3, array in RESP2
+func (c *Client) pushMessage(kind string, parts ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%c%d\r\n", c.pushPrefix(), len(parts)+1)
+	fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(kind), kind)
+	for _, part := range parts {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(part), part)
+	}
+	return c.out.push([]byte(b.String()))
+}
+
+// writeSubscription scrive la conferma di un (P)(UN)SUBSCRIBE per un canale
+func (c *Client) writeSubscription(kind, channel string, nullChannel bool) {
+	fmt.Fprintf(c.writer, "%c3\r\n", c.pushPrefix())
+	c.writeBulk(kind)
+	if nullChannel {
+		c.writeNullBulk()
+	} else {
+		c.writeBulk(channel)
+	}
+	c.writeInt(int64(c.subscriptions()))
+}
+
+func (s *PodCacheServer) handleSubscribe(client *Client, args []string, pattern bool) error {
+	if len(args) == 0 {
+		return client.sendError("wrong number of arguments for 'subscribe' command")
+	}
+
+	kind, table := "subscribe", s.pubsub.channels
+	if pattern {
+		kind, table = "psubscribe", s.pubsub.patterns
+	}
+
+	if !client.subscribed() {
+		client.enterSubscribedMode()
+	}
+	for _, name := range args {
+		set := client.subscriptionSet(pattern)
+		if _, ok := set[name]; !ok {
+			set[name] = struct{}{}
+			s.pubsub.add(table, name, client)
+		}
+		client.writeSubscription(kind, name, false)
+	}
+	return client.flush()
+}
+
+func (s *PodCacheServer) handleUnsubscribe(client *Client, args []string, pattern bool) error {
+	kind, table := "unsubscribe", s.pubsub.channels
+	if pattern {
+		kind, table = "punsubscribe", s.pubsub.patterns
+	}
+
+	set := client.subscriptionSet(pattern)
+	names := args
+	if len(names) == 0 {
+		for name := range set {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	if len(names) == 0 {
+		client.writeSubscription(kind, "", true)
+	}
+	for _, name := range names {
+		if _, ok := set[name]; ok {
+			delete(set, name)
+			s.pubsub.remove(table, name, client)
+		}
+		client.writeSubscription(kind, name, false)
+	}
+
+	if !client.subscribed() {
+		client.out.setClass(classNormal)
+	}
+	return client.flush()
+}
+
+func (c *Client) subscriptionSet(pattern bool) map[string]struct{} {
+	if pattern {
+		if c.patterns == nil {
+			c.patterns = make(map[string]struct{})
+		}
+		return c.patterns
+	}
+	if c.channels == nil {
+		c.channels = make(map[string]struct{})
+	}
+	return c.channels
+}
+
+func (s *PodCacheServer) handlePublish(client *Client, args []string) error {
+	if len(args) != 2 {
+		return client.sendError("wrong number of arguments for 'publish' command")
+	}
+	return client.sendInteger(s.pubsub.publish(args[0], args[1]))
+}
+
+func (s *PodCacheServer) handlePubSub(client *Client, args []string) error {
+	if len(args) == 0 {
+		return client.sendError("wrong number of arguments for 'pubsub' command")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "CHANNELS":
+		if len(args) > 2 {
+			return client.sendError("wrong number of arguments for 'pubsub|channels' command")
+		}
+		pattern := ""
+		if len(args) == 2 {
+			pattern = args[1]
+		}
+		channels := s.pubsub.activeChannels(pattern)
+		client.writeArray(len(channels))
+		for _, channel := range channels {
+			client.writeBulk(channel)
+		}
+		return client.flush()
+	case "NUMSUB":
+		channels := args[1:]
+		client.writeArray(len(channels) * 2)
+		for _, channel := range channels {
+			client.writeBulk(channel)
+			client.writeInt(int64(s.pubsub.numSub(channel)))
+		}
+		return client.flush()
+	case "NUMPAT":
+		if len(args) != 1 {
+			return client.sendError("wrong number of arguments for 'pubsub|numpat' command")
+		}
+		return client.sendInteger(s.pubsub.numPat())
+	default:
+		return client.sendError(fmt.Sprintf("unknown subcommand '%s'. Try PUBSUB HELP.", args[0]))
+	}
+}
+
+// handleHello negozia la versione del protocollo (RESP2 o RESP3) e restituisce
+// le informazioni sul server; AUTH autentica il client e SETNAME ne imposta il nome
+func (s *PodCacheServer) handleHello(client *Client, args []string) error {
+	protocol := client.protocolVersion()
+	var auth []string
+	var name *string
+	if len(args) > 0 {
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			return client.sendError("Protocol version is not an integer or out of range")
+		}
+		if version != 2 && version != 3 {
+			client.writer.WriteString("-NOPROTO unsupported protocol version\r\n")
+			return client.flush()
+		}
+		protocol = version
+
+		for i := 1; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "AUTH":
+				if i+2 >= len(args) {
+					return client.sendError(errSyntax.Error())
+				}
+				auth = args[i+1 : i+3]
+				i += 2
+			case "SETNAME":
+				if i+1 >= len(args) {
+					return client.sendError(errSyntax.Error())
+				}
+				if !validClientName(args[i+1]) {
+					return client.sendError("Client names cannot contain spaces, newlines or special characters.")
+				}
+				name = &args[i+1]
+				i++
+			default:
+				return client.sendError(errSyntax.Error())
+			}
+		}
+	}
+	if auth != nil {
+		if !s.authenticate(client, auth[0], auth[1]) {
+			return nil
+		}
+	} else if !client.authenticated {
+		client.writer.WriteString("-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time\r\n")
+		return client.flush()
+	}
+	client.protocol.Store(int32(protocol))
+	if name != nil {
+		client.name.Store(name)
+	}
+
+	client.writeMap(7)
+	client.writeBulk("server")
+	client.writeBulk("podcache")
+	client.writeBulk("version")
+	client.writeBulk(Version)
+	client.writeBulk("proto")
+	client.writeInt(int64(protocol))
+	client.writeBulk("id")
+	client.writeInt(int64(client.id))
+	client.writeBulk("mode")
+	client.writeBulk("standalone")
+	client.writeBulk("role")
+	client.writeBulk("master")
+	client.writeBulk("modules")
+	client.writeArray(0)
+	return client.flush()
+}
+
+// enterSubscribedMode prepara la connessione a restare in attesa di messaggi:
+// niente timeout di inattività e limiti pub/sub sul buffer di uscita
+func (c *Client) enterSubscribedMode() {
+	c.conn.SetReadDeadline(time.Time{})
+	c.out.setClass(classPubSub)
+}
+
+// counts restituisce il numero di canali e pattern con almeno un iscritto
+func (p *pubSub) counts() (channels, patterns int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.channels), len(p.patterns)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"mi0772/podcache/resp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expectMessage verifica che reply sia un messaggio pub/sub con gli elementi indicati
+func expectMessage(t *testing.T, reply resp.Reply, want ...string) {
+	t.Helper()
+	got := make([]string, len(reply.Elements))
+	for i, e := range reply.Elements {
+		got[i] = e.Str
+		if e.Kind == resp.ReplyInteger {
+			got[i] = strconv.FormatInt(e.Int, 10)
+		}
+	}
+	if reply.Kind != resp.ReplyArray || strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("message = %+v, want %q", reply, want)
+	}
+}
+
+func TestPubSubRESP2(t *testing.T) {
+	s := newTestServer(t)
+	sub := newTestClient(t, s)
+	pub := dialTest(t, "tcp", sub.conn.RemoteAddr().String())
+
+	sub.send("SUBSCRIBE", "news", "sport")
+	expectMessage(t, sub.read(), "subscribe", "news", "1")
+	expectMessage(t, sub.read(), "subscribe", "sport", "2")
+
+	// una connessione RESP2 iscritta accetta solo i comandi di pub/sub
+	sub.fails("ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", "GET", "k")
+	expectMessage(t, sub.do("PING"), "pong", "")
+	expectMessage(t, sub.do("PING", "hi"), "pong", "hi")
+
+	if n := pub.integer("PUBLISH", "news", "hello"); n != 1 {
+		t.Fatalf("PUBLISH = %d, want 1", n)
+	}
+	expectMessage(t, sub.read(), "message", "news", "hello")
+
+	expectMessage(t, sub.do("PSUBSCRIBE", "n*"), "psubscribe", "n*", "3")
+	if n := pub.integer("PUBLISH", "news", "again"); n != 2 {
+		t.Fatalf("PUBLISH to a channel and a pattern = %d, want 2", n)
+	}
+	expectMessage(t, sub.read(), "message", "news", "again")
+	expectMessage(t, sub.read(), "pmessage", "n*", "news", "again")
+	if n := pub.integer("PUBLISH", "weather", "sun"); n != 0 {
+		t.Fatalf("PUBLISH without subscribers = %d, want 0", n)
+	}
+
+	// UNSUBSCRIBE senza argomenti conferma ogni canale in ordine, il conteggio
+	// include i pattern ancora attivi
+	sub.send("UNSUBSCRIBE")
+	expectMessage(t, sub.read(), "unsubscribe", "news", "2")
+	expectMessage(t, sub.read(), "unsubscribe", "sport", "1")
+	expectMessage(t, sub.do("PUNSUBSCRIBE", "n*"), "punsubscribe", "n*", "0")
+
+	// fuori dalla modalità pub/sub i comandi tornano disponibili
+	if reply := sub.do("GET", "k"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("GET after UNSUBSCRIBE = %+v, want nil", reply)
+	}
+	reply := sub.do("UNSUBSCRIBE")
+	if len(reply.Elements) != 3 || reply.Elements[0].Str != "unsubscribe" || reply.Elements[1].Kind != resp.ReplyNil || reply.Elements[2].Int != 0 {
+		t.Fatalf("UNSUBSCRIBE without subscriptions = %+v, want [unsubscribe nil 0]", reply)
+	}
+}
+
+func TestPubSubRESP3(t *testing.T) {
+	s := newTestServer(t)
+	sub := newTestClient(t, s)
+	pub := dialTest(t, "tcp", sub.conn.RemoteAddr().String())
+	sub.do("HELLO", "3")
+
+	// in RESP3 conferme e messaggi sono push e la connessione resta utilizzabile
+	sub.send("SUBSCRIBE", "news")
+	expectMessage(t, sub.push(), "subscribe", "news", "1")
+	sub.ok("SET", "k", "v")
+	if v := sub.bulk("GET", "k"); v != "v" {
+		t.Fatalf("GET while subscribed = %q, want v", v)
+	}
+	if reply := sub.do("PING"); reply.Kind != resp.ReplyStatus || reply.Str != "PONG" {
+		t.Fatalf("PING while subscribed = %+v, want PONG", reply)
+	}
+
+	pub.integer("PUBLISH", "news", "hello")
+	expectMessage(t, sub.push(), "message", "news", "hello")
+	sub.send("UNSUBSCRIBE", "news")
+	expectMessage(t, sub.push(), "unsubscribe", "news", "0")
+}
+
+func TestPubSubIntrospection(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	addr := c.conn.RemoteAddr().String()
+
+	first := dialTest(t, "tcp", addr)
+	first.send("SUBSCRIBE", "news", "sport")
+	first.read()
+	first.read()
+	second := dialTest(t, "tcp", addr)
+	second.do("SUBSCRIBE", "news")
+	second.do("PSUBSCRIBE", "n*")
+	second.do("PSUBSCRIBE", "s*")
+
+	if got := c.array("PUBSUB", "CHANNELS"); strings.Join(got, " ") != "news sport" {
+		t.Fatalf("PUBSUB CHANNELS = %q, want [news sport]", got)
+	}
+	if got := c.array("PUBSUB", "CHANNELS", "s*"); strings.Join(got, " ") != "sport" {
+		t.Fatalf("PUBSUB CHANNELS s* = %q, want [sport]", got)
+	}
+	reply := c.do("PUBSUB", "NUMSUB", "news", "sport", "weather")
+	if len(reply.Elements) != 6 || reply.Elements[1].Int != 2 || reply.Elements[3].Int != 1 || reply.Elements[5].Int != 0 {
+		t.Fatalf("PUBSUB NUMSUB = %+v, want news 2 sport 1 weather 0", reply)
+	}
+	if n := c.integer("PUBSUB", "NUMPAT"); n != 2 {
+		t.Fatalf("PUBSUB NUMPAT = %d, want 2", n)
+	}
+
+	// la disconnessione rimuove le iscrizioni del client
+	second.conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.integer("PUBSUB", "NUMPAT") != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("patterns of a closed connection still registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	reply = c.do("PUBSUB", "NUMSUB", "news")
+	if len(reply.Elements) != 2 || reply.Elements[1].Int != 1 {
+		t.Fatalf("PUBSUB NUMSUB after a disconnection = %+v, want news 1", reply)
+	}
+	c.fails("ERR unknown subcommand 'BOGUS'. Try PUBSUB HELP.", "PUBSUB", "BOGUS")
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Reload applica la configurazione dopo un SIGHUP senza chiudere le connessioni:
+// rilegge il file di configurazione, il file ACL e i certificati TLS. Tutto
+// viene letto e validato prima di applicare qualcosa, così un reload non valido
+// lascia la configurazione com'era.
+func (s *PodCacheServer) Reload() error {
+	var users map[string]*aclUser
+	if s.acl.path != "" {
+		// come all'avvio, un file ACL che non esiste ancora non è un errore
+		if _, err := os.Stat(s.acl.path); !errors.Is(err, os.ErrNotExist) {
+			if users, err = parseACLFile(s.acl.path); err != nil {
+				return fmt.Errorf("ACL file %s: %w", s.acl.path, err)
+			}
+		}
+	}
+	reloader := s.tlsCerts.Load()
+	var certs *tlsFiles
+	if reloader != nil {
+		var err error
+		if certs, err = reloader.read(); err != nil {
+			return fmt.Errorf("TLS certificates: %w", err)
+		}
+	}
+
+	if s.config.File() != "" {
+		changed, restart, err := s.config.Reload()
+		if err != nil {
+			return err
+		}
+		for _, change := range changed {
+			from, to := change.Old, change.New
+			if p, _ := s.config.Lookup(change.Name); p.Sensitive {
+				from, to = "********", "********"
+			}
+			s.logger.Info("Configuration changed", "parameter", change.Name, "old", from, "new", to)
+		}
+		for _, name := range restart {
+			s.logger.Warn("Configuration change ignored, it requires a restart", "parameter", name)
+		}
+	}
+	if users != nil {
+		removed := s.acl.replace(users)
+		s.disconnectUsers(removed)
+		s.logger.Info("ACL file reloaded", "path", s.acl.path, "users", len(users))
+	}
+	if certs != nil {
+		reloader.loaded.Store(certs)
+		s.logger.Info("TLS certificates reloaded", "cert", s.tls.certFile)
+	}
+	return nil
+}
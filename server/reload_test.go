@@ -0,0 +1,106 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeTestFile sostituisce il contenuto di un file di configurazione o ACL
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() returned an error: %v", err)
+	}
+}
+
+func TestReloadACLUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	writeTestFile(t, path, "user default on nopass ~* &* +@all\nuser erin on >pw ~* +@all\n")
+	s := newTestServer(t, "aclfile", path)
+	admin := newTestClient(t, s)
+	addr := admin.conn.RemoteAddr().String()
+	erin := authAs(t, addr, "erin", "pw")
+
+	// il reload sostituisce gli utenti e disconnette quelli rimossi
+	writeTestFile(t, path, "user default on nopass ~* &* +@all\nuser grace on >pw2 ~* +@all\n")
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload() returned an error: %v", err)
+	}
+	if users := admin.array("ACL", "USERS"); !slices.Equal(users, []string{"default", "grace"}) {
+		t.Fatalf("ACL USERS after Reload = %v, want [default grace]", users)
+	}
+	if !erin.closed() {
+		t.Fatal("client of a removed user still connected after Reload")
+	}
+	authAs(t, addr, "grace", "pw2")
+	dialTest(t, "tcp", addr).fails("WRONGPASS", "AUTH", "erin", "pw")
+}
+
+func TestReloadInvalidFile(t *testing.T) {
+	aclPath := filepath.Join(t.TempDir(), "users.acl")
+	users := "user default on nopass ~* &* +@all\n"
+	writeTestFile(t, aclPath, users)
+	s, path := newFileTestServer(t, "maxclients 100\nslowlog-max-len 64\n", "aclfile", aclPath)
+	c := newTestClient(t, s)
+	unchanged := func(when string) {
+		t.Helper()
+		if got := c.array("CONFIG", "GET", "maxclients", "slowlog-max-len"); strings.Join(got, " ") != "maxclients 100 slowlog-max-len 64" {
+			t.Fatalf("CONFIG GET after %s = %q, want the previous values", when, got)
+		}
+		if got := c.array("ACL", "USERS"); !slices.Equal(got, []string{"default"}) {
+			t.Fatalf("ACL USERS after %s = %v, want [default]", when, got)
+		}
+	}
+
+	// un errore dopo una direttiva valida non applica neanche quella, né il file ACL
+	writeTestFile(t, aclPath, users+"user grace on >pw ~* +@all\n")
+	writeTestFile(t, path, "maxclients 20\nslowlog-max-len x\n")
+	if err := s.Reload(); err == nil || !strings.Contains(err.Error(), "slowlog-max-len") {
+		t.Fatalf("Reload() with an invalid value = %v, want an error naming slowlog-max-len", err)
+	}
+	unchanged("an invalid config file")
+	writeTestFile(t, path, "maxclients 20\nbogus 1\n")
+	if err := s.Reload(); err == nil {
+		t.Fatal("Reload() accepted an unknown directive")
+	}
+	unchanged("an unknown directive")
+
+	// un file ACL non valido blocca anche la configurazione
+	writeTestFile(t, path, "maxclients 20\nslowlog-max-len 10\n")
+	writeTestFile(t, aclPath, users+"user grace on bogusrule\n")
+	if err := s.Reload(); err == nil || !strings.Contains(err.Error(), "ACL file") {
+		t.Fatalf("Reload() with an invalid ACL file = %v, want an ACL file error", err)
+	}
+	unchanged("an invalid ACL file")
+
+	writeTestFile(t, aclPath, users+"user grace on >pw ~* +@all\n")
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload() returned an error: %v", err)
+	}
+	if got := c.array("CONFIG", "GET", "maxclients", "slowlog-max-len"); strings.Join(got, " ") != "maxclients 20 slowlog-max-len 10" {
+		t.Fatalf("CONFIG GET after a valid Reload = %q", got)
+	}
+	if got := c.array("ACL", "USERS"); !slices.Equal(got, []string{"default", "grace"}) {
+		t.Fatalf("ACL USERS after a valid Reload = %v", got)
+	}
+}
+
+func TestReloadRestartParams(t *testing.T) {
+	t.Setenv("PODCACHE_TIMEOUT", "30")
+	s, path := newFileTestServer(t, "databases 16\nmaxclients 100\ntimeout 60\n")
+	c := newTestClient(t, s)
+
+	// i parametri che richiedono un riavvio restano com'erano, gli altri cambiano;
+	// l'ambiente mantiene la precedenza sul file
+	writeTestFile(t, path, "databases 4\nmaxclients 20\ntimeout 90\n")
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload() returned an error: %v", err)
+	}
+	if got := c.array("CONFIG", "GET", "databases", "maxclients", "timeout"); strings.Join(got, " ") != "databases 16 maxclients 20 timeout 30" {
+		t.Fatalf("CONFIG GET after Reload = %q, want databases 16 maxclients 20 timeout 30", got)
+	}
+	c.ok("SELECT", "10")
+}
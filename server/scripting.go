@@ -0,0 +1,591 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mi0772/podcache/cache"
+	"mi0772/podcache/logging"
+	"mi0772/podcache/lua"
+	"mi0772/podcache/resp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultScriptTimeLimit è il lua-time-limit di Redis: oltre questa durata uno
+// script in esecuzione rende il server BUSY per gli altri client
+const defaultScriptTimeLimit = 5 * time.Second
+
+// scriptChunk è il nome del chunk che compare nei messaggi d'errore, come in Redis
+const scriptChunk = "user_script"
+
+var errScriptKilled = errors.New("Script killed by user with SCRIPT KILL...")
+
+// scriptRun è uno script in esecuzione
+type scriptRun struct {
+	client   *Client
+	start    time.Time
+	keys     map[string]struct{}
+	readOnly bool
+
+	// wrote è letto da SCRIPT KILL su un'altra connessione
+	wrote  atomic.Bool
+	killed atomic.Bool
+}
+
+// scriptEngine conserva gli script compilati per SHA1 e quelli in esecuzione.
+// Gli script non sono serializzati tra loro: ognuno tiene solo i lock delle
+// partizioni delle proprie KEYS, come una transazione EXEC.
+type scriptEngine struct {
+	mu        sync.Mutex
+	timeLimit time.Duration
+	scripts   map[string]*lua.Closure
+	running   map[*scriptRun]struct{}
+	active    atomic.Int32
+}
+
+func newScriptEngine(timeLimit time.Duration) *scriptEngine {
+	return &scriptEngine{
+		timeLimit: timeLimit,
+		scripts:   make(map[string]*lua.Closure),
+		running:   make(map[*scriptRun]struct{}),
+	}
+}
+
+func sha1hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// load compila lo script e lo registra nella cache, restituendone lo SHA1
+func (e *scriptEngine) load(source string) (string, *lua.Closure, error) {
+	sha := sha1hex(source)
+	if fn := e.get(sha); fn != nil {
+		return sha, fn, nil
+	}
+	fn, err := lua.Compile(source, scriptChunk)
+	if err != nil {
+		return "", nil, err
+	}
+	e.mu.Lock()
+	e.scripts[sha] = fn
+	e.mu.Unlock()
+	return sha, fn, nil
+}
+
+func (e *scriptEngine) get(sha string) *lua.Closure {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.scripts[strings.ToLower(sha)]
+}
+
+func (e *scriptEngine) flush() {
+	e.mu.Lock()
+	e.scripts = make(map[string]*lua.Closure)
+	e.mu.Unlock()
+}
+
+func (e *scriptEngine) begin(run *scriptRun) {
+	e.mu.Lock()
+	e.running[run] = struct{}{}
+	e.mu.Unlock()
+	e.active.Add(1)
+}
+
+func (e *scriptEngine) end(run *scriptRun) {
+	e.mu.Lock()
+	delete(e.running, run)
+	e.mu.Unlock()
+	e.active.Add(-1)
+}
+
+// setTimeLimit cambia il limite oltre il quale gli altri client ricevono BUSY
+func (e *scriptEngine) setTimeLimit(limit time.Duration) {
+	e.mu.Lock()
+	e.timeLimit = limit
+	e.mu.Unlock()
+}
+
+// busy indica se uno script di un altro client ha superato il limite di tempo
+func (e *scriptEngine) busy(client *Client) bool {
+	if e.active.Load() == 0 {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for run := range e.running {
+		if run.client != client && time.Since(run.start) > e.timeLimit {
+			return true
+		}
+	}
+	return false
+}
+
+// kill interrompe gli script in esecuzione; quelli che hanno già scritto non
+// possono essere interrotti senza lasciare il dataset a metà
+func (e *scriptEngine) kill() (running, unkillable bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for run := range e.running {
+		running = true
+		if run.wrote.Load() {
+			unkillable = true
+			continue
+		}
+		run.killed.Store(true)
+	}
+	return running, unkillable
+}
+
+// rejectIfBusy risponde BUSY se un altro client sta eseguendo uno script
+// oltre il limite di tempo; restano consentiti solo SCRIPT KILL e FUNCTION KILL
+func (s *PodCacheServer) rejectIfBusy(client *Client, cmd *resp.Command) bool {
+	if !s.scripting.busy(client) {
+		return false
+	}
+	if (cmd.Type == resp.RESP_SCRIPT || cmd.Type == resp.RESP_FUNCTION) &&
+		len(cmd.Arguments) > 0 && strings.ToUpper(cmd.Arguments[0]) == "KILL" {
+		return false
+	}
+	client.writer.WriteString("-BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE.\r\n")
+	client.flush()
+	return true
+}
+
+// parseNumKeys divide gli argomenti di EVAL/FCALL (nome numkeys key... arg...) in chiavi e argomenti
+func parseNumKeys(args []string) (keys, argv []string, err error) {
+	numKeys, convErr := strconv.Atoi(args[1])
+	if convErr != nil {
+		return nil, nil, errors.New("value is not an integer or out of range")
+	}
+	if numKeys < 0 {
+		return nil, nil, errors.New("Number of keys can't be negative")
+	}
+	if numKeys > len(args)-2 {
+		return nil, nil, errors.New("Number of keys can't be greater than number of args")
+	}
+	return args[2 : 2+numKeys], args[2+numKeys:], nil
+}
+
+// handleEval implementa EVAL script numkeys [key ...] [arg ...] ed EVALSHA
+func (s *PodCacheServer) handleEval(client *Client, args []string, bySHA bool) error {
+	if len(args) < 2 {
+		name := "eval"
+		if bySHA {
+			name = "evalsha"
+		}
+		return client.sendError("wrong number of arguments for '" + name + "' command")
+	}
+	keys, argv, err := parseNumKeys(args)
+	if err != nil {
+		return client.sendError(err.Error())
+	}
+
+	var fn *lua.Closure
+	if bySHA {
+		if fn = s.scripting.get(args[0]); fn == nil {
+			client.writer.WriteString("-NOSCRIPT No matching script. Please use EVAL.\r\n")
+			return client.flush()
+		}
+	} else {
+		if _, fn, err = s.scripting.load(args[0]); err != nil {
+			return client.sendError("Error compiling script (new function): " + err.Error())
+		}
+	}
+	return s.runScript(client, keys, false, func(state *lua.State) ([]lua.Value, error) {
+		state.SetGlobal("KEYS", stringsTable(keys))
+		state.SetGlobal("ARGV", stringsTable(argv))
+		return state.Call(fn, nil)
+	})
+}
+
+// runScript prepara un interprete con la libreria redis e vi esegue invoke
+// tenendo i lock delle chiavi dichiarate, poi invia al
+// client il valore restituito
+func (s *PodCacheServer) runScript(client *Client, keys []string, readOnly bool, invoke func(state *lua.State) ([]lua.Value, error)) error {
+	run := &scriptRun{client: client, start: time.Now(), keys: make(map[string]struct{}, len(keys)), readOnly: readOnly}
+	for _, key := range keys {
+		run.keys[key] = struct{}{}
+	}
+	s.scripting.begin(run)
+	defer s.scripting.end(run)
+
+	return s.db(client).Atomic(keys, func(view *cache.PodCache) error {
+		state := lua.NewState()
+		state.OpenLibs()
+		state.SetGlobal("redis", s.redisLib(client, run, view))
+		state.Strict = true
+		state.Interrupt = func() error {
+			if run.killed.Load() {
+				return errScriptKilled
+			}
+			return nil
+		}
+
+		rets, err := invoke(state)
+		if err != nil {
+			return sendScriptError(client, err)
+		}
+		var result lua.Value
+		if len(rets) > 0 {
+			result = rets[0]
+		}
+		writeLuaValue(client, result)
+		return client.flush()
+	})
+}
+
+func stringsTable(values []string) *lua.Table {
+	t := lua.NewTable()
+	for _, v := range values {
+		t.Append(v)
+	}
+	return t
+}
+
+func sendScriptError(client *Client, err error) error {
+	if errors.Is(err, errScriptKilled) {
+		return client.sendError(err.Error())
+	}
+	var luaErr *lua.Error
+	if errors.As(err, &luaErr) {
+		if t, ok := luaErr.Value.(*lua.Table); ok {
+			if msg, ok := t.Get("err").(string); ok {
+				writeErrorMessage(client, msg)
+				return client.flush()
+			}
+		}
+	}
+	return client.sendError(sanitizeLine(err.Error()))
+}
+
+// writeErrorMessage scrive un errore così com'è, aggiungendo ERR se manca il codice
+func writeErrorMessage(client *Client, message string) {
+	message = sanitizeLine(message)
+	if code, _, _ := strings.Cut(message, " "); !isErrorCode(code) {
+		message = "ERR " + message
+	}
+	client.writer.WriteString("-" + message + "\r\n")
+}
+
+func sanitizeLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// writeLuaValue converte il valore restituito dallo script in una risposta RESP
+// con le regole di Redis: i numeri sono troncati a interi, true diventa 1,
+// false e nil una bulk nulla, le tabelle array fino al primo nil
+func writeLuaValue(client *Client, v lua.Value) {
+	switch x := v.(type) {
+	case string:
+		client.writeBulk(x)
+	case float64:
+		client.writeInt(int64(x))
+	case bool:
+		if x {
+			client.writeInt(1)
+		} else {
+			client.writeNullBulk()
+		}
+	case *lua.Table:
+		if msg, ok := x.Get("err").(string); ok {
+			writeErrorMessage(client, msg)
+			return
+		}
+		if msg, ok := x.Get("ok").(string); ok {
+			client.writer.WriteString("+" + sanitizeLine(msg) + "\r\n")
+			return
+		}
+		var items []lua.Value
+		for i := 1; ; i++ {
+			item := x.Get(float64(i))
+			if item == nil {
+				break
+			}
+			items = append(items, item)
+		}
+		client.writeArray(len(items))
+		for _, item := range items {
+			writeLuaValue(client, item)
+		}
+	default:
+		client.writeNullBulk()
+	}
+}
+
+// redisLib costruisce la tabella redis esposta allo script
+func (s *PodCacheServer) redisLib(client *Client, run *scriptRun, view *cache.PodCache) *lua.Table {
+	// il client fittizio esegue i comandi sulla vista con i lock già presi e
+	// scrive le risposte in un buffer, da cui vengono rilette e convertite in Lua
+	var output bytes.Buffer
+	scriptClient := &Client{
+		id:     client.id,
+		conn:   client.conn,
+		writer: bufio.NewWriter(&output),
+		view:   client.view,
+		txView: view,
+	}
+	scriptClient.protocol.Store(2)
+
+	call := func(args []lua.Value, raise bool) ([]lua.Value, error) {
+		reply, err := s.scriptCall(client, scriptClient, &output, run, args)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Kind == resp.ReplyError && raise {
+			return nil, &lua.Error{Value: replyToLua(reply)}
+		}
+		return []lua.Value{replyToLua(reply)}, nil
+	}
+
+	lib := lua.NewTable()
+	lua.Register(lib, map[string]func(*lua.State, []lua.Value) ([]lua.Value, error){
+		"call": func(_ *lua.State, args []lua.Value) ([]lua.Value, error) {
+			return call(args, true)
+		},
+		"pcall": func(_ *lua.State, args []lua.Value) ([]lua.Value, error) {
+			rets, err := call(args, false)
+			var luaErr *lua.Error
+			if errors.As(err, &luaErr) {
+				return []lua.Value{luaErr.Value}, nil
+			}
+			return rets, err
+		},
+		"error_reply": func(_ *lua.State, args []lua.Value) ([]lua.Value, error) {
+			return statusTable(args, "err", "error_reply")
+		},
+		"status_reply": func(_ *lua.State, args []lua.Value) ([]lua.Value, error) {
+			return statusTable(args, "ok", "status_reply")
+		},
+		"sha1hex": func(_ *lua.State, args []lua.Value) ([]lua.Value, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("wrong number of arguments")
+			}
+			str, _ := lua.ToString(args[0])
+			return []lua.Value{sha1hex(str)}, nil
+		},
+		"log": scriptLog(s.logger),
+	})
+	setLogLevels(lib)
+	return lib
+}
+
+// scriptLog implementa redis.log inoltrando il messaggio al logger del server
+func scriptLog(logger logging.Logger) func(*lua.State, []lua.Value) ([]lua.Value, error) {
+	return func(_ *lua.State, args []lua.Value) ([]lua.Value, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("redis.log() requires two arguments or more.")
+		}
+		level, ok := lua.ToNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("First argument must be a number (log level).")
+		}
+		parts := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			str, _ := lua.ToString(arg)
+			parts = append(parts, str)
+		}
+		message := strings.Join(parts, " ")
+		switch int(level) {
+		case 0, 1:
+			logger.Debug("Script log", "message", message)
+		case 2:
+			logger.Info("Script log", "message", message)
+		default:
+			logger.Warn("Script log", "message", message)
+		}
+		return nil, nil
+	}
+}
+
+func setLogLevels(lib *lua.Table) {
+	lib.Set("LOG_DEBUG", 0.0)
+	lib.Set("LOG_VERBOSE", 1.0)
+	lib.Set("LOG_NOTICE", 2.0)
+	lib.Set("LOG_WARNING", 3.0)
+}
+
+func statusTable(args []lua.Value, field, fname string) ([]lua.Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("wrong number or type of arguments")
+	}
+	str, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("bad argument #1 to '%s' (string expected, got %s)", fname, lua.TypeName(args[0]))
+	}
+	t := lua.NewTable()
+	t.Set(field, str)
+	return []lua.Value{t}, nil
+}
+
+// scriptError è l'errore sollevato da redis.call, una tabella {err=...} come in Redis
+func scriptError(message string) error {
+	t := lua.NewTable()
+	t.Set("err", "ERR "+message)
+	return &lua.Error{Value: t}
+}
+
+// scriptCall esegue un comando per conto dello script e ne rilegge la risposta
+func (s *PodCacheServer) scriptCall(client, scriptClient *Client, output *bytes.Buffer, run *scriptRun, args []lua.Value) (resp.Reply, error) {
+	if len(args) == 0 {
+		return resp.Reply{}, scriptError("Please specify at least one argument for this redis lib call")
+	}
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		str, ok := lua.ToString(arg)
+		if !ok {
+			return resp.Reply{}, scriptError("Lua redis lib command arguments must be strings or integers")
+		}
+		strs[i] = str
+	}
+
+	cmd := resp.NewCommand(strs[0], strs[1:]...)
+	spec := lookupCommand(cmd)
+	if spec == nil {
+		return resp.Reply{}, scriptError("Unknown Redis command called from script")
+	}
+	if spec.flags&flagNoScript != 0 {
+		return resp.Reply{}, scriptError("This Redis command is not allowed from script")
+	}
+	if !spec.checkArity(cmd.Arguments) {
+		return resp.Reply{}, scriptError("Wrong number of args calling Redis command from script")
+	}
+	if denial := s.checkACL(s.acl.user(client.username()), spec, cmd); denial != nil {
+		s.acl.log.add(denial.reason, "lua", denial.object, client.username(), client.info())
+		switch denial.reason {
+		case "command":
+			return resp.Reply{}, scriptError("The user executing the script can't run this command or subcommand")
+		case "key":
+			return resp.Reply{}, scriptError("The user executing the script can't access at least one of the keys mentioned in the command")
+		default:
+			return resp.Reply{}, scriptError("The user executing the script can't publish to the channel mentioned in the command")
+		}
+	}
+	if spec.flags&flagWrite != 0 {
+		if run.readOnly {
+			return resp.Reply{}, scriptError("Write commands are not allowed from read-only scripts.")
+		}
+		run.wrote.Store(true)
+	}
+	// i lock coprono solo le partizioni delle chiavi dichiarate in KEYS
+	for _, key := range spec.extractKeys(cmd.Arguments) {
+		if _, ok := run.keys[key]; !ok {
+			return resp.Reply{}, scriptError("Script attempted to access a key not declared in KEYS: '" + key + "'")
+		}
+	}
+
+	s.trackRead(client, cmd)
+	output.Reset()
+	s.dispatch(scriptClient, cmd)
+	scriptClient.flush()
+	return resp.ReadReply(bufio.NewReader(output))
+}
+
+// replyToLua converte una risposta RESP nei tipi Lua con le regole di Redis
+func replyToLua(reply resp.Reply) lua.Value {
+	switch reply.Kind {
+	case resp.ReplyStatus:
+		t := lua.NewTable()
+		t.Set("ok", reply.Str)
+		return t
+	case resp.ReplyError:
+		t := lua.NewTable()
+		t.Set("err", reply.Str)
+		return t
+	case resp.ReplyInteger:
+		return float64(reply.Int)
+	case resp.ReplyBulk:
+		return reply.Str
+	case resp.ReplyArray:
+		t := lua.NewTable()
+		for _, element := range reply.Elements {
+			// gli elementi nulli diventano false, così l'array non si interrompe
+			t.Append(replyToLua(element))
+		}
+		return t
+	}
+	return false
+}
+
+// handleScript implementa SCRIPT LOAD|EXISTS|FLUSH|KILL
+func (s *PodCacheServer) handleScript(client *Client, args []string) error {
+	if len(args) == 0 {
+		return client.sendError("wrong number of arguments for 'script' command")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "LOAD":
+		if len(args) != 2 {
+			return client.sendError("wrong number of arguments for 'script|load' command")
+		}
+		sha, _, err := s.scripting.load(args[1])
+		if err != nil {
+			return client.sendError("Error compiling script (new function): " + err.Error())
+		}
+		return client.sendBulkString(sha)
+	case "EXISTS":
+		if len(args) < 2 {
+			return client.sendError("wrong number of arguments for 'script|exists' command")
+		}
+		client.writeArray(len(args) - 1)
+		for _, sha := range args[1:] {
+			if s.scripting.get(sha) != nil {
+				client.writeInt(1)
+			} else {
+				client.writeInt(0)
+			}
+		}
+		return client.flush()
+	case "FLUSH":
+		if len(args) > 2 {
+			return client.sendError("wrong number of arguments for 'script|flush' command")
+		}
+		if len(args) == 2 {
+			mode := strings.ToUpper(args[1])
+			if mode != "ASYNC" && mode != "SYNC" {
+				return client.sendError("SCRIPT FLUSH only support SYNC|ASYNC option")
+			}
+		}
+		s.scripting.flush()
+		return client.sendOK("OK")
+	case "KILL":
+		if len(args) != 1 {
+			return client.sendError("wrong number of arguments for 'script|kill' command")
+		}
+		return s.killScripts(client)
+	default:
+		return client.sendError(fmt.Sprintf("unknown subcommand '%s'. Try SCRIPT HELP.", args[0]))
+	}
+}
+
+// killScripts implementa SCRIPT KILL e FUNCTION KILL
+func (s *PodCacheServer) killScripts(client *Client) error {
+	running, unkillable := s.scripting.kill()
+	switch {
+	case !running:
+		client.writer.WriteString("-NOTBUSY No scripts in execution right now.\r\n")
+		return client.flush()
+	case unkillable:
+		client.writer.WriteString("-UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.\r\n")
+		return client.flush()
+	}
+	return client.sendOK("OK")
+}
+
+// evalKeys estrae le chiavi di EVAL/EVALSHA/FCALL: script numkeys key... arg...
+func evalKeys(args []string) []string {
+	if len(args) < 2 {
+		return nil
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 || n > len(args)-2 {
+		return nil
+	}
+	return args[2 : 2+n]
+}
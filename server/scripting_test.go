@@ -0,0 +1,133 @@
+package server
+
+import (
+	"mi0772/podcache/resp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	if got := c.array("EVAL", "return {KEYS[1], ARGV[1], ARGV[2]}", "1", "k", "a", "b"); strings.Join(got, " ") != "k a b" {
+		t.Fatalf("EVAL KEYS/ARGV = %q, want [k a b]", got)
+	}
+	c.ok("EVAL", "return redis.call('SET', KEYS[1], ARGV[1])", "1", "k", "v")
+	if got := c.bulk("EVAL", "return redis.call('GET', KEYS[1])", "1", "k"); got != "v" {
+		t.Fatalf("EVAL GET = %q, want v", got)
+	}
+
+	// conversioni dei valori Lua restituiti
+	if got := c.integer("EVAL", "return 3.7", "0"); got != 3 {
+		t.Fatalf("EVAL return 3.7 = %d, want 3", got)
+	}
+	if got := c.integer("EVAL", "return true", "0"); got != 1 {
+		t.Fatalf("EVAL return true = %d, want 1", got)
+	}
+	if reply := c.do("EVAL", "return false", "0"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("EVAL return false = %+v, want nil", reply)
+	}
+	if got := c.array("EVAL", "return {1, 2, nil, 4}", "0"); len(got) != 2 {
+		t.Fatalf("EVAL array with nil = %q, want 2 elements", got)
+	}
+	if reply := c.do("EVAL", "return redis.status_reply('DONE')", "0"); reply.Kind != resp.ReplyStatus || reply.Str != "DONE" {
+		t.Fatalf("EVAL status_reply = %+v, want +DONE", reply)
+	}
+	c.fails("MYERR custom", "EVAL", "return redis.error_reply('MYERR custom')", "0")
+
+	c.fails("ERR Number of keys can't be greater than number of args", "EVAL", "return 1", "2", "k")
+	c.fails("ERR Number of keys can't be negative", "EVAL", "return 1", "-1")
+	c.fails("ERR value is not an integer or out of range", "EVAL", "return 1", "x")
+	c.fails("ERR Error compiling script", "EVAL", "return +", "0")
+}
+
+func TestEvalSHA(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+	script := "return ARGV[1]"
+
+	sha := c.bulk("SCRIPT", "LOAD", script)
+	if sha != sha1hex(script) {
+		t.Fatalf("SCRIPT LOAD = %q, want %q", sha, sha1hex(script))
+	}
+	if got := c.bulk("EVALSHA", sha, "0", "hello"); got != "hello" {
+		t.Fatalf("EVALSHA = %q, want hello", got)
+	}
+	// uno SHA mai caricato chiede al client di usare EVAL
+	c.fails("NOSCRIPT No matching script. Please use EVAL.", "EVALSHA", strings.Repeat("0", 40), "0")
+
+	// EVAL registra lo script per le EVALSHA successive
+	other := "return 'other'"
+	c.bulk("EVAL", other, "0")
+	reply := c.do("SCRIPT", "EXISTS", sha, sha1hex(other), strings.Repeat("f", 40))
+	if len(reply.Elements) != 3 || reply.Elements[0].Int != 1 || reply.Elements[1].Int != 1 || reply.Elements[2].Int != 0 {
+		t.Fatalf("SCRIPT EXISTS = %+v, want [1 1 0]", reply)
+	}
+
+	c.ok("SCRIPT", "FLUSH")
+	c.fails("NOSCRIPT", "EVALSHA", sha, "0", "hello")
+	if reply := c.do("SCRIPT", "EXISTS", sha); len(reply.Elements) != 1 || reply.Elements[0].Int != 0 {
+		t.Fatalf("SCRIPT EXISTS after FLUSH = %+v, want [0]", reply)
+	}
+	c.ok("SCRIPT", "FLUSH", "ASYNC")
+	c.fails("ERR SCRIPT FLUSH only support SYNC|ASYNC option", "SCRIPT", "FLUSH", "LATER")
+	c.fails("ERR Error compiling script", "SCRIPT", "LOAD", "return +")
+	c.fails("ERR unknown subcommand 'BOGUS'", "SCRIPT", "BOGUS")
+}
+
+func TestScriptCallErrors(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+	c.ok("SET", "text", "abc")
+
+	// redis.call propaga l'errore del comando, redis.pcall lo restituisce come tabella
+	c.fails("ERR value is not an integer or out of range", "EVAL", "return redis.call('INCR', KEYS[1])", "1", "text")
+	if got := c.bulk("EVAL", "local r = redis.pcall('INCR', KEYS[1]) return r['err']", "1", "text"); !strings.HasPrefix(got, "ERR value is not an integer") {
+		t.Fatalf("redis.pcall error = %q", got)
+	}
+	c.fails("ERR Unknown Redis command called from script", "EVAL", "return redis.call('NOSUCHCOMMAND')", "0")
+	c.fails("ERR Wrong number of args calling Redis command from script", "EVAL", "return redis.call('GET')", "0")
+	c.fails("ERR This Redis command is not allowed from script", "EVAL", "return redis.call('SWAPDB', '0', '1')", "0")
+	c.fails("ERR Please specify at least one argument for this redis lib call", "EVAL", "return redis.call()", "0")
+
+	// le chiavi vanno dichiarate in KEYS: i lock coprono solo quelle
+	c.fails("ERR Script attempted to access a key not declared in KEYS: 'text'", "EVAL", "return redis.call('GET', 'text')", "0")
+	c.fails("ERR Script attempted to access a key not declared in KEYS: 'other'", "EVAL", "return redis.call('SET', 'other', '1')", "1", "text")
+	if reply := c.do("GET", "other"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("GET other = %+v, want nil: the rejected call must not write", reply)
+	}
+}
+
+func TestScriptBusyAndKill(t *testing.T) {
+	s := newTestServer(t, "busy-reply-threshold", "50")
+	c := newTestClient(t, s)
+	other := dialTest(t, "tcp", c.conn.RemoteAddr().String())
+
+	other.fails("NOTBUSY No scripts in execution right now.", "SCRIPT", "KILL")
+
+	c.send("EVAL", "while true do end", "0")
+	// finché lo script non supera busy-reply-threshold gli altri client sono serviti
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reply := other.do("PING")
+		if reply.Kind == resp.ReplyError {
+			if !strings.HasPrefix(reply.Str, "BUSY Redis is busy running a script.") {
+				t.Fatalf("PING during a long script = %+v, want BUSY", reply)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no BUSY reply while the script is running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	other.fails("BUSY", "GET", "k")
+
+	other.ok("SCRIPT", "KILL")
+	if reply := c.read(); reply.Kind != resp.ReplyError || !strings.Contains(reply.Str, "Script killed by user with SCRIPT KILL") {
+		t.Fatalf("EVAL after SCRIPT KILL = %+v, want the killed error", reply)
+	}
+	if reply := other.do("PING"); reply.Kind != resp.ReplyStatus || reply.Str != "PONG" {
+		t.Fatalf("PING after SCRIPT KILL = %+v, want PONG", reply)
+	}
+	c.ok("SET", "k", "v")
+}
@@ -3,20 +3,27 @@ package server
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"mi0772/podcache/cache"
+	"mi0772/podcache/config"
 	"mi0772/podcache/logging"
 	"mi0772/podcache/resp"
+	"mi0772/podcache/tracing"
 	"net"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const MAX_COMMAND_SIZE = 512 * 1024 * 1024
 
+// Version è la versione riportata da HELLO, impostata dal main
+var Version = "dev"
+
 var (
 	ErrMissingKey     = errors.New("missing key")
 	ErrMissingValue   = errors.New("missing key or value")
@@ -25,41 +32,168 @@ var (
 )
 
 type PodCacheServer struct {
-	port    int
-	cache   *cache.PodCache
-	running bool
-	logger  logging.Logger
+	port     int
+	cache    *cache.PodCache
+	running  bool
+	logger   logging.Logger
+	waiters  *keyWaiters
+	pubsub   *pubSub
+	clients  *clientRegistry
+	tracking *trackingTable
+	pause    *clientPause
+	stats    *serverStats
+	slowlog  *slowlog
+
+	config *config.Registry
+	// idleTimeout è letto a ogni comando, quindi è tenuto allineato a "timeout"
+	idleTimeout  atomic.Int64
+	outputLimits atomic.Pointer[outputLimits]
+	// connected conta le connessioni fino al rilascio di socket e coda di
+	// uscita: vale sia per maxclients sia per connected_clients
+	connected atomic.Int64
+	// writeTimeout è clientWriteTimeout, più breve nei test
+	writeTimeout time.Duration
+	scripting    *scriptEngine
+	functions    *functionRegistry
+	acl          *aclRegistry
+	tls          *tlsOptions
+	tlsCerts     atomic.Pointer[tlsReloader]
+	unix         *unixSocketOptions
+	tracer       *tracing.Tracer
+	// traces è nil se tracing-endpoint non è impostato
+	traces *tracing.Exporter
+
+	// notifyFlags sono i flag di notify-keyspace-events attivi
+	notifyFlags  atomic.Uint32
+	nextClientID atomic.Uint64
 }
 
-func NewPodCacheServer(cache *cache.PodCache, logger logging.Logger) *PodCacheServer {
+func NewPodCacheServer(cache *cache.PodCache, cfg *config.Registry, logger logging.Logger) *PodCacheServer {
 	server := &PodCacheServer{
-		cache:  cache,
-		logger: logger,
+		cache:    cache,
+		config:   cfg,
+		logger:   logger,
+		waiters:  newKeyWaiters(),
+		pubsub:   newPubSub(),
+		clients:  newClientRegistry(),
+		tracking: newTrackingTable(),
+		pause:    newClientPause(),
+		stats:    newServerStats(),
 	}
-	server.port = getPort(logger)
+	server.writeTimeout = clientWriteTimeout
+	server.port = int(cfg.Int("port"))
+	server.tls = getTLSOptions(cfg)
+	server.unix = getUnixSocket(cfg)
+	server.tracer, server.traces = newTracer(cfg, logger)
+	server.slowlog = newSlowlog(cfg.Int("slowlog-log-slower-than"), cfg.Int("slowlog-max-len"))
+	cache.LatencyMonitor().SetThreshold(time.Duration(cfg.Int("latency-monitor-threshold")) * time.Millisecond)
+	server.idleTimeout.Store(int64(server.configSeconds("timeout")))
+	limits, _ := parseOutputLimits(cfg.String("client-output-buffer-limit"), defaultOutputLimits)
+	server.outputLimits.Store(&limits)
+	server.acl = newACLRegistry(cfg.String("aclfile"), getRequirePass(cfg, logger), logger)
+	cache.SetDatabases(int(cfg.Int("databases")))
+	server.scripting = newScriptEngine(time.Duration(cfg.Int("busy-reply-threshold")) * time.Millisecond)
+	server.functions = newFunctionRegistry(getFunctionsPath(cfg), logger)
+	server.functions.loadFromDisk()
+	server.configureNotifications()
+	cache.SetInvalidator(server.invalidateKey)
+	server.watchConfig()
 	return server
 }
 
 func (s *PodCacheServer) Start(ctx context.Context) error {
 	logging.LogServerPhase(s.logger, logging.OpStarting)
 
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+	// PODCACHE_PORT=0 disattiva la porta in chiaro, per servire solo TLS o il socket Unix
+	var listeners []net.Listener
+	if s.port != 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+		if err != nil {
+			logging.LogServerError(s.logger, logging.OpStarting, err)
+			s.logger.Fatal("failed to start server")
+		}
+		listeners = append(listeners, listener)
+	}
+	if s.tls != nil {
+		reloader, err := newTLSReloader(s.tls, s.logger)
+		if err != nil {
+			logging.LogServerError(s.logger, logging.OpStarting, err)
+			s.logger.Fatal("failed to load TLS certificates")
+		}
+		s.tlsCerts.Store(reloader)
+		listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.tls.port), reloader.config())
+		if err != nil {
+			logging.LogServerError(s.logger, logging.OpStarting, err)
+			s.logger.Fatal("failed to start TLS listener")
+		}
+		listeners = append(listeners, listener)
+		go reloader.watch(ctx, tlsReloadInterval)
+	}
+	if s.unix != nil {
+		listener, err := listenUnix(s.unix)
+		if err != nil {
+			logging.LogServerError(s.logger, logging.OpStarting, err)
+			s.logger.Fatal("failed to start unix socket listener", "path", s.unix.path)
+		}
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		s.logger.Fatal("no listener configured: set PODCACHE_PORT, PODCACHE_TLS_PORT or PODCACHE_UNIXSOCKET")
+	}
+	// allo shutdown Start attende l'invio delle tracce ancora in coda
+	tracesDone := make(chan struct{})
+	go func() {
+		defer close(tracesDone)
+		if s.traces != nil {
+			s.traces.Run(ctx)
+		}
+	}()
+	metricsPort, err := s.startMetrics(ctx)
 	if err != nil {
 		logging.LogServerError(s.logger, logging.OpStarting, err)
-		s.logger.Fatal("failed to start server")
+		s.logger.Fatal("failed to start metrics endpoint")
 	}
-	defer listener.Close()
 
 	s.running = true
 
-	logging.LogServerPhase(s.logger, logging.OpStarted, "port", s.port)
+	started := []any{"port", s.port}
+	if s.tls != nil {
+		started = append(started, "tls_port", s.tls.port)
+	}
+	if s.unix != nil {
+		started = append(started, "unixsocket", s.unix.path)
+	}
+	if metricsPort != 0 {
+		started = append(started, "metrics_port", metricsPort)
+	}
+	if s.traces != nil {
+		started = append(started, "tracing_endpoint", s.config.String("tracing-endpoint"))
+	}
+	logging.LogServerPhase(s.logger, logging.OpStarted, started...)
 	// Graceful shutdown
 	go func() {
 		<-ctx.Done()
 		s.running = false
-		listener.Close()
+		for _, listener := range listeners {
+			listener.Close()
+		}
 	}()
 
+	var wg sync.WaitGroup
+	for _, listener := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serve(listener)
+		}()
+	}
+	wg.Wait()
+	<-tracesDone
+
+	return nil
+}
+
+func (s *PodCacheServer) serve(listener net.Listener) {
 	for s.running {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -70,37 +204,107 @@ func (s *PodCacheServer) Start(ctx context.Context) error {
 		}
 		go s.handleConnection(conn)
 	}
-
-	return nil
 }
 
 func (s *PodCacheServer) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
-	// Set timeouts if it's a TCP connection
-	if tcpConn, ok := conn.(*net.TCPConn); ok {
-		tcpConn.SetReadDeadline(time.Now().Add(30 * time.Second))
-		tcpConn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	setKeepAlive(conn, s.configSeconds("tcp-keepalive"))
+	// l'handshake TLS avviene subito, così un certificato client rifiutato
+	// chiude la connessione prima di allocare il client
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			s.logger.Warn("TLS handshake failed", "addr", conn.RemoteAddr().String(), "error", err)
+			return
+		}
+		conn.SetDeadline(time.Time{})
+	}
+
+	// come maxclients di Redis, oltre il limite la connessione riceve un errore e viene chiusa
+	s.stats.connectionsReceived.Add(1)
+	maxClients := s.config.Int("maxclients")
+	if s.connected.Add(1) > maxClients {
+		s.connected.Add(-1)
+		s.stats.rejectedConnections.Add(1)
+		s.logger.Warn("Max number of clients reached, closing connection", "addr", conn.RemoteAddr().String(), "maxclients", maxClients)
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte("-ERR max number of clients reached\r\n"))
+		return
 	}
 
+	// il posto si libera dopo out.close, quando la coda non trattiene più memoria
+	defer s.connected.Add(-1)
+	out := newClientOutput(conn, &s.outputLimits, s.writeTimeout)
 	client := &Client{
+		id:     s.nextClientID.Add(1),
 		conn:   conn,
 		reader: bufio.NewReader(conn),
-		writer: bufio.NewWriter(conn),
+		writer: bufio.NewWriter(out),
+		out:    out,
+		killed: make(chan struct{}),
 	}
-
+	client.created = time.Now()
+	client.lastInteraction = client.created
+	// se l'utente default non ha password il client è già autenticato
+	if u := s.acl.user(defaultUser); u != nil && u.enabled && u.nopass {
+		client.authenticated = true
+	}
+	client.setUser(defaultUser)
+	client.protocol.Store(2)
+	client.view = s.cache.WithOrigin(client).WithTierUsage(&client.tiers)
+	client.publishState()
+	s.clients.add(client)
+	defer out.close()
+	defer s.clients.remove(client)
+	defer s.tracking.disable(client)
+	defer s.unwatchAll(client)
+	defer s.unsubscribeAll(client)
+
+	deadline := false
 	for {
-		command, err := s.readCommand(client)
+		// il timeout di inattività riparte a ogni comando; i client in pub/sub
+		// restano in attesa di messaggi e non scadono, come in Redis. Con
+		// CONFIG SET timeout 0 la scadenza già impostata va tolta.
+		timeout := time.Duration(s.idleTimeout.Load())
+		if timeout > 0 && !client.subscribed() {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+			deadline = true
+		} else if deadline && timeout == 0 {
+			conn.SetReadDeadline(time.Time{})
+			deadline = false
+		}
+		command, span, err := s.readCommand(client)
 		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				s.logger.Debug("Client idle timeout, closing connection", "addr", conn.RemoteAddr().String(), "timeout", timeout)
+				return
+			}
 			if !isConnectionClosed(err) {
 				s.logger.Error("Command read error", "error", err)
 				client.sendError("Invalid command")
+				client.commit()
 			}
 			return
 		}
 
-		if err := s.executeCommand(client, command); err != nil {
-			if errors.Is(err, errQuit) {
+		err = s.executeTraced(client, command, span)
+		flush := span.Child("flush")
+		commitErr := client.commit()
+		flush.End()
+		span.End()
+		if commitErr != nil {
+			if errors.Is(commitErr, errOutputLimit) {
+				s.logger.Warn("Client output buffer limit reached, closing connection", "addr", conn.RemoteAddr().String())
+			}
+			return
+		}
+		if client.closeAfterReply {
+			return
+		}
+		if err != nil {
+			if errors.Is(err, errQuit) || errors.Is(err, errClientKilled) {
 				return
 			}
 			s.logger.Error("Command execution error", "error", err)
@@ -110,22 +314,118 @@ func (s *PodCacheServer) handleConnection(conn net.Conn) {
 
 var errQuit = errors.New("client quit")
 
-func (s *PodCacheServer) readCommand(client *Client) (*resp.Command, error) {
-
+// readCommand legge il prossimo comando e, se la richiesta è campionata, ne
+// apre lo span radice. L'attesa del client non fa parte della richiesta: lo
+// span inizia quando arriva il primo byte.
+func (s *PodCacheServer) readCommand(client *Client) (*resp.Command, *tracing.Span, error) {
+	if _, err := client.reader.Peek(1); err != nil {
+		return nil, nil, err
+	}
+	span := s.tracer.Start("command")
+	parse := span.Child("parse")
 	reader := bufio.NewReader(client.reader)
-	return resp.ParseFromReader(reader)
-
+	command, err := resp.ParseFromReader(reader)
+	parse.End()
+	if err != nil {
+		span.SetError(err.Error())
+		span.End()
+		return nil, nil, err
+	}
+	return command, span, nil
 }
 
 func (s *PodCacheServer) executeCommand(client *Client, cmd *resp.Command) error {
+	client.lastCmd = commandName(cmd)
+	client.lastInteraction = time.Now()
+	client.publishState()
+	defer client.publishState()
+
+	spec := lookupCommand(cmd)
+	// le statistiche di INFO contano l'esito dalla risposta scritta dal comando
+	mark := client.outputMark()
+	outcome := outcomeRejected
+	var elapsed time.Duration
+	defer func() {
+		s.stats.record(spec, outcome, elapsed, client.errorCode(mark))
+	}()
+
+	if s.rejectIfUnauthenticated(client, spec) {
+		return nil
+	}
+	if s.rejectIfDenied(client, spec, cmd) {
+		return nil
+	}
+	if s.rejectIfBusy(client, cmd) {
+		return nil
+	}
+	if err := s.waitIfPaused(client, spec, cmd); err != nil {
+		return err
+	}
+
+	// in RESP2 una connessione iscritta a canali riceve solo messaggi push
+	if client.subscribed() && client.protocolVersion() == 2 {
+		switch cmd.Type {
+		case resp.RESP_SUBSCRIBE, resp.RESP_PSUBSCRIBE, resp.RESP_UNSUBSCRIBE, resp.RESP_PUNSUBSCRIBE, resp.RESP_PING, resp.RESP_QUIT:
+		default:
+			return client.sendError(fmt.Sprintf("Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context",
+				strings.ToLower(string(cmd.Type))))
+		}
+	}
+
+	if client.multi {
+		switch cmd.Type {
+		case resp.RESP_EXEC, resp.RESP_DISCARD, resp.RESP_MULTI, resp.RESP_WATCH, resp.RESP_QUIT:
+		default:
+			outcome = outcomeQueued
+			return s.queueCommand(client, cmd)
+		}
+	}
+
+	s.trackRead(client, cmd)
+	outcome = outcomeExecuted
+	client.tiers.Reset()
+	client.blockedFor = 0
+	start := time.Now()
+	err := s.dispatch(client, cmd)
+	// come in Redis l'attesa di XREAD BLOCK non conta nella durata del comando
+	elapsed = time.Since(start) - client.blockedFor
+	// i comandi sconosciuti non sono registrati, come quelli rifiutati
+	if spec != nil {
+		s.recordSlow(client, cmd, elapsed)
+		s.cache.LatencyMonitor().Add(commandLatencyEvent(spec), elapsed)
+	}
+	// CLIENT CACHING vale per il comando successivo, o per l'intera transazione
+	if !isClientCaching(cmd) && !client.multi {
+		client.cachingYes, client.cachingNo = false, false
+	}
+	return err
+}
+
+func isClientCaching(cmd *resp.Command) bool {
+	return cmd.Type == resp.RESP_CLIENT && len(cmd.Arguments) > 0 && strings.ToUpper(cmd.Arguments[0]) == "CACHING"
+}
+
+func (s *PodCacheServer) dispatch(client *Client, cmd *resp.Command) error {
 	switch cmd.Type {
 	case resp.RESP_PING:
-		return s.handlePing(client)
+		return s.handlePing(client, cmd.Arguments)
 	case resp.RESP_CLIENT:
 		return s.handleClient(client, cmd.Arguments)
 	case resp.RESP_QUIT:
 		client.sendOK("BYE")
 		return errQuit
+	case resp.RESP_AUTH:
+		return s.handleAuth(client, cmd.Arguments)
+	case resp.RESP_ACL:
+		return s.handleAcl(client, cmd.Arguments)
+	case resp.RESP_INFO:
+		return s.handleInfo(client, cmd.Arguments)
+	case resp.RESP_CONFIG:
+		return s.handleConfig(client, cmd.Arguments)
+	case resp.RESP_SLOWLOG:
+		return s.handleSlowlog(client, cmd.Arguments)
+	case resp.RESP_LATENCY:
+		return s.handleLatency(client, cmd.Arguments)
 	case resp.RESP_GET:
 		return s.handleGet(client, cmd.Arguments)
 	case resp.RESP_SET:
@@ -134,12 +434,122 @@ func (s *PodCacheServer) executeCommand(client *Client, cmd *resp.Command) error
 		return s.handleIncrement(client, cmd)
 	case resp.RESP_DEL, resp.RESP_UNLINK:
 		return s.handleDelete(client, cmd.Arguments)
+	case resp.RESP_PFADD:
+		return s.handlePFAdd(client, cmd.Arguments)
+	case resp.RESP_PFCOUNT:
+		return s.handlePFCount(client, cmd.Arguments)
+	case resp.RESP_PFMERGE:
+		return s.handlePFMerge(client, cmd.Arguments)
+	case resp.RESP_XADD:
+		return s.handleXAdd(client, cmd.Arguments)
+	case resp.RESP_XRANGE:
+		return s.handleXRange(client, cmd.Arguments, false)
+	case resp.RESP_XREVRANGE:
+		return s.handleXRange(client, cmd.Arguments, true)
+	case resp.RESP_XLEN:
+		return s.handleXLen(client, cmd.Arguments)
+	case resp.RESP_XTRIM:
+		return s.handleXTrim(client, cmd.Arguments)
+	case resp.RESP_XREAD:
+		return s.handleXRead(client, cmd.Arguments)
+	case resp.RESP_XGROUP:
+		return s.handleXGroup(client, cmd.Arguments)
+	case resp.RESP_XREADGROUP:
+		return s.handleXReadGroup(client, cmd.Arguments)
+	case resp.RESP_XACK:
+		return s.handleXAck(client, cmd.Arguments)
+	case resp.RESP_XPENDING:
+		return s.handleXPending(client, cmd.Arguments)
+	case resp.RESP_XCLAIM:
+		return s.handleXClaim(client, cmd.Arguments)
+	case resp.RESP_GEOADD:
+		return s.handleGeoAdd(client, cmd.Arguments)
+	case resp.RESP_GEOPOS:
+		return s.handleGeoPos(client, cmd.Arguments)
+	case resp.RESP_GEODIST:
+		return s.handleGeoDist(client, cmd.Arguments)
+	case resp.RESP_GEOHASH:
+		return s.handleGeoHash(client, cmd.Arguments)
+	case resp.RESP_GEOSEARCH:
+		return s.handleGeoSearch(client, cmd.Arguments)
+	case resp.RESP_GEOSEARCHSTORE:
+		return s.handleGeoSearchStore(client, cmd.Arguments)
+	case resp.RESP_ZREM:
+		return s.handleZRem(client, cmd.Arguments)
+	case resp.RESP_ZCARD:
+		return s.handleZCard(client, cmd.Arguments)
+	case resp.RESP_MULTI:
+		return s.handleMulti(client)
+	case resp.RESP_EXEC:
+		return s.handleExec(client)
+	case resp.RESP_DISCARD:
+		return s.handleDiscard(client)
+	case resp.RESP_WATCH:
+		return s.handleWatch(client, cmd.Arguments)
+	case resp.RESP_UNWATCH:
+		return s.handleUnwatch(client)
+	case resp.RESP_SUBSCRIBE:
+		return s.handleSubscribe(client, cmd.Arguments, false)
+	case resp.RESP_PSUBSCRIBE:
+		return s.handleSubscribe(client, cmd.Arguments, true)
+	case resp.RESP_UNSUBSCRIBE:
+		return s.handleUnsubscribe(client, cmd.Arguments, false)
+	case resp.RESP_PUNSUBSCRIBE:
+		return s.handleUnsubscribe(client, cmd.Arguments, true)
+	case resp.RESP_PUBLISH:
+		return s.handlePublish(client, cmd.Arguments)
+	case resp.RESP_PUBSUB:
+		return s.handlePubSub(client, cmd.Arguments)
+	case resp.RESP_HELLO:
+		return s.handleHello(client, cmd.Arguments)
+	case resp.RESP_EVAL:
+		return s.handleEval(client, cmd.Arguments, false)
+	case resp.RESP_EVALSHA:
+		return s.handleEval(client, cmd.Arguments, true)
+	case resp.RESP_SCRIPT:
+		return s.handleScript(client, cmd.Arguments)
+	case resp.RESP_SELECT:
+		return s.handleSelect(client, cmd.Arguments)
+	case resp.RESP_SWAPDB:
+		return s.handleSwapDB(client, cmd.Arguments)
+	case resp.RESP_FLUSHDB:
+		return s.handleFlush(client, cmd.Arguments, false)
+	case resp.RESP_FLUSHALL:
+		return s.handleFlush(client, cmd.Arguments, true)
+	case resp.RESP_DBSIZE:
+		return s.handleDBSize(client)
+	case resp.RESP_MOVE:
+		return s.handleMove(client, cmd.Arguments)
+	case resp.RESP_FUNCTION:
+		return s.handleFunction(client, cmd.Arguments)
+	case resp.RESP_FCALL:
+		return s.handleFcall(client, cmd.Arguments, false)
+	case resp.RESP_FCALL_RO:
+		return s.handleFcall(client, cmd.Arguments, true)
 	default:
 		return client.sendError("Unknown command")
 	}
 }
 
-func (s *PodCacheServer) handlePing(client *Client) error {
+func (s *PodCacheServer) handlePing(client *Client, args []string) error {
+	if len(args) > 1 {
+		return client.sendError("wrong number of arguments for 'ping' command")
+	}
+	// in modalità pub/sub RESP2 PING risponde con un array, come i messaggi
+	if client.subscribed() && client.protocolVersion() == 2 {
+		client.writeArray(2)
+		client.writeBulk("pong")
+		if len(args) == 1 {
+			client.writeBulk(args[0])
+		} else {
+			client.writeBulk("")
+		}
+		return client.flush()
+	}
+	if len(args) == 1 {
+		client.writeBulk(args[0])
+		return client.flush()
+	}
 	return client.sendOK("PONG")
 }
 
@@ -150,16 +560,31 @@ func (s *PodCacheServer) handleClient(client *Client, args []string) error {
 
 	switch strings.ToUpper(args[0]) {
 	case "LIST":
-		info := fmt.Sprintf("id=1 addr=%s age=0 idle=0 flags=N",
-			client.conn.RemoteAddr().String())
-		return client.sendBulkString(info)
-	case "SETNAME":
-		if len(args) < 2 {
-			return client.sendError("wrong number of arguments for 'client setname'")
-		}
+		return s.handleClientList(client, args[1:])
+	case "INFO":
+		client.publishState()
+		return client.sendBulkString(client.info() + "\n")
+	case "KILL":
+		return s.handleClientKill(client, args[1:])
+	case "PAUSE":
+		return s.handleClientPause(client, args[1:])
+	case "UNPAUSE":
+		s.pause.unpause()
 		return client.sendOK("OK")
+	case "SETNAME":
+		return s.handleClientSetName(client, args[1:])
 	case "GETNAME":
-		return client.sendBulkString("")
+		return s.handleClientGetName(client)
+	case "ID":
+		return client.sendInteger(int(client.id))
+	case "TRACKING":
+		return s.handleClientTracking(client, args[1:])
+	case "CACHING":
+		return s.handleClientCaching(client, args[1:])
+	case "GETREDIR":
+		return s.handleClientGetRedir(client)
+	case "TRACKINGINFO":
+		return s.handleClientTrackingInfo(client)
 	default:
 		return client.sendOK("OK")
 	}
@@ -170,9 +595,9 @@ func (s *PodCacheServer) handleGet(client *Client, args []string) error {
 		return client.sendError(ErrMissingKey.Error())
 	}
 
-	value, err := s.cache.Get(args[0])
+	value, err := s.db(client).Get(args[0])
 	if err != nil {
-		return client.sendError(err.Error())
+		return client.sendCacheError(err)
 	}
 
 	if value == nil {
@@ -187,8 +612,8 @@ func (s *PodCacheServer) handleSet(client *Client, args []string) error {
 		return client.sendError(ErrMissingValue.Error())
 	}
 
-	if err := s.cache.Put(args[0], []byte(args[1])); err != nil {
-		return client.sendError(err.Error())
+	if err := s.db(client).Put(args[0], []byte(args[1])); err != nil {
+		return client.sendCacheError(err)
 	}
 
 	return client.sendOK("OK")
@@ -200,37 +625,21 @@ func (s *PodCacheServer) handleIncrement(client *Client, cmd *resp.Command) erro
 	}
 
 	key := cmd.Arguments[0]
-	increment := 1
+	increment := int64(1)
 
 	if cmd.Type == resp.RESP_INCRBY && len(cmd.Arguments) >= 2 {
 		var err error
-		increment, err = strconv.Atoi(cmd.Arguments[1])
+		increment, err = strconv.ParseInt(cmd.Arguments[1], 10, 64)
 		if err != nil {
 			return client.sendError("increment must be an integer")
 		}
 	}
 
-	currentValue, err := s.cache.Get(key)
+	value, err := s.db(client).IncrBy(key, increment)
 	if err != nil {
-		return client.sendError(err.Error())
-	}
-
-	var newValue int
-	if currentValue == nil {
-		newValue = increment
-	} else {
-		currentInt, err := strconv.Atoi(string(currentValue))
-		if err != nil {
-			return client.sendError(ErrNotInteger.Error())
-		}
-		newValue = currentInt + increment
+		return client.sendCacheError(err)
 	}
-
-	if err := s.cache.Put(key, []byte(strconv.Itoa(newValue))); err != nil {
-		return client.sendError(err.Error())
-	}
-
-	return client.sendInteger(newValue)
+	return client.sendInteger(int(value))
 }
 
 func (s *PodCacheServer) handleDelete(client *Client, args []string) error {
@@ -240,7 +649,7 @@ func (s *PodCacheServer) handleDelete(client *Client, args []string) error {
 
 	deleted := 0
 	for _, key := range args {
-		if s.cache.Evict(key) {
+		if s.db(client).Evict(key) {
 			deleted++
 		}
 	}
@@ -250,9 +659,71 @@ func (s *PodCacheServer) handleDelete(client *Client, args []string) error {
 
 // Client rappresenta una connessione client
 type Client struct {
+	id     uint64
 	conn   net.Conn
 	reader *bufio.Reader
 	writer *bufio.Writer
+	out    *clientOutput
+	// protocol è la versione RESP negoziata con HELLO; è letta anche da chi
+	// pubblica messaggi verso il client, per questo è atomica
+	protocol atomic.Int32
+
+	created time.Time
+	// name è il nome di CLIENT SETNAME; state è lo stato pubblicato per
+	// CLIENT LIST, blocked è vero durante le attese di XREAD BLOCK e CLIENT PAUSE
+	name            atomic.Pointer[string]
+	state           atomic.Pointer[clientState]
+	blocked         atomic.Bool
+	lastCmd         string
+	lastInteraction time.Time
+	// killed è chiuso da CLIENT KILL; closeAfterReply chiude la connessione
+	// dopo la risposta quando il client chiude sé stesso
+	killed          chan struct{}
+	killOnce        sync.Once
+	closeAfterReply bool
+
+	// authenticated diventa vero con AUTH o HELLO AUTH se l'utente default ha
+	// una password; user è l'utente ACL, letto anche da ACL DELUSER
+	authenticated bool
+	user          atomic.Pointer[string]
+
+	// view è la cache vista da questo client sul DB selezionato con SELECT:
+	// le modifiche ne riportano l'origine
+	view *cache.PodCache
+
+	// canali e pattern pub/sub a cui il client è iscritto
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	// tracking sono le opzioni di CLIENT TRACKING (nil se disattivato);
+	// cachingYes/cachingNo sono il CLIENT CACHING per il comando successivo
+	tracking   *trackingOptions
+	cachingYes bool
+	cachingNo  bool
+
+	// stato MULTI/EXEC: comandi accodati, errori in accodamento e chiavi in WATCH
+	multi    bool
+	queued   []*resp.Command
+	txFailed bool
+	watched  map[watchedKey]uint64
+	// txView è la vista con i lock già acquisiti durante EXEC
+	txView *cache.PodCache
+
+	// tiers sono i livelli usati dal comando corrente tramite view, per SLOWLOG;
+	// blockedFor è il tempo passato in waitBlocked dal comando corrente
+	tiers      cache.TierUsage
+	blockedFor time.Duration
+}
+
+func (c *Client) username() string {
+	if name := c.user.Load(); name != nil {
+		return *name
+	}
+	return defaultUser
+}
+
+func (c *Client) setUser(name string) {
+	c.user.Store(&name)
 }
 
 func (c *Client) sendOK(message string) error {
@@ -271,6 +742,32 @@ func (c *Client) sendError(message string) error {
 	return c.writer.Flush()
 }
 
+// sendCacheError inoltra un errore della cache; se il messaggio porta già un
+// codice Redis (es. WRONGTYPE) viene inviato così com'è, altrimenti come ERR
+func (c *Client) sendCacheError(err error) error {
+	message := err.Error()
+	if code, _, found := strings.Cut(message, " "); found && isErrorCode(code) {
+		_, err := c.writer.WriteString(fmt.Sprintf("-%s\r\n", message))
+		if err != nil {
+			return err
+		}
+		return c.writer.Flush()
+	}
+	return c.sendError(message)
+}
+
+func isErrorCode(word string) bool {
+	if len(word) < 2 {
+		return false
+	}
+	for _, r := range word {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *Client) sendInteger(value int) error {
 	_, err := c.writer.WriteString(fmt.Sprintf(":%d\r\n", value))
 	if err != nil {
@@ -292,24 +789,77 @@ func (c *Client) sendBulkString(value string) error {
 }
 
 func (c *Client) sendNullBulkString() error {
-	_, err := c.writer.WriteString("$-1\r\n")
-	if err != nil {
-		return err
+	c.writeNullBulk()
+	return c.flush()
+}
+
+func (c *Client) sendNullArray() error {
+	c.writeNullArray()
+	return c.flush()
+}
+
+// I metodi write* accodano sul buffer senza flush, per comporre risposte
+// annidate; gli errori del bufio.Writer sono persistenti e riemergono in flush().
+
+func (c *Client) writeArray(length int) {
+	fmt.Fprintf(c.writer, "*%d\r\n", length)
+}
+
+func (c *Client) writeBulk(value string) {
+	fmt.Fprintf(c.writer, "$%d\r\n%s\r\n", len(value), value)
+}
+
+func (c *Client) writeInt(value int64) {
+	fmt.Fprintf(c.writer, ":%d\r\n", value)
+}
+
+// writeMap scrive l'intestazione di una mappa: nativa in RESP3, array piatto in RESP2
+func (c *Client) writeMap(length int) {
+	if c.protocolVersion() == 3 {
+		fmt.Fprintf(c.writer, "%%%d\r\n", length)
+		return
 	}
+	c.writeArray(length * 2)
+}
+
+func (c *Client) writeNullBulk() {
+	if c.protocolVersion() == 3 {
+		c.writer.WriteString("_\r\n")
+		return
+	}
+	c.writer.WriteString("$-1\r\n")
+}
+
+func (c *Client) writeNullArray() {
+	if c.protocolVersion() == 3 {
+		c.writer.WriteString("_\r\n")
+		return
+	}
+	c.writer.WriteString("*-1\r\n")
+}
+
+func (c *Client) flush() error {
 	return c.writer.Flush()
 }
 
-func getPort(logger logging.Logger) int {
-	if portStr, exists := os.LookupEnv("PODCACHE_PORT"); exists {
-		logger.Debug("PODCACHE_PORT found in environment", "port", portStr)
-		port, err := strconv.Atoi(portStr)
-		if err != nil {
-			logger.Error("PODCACHE_PORT must be a valid number", "error", err)
-			os.Exit(1)
-		}
-		return port
+// commit consegna all'output la risposta completa dell'ultimo comando
+func (c *Client) commit() error {
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+	return c.out.commit()
+}
+
+func (c *Client) protocolVersion() int {
+	return int(c.protocol.Load())
+}
+
+// pushPrefix è il tipo dei messaggi fuori banda: push in RESP3, array in RESP2
+func (c *Client) pushPrefix() byte {
+	if c.protocolVersion() == 3 {
+		return '>'
 	}
-	return 6379
+	return '*'
 }
 
 func isConnectionClosed(err error) bool {
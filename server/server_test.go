@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"mi0772/podcache/cache"
+	"mi0772/podcache/config"
+	"mi0772/podcache/logging"
+	"mi0772/podcache/resp"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer crea un server con i parametri di default, modificati dalle
+// coppie nome, valore di settings; disco e funzioni stanno in una directory temporanea
+func newTestServer(t *testing.T, settings ...string) *PodCacheServer {
+	t.Helper()
+
+	cfg := config.New()
+	RegisterConfig(cfg)
+	return configuredTestServer(t, cfg, settings...)
+}
+
+// newFileTestServer crea un server come newTestServer che legge prima il file
+// di configurazione con il contenuto indicato e poi l'ambiente, come all'avvio;
+// restituisce anche il percorso del file
+func newFileTestServer(t *testing.T, content string, settings ...string) (*PodCacheServer, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "podcache.conf")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() returned an error: %v", err)
+	}
+	cfg := config.New()
+	RegisterConfig(cfg)
+	if err := cfg.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile() returned an error: %v", err)
+	}
+	if err := cfg.LoadEnv(); err != nil {
+		t.Fatalf("LoadEnv() returned an error: %v", err)
+	}
+	return configuredTestServer(t, cfg, settings...), path
+}
+
+// configuredTestServer applica settings e dir a cfg e crea il server
+func configuredTestServer(t *testing.T, cfg *config.Registry, settings ...string) *PodCacheServer {
+	t.Helper()
+
+	overrides := config.Overrides{{Name: "dir", Value: t.TempDir()}}
+	for i := 0; i+1 < len(settings); i += 2 {
+		overrides = append(overrides, config.Entry{Name: settings[i], Value: settings[i+1]})
+	}
+	if err := cfg.Apply(overrides); err != nil {
+		t.Fatalf("Apply() returned an error: %v", err)
+	}
+
+	c, err := cache.NewPodCache(2, 4<<20, cfg.String("dir"), logging.NewNoOpLogger())
+	if err != nil {
+		t.Fatalf("NewPodCache() returned an error: %v", err)
+	}
+	return NewPodCacheServer(c, cfg, logging.NewNoOpLogger())
+}
+
+// listenTest serve le connessioni su una porta locale fino alla fine del test
+// e ne restituisce l'indirizzo
+func (s *PodCacheServer) listenTest(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() returned an error: %v", err)
+	}
+	return s.serveTest(t, listener)
+}
+
+// serveTest accetta le connessioni di listener fino alla fine del test
+func (s *PodCacheServer) serveTest(t *testing.T, listener net.Listener) string {
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handleConnection(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// testClient è una connessione RESP verso il server di un test
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTest(t *testing.T, network, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatalf("net.Dial() returned an error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// newTestClient avvia il server e vi collega un client
+func newTestClient(t *testing.T, s *PodCacheServer) *testClient {
+	t.Helper()
+	return dialTest(t, "tcp", s.listenTest(t))
+}
+
+// send scrive un comando senza attendere la risposta
+func (c *testClient) send(args ...string) {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		c.t.Fatalf("write %v: %v", args, err)
+	}
+}
+
+// read legge la prossima risposta
+func (c *testClient) read() resp.Reply {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := resp.ReadReply(c.reader)
+	if err != nil {
+		c.t.Fatalf("read reply: %v", err)
+	}
+	return reply
+}
+
+// do esegue un comando e restituisce la risposta
+func (c *testClient) do(args ...string) resp.Reply {
+	c.t.Helper()
+	c.send(args...)
+	return c.read()
+}
+
+// ok esegue un comando che deve rispondere +OK
+func (c *testClient) ok(args ...string) {
+	c.t.Helper()
+	if reply := c.do(args...); reply.Kind != resp.ReplyStatus || reply.Str != "OK" {
+		c.t.Fatalf("%v = %+v, want OK", args, reply)
+	}
+}
+
+// fails esegue un comando che deve rispondere con un errore che inizia con prefix
+func (c *testClient) fails(prefix string, args ...string) {
+	c.t.Helper()
+	if reply := c.do(args...); reply.Kind != resp.ReplyError || !strings.HasPrefix(reply.Str, prefix) {
+		c.t.Fatalf("%v = %+v, want error %q", args, reply, prefix)
+	}
+}
+
+// integer esegue un comando che deve rispondere con un intero
+func (c *testClient) integer(args ...string) int64 {
+	c.t.Helper()
+	reply := c.do(args...)
+	if reply.Kind != resp.ReplyInteger {
+		c.t.Fatalf("%v = %+v, want an integer", args, reply)
+	}
+	return reply.Int
+}
+
+// bulk esegue un comando che deve rispondere con una bulk string
+func (c *testClient) bulk(args ...string) string {
+	c.t.Helper()
+	reply := c.do(args...)
+	if reply.Kind != resp.ReplyBulk {
+		c.t.Fatalf("%v = %+v, want a bulk string", args, reply)
+	}
+	return reply.Str
+}
+
+// array esegue un comando che deve rispondere con un array di stringhe
+func (c *testClient) array(args ...string) []string {
+	c.t.Helper()
+	reply := c.do(args...)
+	if reply.Kind != resp.ReplyArray {
+		c.t.Fatalf("%v = %+v, want an array", args, reply)
+	}
+	values := make([]string, len(reply.Elements))
+	for i, e := range reply.Elements {
+		values[i] = e.Str
+	}
+	return values
+}
+
+// push legge il prossimo messaggio, che deve essere un push RESP3
+func (c *testClient) push() resp.Reply {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if kind, err := c.reader.Peek(1); err != nil || kind[0] != '>' {
+		c.t.Fatalf("next reply is not a push: %q, %v", kind, err)
+	}
+	return c.read()
+}
+
+// silent verifica che il server non invii nulla per un breve intervallo
+func (c *testClient) silent() {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := c.reader.Peek(1); err == nil {
+		c.t.Fatalf("unexpected reply %+v", c.read())
+	}
+}
+
+// closed riporta se il server ha chiuso la connessione, scartando le risposte
+// ancora da leggere
+func (c *testClient) closed() bool {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, err := resp.ReadReply(c.reader)
+		if err == nil {
+			continue
+		}
+		var netErr net.Error
+		return !errors.As(err, &netErr) || !netErr.Timeout()
+	}
+}
+
+// field restituisce il valore di name in una risposta di tipo mappa
+func field(reply resp.Reply, name string) resp.Reply {
+	for i := 0; i+1 < len(reply.Elements); i += 2 {
+		if reply.Elements[i].Str == name {
+			return reply.Elements[i+1]
+		}
+	}
+	return resp.Reply{Kind: resp.ReplyNil}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"fmt"
+	"mi0772/podcache/resp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limiti degli argomenti registrati, come SLOWLOG_ENTRY_MAX_ARGC e
+// SLOWLOG_ENTRY_MAX_STRING di Redis
+const (
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+const (
+	defaultSlowlogSlowerThan = 10000 // microsecondi
+	defaultSlowlogMaxLen     = 128
+)
+
+// slowlogEntry è un comando registrato da SLOWLOG; tiers sono i livelli che
+// lo hanno servito (vedi cache.TierUsage)
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+	tiers    string
+}
+
+// slowlog conserva gli ultimi comandi più lenti di slowlog-log-slower-than;
+// soglia e lunghezza sono lette a ogni comando, per questo sono atomiche
+type slowlog struct {
+	slowerThan atomic.Int64 // microsecondi, negativo disattiva
+	maxLen     atomic.Int64
+
+	mu      sync.Mutex
+	entries []slowlogEntry // dalla più vecchia alla più recente
+	nextID  int64
+}
+
+func newSlowlog(slowerThan, maxLen int64) *slowlog {
+	l := &slowlog{}
+	l.slowerThan.Store(slowerThan)
+	l.maxLen.Store(maxLen)
+	return l
+}
+
+// slow indica se un comando durato elapsed va registrato
+func (l *slowlog) slow(elapsed time.Duration) bool {
+	threshold := l.slowerThan.Load()
+	return threshold >= 0 && elapsed.Microseconds() >= threshold
+}
+
+func (l *slowlog) add(entry slowlogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.id = l.nextID
+	l.nextID++
+	l.entries = append(l.entries, entry)
+	l.trim()
+}
+
+// trim assume che il caller abbia il lock
+func (l *slowlog) trim() {
+	if excess := len(l.entries) - int(l.maxLen.Load()); excess > 0 {
+		l.entries = append([]slowlogEntry(nil), l.entries[excess:]...)
+	}
+}
+
+func (l *slowlog) setMaxLen(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxLen.Store(n)
+	l.trim()
+}
+
+// get restituisce le ultime count voci dalla più recente; count negativo le restituisce tutte
+func (l *slowlog) get(count int) []slowlogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if count < 0 || count > len(l.entries) {
+		count = len(l.entries)
+	}
+	entries := make([]slowlogEntry, count)
+	for i := range entries {
+		entries[i] = l.entries[len(l.entries)-1-i]
+	}
+	return entries
+}
+
+func (l *slowlog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+func (l *slowlog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+}
+
+// recordSlow registra il comando appena eseguito se è durato almeno
+// slowlog-log-slower-than
+func (s *PodCacheServer) recordSlow(client *Client, cmd *resp.Command, elapsed time.Duration) {
+	if !s.slowlog.slow(elapsed) {
+		return
+	}
+	addr, _ := client.addrs()
+	s.slowlog.add(slowlogEntry{
+		time:     time.Now(),
+		duration: elapsed,
+		args:     s.slowlogArgs(cmd),
+		addr:     addr,
+		name:     client.clientName(),
+		tiers:    client.tiers.String(),
+	})
+}
+
+// slowlogArgs restituisce il comando come lo registra Redis: al più 32
+// argomenti, quelli oltre 128 byte troncati e le password nascoste
+func (s *PodCacheServer) slowlogArgs(cmd *resp.Command) []string {
+	args := append([]string{string(cmd.Type)}, cmd.Arguments...)
+	s.redactArgs(cmd.Type, args)
+
+	n := min(len(args), slowlogMaxArgs)
+	logged := make([]string, n)
+	for i := 0; i < n; i++ {
+		if i == slowlogMaxArgs-1 && len(args) > slowlogMaxArgs {
+			logged[i] = fmt.Sprintf("... (%d more arguments)", len(args)-slowlogMaxArgs+1)
+			break
+		}
+		arg := args[i]
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		logged[i] = arg
+	}
+	return logged
+}
+
+// redactArgs nasconde le password degli argomenti (args[0] è il comando):
+// AUTH, HELLO ... AUTH, le regole di ACL SETUSER e i parametri Sensitive di CONFIG SET
+func (s *PodCacheServer) redactArgs(command resp.RespCommand, args []string) {
+	const redacted = "(redacted)"
+	switch command {
+	case resp.RESP_AUTH:
+		for i := 1; i < len(args); i++ {
+			args[i] = redacted
+		}
+	case resp.RESP_HELLO:
+		for i := 2; i < len(args); i++ {
+			if strings.EqualFold(args[i], "AUTH") {
+				for j := i + 1; j < len(args) && j <= i+2; j++ {
+					args[j] = redacted
+				}
+				i += 2
+			}
+		}
+	case resp.RESP_ACL:
+		if len(args) > 1 && strings.EqualFold(args[1], "SETUSER") {
+			for i := 3; i < len(args); i++ {
+				args[i] = redacted
+			}
+		}
+	case resp.RESP_CONFIG:
+		if len(args) > 1 && strings.EqualFold(args[1], "SET") {
+			for i := 2; i+1 < len(args); i += 2 {
+				if p, ok := s.config.Lookup(args[i]); ok && p.Sensitive {
+					args[i+1] = redacted
+				}
+			}
+		}
+	}
+}
+
+// handleSlowlog implementa SLOWLOG GET [count] [WITHTIER], LEN e RESET.
+// WITHTIER aggiunge a ogni voce, dopo i sei campi di Redis, i livelli che
+// hanno servito il comando: senza, le voci restano leggibili dai client Redis.
+func (s *PodCacheServer) handleSlowlog(client *Client, args []string) error {
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		rest := args[1:]
+		withTier := false
+		if len(rest) > 0 && strings.ToUpper(rest[len(rest)-1]) == "WITHTIER" {
+			withTier = true
+			rest = rest[:len(rest)-1]
+		}
+		if len(rest) > 1 {
+			return client.sendError("wrong number of arguments for 'slowlog|get' command")
+		}
+		count := 10
+		if len(rest) == 1 {
+			n, err := strconv.Atoi(rest[0])
+			if err != nil || n < -1 {
+				return client.sendError("count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		return s.sendSlowlog(client, s.slowlog.get(count), withTier)
+	case "LEN":
+		if len(args) != 1 {
+			return client.sendError("wrong number of arguments for 'slowlog|len' command")
+		}
+		return client.sendInteger(s.slowlog.len())
+	case "RESET":
+		if len(args) != 1 {
+			return client.sendError("wrong number of arguments for 'slowlog|reset' command")
+		}
+		s.slowlog.reset()
+		return client.sendOK("OK")
+	default:
+		return client.sendError(fmt.Sprintf("unknown subcommand '%s'. Try SLOWLOG HELP.", args[0]))
+	}
+}
+
+func (s *PodCacheServer) sendSlowlog(client *Client, entries []slowlogEntry, withTier bool) error {
+	fields := 6
+	if withTier {
+		fields++
+	}
+	client.writeArray(len(entries))
+	for _, entry := range entries {
+		client.writeArray(fields)
+		client.writeInt(entry.id)
+		client.writeInt(entry.time.Unix())
+		client.writeInt(entry.duration.Microseconds())
+		client.writeArray(len(entry.args))
+		for _, arg := range entry.args {
+			client.writeBulk(arg)
+		}
+		client.writeBulk(entry.addr)
+		client.writeBulk(entry.name)
+		if withTier {
+			client.writeBulk(entry.tiers)
+		}
+	}
+	return client.flush()
+}
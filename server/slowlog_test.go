@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"mi0772/podcache/resp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowlogArgsOf restituisce gli argomenti registrati di una voce di SLOWLOG GET
+func slowlogArgsOf(entry resp.Reply) []string {
+	args := make([]string, len(entry.Elements[3].Elements))
+	for i, arg := range entry.Elements[3].Elements {
+		args[i] = arg.Str
+	}
+	return args
+}
+
+// slowlogGet esegue SLOWLOG GET con args e restituisce le voci, dalla più recente
+func (c *testClient) slowlogGet(args ...string) []resp.Reply {
+	c.t.Helper()
+	reply := c.do(append([]string{"SLOWLOG", "GET"}, args...)...)
+	if reply.Kind != resp.ReplyArray {
+		c.t.Fatalf("SLOWLOG GET %v = %+v, want an array", args, reply)
+	}
+	return reply.Elements
+}
+
+func TestSlowlogGet(t *testing.T) {
+	c := newTestClient(t, newTestServer(t, "slowlog-log-slower-than", "0"))
+
+	c.ok("SLOWLOG", "RESET")
+	c.ok("CLIENT", "SETNAME", "tester")
+	c.ok("SET", "key", "value")
+
+	// SLOWLOG stesso viene registrato, dopo la risposta
+	entries := c.slowlogGet()
+	if len(entries) != 3 {
+		t.Fatalf("SLOWLOG GET returned %d entries, want 3", len(entries))
+	}
+	for i, want := range []string{"SET key value", "CLIENT SETNAME tester", "SLOWLOG RESET"} {
+		if got := strings.Join(slowlogArgsOf(entries[i]), " "); got != want {
+			t.Errorf("entry %d args = %q, want %q", i, got, want)
+		}
+	}
+	set := entries[0]
+	if len(set.Elements) != 6 {
+		t.Fatalf("entry has %d fields, want 6", len(set.Elements))
+	}
+	if id, prev := set.Elements[0].Int, entries[1].Elements[0].Int; id != prev+1 {
+		t.Errorf("entry ids = %d, %d, want consecutive", prev, id)
+	}
+	if at := time.Unix(set.Elements[1].Int, 0); time.Since(at) > time.Minute {
+		t.Errorf("entry time = %v, want now", at)
+	}
+	if set.Elements[2].Int < 0 {
+		t.Errorf("entry duration = %d, want >= 0", set.Elements[2].Int)
+	}
+	if got, want := set.Elements[4].Str, c.conn.LocalAddr().String(); got != want {
+		t.Errorf("entry addr = %q, want %q", got, want)
+	}
+	if got := set.Elements[5].Str; got != "tester" {
+		t.Errorf("entry name = %q, want tester", got)
+	}
+
+	// GET restituisce 10 voci di default, count le limita e -1 le restituisce tutte
+	for i := 0; i < 12; i++ {
+		c.do("PING")
+	}
+	if got := len(c.slowlogGet()); got != 10 {
+		t.Errorf("SLOWLOG GET returned %d entries, want 10", got)
+	}
+	if entries = c.slowlogGet("1"); len(entries) != 1 || strings.Join(slowlogArgsOf(entries[0]), " ") != "SLOWLOG GET" {
+		t.Errorf("SLOWLOG GET 1 = %+v, want the previous SLOWLOG GET", entries)
+	}
+	if got := len(c.slowlogGet("0")); got != 0 {
+		t.Errorf("SLOWLOG GET 0 returned %d entries, want 0", got)
+	}
+	if got, want := len(c.slowlogGet("-1")), int(c.integer("SLOWLOG", "LEN"))-1; got != want {
+		t.Errorf("SLOWLOG GET -1 returned %d entries, want %d", got, want)
+	}
+
+	// WITHTIER aggiunge i livelli che hanno servito il comando
+	c.ok("SET", "key", "other")
+	entries = c.slowlogGet("1", "WITHTIER")
+	if len(entries) != 1 || len(entries[0].Elements) != 7 {
+		t.Fatalf("SLOWLOG GET 1 WITHTIER = %+v, want one entry with 7 fields", entries)
+	}
+	if got := entries[0].Elements[6].Str; !strings.HasPrefix(got, "ram:") {
+		t.Errorf("SET tiers = %q, want a RAM partition", got)
+	}
+	if entries = c.slowlogGet("withtier"); len(entries) != 10 || entries[0].Elements[6].Str != "none" {
+		t.Errorf("SLOWLOG GET WITHTIER = %+v, want 10 entries, the first served by no tier", entries)
+	}
+
+	c.fails("ERR count should be greater than or equal to -1", "SLOWLOG", "GET", "-2")
+	c.fails("ERR count should be greater than or equal to -1", "SLOWLOG", "GET", "many")
+	c.fails("ERR wrong number of arguments for 'slowlog|get' command", "SLOWLOG", "GET", "1", "2")
+	c.fails("ERR wrong number of arguments for 'slowlog|len' command", "SLOWLOG", "LEN", "1")
+	c.fails("ERR wrong number of arguments for 'slowlog|reset' command", "SLOWLOG", "RESET", "1")
+	c.fails("ERR unknown subcommand 'BOGUS'", "SLOWLOG", "BOGUS")
+}
+
+func TestSlowlogArgs(t *testing.T) {
+	c := newTestClient(t, newTestServer(t, "slowlog-log-slower-than", "0"))
+
+	long := strings.Repeat("x", slowlogMaxArgLen+72)
+	c.ok("SET", "key", long)
+	if args := slowlogArgsOf(c.slowlogGet("1")[0]); args[2] != strings.Repeat("x", slowlogMaxArgLen)+"... (72 more bytes)" {
+		t.Errorf("long argument logged as %q", args[2])
+	}
+
+	del := []string{"DEL"}
+	for i := 0; i < 40; i++ {
+		del = append(del, fmt.Sprintf("key%d", i))
+	}
+	c.integer(del...)
+	args := slowlogArgsOf(c.slowlogGet("1")[0])
+	if len(args) != slowlogMaxArgs || args[slowlogMaxArgs-2] != "key29" || args[slowlogMaxArgs-1] != "... (10 more arguments)" {
+		t.Errorf("DEL with 41 arguments logged as %q", args)
+	}
+
+	// le password non finiscono nello slowlog
+	c.ok("CONFIG", "SET", "requirepass", "s3cret")
+	c.ok("AUTH", "s3cret")
+	c.ok("CONFIG", "SET", "requirepass", "")
+	entries := c.slowlogGet("3")
+	for i, want := range []string{"CONFIG SET requirepass (redacted)", "AUTH (redacted)", "CONFIG SET requirepass (redacted)"} {
+		if got := strings.Join(slowlogArgsOf(entries[i]), " "); got != want {
+			t.Errorf("entry %d args = %q, want %q", i, got, want)
+		}
+	}
+
+	// i comandi sconosciuti non sono registrati
+	c.ok("SLOWLOG", "RESET")
+	c.fails("ERR", "NOSUCHCOMMAND")
+	if entries = c.slowlogGet(); len(entries) != 1 || slowlogArgsOf(entries[0])[0] != "SLOWLOG" {
+		t.Errorf("SLOWLOG GET after an unknown command = %+v, want only SLOWLOG RESET", entries)
+	}
+}
+
+func TestSlowlogThresholds(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	// con la soglia di default i comandi veloci non sono registrati
+	c.ok("SET", "key", "value")
+	c.bulk("GET", "key")
+	if got := c.integer("SLOWLOG", "LEN"); got != 0 {
+		t.Errorf("SLOWLOG LEN with the default threshold = %d, want 0", got)
+	}
+
+	c.ok("CONFIG", "SET", "slowlog-log-slower-than", "-1")
+	c.ok("SET", "key", "value")
+	if got := c.integer("SLOWLOG", "LEN"); got != 0 {
+		t.Errorf("SLOWLOG LEN with the slowlog disabled = %d, want 0", got)
+	}
+
+	// la nuova soglia vale già per il CONFIG SET che la imposta
+	c.ok("CONFIG", "SET", "slowlog-log-slower-than", "0")
+	if got := c.integer("SLOWLOG", "LEN"); got != 1 {
+		t.Errorf("SLOWLOG LEN after enabling = %d, want 1", got)
+	}
+	if got := c.integer("SLOWLOG", "LEN"); got != 2 {
+		t.Errorf("SLOWLOG LEN = %d, want 2", got)
+	}
+
+	c.ok("CONFIG", "SET", "slowlog-max-len", "3")
+	for i := 0; i < 5; i++ {
+		c.do("PING")
+	}
+	if got := c.integer("SLOWLOG", "LEN"); got != 3 {
+		t.Errorf("SLOWLOG LEN with slowlog-max-len 3 = %d, want 3", got)
+	}
+	entries := c.slowlogGet()
+	if len(entries) != 3 || strings.Join(slowlogArgsOf(entries[0]), " ") != "SLOWLOG LEN" || slowlogArgsOf(entries[2])[0] != "PING" {
+		t.Errorf("SLOWLOG GET with slowlog-max-len 3 = %+v, want the newest 3 entries", entries)
+	}
+	// abbassare slowlog-max-len scarta subito le voci più vecchie
+	c.ok("CONFIG", "SET", "slowlog-max-len", "1")
+	if entries = c.slowlogGet(); len(entries) != 1 || strings.Join(slowlogArgsOf(entries[0]), " ") != "CONFIG SET slowlog-max-len 1" {
+		t.Errorf("SLOWLOG GET after shrinking = %+v, want only the CONFIG SET", entries)
+	}
+
+	c.ok("SLOWLOG", "RESET")
+	c.ok("CONFIG", "SET", "slowlog-log-slower-than", "60000000")
+	c.ok("SET", "key", "value")
+	if got := c.integer("SLOWLOG", "LEN"); got != 1 {
+		t.Errorf("SLOWLOG LEN with a 60s threshold = %d, want only SLOWLOG RESET", got)
+	}
+	c.fails("ERR", "CONFIG", "SET", "slowlog-log-slower-than", "-2")
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"mi0772/podcache/metrics"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// commandStat sono i contatori di un comando per la sezione commandstats di INFO
+// e per le metriche
+type commandStat struct {
+	calls    atomic.Uint64
+	usec     atomic.Uint64
+	rejected atomic.Uint64
+	failed   atomic.Uint64
+	latency  *metrics.Histogram
+}
+
+// serverStats raccoglie i contatori di INFO stats, commandstats ed errorstats
+type serverStats struct {
+	started time.Time
+	// runID identifica questa esecuzione del server, come run_id di Redis
+	runID string
+
+	connectionsReceived atomic.Uint64
+	rejectedConnections atomic.Uint64
+	commandsProcessed   atomic.Uint64
+	errorReplies        atomic.Uint64
+
+	// commands è creata all'avvio dalla tabella dei comandi e poi solo letta
+	commands map[string]*commandStat
+
+	errorsMu sync.Mutex
+	errors   map[string]uint64
+}
+
+func newServerStats() *serverStats {
+	id := make([]byte, 20)
+	rand.Read(id)
+	stats := &serverStats{
+		started:  time.Now(),
+		runID:    hex.EncodeToString(id),
+		commands: make(map[string]*commandStat, len(commandTable)),
+		errors:   make(map[string]uint64),
+	}
+	for _, spec := range commandTable {
+		stats.commands[spec.name] = &commandStat{latency: metrics.NewHistogram(metrics.LatencyBuckets)}
+	}
+	return stats
+}
+
+// commandOutcome è l'esito di un comando ai fini delle statistiche
+type commandOutcome int
+
+const (
+	outcomeRejected commandOutcome = iota // rifiutato prima dell'esecuzione (auth, ACL, BUSY...)
+	outcomeQueued                         // accodato in MULTI: conta alla EXEC
+	outcomeExecuted
+)
+
+// record aggiorna i contatori; errCode è il codice della risposta di errore
+// (ERR, WRONGTYPE...) o vuoto se la risposta non è un errore
+func (st *serverStats) record(spec *commandSpec, outcome commandOutcome, elapsed time.Duration, errCode string) {
+	if errCode != "" {
+		st.errorReplies.Add(1)
+		st.errorsMu.Lock()
+		st.errors[errCode]++
+		st.errorsMu.Unlock()
+	}
+	if outcome == outcomeQueued && errCode == "" {
+		return
+	}
+	st.commandsProcessed.Add(1)
+	if spec == nil {
+		return
+	}
+	stat := st.commands[spec.name]
+	switch {
+	case outcome != outcomeExecuted:
+		stat.rejected.Add(1)
+	default:
+		stat.calls.Add(1)
+		stat.usec.Add(uint64(elapsed.Microseconds()))
+		stat.latency.Observe(elapsed)
+		if errCode != "" {
+			stat.failed.Add(1)
+		}
+	}
+}
+
+// reset azzera i contatori, come CONFIG RESETSTAT; avvio e run_id restano
+func (st *serverStats) reset() {
+	st.connectionsReceived.Store(0)
+	st.rejectedConnections.Store(0)
+	st.commandsProcessed.Store(0)
+	st.errorReplies.Store(0)
+	for _, stat := range st.commands {
+		stat.calls.Store(0)
+		stat.usec.Store(0)
+		stat.rejected.Store(0)
+		stat.failed.Store(0)
+		stat.latency.Reset()
+	}
+	st.errorsMu.Lock()
+	st.errors = make(map[string]uint64)
+	st.errorsMu.Unlock()
+}
+
+// errorCounts restituisce i contatori degli errori ordinati per codice
+func (st *serverStats) errorCounts() ([]string, map[string]uint64) {
+	st.errorsMu.Lock()
+	defer st.errorsMu.Unlock()
+	counts := make(map[string]uint64, len(st.errors))
+	codes := make([]string, 0, len(st.errors))
+	for code, n := range st.errors {
+		counts[code] = n
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes, counts
+}
+
+// errorCode estrae il codice dalla risposta scritta dopo mark, se è un errore
+func (c *Client) errorCode(mark int) string {
+	c.writer.Flush()
+	pending := c.out.pending
+	if mark >= len(pending) || pending[mark] != '-' {
+		return ""
+	}
+	line := pending[mark+1:]
+	for i, b := range line {
+		if b == ' ' || b == '\r' {
+			return string(line[:i])
+		}
+	}
+	return string(line)
+}
+
+// outputMark segna l'inizio della risposta al comando corrente
+func (c *Client) outputMark() int {
+	c.writer.Flush()
+	return len(c.out.pending)
+}
@@ -0,0 +1,645 @@
+package server
+
+import (
+	"errors"
+	"mi0772/podcache/cache"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errSyntax = errors.New("syntax error")
+
+func (s *PodCacheServer) handleXAdd(client *Client, args []string) error {
+	if len(args) < 4 {
+		return client.sendError("wrong number of arguments for 'xadd' command")
+	}
+
+	key := args[0]
+	noMkStream := false
+	var trim *cache.StreamTrim
+
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			continue
+		case "MAXLEN", "MINID":
+			t, next, err := parseStreamTrim(args, i)
+			if err != nil {
+				return client.sendError(err.Error())
+			}
+			trim = &t
+			i = next - 1
+			continue
+		}
+		break
+	}
+
+	if i >= len(args) {
+		return client.sendError("wrong number of arguments for 'xadd' command")
+	}
+	idSpec := args[i]
+	fields := args[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return client.sendError("wrong number of arguments for 'xadd' command")
+	}
+
+	db := s.db(client)
+	id, added, err := db.XAdd(key, idSpec, fields, noMkStream, trim)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	if !added {
+		return client.sendNullBulkString()
+	}
+
+	s.waiters.signal(db.DB(), key)
+	return client.sendBulkString(id.String())
+}
+
+// parseStreamTrim interpreta MAXLEN|MINID [=|~] threshold [LIMIT count] a partire da args[i]
+// e restituisce l'indice del primo argomento non consumato.
+func parseStreamTrim(args []string, i int) (cache.StreamTrim, int, error) {
+	var trim cache.StreamTrim
+	trim.ByMinID = strings.ToUpper(args[i]) == "MINID"
+	i++
+
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		trim.Approximate = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return trim, i, errSyntax
+	}
+
+	if trim.ByMinID {
+		id, err := cache.ParseStreamID(args[i], 0)
+		if err != nil {
+			return trim, i, err
+		}
+		trim.MinID = id
+	} else {
+		maxLen, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil || maxLen < 0 {
+			return trim, i, errors.New("The MAXLEN argument must be >= 0.")
+		}
+		trim.MaxLen = maxLen
+	}
+	i++
+
+	if i+1 < len(args) && strings.ToUpper(args[i]) == "LIMIT" {
+		limit, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || limit < 0 {
+			return trim, i, errors.New("The LIMIT argument must be >= 0.")
+		}
+		if !trim.Approximate {
+			return trim, i, errors.New("syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		trim.Limit = limit
+		i += 2
+	}
+	return trim, i, nil
+}
+
+func (s *PodCacheServer) handleXRange(client *Client, args []string, reverse bool) error {
+	if len(args) != 3 && len(args) != 5 {
+		if reverse {
+			return client.sendError("wrong number of arguments for 'xrevrange' command")
+		}
+		return client.sendError("wrong number of arguments for 'xrange' command")
+	}
+
+	// XREVRANGE riceve gli estremi in ordine inverso
+	startArg, endArg := args[1], args[2]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := cache.ParseRangeStart(startArg)
+	if err != nil {
+		return client.sendError(err.Error())
+	}
+	end, err := cache.ParseRangeEnd(endArg)
+	if err != nil {
+		return client.sendError(err.Error())
+	}
+
+	count := -1
+	if len(args) == 5 {
+		if strings.ToUpper(args[3]) != "COUNT" {
+			return client.sendError(errSyntax.Error())
+		}
+		if count, err = strconv.Atoi(args[4]); err != nil {
+			return client.sendError(ErrNotInteger.Error())
+		}
+		if count <= 0 {
+			client.writeArray(0)
+			return client.flush()
+		}
+	}
+
+	entries, err := s.db(client).XRange(args[0], start, end, count, reverse)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	client.writeStreamEntries(entries)
+	return client.flush()
+}
+
+func (s *PodCacheServer) handleXLen(client *Client, args []string) error {
+	if len(args) != 1 {
+		return client.sendError("wrong number of arguments for 'xlen' command")
+	}
+	length, err := s.db(client).XLen(args[0])
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	return client.sendInteger(length)
+}
+
+func (s *PodCacheServer) handleXTrim(client *Client, args []string) error {
+	if len(args) < 3 {
+		return client.sendError("wrong number of arguments for 'xtrim' command")
+	}
+	if upper := strings.ToUpper(args[1]); upper != "MAXLEN" && upper != "MINID" {
+		return client.sendError(errSyntax.Error())
+	}
+
+	trim, next, err := parseStreamTrim(args, 1)
+	if err != nil {
+		return client.sendError(err.Error())
+	}
+	if next != len(args) {
+		return client.sendError(errSyntax.Error())
+	}
+
+	removed, err := s.db(client).XTrim(args[0], trim)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	return client.sendInteger(int(removed))
+}
+
+// streamReadOptions raccoglie le opzioni comuni di XREAD e XREADGROUP
+type streamReadOptions struct {
+	count   int
+	block   int64
+	blocked bool
+	noAck   bool
+	keys    []string
+	ids     []string
+}
+
+func parseStreamReadOptions(args []string, allowNoAck bool) (streamReadOptions, error) {
+	opts := streamReadOptions{count: -1}
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return opts, errSyntax
+			}
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, ErrNotInteger
+			}
+			opts.count = count
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return opts, errSyntax
+			}
+			block, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, errors.New("timeout is not an integer or out of range")
+			}
+			if block < 0 {
+				return opts, errors.New("timeout is negative")
+			}
+			opts.block = block
+			opts.blocked = true
+			i++
+		case "NOACK":
+			if !allowNoAck {
+				return opts, errSyntax
+			}
+			opts.noAck = true
+		case "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return opts, errors.New("Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+			}
+			opts.keys = rest[:len(rest)/2]
+			opts.ids = rest[len(rest)/2:]
+			return opts, nil
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, errSyntax
+}
+
+func (s *PodCacheServer) handleXRead(client *Client, args []string) error {
+	opts, err := parseStreamReadOptions(args, false)
+	if err != nil {
+		return client.sendError(err.Error())
+	}
+	// dentro EXEC non si può attendere: si risponde come a timeout scaduto
+	opts.blocked = opts.blocked && client.txView == nil
+
+	// "$" va risolto una sola volta, prima di un'eventuale attesa
+	lastIDs, err := s.db(client).StreamLastIDs(opts.keys...)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	after := make([]cache.StreamID, len(opts.keys))
+	for i, id := range opts.ids {
+		if id == "$" {
+			after[i] = lastIDs[i]
+			continue
+		}
+		if after[i], err = cache.ParseStreamID(id, 0); err != nil {
+			return client.sendError(err.Error())
+		}
+	}
+
+	timeout, stop := blockingTimeout(opts.block)
+	defer stop()
+
+	for {
+		var wait <-chan struct{}
+		cancel := func() {}
+		if opts.blocked {
+			wait, cancel = s.waiters.register(s.db(client).DB(), opts.keys)
+		}
+
+		results, err := s.db(client).XRead(opts.keys, after, opts.count)
+		if err != nil || len(results) > 0 || !opts.blocked {
+			cancel()
+			if err != nil {
+				return client.sendCacheError(err)
+			}
+			return client.sendStreamResults(results)
+		}
+
+		woken, err := client.waitBlocked(wait, timeout)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if !woken {
+			return client.sendNullArray()
+		}
+	}
+}
+
+func (s *PodCacheServer) handleXReadGroup(client *Client, args []string) error {
+	if len(args) < 3 || strings.ToUpper(args[0]) != "GROUP" {
+		return client.sendError(errSyntax.Error())
+	}
+	group, consumer := args[1], args[2]
+
+	opts, err := parseStreamReadOptions(args[3:], true)
+	if err != nil {
+		return client.sendError(err.Error())
+	}
+	opts.blocked = opts.blocked && client.txView == nil
+
+	onlyNew := true
+	for _, id := range opts.ids {
+		if id == ">" {
+			continue
+		}
+		onlyNew = false
+		if _, err := cache.ParseStreamID(id, 0); err != nil {
+			return client.sendError(err.Error())
+		}
+	}
+
+	timeout, stop := blockingTimeout(opts.block)
+	defer stop()
+
+	for {
+		var wait <-chan struct{}
+		cancel := func() {}
+		blocking := opts.blocked && onlyNew
+		if blocking {
+			wait, cancel = s.waiters.register(s.db(client).DB(), opts.keys)
+		}
+
+		results, err := s.db(client).XReadGroup(group, consumer, opts.keys, opts.ids, opts.count, opts.noAck)
+		if err != nil || len(results) > 0 || !blocking {
+			cancel()
+			if err != nil {
+				return client.sendCacheError(err)
+			}
+			return client.sendStreamResults(results)
+		}
+
+		woken, err := client.waitBlocked(wait, timeout)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if !woken {
+			return client.sendNullArray()
+		}
+	}
+}
+
+func (s *PodCacheServer) handleXGroup(client *Client, args []string) error {
+	if len(args) < 1 {
+		return client.sendError("wrong number of arguments for 'xgroup' command")
+	}
+
+	sub := strings.ToUpper(args[0])
+	switch sub {
+	case "CREATE", "SETID":
+		if len(args) < 4 {
+			return client.sendError("wrong number of arguments for 'xgroup|" + strings.ToLower(sub) + "' command")
+		}
+		mkStream := false
+		entriesRead := int64(-1)
+		for i := 4; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "MKSTREAM":
+				if sub != "CREATE" {
+					return client.sendError(errSyntax.Error())
+				}
+				mkStream = true
+			case "ENTRIESREAD":
+				if i+1 >= len(args) {
+					return client.sendError(errSyntax.Error())
+				}
+				n, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || n < -1 {
+					return client.sendError("value for ENTRIESREAD must be positive or -1")
+				}
+				entriesRead = n
+				i++
+			default:
+				return client.sendError(errSyntax.Error())
+			}
+		}
+
+		var err error
+		if sub == "CREATE" {
+			err = s.db(client).XGroupCreate(args[1], args[2], args[3], mkStream, entriesRead)
+		} else {
+			err = s.db(client).XGroupSetID(args[1], args[2], args[3], entriesRead)
+		}
+		if err != nil {
+			return client.sendCacheError(err)
+		}
+		return client.sendOK("OK")
+
+	case "DESTROY":
+		if len(args) != 3 {
+			return client.sendError("wrong number of arguments for 'xgroup|destroy' command")
+		}
+		destroyed, err := s.db(client).XGroupDestroy(args[1], args[2])
+		if err != nil {
+			return client.sendCacheError(err)
+		}
+		return client.sendInteger(boolToInt(destroyed))
+
+	case "CREATECONSUMER":
+		if len(args) != 4 {
+			return client.sendError("wrong number of arguments for 'xgroup|createconsumer' command")
+		}
+		created, err := s.db(client).XGroupCreateConsumer(args[1], args[2], args[3])
+		if err != nil {
+			return client.sendCacheError(err)
+		}
+		return client.sendInteger(boolToInt(created))
+
+	case "DELCONSUMER":
+		if len(args) != 4 {
+			return client.sendError("wrong number of arguments for 'xgroup|delconsumer' command")
+		}
+		pending, err := s.db(client).XGroupDelConsumer(args[1], args[2], args[3])
+		if err != nil {
+			return client.sendCacheError(err)
+		}
+		return client.sendInteger(pending)
+
+	default:
+		return client.sendError("unknown subcommand '" + args[0] + "'. Try XGROUP HELP.")
+	}
+}
+
+func (s *PodCacheServer) handleXAck(client *Client, args []string) error {
+	if len(args) < 3 {
+		return client.sendError("wrong number of arguments for 'xack' command")
+	}
+
+	ids := make([]cache.StreamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, err := cache.ParseStreamID(arg, 0)
+		if err != nil {
+			return client.sendError(err.Error())
+		}
+		ids = append(ids, id)
+	}
+
+	acked, err := s.db(client).XAck(args[0], args[1], ids)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+	return client.sendInteger(acked)
+}
+
+func (s *PodCacheServer) handleXPending(client *Client, args []string) error {
+	if len(args) < 2 {
+		return client.sendError("wrong number of arguments for 'xpending' command")
+	}
+	key, group := args[0], args[1]
+
+	if len(args) == 2 {
+		summary, err := s.db(client).XPending(key, group)
+		if err != nil {
+			return client.sendCacheError(err)
+		}
+		client.writeArray(4)
+		client.writeInt(int64(summary.Count))
+		if summary.Count == 0 {
+			client.writeNullBulk()
+			client.writeNullBulk()
+			client.writeNullArray()
+			return client.flush()
+		}
+		client.writeBulk(summary.Smallest.String())
+		client.writeBulk(summary.Greatest.String())
+		client.writeArray(len(summary.Consumers))
+		for _, consumer := range summary.Consumers {
+			client.writeArray(2)
+			client.writeBulk(consumer.Name)
+			client.writeBulk(strconv.Itoa(consumer.Count))
+		}
+		return client.flush()
+	}
+
+	rest := args[2:]
+	minIdle := int64(0)
+	if strings.ToUpper(rest[0]) == "IDLE" {
+		if len(rest) < 2 {
+			return client.sendError(errSyntax.Error())
+		}
+		idle, err := strconv.ParseInt(rest[1], 10, 64)
+		if err != nil {
+			return client.sendError(ErrNotInteger.Error())
+		}
+		minIdle = idle
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return client.sendError(errSyntax.Error())
+	}
+
+	start, err := cache.ParseRangeStart(rest[0])
+	if err != nil {
+		return client.sendError(err.Error())
+	}
+	end, err := cache.ParseRangeEnd(rest[1])
+	if err != nil {
+		return client.sendError(err.Error())
+	}
+	count, err := strconv.Atoi(rest[2])
+	if err != nil {
+		return client.sendError(ErrNotInteger.Error())
+	}
+	consumer := ""
+	if len(rest) == 4 {
+		consumer = rest[3]
+	}
+
+	pending, err := s.db(client).XPendingRange(key, group, minIdle, start, end, count, consumer)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+
+	now := time.Now().UnixMilli()
+	client.writeArray(len(pending))
+	for _, entry := range pending {
+		client.writeArray(4)
+		client.writeBulk(entry.ID.String())
+		client.writeBulk(entry.Consumer)
+		client.writeInt(now - entry.DeliveryTime)
+		client.writeInt(int64(entry.DeliveryCount))
+	}
+	return client.flush()
+}
+
+func (s *PodCacheServer) handleXClaim(client *Client, args []string) error {
+	if len(args) < 5 {
+		return client.sendError("wrong number of arguments for 'xclaim' command")
+	}
+	key, group, consumer := args[0], args[1], args[2]
+
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return client.sendError("Invalid min-idle-time argument for XCLAIM")
+	}
+
+	var opts cache.ClaimOptions
+	var ids []cache.StreamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := cache.ParseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "FORCE":
+			opts.Force = true
+		case "JUSTID":
+			opts.JustID = true
+		case "IDLE", "TIME", "RETRYCOUNT", "LASTID":
+			if i+1 >= len(args) {
+				return client.sendError(errSyntax.Error())
+			}
+			value := args[i+1]
+			i++
+			if option == "LASTID" {
+				if _, err := cache.ParseStreamID(value, 0); err != nil {
+					return client.sendError(err.Error())
+				}
+				continue
+			}
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return client.sendError("Invalid " + option + " option argument for XCLAIM")
+			}
+			switch option {
+			case "IDLE":
+				opts.Idle = &n
+			case "TIME":
+				opts.Time = &n
+			case "RETRYCOUNT":
+				retry := uint64(n)
+				opts.RetryCount = &retry
+			}
+		default:
+			return client.sendError("Unrecognized XCLAIM option '" + args[i] + "'")
+		}
+	}
+
+	claimed, err := s.db(client).XClaim(key, group, consumer, minIdle, ids, opts)
+	if err != nil {
+		return client.sendCacheError(err)
+	}
+
+	if opts.JustID {
+		client.writeArray(len(claimed))
+		for _, entry := range claimed {
+			client.writeBulk(entry.ID.String())
+		}
+		return client.flush()
+	}
+	client.writeStreamEntries(claimed)
+	return client.flush()
+}
+
+// writeStreamEntries scrive le entry come [[id, [field, value, ...]], ...];
+// le entry cancellate ma ancora in una PEL hanno i campi nulli
+func (c *Client) writeStreamEntries(entries []cache.StreamEntry) {
+	c.writeArray(len(entries))
+	for _, entry := range entries {
+		c.writeArray(2)
+		c.writeBulk(entry.ID.String())
+		if entry.Fields == nil {
+			c.writeNullArray()
+			continue
+		}
+		c.writeArray(len(entry.Fields))
+		for _, field := range entry.Fields {
+			c.writeBulk(field)
+		}
+	}
+}
+
+func (c *Client) sendStreamResults(results []cache.StreamReadResult) error {
+	if len(results) == 0 {
+		return c.sendNullArray()
+	}
+	c.writeArray(len(results))
+	for _, result := range results {
+		c.writeArray(2)
+		c.writeBulk(result.Key)
+		c.writeStreamEntries(result.Entries)
+	}
+	return c.flush()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"mi0772/podcache/resp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamIDs restituisce gli ID delle entry di key nella risposta di XREAD o
+// XREADGROUP, nell'ordine; la risposta non deve contenere altri stream
+func streamIDs(t *testing.T, reply resp.Reply, key string) string {
+	t.Helper()
+	if reply.Kind != resp.ReplyArray || len(reply.Elements) != 1 || reply.Elements[0].Elements[0].Str != key {
+		t.Fatalf("reply = %+v, want the entries of %s", reply, key)
+	}
+	var ids []string
+	for _, entry := range reply.Elements[0].Elements[1].Elements {
+		ids = append(ids, entry.Elements[0].Str)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestXReadBlock(t *testing.T) {
+	s := newTestServer(t)
+	writer := newTestClient(t, s)
+	addr := writer.conn.RemoteAddr().String()
+	reader := dialTest(t, "tcp", addr)
+	writer.bulk("XADD", "s", "1-1", "f", "v")
+
+	// con dati già presenti BLOCK non attende
+	if ids := streamIDs(t, reader.do("XREAD", "BLOCK", "0", "STREAMS", "s", "0-0"), "s"); ids != "1-1" {
+		t.Fatalf("XREAD BLOCK with data = %q, want 1-1", ids)
+	}
+
+	// $ vale l'ultimo ID al momento della chiamata: solo le nuove entry svegliano il client
+	reader.send("XREAD", "BLOCK", "0", "STREAMS", "s", "$")
+	reader.silent()
+	_, info := infoReply(writer, "clients")
+	if got := info["clients"]["blocked_clients"]; got != "1" {
+		t.Fatalf("blocked_clients = %s, want 1", got)
+	}
+	writer.bulk("XADD", "other", "1-1", "f", "v")
+	reader.silent()
+	writer.bulk("XADD", "s", "2-1", "f", "v")
+	if ids := streamIDs(t, reader.read(), "s"); ids != "2-1" {
+		t.Fatalf("XREAD BLOCK $ after XADD = %q, want 2-1", ids)
+	}
+
+	// la stessa chiave in un altro DB non sveglia il client
+	reader.send("XREAD", "BLOCK", "0", "STREAMS", "s", "$")
+	reader.silent()
+	writer.ok("SELECT", "1")
+	writer.bulk("XADD", "s", "9-1", "f", "v")
+	reader.silent()
+	writer.ok("SELECT", "0")
+	writer.bulk("XADD", "s", "3-1", "f", "v")
+	if ids := streamIDs(t, reader.read(), "s"); ids != "3-1" {
+		t.Fatalf("XREAD BLOCK $ after XADD in DB 0 = %q, want 3-1", ids)
+	}
+
+	// con più chiavi risponde solo con quelle che hanno nuove entry
+	reader.send("XREAD", "BLOCK", "0", "STREAMS", "s", "other", "$", "$")
+	reader.silent()
+	writer.bulk("XADD", "other", "2-1", "f", "v")
+	if ids := streamIDs(t, reader.read(), "other"); ids != "2-1" {
+		t.Fatalf("XREAD BLOCK on two keys = %q, want other 2-1", ids)
+	}
+
+	// allo scadere del timeout la risposta è un array nullo
+	start := time.Now()
+	if reply := reader.do("XREAD", "BLOCK", "100", "STREAMS", "s", "$"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("XREAD BLOCK 100 without writes = %+v, want a null array", reply)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("XREAD BLOCK 100 returned after %v", elapsed)
+	}
+
+	// dentro EXEC non si attende
+	reader.ok("MULTI")
+	reader.queued("XREAD", "BLOCK", "0", "STREAMS", "s", "$")
+	if reply := reader.do("EXEC"); reply.Kind != resp.ReplyArray || len(reply.Elements) != 1 || reply.Elements[0].Kind != resp.ReplyNil {
+		t.Fatalf("EXEC with XREAD BLOCK = %+v, want [nil]", reply)
+	}
+	reader.fails("ERR timeout is negative", "XREAD", "BLOCK", "-1", "STREAMS", "s", "$")
+}
+
+func TestXReadGroupBlock(t *testing.T) {
+	s := newTestServer(t)
+	writer := newTestClient(t, s)
+	addr := writer.conn.RemoteAddr().String()
+	alice := dialTest(t, "tcp", addr)
+	bob := dialTest(t, "tcp", addr)
+	writer.ok("XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+
+	alice.send("XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">")
+	alice.silent()
+	writer.bulk("XADD", "s", "1-1", "f", "v")
+	if ids := streamIDs(t, alice.read(), "s"); ids != "1-1" {
+		t.Fatalf("XREADGROUP BLOCK after XADD = %q, want 1-1", ids)
+	}
+
+	// la cronologia del consumer (ID diverso da >) non attende mai
+	if ids := streamIDs(t, alice.do("XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", "0"), "s"); ids != "1-1" {
+		t.Fatalf("XREADGROUP BLOCK with ID 0 = %q, want the pending 1-1", ids)
+	}
+
+	// due consumer svegliati dalla stessa XADD: chi non riceve l'entry torna ad attendere
+	alice.send("XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">")
+	bob.send("XREADGROUP", "GROUP", "g", "bob", "BLOCK", "0", "STREAMS", "s", ">")
+	alice.silent()
+	bob.silent()
+	writer.bulk("XADD", "s", "2-1", "f", "v")
+	writer.bulk("XADD", "s", "3-1", "f", "v")
+	got := []string{streamIDs(t, alice.read(), "s"), streamIDs(t, bob.read(), "s")}
+	if !(got[0] == "2-1" && got[1] == "3-1") && !(got[0] == "3-1" && got[1] == "2-1") {
+		t.Fatalf("entries delivered to alice and bob = %q, want one each", got)
+	}
+
+	if reply := alice.do("XREADGROUP", "GROUP", "g", "alice", "BLOCK", "100", "STREAMS", "s", ">"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("XREADGROUP BLOCK 100 without writes = %+v, want a null array", reply)
+	}
+	alice.fails("NOGROUP", "XREADGROUP", "GROUP", "missing", "alice", "BLOCK", "0", "STREAMS", "s", ">")
+}
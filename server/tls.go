@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"mi0772/podcache/config"
+	"mi0772/podcache/logging"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// tlsReloadInterval è ogni quanto si controlla se certificato, chiave o CA
+// sono cambiati su disco (es. rinnovo di cert-manager)
+const tlsReloadInterval = 5 * time.Second
+
+// tlsHandshakeTimeout limita l'attesa dell'handshake di un nuovo client
+const tlsHandshakeTimeout = 10 * time.Second
+
+// tlsOptions è la configurazione del listener TLS, come le opzioni tls-* di Redis
+type tlsOptions struct {
+	port        int
+	certFile    string
+	keyFile     string
+	caFile      string
+	clientAuth  tls.ClientAuthType
+	minVersion  uint16
+	cipherSuite []uint16
+}
+
+// getTLSOptions legge i parametri tls-*; con tls-port a 0 il TLS è
+// disattivato. Le combinazioni non valide sono già escluse da CheckConfig.
+func getTLSOptions(cfg *config.Registry) *tlsOptions {
+	port := int(cfg.Int("tls-port"))
+	if port == 0 {
+		return nil
+	}
+
+	// i singoli valori sono già validati dal registro
+	opts := &tlsOptions{
+		port:     port,
+		certFile: cfg.String("tls-cert-file"),
+		keyFile:  cfg.String("tls-key-file"),
+		caFile:   cfg.String("tls-ca-cert-file"),
+	}
+
+	// senza CA i certificati client non si possono verificare: il default
+	// (yes) vale solo se la CA è configurata
+	opts.clientAuth, _ = parseClientAuth(cfg.String("tls-auth-clients"))
+	if opts.caFile == "" {
+		opts.clientAuth = tls.NoClientCert
+	}
+	opts.minVersion, _ = parseTLSVersion(cfg.String("tls-min-version"))
+	if ciphers := cfg.String("tls-ciphers"); ciphers != "" {
+		opts.cipherSuite, _ = parseCipherSuites(ciphers)
+	}
+	return opts
+}
+
+// parseClientAuth interpreta tls-auth-clients: yes, optional, no
+func parseClientAuth(value string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(value) {
+	case "yes":
+		return tls.RequireAndVerifyClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "no":
+		return tls.NoClientCert, nil
+	}
+	return 0, errors.New("must be yes, optional or no")
+}
+
+// parseTLSVersion accetta "1.2" o "TLSv1.2", come tls-protocols di Redis
+func parseTLSVersion(value string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(value), "tlsv") {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, errors.New("supported versions are TLSv1.2 and TLSv1.3")
+}
+
+// parseCipherSuites interpreta una lista separata da virgole con i nomi di
+// crypto/tls (es. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256). Valgono solo per
+// TLS 1.2: le suite di TLS 1.3 non sono configurabili.
+func parseCipherSuites(value string) ([]uint16, error) {
+	byName := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no cipher suites given")
+	}
+	return ids, nil
+}
+
+// tlsReloader serve il certificato e la CA correnti e li ricarica quando i file
+// cambiano. Le connessioni già aperte restano su quelli del loro handshake.
+type tlsReloader struct {
+	opts   *tlsOptions
+	logger logging.Logger
+
+	loaded atomic.Pointer[tlsFiles]
+}
+
+// tlsFiles sono certificato e CA letti da disco, con le date di modifica dei file
+type tlsFiles struct {
+	cert      tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  []time.Time
+}
+
+func newTLSReloader(opts *tlsOptions, logger logging.Logger) (*tlsReloader, error) {
+	r := &tlsReloader{opts: opts, logger: logger}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *tlsReloader) files() []string {
+	files := []string{r.opts.certFile, r.opts.keyFile}
+	if r.opts.caFile != "" {
+		files = append(files, r.opts.caFile)
+	}
+	return files
+}
+
+// load legge certificato, chiave e CA; in caso di errore i precedenti restano in uso
+func (r *tlsReloader) load() error {
+	files, err := r.read()
+	if err != nil {
+		return err
+	}
+	r.loaded.Store(files)
+	return nil
+}
+
+// read legge i file senza metterli in uso
+func (r *tlsReloader) read() (*tlsFiles, error) {
+	files := &tlsFiles{modTimes: make([]time.Time, 0, 3)}
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		files.modTimes = append(files.modTimes, info.ModTime())
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.opts.certFile, r.opts.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	files.cert = cert
+	if r.opts.caFile != "" {
+		pem, err := os.ReadFile(r.opts.caFile)
+		if err != nil {
+			return nil, err
+		}
+		files.clientCAs = x509.NewCertPool()
+		if !files.clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", r.opts.caFile)
+		}
+	}
+	return files, nil
+}
+
+// changed confronta le date di modifica dei file con quelle dell'ultimo caricamento
+func (r *tlsReloader) changed() bool {
+	modTimes := r.loaded.Load().modTimes
+	for i, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			// durante la sostituzione atomica il file può mancare per un istante
+			return false
+		}
+		if i >= len(modTimes) || !info.ModTime().Equal(modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch ricarica i file quando cambiano, finché ctx non termina
+func (r *tlsReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.load(); err != nil {
+				r.logger.Error("Failed to reload TLS certificates, keeping the previous ones", "error", err)
+				continue
+			}
+			r.logger.Info("TLS certificates reloaded", "cert", r.opts.certFile)
+		}
+	}
+}
+
+// config costruisce la configurazione del listener: certificato e CA sono letti
+// a ogni handshake, così un reload vale per le nuove connessioni
+func (r *tlsReloader) config() *tls.Config {
+	base := &tls.Config{
+		MinVersion:   r.opts.minVersion,
+		CipherSuites: r.opts.cipherSuite,
+		ClientAuth:   r.opts.clientAuth,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.loaded.Load().cert, nil
+		},
+	}
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			config := base.Clone()
+			config.ClientCAs = r.loaded.Load().clientCAs
+			return config, nil
+		},
+	}
+}
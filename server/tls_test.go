@@ -0,0 +1,219 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"mi0772/podcache/logging"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert è un certificato generato nel test, con la chiave in PEM
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert crea un certificato firmato da parent, o autofirmato se parent è nil
+func newTestCert(t *testing.T, cn string, serial int64, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned an error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate() returned an error: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() returned an error: %v", err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatalf("X509KeyPair() returned an error: %v", err)
+	}
+	return cert
+}
+
+// writeTestFiles scrive certificato e chiave e ne sposta la data di modifica,
+// così il reload li vede cambiati anche se scritti nello stesso istante
+func writeTestFiles(t *testing.T, opts *tlsOptions, c *testCert, modTime time.Time) {
+	t.Helper()
+	for file, data := range map[string][]byte{opts.certFile: c.certPEM, opts.keyFile: c.keyPEM} {
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			t.Fatalf("WriteFile(%s) returned an error: %v", file, err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatalf("Chtimes(%s) returned an error: %v", file, err)
+		}
+	}
+}
+
+// handshake esegue un handshake contro il reloader e restituisce il
+// certificato presentato al client e l'errore visto dal server
+func handshake(t *testing.T, r *tlsReloader, client *tls.Config) (*x509.Certificate, error) {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", r.config())
+	if err != nil {
+		t.Fatalf("tls.Listen() returned an error: %v", err)
+	}
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		serverErr <- conn.(*tls.Conn).Handshake()
+	}()
+
+	var peer *x509.Certificate
+	conn, err := tls.Dial("tcp", listener.Addr().String(), client)
+	if err == nil {
+		peer = conn.ConnectionState().PeerCertificates[0]
+		conn.Close()
+	}
+	return peer, <-serverErr
+}
+
+func newTestTLSOptions(t *testing.T) *tlsOptions {
+	dir := t.TempDir()
+	return &tlsOptions{
+		certFile:   filepath.Join(dir, "server.crt"),
+		keyFile:    filepath.Join(dir, "server.key"),
+		minVersion: tls.VersionTLS12,
+	}
+}
+
+func TestTLSMutualAuthentication(t *testing.T) {
+	ca := newTestCert(t, "test-ca", 1, nil)
+	server := newTestCert(t, "server", 2, ca)
+	client := newTestCert(t, "client", 3, ca)
+	stranger := newTestCert(t, "stranger", 4, nil)
+
+	opts := newTestTLSOptions(t)
+	opts.caFile = filepath.Join(filepath.Dir(opts.certFile), "ca.crt")
+	opts.clientAuth = tls.RequireAndVerifyClientCert
+	writeTestFiles(t, opts, server, time.Now())
+	if err := os.WriteFile(opts.caFile, ca.certPEM, 0o600); err != nil {
+		t.Fatalf("WriteFile(ca) returned an error: %v", err)
+	}
+	r, err := newTLSReloader(opts, logging.NewNoOpLogger())
+	if err != nil {
+		t.Fatalf("newTLSReloader() returned an error: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	if _, err := handshake(t, r, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{client.tlsCertificate(t)}}); err != nil {
+		t.Fatalf("handshake with a client certificate signed by the CA failed: %v", err)
+	}
+	if _, err := handshake(t, r, &tls.Config{RootCAs: roots}); err == nil {
+		t.Fatal("handshake without a client certificate succeeded")
+	}
+	if _, err := handshake(t, r, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{stranger.tlsCertificate(t)}}); err == nil {
+		t.Fatal("handshake with a client certificate not signed by the CA succeeded")
+	}
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	first := newTestCert(t, "first", 10, nil)
+	second := newTestCert(t, "second", 11, nil)
+
+	opts := newTestTLSOptions(t)
+	writeTestFiles(t, opts, first, time.Now().Add(-time.Minute))
+	r, err := newTLSReloader(opts, logging.NewNoOpLogger())
+	if err != nil {
+		t.Fatalf("newTLSReloader() returned an error: %v", err)
+	}
+	client := &tls.Config{InsecureSkipVerify: true}
+
+	if peer, _ := handshake(t, r, client); peer == nil || peer.Subject.CommonName != "first" {
+		t.Fatalf("peer certificate before reload = %v, want first", peer)
+	}
+	if r.changed() {
+		t.Fatal("changed() = true with unmodified files")
+	}
+
+	writeTestFiles(t, opts, second, time.Now())
+	if !r.changed() {
+		t.Fatal("changed() = false after rewriting the certificate")
+	}
+	if err := r.load(); err != nil {
+		t.Fatalf("load() returned an error: %v", err)
+	}
+	if peer, _ := handshake(t, r, client); peer == nil || peer.Subject.CommonName != "second" {
+		t.Fatalf("peer certificate after reload = %v, want second", peer)
+	}
+
+	// una chiave che non corrisponde al certificato lascia in uso il precedente
+	os.WriteFile(opts.keyFile, first.keyPEM, 0o600)
+	if err := r.load(); err == nil {
+		t.Fatal("load() with a mismatched key succeeded")
+	}
+	if peer, _ := handshake(t, r, client); peer == nil || peer.Subject.CommonName != "second" {
+		t.Fatalf("peer certificate after a failed reload = %v, want second", peer)
+	}
+}
+
+func TestTLSOptionParsing(t *testing.T) {
+	if v, err := parseTLSVersion("TLSv1.3"); err != nil || v != tls.VersionTLS13 {
+		t.Fatalf("parseTLSVersion(TLSv1.3) = %v, %v", v, err)
+	}
+	if _, err := parseTLSVersion("1.0"); err == nil {
+		t.Fatal("parseTLSVersion(1.0) succeeded")
+	}
+	suites, err := parseCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	if err != nil || len(suites) != 2 || suites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Fatalf("parseCipherSuites() = %v, %v", suites, err)
+	}
+	if _, err := parseCipherSuites("TLS_RSA_WITH_RC4_128_SHA"); err == nil {
+		t.Fatal("parseCipherSuites() accepted an insecure suite")
+	}
+	if auth, err := parseClientAuth("optional"); err != nil || auth != tls.VerifyClientCertIfGiven {
+		t.Fatalf("parseClientAuth(optional) = %v, %v", auth, err)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"mi0772/podcache/config"
+	"mi0772/podcache/logging"
+	"mi0772/podcache/resp"
+	"mi0772/podcache/tracing"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// newTracer crea il tracer delle richieste; senza tracing-endpoint non
+// campiona nulla e gli span sono nil
+func newTracer(cfg *config.Registry, logger logging.Logger) (*tracing.Tracer, *tracing.Exporter) {
+	ratio, _ := strconv.ParseFloat(cfg.String("tracing-sample-ratio"), 64)
+	endpoint := cfg.String("tracing-endpoint")
+	if endpoint == "" {
+		return tracing.NewTracer(nil, ratio), nil
+	}
+	exporter := tracing.NewExporter(endpoint, []tracing.Attr{
+		{Key: "service.name", Value: "podcache"},
+		{Key: "service.version", Value: Version},
+	}, logger)
+	return tracing.NewTracer(exporter, ratio), exporter
+}
+
+func normalizeTracingEndpoint(value, _ string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("must be an http or https URL such as http://localhost:4318/v1/traces")
+	}
+	return value, nil
+}
+
+func normalizeSampleRatio(value, _ string) (string, error) {
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return "", errors.New("must be a number between 0 and 1")
+	}
+	return strconv.FormatFloat(ratio, 'g', -1, 64), nil
+}
+
+// executeTraced esegue il comando dentro lo span "execute" della richiesta
+// campionata: la vista del client porta lo span alla cache, che vi aggiunge
+// attesa dei lock, ricerche in RAM e accessi al disco
+func (s *PodCacheServer) executeTraced(client *Client, cmd *resp.Command, span *tracing.Span) error {
+	if span == nil {
+		return s.executeCommand(client, cmd)
+	}
+	span.SetName(strings.ToUpper(string(cmd.Type)))
+	span.SetAttr("db.system", "redis")
+	span.SetAttr("db.operation", commandName(cmd))
+	span.SetAttr("db.redis.database_index", client.view.DB())
+	span.SetAttr("client.id", int64(client.id))
+
+	execute := span.Child("execute")
+	mark := client.outputMark()
+	client.view = client.view.WithSpan(execute)
+	err := s.executeCommand(client, cmd)
+	// SELECT può aver sostituito la vista: si toglie lo span da quella corrente
+	client.view = client.view.WithSpan(nil)
+	if code := client.errorCode(mark); code != "" {
+		execute.SetError(code)
+		span.SetError(code)
+	}
+	execute.End()
+	return err
+}
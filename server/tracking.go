@@ -0,0 +1,361 @@
+package server
+
+import (
+	"fmt"
+	"mi0772/podcache/resp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// trackingChannel è il canale su cui i client RESP2 ricevono le invalidazioni con REDIRECT
+const trackingChannel = "__redis__:invalidate"
+
+// trackingOptions sono le opzioni di CLIENT TRACKING di una connessione
+type trackingOptions struct {
+	redirect uint64
+	bcast    bool
+	prefixes []string
+	optIn    bool
+	optOut   bool
+	noLoop   bool
+}
+
+// trackingTable implementa il client-side caching assistito dal server come in
+// Redis: in modalità default ricorda quali client hanno letto ogni chiave e li
+// avvisa alla prima modifica, dimenticandoli fino alla lettura successiva; in
+// modalità BCAST avvisa tutti i client iscritti a un prefisso della chiave.
+type trackingTable struct {
+	mu       sync.Mutex
+	keys     map[string]map[uint64]struct{}
+	prefixes map[string]map[*Client]struct{}
+	clients  map[*Client]*trackingOptions
+}
+
+func newTrackingTable() *trackingTable {
+	return &trackingTable{
+		keys:     make(map[string]map[uint64]struct{}),
+		prefixes: make(map[string]map[*Client]struct{}),
+		clients:  make(map[*Client]*trackingOptions),
+	}
+}
+
+func (t *trackingTable) enable(client *Client, opts *trackingOptions) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clients[client] = opts
+	for _, prefix := range opts.prefixes {
+		clients, ok := t.prefixes[prefix]
+		if !ok {
+			clients = make(map[*Client]struct{})
+			t.prefixes[prefix] = clients
+		}
+		clients[client] = struct{}{}
+	}
+}
+
+// disable rimuove il client; le sue voci nella tabella delle chiavi vengono
+// scartate alla prima invalidazione, come in Redis
+func (t *trackingTable) disable(client *Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	opts, ok := t.clients[client]
+	if !ok {
+		return
+	}
+	for _, prefix := range opts.prefixes {
+		delete(t.prefixes[prefix], client)
+		if len(t.prefixes[prefix]) == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+	delete(t.clients, client)
+}
+
+// remember registra che il client ha letto le chiavi
+func (t *trackingTable) remember(client *Client, keys []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range keys {
+		ids, ok := t.keys[key]
+		if !ok {
+			ids = make(map[uint64]struct{})
+			t.keys[key] = ids
+		}
+		ids[client.id] = struct{}{}
+	}
+}
+
+// invalidateKey è l'Invalidator della cache: avvisa i client interessati alla chiave
+func (s *PodCacheServer) invalidateKey(key string, origin any) {
+	t := s.tracking
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ids, ok := t.keys[key]; ok {
+		delete(t.keys, key)
+		for id := range ids {
+			client := s.clients.get(id)
+			if client == nil {
+				continue
+			}
+			opts, ok := t.clients[client]
+			if !ok || opts.bcast || (opts.noLoop && origin == client) {
+				continue
+			}
+			s.sendInvalidation(client, opts, key)
+		}
+	}
+
+	for prefix, clients := range t.prefixes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for client := range clients {
+			opts := t.clients[client]
+			if opts.noLoop && origin == client {
+				continue
+			}
+			s.sendInvalidation(client, opts, key)
+		}
+	}
+}
+
+// sendInvalidation consegna il messaggio al client o al client di REDIRECT:
+// push "invalidate" in RESP3, messaggio sul canale __redis__:invalidate in RESP2
+func (s *PodCacheServer) sendInvalidation(client *Client, opts *trackingOptions, key string) {
+	target := client
+	if opts.redirect != 0 {
+		if target = s.clients.get(opts.redirect); target == nil {
+			if client.protocolVersion() == 3 {
+				client.out.push([]byte(fmt.Sprintf(">2\r\n$21\r\ntracking-redir-broken\r\n:%d\r\n", opts.redirect)))
+			}
+			return
+		}
+	}
+
+	payload := fmt.Sprintf("*1\r\n$%d\r\n%s\r\n", len(key), key)
+	switch {
+	case target.protocolVersion() == 3:
+		target.out.push([]byte(">2\r\n$10\r\ninvalidate\r\n" + payload))
+	case opts.redirect != 0 && s.pubsub.isSubscribed(target, trackingChannel):
+		target.out.push([]byte(fmt.Sprintf("*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n", len(trackingChannel), trackingChannel) + payload))
+	}
+}
+
+// trackRead registra le chiavi lette dal comando se il client ha il tracking
+// attivo in modalità default, tenendo conto di OPTIN/OPTOUT e CLIENT CACHING.
+// Va chiamata prima di eseguire il comando: una modifica concorrente produce al
+// più un'invalidazione in eccesso, mai una mancata.
+func (s *PodCacheServer) trackRead(client *Client, cmd *resp.Command) {
+	opts := client.tracking
+	if opts == nil || opts.bcast {
+		return
+	}
+	spec := lookupCommand(cmd)
+	if spec == nil || spec.flags&flagReadOnly == 0 {
+		return
+	}
+	if (opts.optIn && !client.cachingYes) || (opts.optOut && client.cachingNo) {
+		return
+	}
+	if keys := spec.extractKeys(cmd.Arguments); len(keys) > 0 {
+		s.tracking.remember(client, keys)
+	}
+}
+
+// handleClientTracking implementa CLIENT TRACKING ON|OFF [REDIRECT id] [PREFIX p]... [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func (s *PodCacheServer) handleClientTracking(client *Client, args []string) error {
+	if len(args) == 0 {
+		return client.sendError("wrong number of arguments for 'client|tracking' command")
+	}
+
+	var on bool
+	switch strings.ToUpper(args[0]) {
+	case "ON":
+		on = true
+	case "OFF":
+	default:
+		return client.sendError(errSyntax.Error())
+	}
+
+	opts := &trackingOptions{}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REDIRECT":
+			if i+1 >= len(args) {
+				return client.sendError(errSyntax.Error())
+			}
+			id, err := strconv.ParseUint(args[i+1], 10, 64)
+			if err != nil {
+				return client.sendError("value is not an integer or out of range")
+			}
+			if id == client.id || s.clients.get(id) == nil {
+				return client.sendError("The client ID you want redirect to does not exist")
+			}
+			opts.redirect = id
+			i++
+		case "PREFIX":
+			if i+1 >= len(args) {
+				return client.sendError(errSyntax.Error())
+			}
+			opts.prefixes = append(opts.prefixes, args[i+1])
+			i++
+		case "BCAST":
+			opts.bcast = true
+		case "OPTIN":
+			opts.optIn = true
+		case "OPTOUT":
+			opts.optOut = true
+		case "NOLOOP":
+			opts.noLoop = true
+		default:
+			return client.sendError(errSyntax.Error())
+		}
+	}
+
+	if !on {
+		s.tracking.disable(client)
+		client.tracking = nil
+		return client.sendOK("OK")
+	}
+
+	if len(opts.prefixes) > 0 && !opts.bcast {
+		return client.sendError("PREFIX option requires BCAST mode to be enabled")
+	}
+	if opts.optIn && opts.optOut {
+		return client.sendError("You can't use both OPTIN and OPTOUT")
+	}
+	if opts.bcast && (opts.optIn || opts.optOut) {
+		return client.sendError("OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if client.tracking != nil && client.tracking.bcast != opts.bcast {
+		return client.sendError("You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+	}
+
+	if opts.bcast {
+		// come Redis, i prefissi si sommano a quelli già registrati
+		if client.tracking != nil {
+			opts.prefixes = append(append([]string(nil), client.tracking.prefixes...), opts.prefixes...)
+		}
+		if len(opts.prefixes) == 0 {
+			opts.prefixes = []string{""}
+		}
+		if err := checkPrefixOverlap(opts.prefixes); err != nil {
+			return client.sendError(err.Error())
+		}
+	}
+
+	s.tracking.disable(client)
+	s.tracking.enable(client, opts)
+	client.tracking = opts
+	return client.sendOK("OK")
+}
+
+// checkPrefixOverlap rifiuta prefissi in cui uno contiene l'altro, che
+// produrrebbero invalidazioni duplicate per lo stesso client
+func checkPrefixOverlap(prefixes []string) error {
+	for i, a := range prefixes {
+		for j, b := range prefixes {
+			if i != j && a != b && strings.HasPrefix(b, a) {
+				return fmt.Errorf("Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.", b, a)
+			}
+		}
+	}
+	return nil
+}
+
+// handleClientCaching implementa CLIENT CACHING YES|NO, valido per il comando successivo
+func (s *PodCacheServer) handleClientCaching(client *Client, args []string) error {
+	if len(args) != 1 {
+		return client.sendError("wrong number of arguments for 'client|caching' command")
+	}
+	opts := client.tracking
+	if opts == nil || (!opts.optIn && !opts.optOut) {
+		return client.sendError("CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "YES":
+		if !opts.optIn {
+			return client.sendError("CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+		}
+		client.cachingYes = true
+	case "NO":
+		if !opts.optOut {
+			return client.sendError("CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+		}
+		client.cachingNo = true
+	default:
+		return client.sendError(errSyntax.Error())
+	}
+	return client.sendOK("OK")
+}
+
+func (s *PodCacheServer) handleClientGetRedir(client *Client) error {
+	if client.tracking == nil {
+		return client.sendInteger(-1)
+	}
+	return client.sendInteger(int(client.tracking.redirect))
+}
+
+func (s *PodCacheServer) handleClientTrackingInfo(client *Client) error {
+	opts := client.tracking
+
+	var flags []string
+	redirect := int64(-1)
+	var prefixes []string
+	if opts == nil {
+		flags = append(flags, "off")
+	} else {
+		flags = append(flags, "on")
+		redirect = int64(opts.redirect)
+		prefixes = opts.prefixes
+		if opts.bcast {
+			flags = append(flags, "bcast")
+		}
+		if opts.optIn {
+			flags = append(flags, "optin")
+			if client.cachingYes {
+				flags = append(flags, "caching-yes")
+			}
+		}
+		if opts.optOut {
+			flags = append(flags, "optout")
+			if client.cachingNo {
+				flags = append(flags, "caching-no")
+			}
+		}
+		if opts.noLoop {
+			flags = append(flags, "noloop")
+		}
+		if opts.redirect != 0 && s.clients.get(opts.redirect) == nil {
+			flags = append(flags, "broken_redirect")
+		}
+	}
+
+	client.writeMap(3)
+	client.writeBulk("flags")
+	client.writeArray(len(flags))
+	for _, flag := range flags {
+		client.writeBulk(flag)
+	}
+	client.writeBulk("redirect")
+	client.writeInt(redirect)
+	client.writeBulk("prefixes")
+	client.writeArray(len(prefixes))
+	for _, prefix := range prefixes {
+		client.writeBulk(prefix)
+	}
+	return client.flush()
+}
+
+// counts restituisce client con tracking, chiavi ricordate e prefissi BCAST, per INFO
+func (t *trackingTable) counts() (clients, keys, prefixes int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.clients), len(t.keys), len(t.prefixes)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"mi0772/podcache/resp"
+	"strconv"
+	"testing"
+)
+
+// invalidated verifica che il push sia l'invalidazione di key
+func invalidated(t *testing.T, push resp.Reply, key string) {
+	t.Helper()
+	if len(push.Elements) != 2 || push.Elements[0].Str != "invalidate" ||
+		len(push.Elements[1].Elements) != 1 || push.Elements[1].Elements[0].Str != key {
+		t.Fatalf("push = %+v, want the invalidation of %s", push, key)
+	}
+}
+
+// trackingClients restituisce un client RESP3 con il tracking attivo con le
+// opzioni indicate e un secondo client che modifica le chiavi
+func trackingClients(t *testing.T, options ...string) (tracked, writer *testClient) {
+	t.Helper()
+	s := newTestServer(t)
+	tracked = newTestClient(t, s)
+	writer = dialTest(t, "tcp", tracked.conn.RemoteAddr().String())
+	tracked.do("HELLO", "3")
+	tracked.ok(append([]string{"CLIENT", "TRACKING", "ON"}, options...)...)
+	return tracked, writer
+}
+
+func TestTrackingDefault(t *testing.T) {
+	c, writer := trackingClients(t)
+
+	c.do("GET", "k")
+	writer.ok("SET", "k", "1")
+	invalidated(t, c.push(), "k")
+
+	// dopo l'invalidazione il client è dimenticato fino alla lettura successiva
+	writer.ok("SET", "k", "2")
+	c.silent()
+	writer.ok("SET", "other", "1")
+	c.silent()
+
+	c.bulk("GET", "k")
+	writer.integer("DEL", "k")
+	invalidated(t, c.push(), "k")
+
+	c.ok("CLIENT", "TRACKING", "OFF")
+	c.do("GET", "k")
+	writer.ok("SET", "k", "3")
+	c.silent()
+}
+
+func TestTrackingNoLoop(t *testing.T) {
+	c, writer := trackingClients(t, "NOLOOP")
+
+	// le modifiche del client stesso non lo invalidano, ma come in Redis la
+	// chiave va letta di nuovo per essere ricordata
+	c.do("GET", "k")
+	c.ok("SET", "k", "mine")
+	c.silent()
+	c.bulk("GET", "k")
+	writer.ok("SET", "k", "theirs")
+	invalidated(t, c.push(), "k")
+}
+
+func TestTrackingBcast(t *testing.T) {
+	c, writer := trackingClients(t, "BCAST", "PREFIX", "user:")
+
+	// in BCAST non serve leggere la chiave, conta solo il prefisso
+	writer.ok("SET", "user:1", "a")
+	invalidated(t, c.push(), "user:1")
+	writer.ok("SET", "user:1", "b")
+	invalidated(t, c.push(), "user:1")
+	writer.ok("SET", "order:1", "a")
+	c.silent()
+
+	c.ok("CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "order:")
+	writer.ok("SET", "order:1", "b")
+	invalidated(t, c.push(), "order:1")
+
+	c.fails("ERR Prefix 'user:admin' overlaps with an existing prefix 'user:'", "CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:admin")
+	c.fails("ERR You can't switch BCAST mode on/off", "CLIENT", "TRACKING", "ON")
+	c.ok("CLIENT", "TRACKING", "OFF")
+	c.fails("ERR PREFIX option requires BCAST mode to be enabled", "CLIENT", "TRACKING", "ON", "PREFIX", "user:")
+}
+
+func TestTrackingOptInOptOut(t *testing.T) {
+	c, writer := trackingClients(t, "OPTIN")
+
+	// con OPTIN si ricordano solo le letture dopo CLIENT CACHING YES
+	c.do("GET", "a")
+	writer.ok("SET", "a", "1")
+	c.silent()
+	c.ok("CLIENT", "CACHING", "YES")
+	c.bulk("GET", "a")
+	writer.ok("SET", "a", "2")
+	invalidated(t, c.push(), "a")
+	c.fails("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.", "CLIENT", "CACHING", "NO")
+
+	// CACHING YES vale per un solo comando
+	c.ok("CLIENT", "CACHING", "YES")
+	c.do("PING")
+	c.bulk("GET", "a")
+	writer.ok("SET", "a", "3")
+	c.silent()
+
+	// con OPTOUT si ricordano tutte le letture tranne quella dopo CLIENT CACHING NO
+	c.ok("CLIENT", "TRACKING", "OFF")
+	c.ok("CLIENT", "TRACKING", "ON", "OPTOUT")
+	c.bulk("GET", "a")
+	writer.ok("SET", "a", "4")
+	invalidated(t, c.push(), "a")
+	c.ok("CLIENT", "CACHING", "NO")
+	c.bulk("GET", "a")
+	writer.ok("SET", "a", "5")
+	c.silent()
+
+	c.ok("CLIENT", "TRACKING", "OFF")
+	c.fails("ERR You can't use both OPTIN and OPTOUT", "CLIENT", "TRACKING", "ON", "OPTIN", "OPTOUT")
+	c.fails("ERR CLIENT CACHING can be called only when the client is in tracking mode", "CLIENT", "CACHING", "YES")
+}
+
+func TestTrackingRedirect(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	addr := c.conn.RemoteAddr().String()
+	writer := dialTest(t, "tcp", addr)
+
+	// un client RESP2 riceve le invalidazioni come messaggi del canale __redis__:invalidate
+	listener := dialTest(t, "tcp", addr)
+	id := strconv.FormatInt(listener.integer("CLIENT", "ID"), 10)
+	listener.do("SUBSCRIBE", "__redis__:invalidate")
+
+	c.fails("ERR The client ID you want redirect to does not exist", "CLIENT", "TRACKING", "ON", "REDIRECT", "999999")
+	c.ok("CLIENT", "TRACKING", "ON", "REDIRECT", id)
+	if redir := c.integer("CLIENT", "GETREDIR"); strconv.FormatInt(redir, 10) != id {
+		t.Fatalf("CLIENT GETREDIR = %d, want %s", redir, id)
+	}
+
+	c.do("GET", "k")
+	writer.ok("SET", "k", "1")
+	message := listener.read()
+	if len(message.Elements) != 3 || message.Elements[0].Str != "message" || message.Elements[1].Str != "__redis__:invalidate" ||
+		len(message.Elements[2].Elements) != 1 || message.Elements[2].Elements[0].Str != "k" {
+		t.Fatalf("redirected invalidation = %+v", message)
+	}
+	c.silent()
+
+	// un client RESP3 riceve le invalidazioni come push
+	listener.conn.Close()
+	resp3 := dialTest(t, "tcp", addr)
+	resp3.do("HELLO", "3")
+	c.ok("CLIENT", "TRACKING", "ON", "REDIRECT", strconv.FormatInt(resp3.integer("CLIENT", "ID"), 10))
+	c.do("GET", "k")
+	writer.ok("SET", "k", "2")
+	invalidated(t, resp3.push(), "k")
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"mi0772/podcache/cache"
+	"mi0772/podcache/resp"
+)
+
+// db restituisce la cache su cui il client opera: durante EXEC è la vista con
+// i lock già acquisiti, altrimenti la vista del client.
+func (s *PodCacheServer) db(client *Client) *cache.PodCache {
+	if client.txView != nil {
+		return client.txView
+	}
+	return client.view
+}
+
+// watchedKey è una chiave in WATCH nel DB in cui è stata osservata
+type watchedKey struct {
+	db  int
+	key string
+}
+
+func (s *PodCacheServer) handleMulti(client *Client) error {
+	if client.multi {
+		return client.sendError("MULTI calls can not be nested")
+	}
+	client.multi = true
+	client.queued = nil
+	client.txFailed = false
+	return client.sendOK("OK")
+}
+
+// queueCommand accoda un comando dopo MULTI. Gli errori rilevabili subito
+// (comando sconosciuto, numero di argomenti) fanno fallire l'intera EXEC.
+func (s *PodCacheServer) queueCommand(client *Client, cmd *resp.Command) error {
+	spec := lookupCommand(cmd)
+	if spec == nil {
+		client.txFailed = true
+		return client.sendError("Unknown command")
+	}
+	if !spec.checkArity(cmd.Arguments) {
+		client.txFailed = true
+		return client.sendError("wrong number of arguments for '" + spec.name + "' command")
+	}
+
+	client.queued = append(client.queued, cmd)
+	return client.sendOK("QUEUED")
+}
+
+func (s *PodCacheServer) handleDiscard(client *Client) error {
+	if !client.multi {
+		return client.sendError("DISCARD without MULTI")
+	}
+	s.resetTransaction(client)
+	return client.sendOK("OK")
+}
+
+// handleExec esegue i comandi accodati tenendo i lock di tutte le partizioni
+// coinvolte (comandi e chiavi in WATCH), così nessun altro client può
+// osservare o modificare quelle chiavi a metà transazione.
+func (s *PodCacheServer) handleExec(client *Client) error {
+	if !client.multi {
+		return client.sendError("EXEC without MULTI")
+	}
+	defer s.resetTransaction(client)
+
+	if client.txFailed {
+		client.writer.WriteString("-EXECABORT Transaction discarded because of previous errors.\r\n")
+		return client.flush()
+	}
+
+	// i permessi possono essere cambiati da ACL SETUSER dopo che i comandi sono
+	// stati accodati: come in Redis EXEC li verifica di nuovo e un comando non
+	// più consentito annulla l'intera transazione
+	for _, cmd := range client.queued {
+		if message := s.aclDenied(client, lookupCommand(cmd), cmd, "multi"); message != "" {
+			client.writer.WriteString("-EXECABORT Transaction discarded because of: " + message + "\r\n")
+			return client.flush()
+		}
+	}
+
+	keys := make([]string, 0, len(client.watched))
+	for w := range client.watched {
+		keys = append(keys, w.key)
+	}
+	// SWAPDB e FLUSHDB toccano l'intero keyspace e richiedono tutti i lock
+	wholeKeyspace := false
+	for _, cmd := range client.queued {
+		spec := lookupCommand(cmd)
+		keys = append(keys, spec.extractKeys(cmd.Arguments)...)
+		wholeKeyspace = wholeKeyspace || spec.flags&flagKeyspace != 0
+	}
+	atomic := func(fn func(view *cache.PodCache) error) error {
+		return client.view.Atomic(keys, fn)
+	}
+	if wholeKeyspace {
+		atomic = client.view.AtomicAll
+	}
+
+	return atomic(func(view *cache.PodCache) error {
+		// WATCH ottimistico: se una chiave osservata è cambiata la transazione non parte
+		for w, version := range client.watched {
+			dbView, err := view.Select(w.db)
+			if err != nil || dbView.Versions(w.key)[0] != version {
+				return client.sendNullArray()
+			}
+		}
+
+		client.txView = view
+		defer func() { client.txView = nil }()
+
+		client.writeArray(len(client.queued))
+		for _, cmd := range client.queued {
+			s.trackRead(client, cmd)
+			if err := s.dispatch(client, cmd); err != nil {
+				return err
+			}
+		}
+		return client.flush()
+	})
+}
+
+func (s *PodCacheServer) handleWatch(client *Client, args []string) error {
+	if client.multi {
+		return client.sendError("WATCH inside MULTI is not allowed")
+	}
+	if len(args) == 0 {
+		return client.sendError("wrong number of arguments for 'watch' command")
+	}
+
+	if client.watched == nil {
+		client.watched = make(map[watchedKey]uint64, len(args))
+	}
+
+	db := client.view.DB()
+	var keys []string
+	for _, key := range args {
+		if _, already := client.watched[watchedKey{db, key}]; !already {
+			keys = append(keys, key)
+		}
+	}
+	for i, version := range client.view.Watch(keys...) {
+		client.watched[watchedKey{db, keys[i]}] = version
+	}
+	return client.sendOK("OK")
+}
+
+func (s *PodCacheServer) handleUnwatch(client *Client) error {
+	s.unwatchAll(client)
+	return client.sendOK("OK")
+}
+
+func (s *PodCacheServer) resetTransaction(client *Client) {
+	client.multi = false
+	client.queued = nil
+	client.txFailed = false
+	s.unwatchAll(client)
+}
+
+func (s *PodCacheServer) unwatchAll(client *Client) {
+	if len(client.watched) == 0 {
+		return
+	}
+	for w := range client.watched {
+		if view, err := s.cache.Select(w.db); err == nil {
+			view.Unwatch(w.key)
+		}
+	}
+	client.watched = nil
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"mi0772/podcache/resp"
+	"testing"
+)
+
+// queued accoda un comando dopo MULTI
+func (c *testClient) queued(args ...string) {
+	c.t.Helper()
+	if reply := c.do(args...); reply.Kind != resp.ReplyStatus || reply.Str != "QUEUED" {
+		c.t.Fatalf("%v = %+v, want QUEUED", args, reply)
+	}
+}
+
+func TestExecAbort(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+
+	// un errore di accodamento scarta l'intera transazione, anche i comandi validi
+	c.ok("MULTI")
+	c.queued("SET", "a", "1")
+	c.fails("ERR Unknown command", "NOSUCHCOMMAND")
+	c.fails("ERR wrong number of arguments for 'get' command", "GET")
+	c.queued("SET", "b", "2")
+	c.fails("EXECABORT Transaction discarded because of previous errors.", "EXEC")
+	if reply := c.do("GET", "a"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("GET a after EXECABORT = %+v, want nil", reply)
+	}
+
+	// dopo EXECABORT il client non è più in MULTI
+	c.fails("ERR EXEC without MULTI", "EXEC")
+	c.ok("MULTI")
+	c.queued("SET", "a", "1")
+	if reply := c.do("EXEC"); reply.Kind != resp.ReplyArray || len(reply.Elements) != 1 || reply.Elements[0].Str != "OK" {
+		t.Fatalf("EXEC = %+v, want [OK]", reply)
+	}
+}
+
+func TestWatchInvalidated(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	other := dialTest(t, "tcp", c.conn.RemoteAddr().String())
+
+	c.ok("WATCH", "k")
+	other.ok("SET", "k", "theirs")
+	c.ok("MULTI")
+	c.queued("SET", "k", "mine")
+	if reply := c.do("EXEC"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("EXEC after a write to a watched key = %+v, want a null array", reply)
+	}
+	if v := c.bulk("GET", "k"); v != "theirs" {
+		t.Fatalf("GET k = %q, want theirs", v)
+	}
+
+	// EXEC rimuove i WATCH: la transazione successiva parte
+	other.ok("SET", "k", "again")
+	c.ok("MULTI")
+	c.queued("SET", "k", "mine")
+	if reply := c.do("EXEC"); reply.Kind != resp.ReplyArray || len(reply.Elements) != 1 {
+		t.Fatalf("EXEC without WATCH = %+v, want [OK]", reply)
+	}
+
+	// una chiave in WATCH in un altro DB non è la stessa chiave
+	c.ok("WATCH", "k")
+	other.ok("SELECT", "1")
+	other.ok("SET", "k", "db1")
+	c.ok("MULTI")
+	c.queued("GET", "k")
+	if reply := c.do("EXEC"); reply.Kind != resp.ReplyArray || len(reply.Elements) != 1 || reply.Elements[0].Str != "mine" {
+		t.Fatalf("EXEC after a write in another DB = %+v, want [mine]", reply)
+	}
+	c.ok("MULTI")
+	c.fails("ERR WATCH inside MULTI is not allowed", "WATCH", "k")
+	c.ok("DISCARD")
+}
+
+func TestDiscard(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	other := dialTest(t, "tcp", c.conn.RemoteAddr().String())
+
+	c.fails("ERR DISCARD without MULTI", "DISCARD")
+	c.ok("WATCH", "k")
+	c.ok("MULTI")
+	c.fails("ERR MULTI calls can not be nested", "MULTI")
+	c.queued("SET", "k", "discarded")
+	c.ok("DISCARD")
+	if reply := c.do("GET", "k"); reply.Kind != resp.ReplyNil {
+		t.Fatalf("GET k after DISCARD = %+v, want nil", reply)
+	}
+	c.fails("ERR EXEC without MULTI", "EXEC")
+
+	// DISCARD rimuove anche i WATCH
+	other.ok("SET", "k", "theirs")
+	c.ok("MULTI")
+	c.queued("SET", "k", "mine")
+	if reply := c.do("EXEC"); reply.Kind != resp.ReplyArray || len(reply.Elements) != 1 {
+		t.Fatalf("EXEC after DISCARD = %+v, want [OK]", reply)
+	}
+	if v := c.bulk("GET", "k"); v != "mine" {
+		t.Fatalf("GET k = %q, want mine", v)
+	}
+}
+
+func TestExecSelect(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+
+	c.ok("SET", "k", "db0")
+	c.ok("MULTI")
+	c.queued("SELECT", "1")
+	c.queued("SET", "k", "db1")
+	c.queued("GET", "k")
+	reply := c.do("EXEC")
+	if reply.Kind != resp.ReplyArray || len(reply.Elements) != 3 || reply.Elements[2].Str != "db1" {
+		t.Fatalf("EXEC = %+v, want [OK OK db1]", reply)
+	}
+
+	// come in Redis il DB scelto nella transazione resta quello della connessione
+	if v := c.bulk("GET", "k"); v != "db1" {
+		t.Fatalf("GET k after EXEC = %q, want db1", v)
+	}
+	c.ok("SELECT", "0")
+	if v := c.bulk("GET", "k"); v != "db0" {
+		t.Fatalf("GET k in DB 0 = %q, want db0", v)
+	}
+}
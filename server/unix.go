@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"mi0772/podcache/config"
+	"net"
+	"os"
+	"strconv"
+)
+
+// unixSocketOptions è il socket Unix, come unixsocket e unixsocketperm di Redis
+type unixSocketOptions struct {
+	path string
+	perm os.FileMode // 0: permessi dati dall'umask
+}
+
+// getUnixSocket legge unixsocket e unixsocketperm (ottale, es. 770)
+func getUnixSocket(cfg *config.Registry) *unixSocketOptions {
+	path := cfg.String("unixsocket")
+	if path == "" {
+		return nil
+	}
+	// unixsocketperm è già validato dal registro
+	perm, _ := strconv.ParseUint(cfg.String("unixsocketperm"), 8, 32)
+	return &unixSocketOptions{path: path, perm: os.FileMode(perm)}
+}
+
+// listenUnix apre il socket rimuovendo quello lasciato da un'esecuzione
+// precedente; il file viene rimosso alla chiusura del listener
+func listenUnix(opts *unixSocketOptions) (net.Listener, error) {
+	if info, err := os.Lstat(opts.path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New(opts.path + " exists and is not a socket")
+		}
+		if err := os.Remove(opts.path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", opts.path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.perm != 0 {
+		if err := os.Chmod(opts.path, opts.perm); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "podcache.sock")
+
+	// un socket lasciato da un'esecuzione precedente viene sostituito
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("net.Listen() returned an error: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s := newTestServer(t, "port", "0", "unixsocket", path, "unixsocketperm", "700")
+	listener, err := listenUnix(s.unix)
+	if err != nil {
+		t.Fatalf("listenUnix() returned an error: %v", err)
+	}
+	s.serveTest(t, listener)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat() returned an error: %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o700 {
+		t.Fatalf("socket mode = %v, want socket with 0700", info.Mode())
+	}
+
+	c := dialTest(t, "unix", path)
+	c.ok("SET", "k", "v")
+	list := c.bulk("CLIENT", "LIST")
+	if !strings.Contains(list, "addr="+path+":0") || !strings.Contains(list, "flags=U") {
+		t.Fatalf("CLIENT LIST over the unix socket = %q", list)
+	}
+
+	listener.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file left after close: %v", err)
+	}
+}
+
+func TestUnixSocketNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, []byte("keep"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(&unixSocketOptions{path: path}); err == nil {
+		t.Fatal("listenUnix() over a regular file succeeded")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "keep" {
+		t.Fatalf("regular file changed to %q", data)
+	}
+}
+
+func TestUnixSocketPermParsing(t *testing.T) {
+	for value, want := range map[string]string{"770": "770", "0700": "700", "0": "0"} {
+		if got, err := normalizeSocketPerm(value, ""); err != nil || got != want {
+			t.Errorf("normalizeSocketPerm(%q) = %q, %v, want %q", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"999", "1777", "rwx"} {
+		if _, err := normalizeSocketPerm(value, ""); err == nil {
+			t.Errorf("normalizeSocketPerm(%q) accepted an invalid permission", value)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mi0772/podcache/logging"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// exportInterval è l'attesa massima di uno span prima dell'invio
+	exportInterval = 5 * time.Second
+	// exportBatch è il numero di span che provoca un invio immediato
+	exportBatch = 512
+	// exportQueue è il numero di tracce in attesa oltre il quale le nuove
+	// vengono scartate: un collector lento non rallenta i comandi
+	exportQueue   = 2048
+	exportTimeout = 10 * time.Second
+)
+
+// Exporter invia le tracce a un collector con OTLP/HTTP in JSON, a lotti e
+// in background (vedi Run)
+type Exporter struct {
+	endpoint string
+	resource []Attr
+	client   *http.Client
+	logger   logging.Logger
+
+	queue   chan []*Span
+	dropped atomic.Uint64
+}
+
+// NewExporter crea un exporter verso endpoint, l'URL completo del collector
+// (es. http://localhost:4318/v1/traces); resource descrive il processo,
+// es. service.name
+func NewExporter(endpoint string, resource []Attr, logger logging.Logger) *Exporter {
+	return &Exporter{
+		endpoint: endpoint,
+		resource: resource,
+		client:   &http.Client{Timeout: exportTimeout},
+		logger:   logger,
+		queue:    make(chan []*Span, exportQueue),
+	}
+}
+
+// Dropped restituisce il numero di tracce scartate perché la coda era piena
+func (e *Exporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+func (e *Exporter) enqueue(spans []*Span) {
+	select {
+	case e.queue <- spans:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Run invia le tracce finché ctx non termina, poi invia quelle ancora in coda
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			e.logger.Warn("Failed to export traces", "endpoint", e.endpoint, "spans", len(batch), "error", err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case spans := <-e.queue:
+			batch = append(batch, spans...)
+			if len(batch) >= exportBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case spans := <-e.queue:
+					batch = append(batch, spans...)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *Exporter) send(spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector replied %s", resp.Status)
+	}
+	return nil
+}
+
+// I tipi otlp* sono il sottoinsieme di ExportTraceServiceRequest usato qui,
+// nella codifica JSON di OTLP: id in esadecimale, interi a 64 bit come stringhe
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// otlpStatusError è STATUS_CODE_ERROR
+const otlpStatusError = 2
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func (e *Exporter) request(spans []*Span) otlpRequest {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.trace.id[:]),
+			SpanID:            hex.EncodeToString(s.id[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        attributes(s.attrs),
+		}
+		if s.parent != ([8]byte{}) {
+			span.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.errMsg != "" {
+			span.Status = &otlpStatus{Code: otlpStatusError, Message: s.errMsg}
+		}
+		out = append(out, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: attributes(e.resource)},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "podcache"}, Spans: out}},
+	}}}
+}
+
+func attributes(attrs []Attr) []otlpKeyValue {
+	out := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpAnyValue
+		switch value := a.Value.(type) {
+		case string:
+			v.StringValue = &value
+		case bool:
+			v.BoolValue = &value
+		case int:
+			n := strconv.Itoa(value)
+			v.IntValue = &n
+		case int64:
+			n := strconv.FormatInt(value, 10)
+			v.IntValue = &n
+		case float64:
+			v.DoubleValue = &value
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		out = append(out, otlpKeyValue{Key: a.Key, Value: v})
+	}
+	return out
+}
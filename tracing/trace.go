@@ -0,0 +1,148 @@
+// Package tracing registra gli span delle richieste con campionamento in testa
+// e li esporta in formato OTLP/HTTP JSON verso un collector OpenTelemetry,
+// senza dipendenze esterne.
+package tracing
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind è il tipo di uno span, con i valori di SpanKind di OTLP
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+)
+
+// Attr è un attributo di uno span; Value è string, bool, int, int64 o float64
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// Tracer crea gli span radice. La decisione di campionamento è presa una sola
+// volta all'inizio della traccia e vale per tutti i suoi span; senza exporter
+// nessuna traccia è campionata.
+type Tracer struct {
+	exporter *Exporter
+	ratio    atomic.Uint64 // bit di un float64 tra 0 e 1
+}
+
+func NewTracer(exporter *Exporter, ratio float64) *Tracer {
+	t := &Tracer{exporter: exporter}
+	t.SetSampleRatio(ratio)
+	return t
+}
+
+// SetSampleRatio imposta la frazione di tracce campionate, da 0 a 1
+func (t *Tracer) SetSampleRatio(ratio float64) {
+	t.ratio.Store(math.Float64bits(min(max(ratio, 0), 1)))
+}
+
+func (t *Tracer) SampleRatio() float64 {
+	return math.Float64frombits(t.ratio.Load())
+}
+
+// Start apre lo span radice di una nuova traccia, o restituisce nil se la
+// traccia non è campionata. Tutti i metodi di Span accettano un receiver nil,
+// così il codice instrumentato non controlla il campionamento.
+func (t *Tracer) Start(name string) *Span {
+	if t == nil || t.exporter == nil {
+		return nil
+	}
+	// come TraceIDRatioBased di OpenTelemetry la decisione dipende dal trace id
+	low := rand.Uint64()
+	ratio := t.SampleRatio()
+	if ratio < 1 && float64(low>>11)/(1<<53) >= ratio {
+		return nil
+	}
+	tr := &trace{exporter: t.exporter}
+	binary.BigEndian.PutUint64(tr.id[:8], rand.Uint64())
+	binary.BigEndian.PutUint64(tr.id[8:], low)
+	return tr.start(name, KindServer, [8]byte{})
+}
+
+// trace raccoglie gli span conclusi di una traccia, esportati insieme alla
+// chiusura della radice
+type trace struct {
+	id       [16]byte
+	exporter *Exporter
+
+	mu    sync.Mutex
+	ended []*Span
+}
+
+func (tr *trace) start(name string, kind Kind, parent [8]byte) *Span {
+	s := &Span{trace: tr, parent: parent, name: name, kind: kind, start: time.Now()}
+	binary.BigEndian.PutUint64(s.id[:], rand.Uint64())
+	return s
+}
+
+// Span è un'operazione temporizzata di una traccia. Va chiuso con End; gli
+// span ancora aperti quando si chiude la radice non vengono esportati.
+type Span struct {
+	trace  *trace
+	id     [8]byte
+	parent [8]byte
+	name   string
+	kind   Kind
+	start  time.Time
+	end    time.Time
+	attrs  []Attr
+	// errMsg non vuoto segna lo span con stato di errore
+	errMsg string
+}
+
+// Child apre uno span figlio nella stessa traccia
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.trace.start(name, KindInternal, s.id)
+}
+
+// SetName cambia il nome dello span, es. quando il comando è noto solo dopo il parsing
+func (s *Span) SetName(name string) {
+	if s != nil {
+		s.name = name
+	}
+}
+
+func (s *Span) SetAttr(key string, value any) {
+	if s != nil {
+		s.attrs = append(s.attrs, Attr{Key: key, Value: value})
+	}
+}
+
+// SetError segna lo span come fallito
+func (s *Span) SetError(msg string) {
+	if s != nil {
+		s.errMsg = msg
+	}
+}
+
+// End chiude lo span; chiudere la radice consegna la traccia all'exporter
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	tr := s.trace
+	root := s.parent == ([8]byte{})
+	tr.mu.Lock()
+	spans := append(tr.ended, s)
+	tr.ended = spans
+	if root {
+		tr.ended = nil
+	}
+	tr.mu.Unlock()
+	if root {
+		tr.exporter.enqueue(spans)
+	}
+}
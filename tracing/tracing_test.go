@@ -0,0 +1,173 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"mi0772/podcache/logging"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeCollector riceve le richieste OTLP/HTTP JSON come un collector locale
+type fakeCollector struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []otlpRequest
+	status   int
+}
+
+func newFakeCollector(t *testing.T) *fakeCollector {
+	c := &fakeCollector{status: http.StatusOK}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request to %s with Content-Type %q", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid OTLP JSON: %v", err)
+		}
+		c.mu.Lock()
+		c.requests = append(c.requests, req)
+		status := c.status
+		c.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *fakeCollector) spans() []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var spans []otlpSpan
+	for _, req := range c.requests {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+// export esegue fn con un tracer collegato al collector e attende l'invio
+func export(t *testing.T, collector *fakeCollector, ratio float64, fn func(*Tracer)) *Exporter {
+	exporter := NewExporter(collector.URL+"/v1/traces", []Attr{{"service.name", "podcache"}}, logging.NewNoOpLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		exporter.Run(ctx)
+		close(done)
+	}()
+	fn(NewTracer(exporter, ratio))
+	cancel()
+	<-done
+	return exporter
+}
+
+func TestExport(t *testing.T) {
+	collector := newFakeCollector(t)
+	export(t, collector, 1, func(tracer *Tracer) {
+		root := tracer.Start("command")
+		parse := root.Child("parse")
+		parse.End()
+		execute := root.Child("execute")
+		disk := execute.Child("disk.read")
+		disk.SetAttr("disk.found", true)
+		disk.End()
+		execute.SetError("ERR")
+		execute.End()
+		// uno span non chiuso alla chiusura della radice non viene esportato
+		root.Child("flush")
+		root.SetName("GET")
+		root.SetAttr("db.redis.database_index", 3)
+		root.End()
+	})
+
+	spans := collector.spans()
+	if len(spans) != 4 {
+		t.Fatalf("exported %d spans, want 4", len(spans))
+	}
+	byName := make(map[string]otlpSpan)
+	for _, s := range spans {
+		if s.TraceID != spans[0].TraceID || len(s.TraceID) != 32 || len(s.SpanID) != 16 {
+			t.Fatalf("span %s has trace id %q, span id %q", s.Name, s.TraceID, s.SpanID)
+		}
+		byName[s.Name] = s
+	}
+	root, execute := byName["GET"], byName["execute"]
+	if root.ParentSpanID != "" || root.Kind != KindServer {
+		t.Fatalf("root span = %+v", root)
+	}
+	if byName["parse"].ParentSpanID != root.SpanID || execute.ParentSpanID != root.SpanID || byName["disk.read"].ParentSpanID != execute.SpanID {
+		t.Fatalf("wrong parents: %+v", spans)
+	}
+	if execute.Status == nil || execute.Status.Code != otlpStatusError || execute.Status.Message != "ERR" {
+		t.Fatalf("execute status = %+v", execute.Status)
+	}
+	if attr := root.Attributes; len(attr) != 1 || attr[0].Value.IntValue == nil || *attr[0].Value.IntValue != "3" {
+		t.Fatalf("root attributes = %+v", attr)
+	}
+	if attr := byName["disk.read"].Attributes; len(attr) != 1 || attr[0].Value.BoolValue == nil || !*attr[0].Value.BoolValue {
+		t.Fatalf("disk.read attributes = %+v", attr)
+	}
+	resource := collector.requests[0].ResourceSpans[0].Resource.Attributes
+	if len(resource) != 1 || resource[0].Key != "service.name" || *resource[0].Value.StringValue != "podcache" {
+		t.Fatalf("resource = %+v", resource)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	collector := newFakeCollector(t)
+	export(t, collector, 0, func(tracer *Tracer) {
+		for i := 0; i < 100; i++ {
+			span := tracer.Start("command")
+			if span != nil {
+				t.Fatal("Start() sampled a trace with ratio 0")
+			}
+			// gli span non campionati sono nil e i loro metodi non fanno nulla
+			child := span.Child("parse")
+			child.SetAttr("k", "v")
+			child.End()
+			span.End()
+		}
+	})
+	if len(collector.spans()) != 0 {
+		t.Fatalf("exported %d spans with ratio 0", len(collector.spans()))
+	}
+
+	tracer := NewTracer(NewExporter("http://localhost", nil, logging.NewNoOpLogger()), 0.25)
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if tracer.Start("command") != nil {
+			sampled++
+		}
+	}
+	if sampled < 2000 || sampled > 3000 {
+		t.Fatalf("sampled %d traces out of 10000 with ratio 0.25", sampled)
+	}
+	if (*Tracer)(nil).Start("command") != nil || NewTracer(nil, 1).Start("command") != nil {
+		t.Fatal("Start() sampled a trace without an exporter")
+	}
+}
+
+func TestExportFailure(t *testing.T) {
+	collector := newFakeCollector(t)
+	collector.status = http.StatusServiceUnavailable
+	exporter := NewExporter(collector.URL+"/v1/traces", nil, logging.NewNoOpLogger())
+	root := NewTracer(exporter, 1).Start("command")
+	root.End()
+	if err := exporter.send(<-exporter.queue); err == nil {
+		t.Fatal("send() succeeded with a 503 from the collector")
+	}
+
+	// con la coda piena le tracce sono scartate senza bloccare
+	for i := 0; i < exportQueue+10; i++ {
+		exporter.enqueue(nil)
+	}
+	if exporter.Dropped() != 10 {
+		t.Fatalf("Dropped() = %d, want 10", exporter.Dropped())
+	}
+}
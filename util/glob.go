@@ -0,0 +1,109 @@
+package util
+
+// GlobMatch confronta s con un pattern in stile glob con la stessa semantica
+// di stringmatchlen di Redis: '*' qualsiasi sequenza, '?' un carattere,
+// '[abc]', '[^abc]' e '[a-z]' classi di caratteri, '\' per l'escape.
+func GlobMatch(pattern, s string) bool {
+	p, str := 0, 0
+	// posizioni a cui tornare quando un '*' deve assorbire un carattere in più
+	starP, starS := -1, 0
+
+	for str < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				starP, starS = p, str
+				continue
+			case '?':
+				p++
+				str++
+				continue
+			case '[':
+				if next, ok := matchClass(pattern, p, s[str]); ok {
+					p = next
+					str++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) {
+					if pattern[p+1] == s[str] {
+						p += 2
+						str++
+						continue
+					}
+				} else if s[str] == '\\' {
+					p++
+					str++
+					continue
+				}
+			default:
+				if pattern[p] == s[str] {
+					p++
+					str++
+					continue
+				}
+			}
+		}
+
+		if starP < 0 {
+			return false
+		}
+		starS++
+		p, str = starP, starS
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass valuta la classe che inizia in pattern[start] ('[') contro c e
+// restituisce la posizione successiva alla ']' di chiusura
+func matchClass(pattern string, start int, c byte) (int, bool) {
+	p := start + 1
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+
+	matched := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				matched = true
+			}
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			p += 2
+		default:
+			if pattern[p] == c {
+				matched = true
+			}
+		}
+		p++
+	}
+	// come Redis, una classe non chiusa si estende fino alla fine del pattern
+	if p < len(pattern) {
+		p++
+	}
+
+	if negate {
+		matched = !matched
+	}
+	return p, matched
+}